@@ -362,6 +362,37 @@ func (rm *MockRegionManager) SplitRaw(regionID, newRegionID uint64, rawKey []byt
 	return proto.Clone(new).(*metapb.Region)
 }
 
+// Merge merges the Region source into its adjacent Region target. The source Region
+// doesn't exist after the merge, and target covers the range of both.
+func (rm *MockRegionManager) Merge(sourceID, targetID uint64) {
+	rm.mu.Lock()
+	defer rm.mu.Unlock()
+	source, target := rm.regions[sourceID], rm.regions[targetID]
+	meta := proto.Clone(target.meta).(*metapb.Region)
+	switch {
+	case bytes.Equal(source.meta.EndKey, target.meta.StartKey):
+		meta.StartKey = source.meta.StartKey
+	case bytes.Equal(source.meta.StartKey, target.meta.EndKey):
+		meta.EndKey = source.meta.EndKey
+	default:
+		panic(errors.Errorf("region %d and %d are not adjacent", sourceID, targetID))
+	}
+	version := source.meta.RegionEpoch.Version
+	if version < meta.RegionEpoch.Version {
+		version = meta.RegionEpoch.Version
+	}
+	meta.RegionEpoch.Version = version + 1
+	merged := newRegionCtx(meta, rm.latches, nil)
+	if err := rm.saveRegions([]*regionCtx{merged}); err != nil {
+		panic(err)
+	}
+	rm.sortedRegions.Delete(newBtreeItem(source))
+	rm.sortedRegions.Delete(newBtreeItem(target))
+	delete(rm.regions, sourceID)
+	rm.regions[targetID] = merged
+	rm.sortedRegions.ReplaceOrInsert(newBtreeItem(merged))
+}
+
 // SplitTable evenly splits the data in table into count regions.
 func (rm *MockRegionManager) SplitTable(tableID int64, count int) {
 	tableStart := tablecodec.GenTableRecordPrefix(tableID)
@@ -56,7 +56,7 @@ func (t BackoffType) metric() prometheus.Observer {
 		return metrics.BackoffHistogramLockFast
 	case BoPDRPC:
 		return metrics.BackoffHistogramPD
-	case BoRegionMiss:
+	case BoRegionMiss, boRegionNotInitialized:
 		return metrics.BackoffHistogramRegionMiss
 	case boTiKVServerBusy, boTiFlashServerBusy:
 		return metrics.BackoffHistogramServerBusy
@@ -100,8 +100,10 @@ func NewBackoffFn(base, cap, jitter int) func(ctx context.Context, maxSleepMs in
 		if maxSleepMs >= 0 && realSleep > maxSleepMs {
 			realSleep = maxSleepMs
 		}
+		timer := time.NewTimer(time.Duration(realSleep) * time.Millisecond)
+		defer timer.Stop()
 		select {
-		case <-time.After(time.Duration(realSleep) * time.Millisecond):
+		case <-timer.C:
 			attempts++
 			lastSleep = sleep
 			return realSleep
@@ -131,6 +133,7 @@ const (
 	boTxnNotFound
 	boStaleCmd
 	boMaxTsNotSynced
+	boRegionNotInitialized
 )
 
 func (t BackoffType) createFn(vars *kv.Variables) func(context.Context, int) int {
@@ -157,6 +160,10 @@ func (t BackoffType) createFn(vars *kv.Variables) func(context.Context, int) int
 		return NewBackoffFn(2, 1000, NoJitter)
 	case boMaxTsNotSynced:
 		return NewBackoffFn(2, 500, NoJitter)
+	case boRegionNotInitialized:
+		// A new peer is initialized once it receives a snapshot from the leader,
+		// which takes longer than the recovery of a region miss.
+		return NewBackoffFn(10, 1000, EqualJitter)
 	}
 	return nil
 }
@@ -185,6 +192,8 @@ func (t BackoffType) String() string {
 		return "txnNotFound"
 	case boMaxTsNotSynced:
 		return "maxTsNotSynced"
+	case boRegionNotInitialized:
+		return "regionNotInitialized"
 	}
 	return ""
 }
@@ -200,7 +209,7 @@ func (t BackoffType) TError() error {
 		return kv.ErrResolveLockTimeout
 	case BoPDRPC:
 		return kv.ErrPDServerTimeout
-	case BoRegionMiss:
+	case BoRegionMiss, boRegionNotInitialized:
 		return kv.ErrRegionUnavailable
 	case boTiKVServerBusy:
 		return kv.ErrTiKVServerBusy
@@ -240,6 +249,10 @@ var (
 
 	// PrewriteMaxBackoff is max sleep time of the `pre-write` command.
 	PrewriteMaxBackoff = 20000
+
+	// ScanRegionNotInitializedMaxBackoff is max sleep time of a scan waiting for a
+	// region to be initialized.
+	ScanRegionNotInitializedMaxBackoff = uint64(10000)
 )
 
 // Backoffer is a utility for retrying queries.
@@ -309,6 +322,17 @@ func (b *Backoffer) Backoff(typ BackoffType, err error) error {
 // BackoffWithMaxSleep sleeps a while base on the backoffType and records the error message
 // and never sleep more than maxSleepMs for each sleep.
 func (b *Backoffer) BackoffWithMaxSleep(typ BackoffType, maxSleepMs int, err error) error {
+	return b.backoff(typ, maxSleepMs, 0, err)
+}
+
+// BackoffWithMinSleep sleeps a while base on the backoffType like Backoff, but sleeps at
+// least minSleepMs, e.g. the backoff time suggested by TiKV. The sleep is counted in the
+// total sleep, and never exceeds the rest of maxSleep.
+func (b *Backoffer) BackoffWithMinSleep(typ BackoffType, minSleepMs int, err error) error {
+	return b.backoff(typ, -1, minSleepMs, err)
+}
+
+func (b *Backoffer) backoff(typ BackoffType, maxSleepMs int, minSleepMs int, err error) error {
 	if strings.Contains(err.Error(), kv.MismatchClusterID) {
 		logutil.BgLogger().Fatal("critical error", zap.Error(err))
 	}
@@ -344,6 +368,18 @@ func (b *Backoffer) BackoffWithMaxSleep(typ BackoffType, maxSleepMs int, err err
 	}
 
 	realSleep := f(b.ctx, maxSleepMs)
+	if b.maxSleep > 0 && minSleepMs > b.maxSleep-b.totalSleep {
+		minSleepMs = b.maxSleep - b.totalSleep
+	}
+	if extra := minSleepMs - realSleep; extra > 0 && b.ctx.Err() == nil {
+		timer := time.NewTimer(time.Duration(extra) * time.Millisecond)
+		select {
+		case <-timer.C:
+			realSleep += extra
+		case <-b.ctx.Done():
+		}
+		timer.Stop()
+	}
 	typ.metric().Observe(float64(realSleep) / 1000)
 	b.totalSleep += realSleep
 	if b.backoffSleepMS == nil {
@@ -368,6 +404,12 @@ func (b *Backoffer) BackoffWithMaxSleep(typ BackoffType, maxSleepMs int, err err
 		}
 	}
 
+	// The sleep is interrupted as soon as the context is done, return instead of
+	// letting the caller retry.
+	if ctxErr := b.ctx.Err(); ctxErr != nil {
+		return errors.Trace(ctxErr)
+	}
+
 	var startTs interface{}
 	if ts := b.ctx.Value(TxnStartKey); ts != nil {
 		startTs = ts
@@ -15,9 +15,12 @@ package tikv
 
 import (
 	"context"
-	"errors"
+	"sync/atomic"
+	"time"
 
 	. "github.com/pingcap/check"
+	"github.com/pingcap/errors"
+	"github.com/pingcap/tidb/store/tikv/kv"
 )
 
 type testBackoffSuite struct {
@@ -31,3 +34,48 @@ func (s *testBackoffSuite) TestBackoffWithMax(c *C) {
 	c.Assert(err, IsNil)
 	c.Assert(b.totalSleep, Equals, 30)
 }
+
+func (s *testBackoffSuite) TestBackoffWithMin(c *C) {
+	b := NewBackofferWithVars(context.TODO(), 250, nil)
+	start := time.Now()
+	// The first sleep of BoTxnLockFast is at most 100ms.
+	err := b.BackoffWithMinSleep(BoTxnLockFast, 300, errors.New("test"))
+	c.Assert(err, IsNil)
+	c.Assert(b.totalSleep, Equals, 300)
+	c.Assert(time.Since(start), GreaterEqual, 300*time.Millisecond)
+
+	// The sleep never exceeds maxSleep.
+	err = b.BackoffWithMinSleep(BoTxnLockFast, 3000, errors.New("test"))
+	c.Assert(err, IsNil)
+	c.Assert(b.totalSleep, Equals, b.maxSleep)
+}
+
+func (s *testBackoffSuite) TestBackoffCanceled(c *C) {
+	ctx, cancel := context.WithCancel(context.Background())
+	b := NewBackofferWithVars(ctx, 20000, nil)
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		cancel()
+	}()
+	start := time.Now()
+	// The first sleep of boTiKVServerBusy is at least 1s.
+	err := b.Backoff(boTiKVServerBusy, errors.New("test"))
+	c.Assert(err, NotNil)
+	c.Assert(time.Since(start), Less, 500*time.Millisecond)
+	c.Assert(errors.Cause(err), Equals, context.Canceled)
+}
+
+func (s *testBackoffSuite) TestBackoffKilled(c *C) {
+	ctx, cancel := context.WithCancel(context.Background())
+	killed := uint32(0)
+	vars := kv.NewVariables(&killed)
+	b := NewBackofferWithVars(ctx, 20000, vars)
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		atomic.StoreUint32(&killed, 1)
+		cancel()
+	}()
+	// The killed statement is interrupted rather than canceled.
+	err := b.Backoff(boTiKVServerBusy, errors.New("test"))
+	c.Assert(errors.Cause(err), Equals, kv.ErrQueryInterrupted)
+}
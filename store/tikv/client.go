@@ -155,6 +155,7 @@ func (a *connArray) Init(addr string, security config.Security, idleNotify *uint
 			grpc.WithUnaryInterceptor(unaryInterceptor),
 			grpc.WithStreamInterceptor(streamInterceptor),
 			grpc.WithDefaultCallOptions(callOptions...),
+			grpc.WithUserAgent(cfg.TiKVClient.ClientLabel),
 			grpc.WithConnectParams(grpc.ConnectParams{
 				Backoff: backoff.Config{
 					BaseDelay:  100 * time.Millisecond, // Default was 1s.
@@ -334,6 +335,31 @@ func (c *RPCClient) updateTiKVSendReqHistogram(req *tikvrpc.Request, start time.
 	v.(prometheus.Observer).Observe(time.Since(start).Seconds())
 }
 
+// connWarmer is implemented by clients which can set up connections in advance.
+type connWarmer interface {
+	WarmupConns(ctx context.Context, addrs []string) error
+}
+
+// WarmupConns sets up the connections to addrs and waits until all of them are
+// ready or ctx is done. Connections which are already set up are reused, so it's
+// safe to warm up the same addresses repeatedly.
+func (c *RPCClient) WarmupConns(ctx context.Context, addrs []string) error {
+	for _, addr := range addrs {
+		array, err := c.getConnArray(addr, true)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		for _, conn := range array.v {
+			for state := conn.GetState(); state != connectivity.Ready; state = conn.GetState() {
+				if !conn.WaitForStateChange(ctx, state) {
+					return errors.Trace(ctx.Err())
+				}
+			}
+		}
+	}
+	return nil
+}
+
 // SendRequest sends a Request to server and receives Response.
 func (c *RPCClient) SendRequest(ctx context.Context, addr string, req *tikvrpc.Request, timeout time.Duration) (*tikvrpc.Response, error) {
 	if span := opentracing.SpanFromContext(ctx); span != nil && span.Tracer() != nil {
@@ -369,7 +395,7 @@ func (c *RPCClient) SendRequest(ctx context.Context, addr string, req *tikvrpc.R
 
 	// TiDB RPC server supports batch RPC, but batch connection will send heart beat, It's not necessary since
 	// request to TiDB is not high frequency.
-	if config.GetGlobalConfig().TiKVClient.MaxBatchSize > 0 && enableBatch {
+	if config.GetGlobalConfig().TiKVClient.MaxBatchSize > 0 && enableBatch && len(req.CallOptions) == 0 && !req.NoBatch {
 		if batchReq := req.ToBatchCommandsRequest(); batchReq != nil {
 			defer trace.StartRegion(ctx, req.Type.String()).End()
 			return sendBatchRequest(ctx, addr, req.ForwardedHost, connArray.batchConn, batchReq, timeout)
@@ -39,6 +39,13 @@ func (r reqCollapse) Close() error {
 	return r.Client.Close()
 }
 
+func (r reqCollapse) WarmupConns(ctx context.Context, addrs []string) error {
+	if w, ok := r.Client.(connWarmer); ok {
+		return w.WarmupConns(ctx, addrs)
+	}
+	return nil
+}
+
 func (r reqCollapse) SendRequest(ctx context.Context, addr string, req *tikvrpc.Request, timeout time.Duration) (*tikvrpc.Response, error) {
 	if r.Client == nil {
 		panic("client should not be nil")
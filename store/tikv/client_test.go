@@ -14,8 +14,10 @@
 package tikv
 
 import (
+	"bytes"
 	"context"
 	"fmt"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"testing"
@@ -29,6 +31,10 @@ import (
 	"github.com/pingcap/kvproto/pkg/tikvpb"
 	"github.com/pingcap/tidb/store/tikv/config"
 	"github.com/pingcap/tidb/store/tikv/tikvrpc"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/connectivity"
+	"google.golang.org/grpc/encoding"
+	"google.golang.org/grpc/encoding/gzip"
 	"google.golang.org/grpc/metadata"
 )
 
@@ -104,6 +110,35 @@ func (s *testClientSuite) TestSendWhenReconnect(c *C) {
 	server.Stop()
 }
 
+func (s *testClientSuite) TestWarmupConns(c *C) {
+	server, port := startMockTikvService()
+	c.Assert(port > 0, IsTrue)
+	defer server.Stop()
+
+	rpcClient := NewRPCClient(config.Security{})
+	defer rpcClient.Close()
+	addr := fmt.Sprintf("%s:%d", "127.0.0.1", port)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	c.Assert(rpcClient.WarmupConns(ctx, []string{addr}), IsNil)
+	conn, err := rpcClient.getConnArray(addr, true)
+	c.Assert(err, IsNil)
+	for _, cc := range conn.v {
+		c.Assert(cc.GetState(), Equals, connectivity.Ready)
+	}
+	// Warming up again reuses the connections.
+	c.Assert(rpcClient.WarmupConns(ctx, []string{addr}), IsNil)
+	conn1, err := rpcClient.getConnArray(addr, true)
+	c.Assert(err, IsNil)
+	c.Assert(conn1, Equals, conn)
+
+	// It gives up when the context is done.
+	ctx, cancel = context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	err = rpcClient.WarmupConns(ctx, []string{"127.0.0.1:1"})
+	c.Assert(errors.Cause(err), Equals, context.DeadlineExceeded)
+}
+
 // chanClient sends received requests to the channel.
 type chanClient struct {
 	wg *sync.WaitGroup
@@ -271,6 +306,74 @@ func (s *testClientSerialSuite) TestForwardMetadataByUnaryCall(c *C) {
 	c.Assert(atomic.LoadUint64(&checkCnt), Equals, uint64(4))
 }
 
+func (s *testClientSerialSuite) TestClientLabel(c *C) {
+	server, port := startMockTikvService()
+	c.Assert(port > 0, IsTrue)
+	defer server.Stop()
+	addr := fmt.Sprintf("%s:%d", "127.0.0.1", port)
+
+	defer config.UpdateGlobal(func(conf *config.Config) {
+		conf.TiKVClient.ClientLabel = "gateway-v2.1"
+	})()
+	rpcClient := NewRPCClient(config.Security{})
+	defer rpcClient.closeConns()
+
+	var checkCnt uint64
+	server.setMetaChecker(func(ctx context.Context) error {
+		atomic.AddUint64(&checkCnt, 1)
+		md, ok := metadata.FromIncomingContext(ctx)
+		c.Assert(ok, IsTrue)
+		vals := md.Get("user-agent")
+		c.Assert(vals, HasLen, 1)
+		c.Assert(strings.HasPrefix(vals[0], "gateway-v2.1 "), IsTrue, Commentf("user agent %s", vals[0]))
+		return nil
+	})
+	prewriteReq := tikvrpc.NewRequest(tikvrpc.CmdPrewrite, &kvrpcpb.PrewriteRequest{})
+	_, err := rpcClient.SendRequest(context.Background(), addr, prewriteReq, 10*time.Second)
+	c.Assert(err, IsNil)
+	c.Assert(atomic.LoadUint64(&checkCnt), Greater, uint64(0))
+}
+
+// testCredentials attaches a metadata entry to every call.
+type testCredentials struct{}
+
+func (testCredentials) GetRequestMetadata(ctx context.Context, uri ...string) (map[string]string, error) {
+	return map[string]string{"x-test": "1"}, nil
+}
+
+func (testCredentials) RequireTransportSecurity() bool { return false }
+
+func (s *testClientSerialSuite) TestCallOptions(c *C) {
+	server, port := startMockTikvService()
+	c.Assert(port > 0, IsTrue)
+	defer server.Stop()
+	addr := fmt.Sprintf("%s:%d", "127.0.0.1", port)
+
+	defer config.UpdateGlobal(func(conf *config.Config) {
+		conf.TiKVClient.MaxBatchSize = 128
+		conf.TiKVClient.GrpcConnectionCount = 1
+	})()
+	rpcClient := NewRPCClient(config.Security{})
+	defer rpcClient.closeConns()
+
+	var checkCnt uint64
+	server.setMetaChecker(func(ctx context.Context) error {
+		atomic.AddUint64(&checkCnt, 1)
+		md, ok := metadata.FromIncomingContext(ctx)
+		c.Assert(ok, IsTrue)
+		c.Assert(md.Get("x-test"), DeepEquals, []string{"1"})
+		return nil
+	})
+	// The requests with call options are sent by unary calls even if batch is enabled.
+	prewriteReq := tikvrpc.NewRequest(tikvrpc.CmdPrewrite, &kvrpcpb.PrewriteRequest{})
+	prewriteReq.CallOptions = []grpc.CallOption{grpc.PerRPCCredentials(testCredentials{})}
+	for i := 0; i < 3; i++ {
+		_, err := rpcClient.SendRequest(context.Background(), addr, prewriteReq, 10*time.Second)
+		c.Assert(err, IsNil)
+	}
+	c.Assert(atomic.LoadUint64(&checkCnt), Equals, uint64(3))
+}
+
 func (s *testClientSerialSuite) TestForwardMetadataByBatchCommands(c *C) {
 	server, port := startMockTikvService()
 	c.Assert(port > 0, IsTrue)
@@ -442,3 +545,37 @@ func (s *testClientSuite) TestBatchCommandsBuilder(c *C) {
 	c.Assert(len(builder.forwardingReqs), Equals, 0)
 	c.Assert(builder.idAlloc, Not(Equals), 0)
 }
+
+// BenchmarkScanResponseCompression shows the CPU cost and the saved bandwidth of
+// compressing scan responses with gzip, which is enabled by grpc-compression-type.
+func BenchmarkScanResponseCompression(b *testing.B) {
+	resp := &kvrpcpb.ScanResponse{}
+	for i := 0; i < 256; i++ {
+		resp.Pairs = append(resp.Pairs, &kvrpcpb.KvPair{
+			Key:   []byte(fmt.Sprintf("t_r_%016d", i)),
+			Value: []byte(fmt.Sprintf("value-%d-%0128d", i, i)),
+		})
+	}
+	data, err := resp.Marshal()
+	if err != nil {
+		b.Fatal(err)
+	}
+	compressor := encoding.GetCompressor(gzip.Name)
+	var buf bytes.Buffer
+	b.SetBytes(int64(len(data)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		buf.Reset()
+		w, err := compressor.Compress(&buf)
+		if err != nil {
+			b.Fatal(err)
+		}
+		if _, err = w.Write(data); err != nil {
+			b.Fatal(err)
+		}
+		if err = w.Close(); err != nil {
+			b.Fatal(err)
+		}
+	}
+	b.ReportMetric(float64(buf.Len())/float64(len(data)), "compression-ratio")
+}
@@ -57,6 +57,18 @@ type TiKVClient struct {
 	// If a store has been up to the limit, it will return error for successive request to
 	// prevent the store occupying too much token in dispatching level.
 	StoreLimit int64 `toml:"store-limit" json:"store-limit"`
+	// RegionScanLimit is the max number of concurrent scan requests sent to the same region.
+	// Requests exceeding the limit wait until others finish. 0 means no limit.
+	RegionScanLimit int64 `toml:"region-scan-limit" json:"region-scan-limit"`
+	// ScanVisibilityCheckRetries is the number of times a scan re-checks the GC safe point
+	// after reloading it, before failing because its snapshot may have been GC'd.
+	ScanVisibilityCheckRetries int64 `toml:"scan-visibility-check-retries" json:"scan-visibility-check-retries"`
+	// ReplicaReadLabelWeights makes replica reads prefer the stores whose labels have
+	// the highest total weight, e.g. stores with faster disks.
+	ReplicaReadLabelWeights []StoreLabelWeight `toml:"replica-read-label-weights" json:"replica-read-label-weights"`
+	// ClientLabel is sent in the gRPC user agent of the connections to TiKV, so that
+	// operators can tell which client the requests come from.
+	ClientLabel string `toml:"client-label" json:"client-label"`
 	// StoreLivenessTimeout is the timeout for store liveness check request.
 	StoreLivenessTimeout string           `toml:"store-liveness-timeout" json:"store-liveness-timeout"`
 	CoprCache            CoprocessorCache `toml:"copr-cache" json:"copr-cache"`
@@ -93,6 +105,13 @@ type CoprocessorCache struct {
 	AdmissionMinProcessMs uint64 `toml:"admission-min-process-ms" json:"-"`
 }
 
+// StoreLabelWeight is the weight of stores with the label Key=Value.
+type StoreLabelWeight struct {
+	Key    string `toml:"key" json:"key"`
+	Value  string `toml:"value" json:"value"`
+	Weight int    `toml:"weight" json:"weight"`
+}
+
 // DefaultTiKVClient returns default config for TiKVClient.
 func DefaultTiKVClient() TiKVClient {
 	return TiKVClient{
@@ -116,9 +135,11 @@ func DefaultTiKVClient() TiKVClient {
 
 		EnableChunkRPC: true,
 
-		RegionCacheTTL:       600,
-		StoreLimit:           0,
-		StoreLivenessTimeout: DefStoreLivenessTimeout,
+		RegionCacheTTL:             600,
+		StoreLimit:                 0,
+		RegionScanLimit:            0,
+		ScanVisibilityCheckRetries: 0,
+		StoreLivenessTimeout:       DefStoreLivenessTimeout,
 
 		TTLRefreshedTxnSize: 32 * 1024 * 1024,
 
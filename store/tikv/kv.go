@@ -77,6 +77,9 @@ type KVStore struct {
 	closed    chan struct{} // this is used to nofity when the store is closed
 
 	replicaReadSeed uint32 // this is used to load balance followers / learners when replica read is enabled
+
+	lockWaiters lockWaitQueue    // this is used to queue the scanners waiting for the same lock fairly
+	scanKills   scanKillRegistry // this is used to cancel the scans of killed connections
 }
 
 // UpdateSPCache updates cached safepoint.
@@ -87,6 +90,17 @@ func (s *KVStore) UpdateSPCache(cachedSP uint64, cachedTime time.Time) {
 	s.spMutex.Unlock()
 }
 
+// reloadSafePoint loads the GC safe point and updates the cached one.
+func (s *KVStore) reloadSafePoint() error {
+	spCachedTime := time.Now()
+	cachedSafePoint, err := loadSafePoint(s.GetSafePointKV())
+	if err != nil {
+		return errors.Trace(err)
+	}
+	s.UpdateSPCache(cachedSafePoint, spCachedTime)
+	return nil
+}
+
 // CheckVisibility checks if it is safe to read using given ts.
 func (s *KVStore) CheckVisibility(startTime uint64) error {
 	s.spMutex.RLock()
@@ -205,6 +219,17 @@ func (s *KVStore) GetSnapshot(ts uint64) *KVSnapshot {
 	return snapshot
 }
 
+// NewLatestScanner creates a Scanner which reads the latest committed versions of the
+// keys in range [startKey, endKey) instead of the keys in a snapshot, by scanning at
+// the max timestamp. It never fails because of GC, but it's NOT a consistent read:
+// every batch sees the data committed before the batch is read, so scanning the same
+// range twice may return different results, and a transaction committed during the
+// scan may be partly visible. It's only meant for best-effort reads like monitoring.
+func (s *KVStore) NewLatestScanner(startKey, endKey []byte, batchSize int, opts ...ScannerOption) (*Scanner, error) {
+	scanner, err := newScanner(s.GetSnapshot(maxTimestamp), startKey, endKey, batchSize, false, opts...)
+	return scanner, errors.Trace(err)
+}
+
 // Close store
 func (s *KVStore) Close() error {
 	s.oracle.Close()
@@ -313,6 +338,29 @@ func (s *KVStore) SendReq(bo *Backoffer, req *tikvrpc.Request, regionID RegionVe
 	return sender.SendReq(bo, req, regionID, timeout)
 }
 
+// WarmupStores sets up the connections to the stores in advance, e.g. before
+// launching many scanners in parallel, so that their first requests don't pay for
+// the connection setup. It returns after the connections are ready or ctx is done.
+func (s *KVStore) WarmupStores(ctx context.Context, storeIDs []uint64) error {
+	w, ok := s.client.(connWarmer)
+	if !ok {
+		return nil
+	}
+	bo := NewBackofferWithVars(ctx, locateRegionMaxBackoff, nil)
+	addrs := make([]string, 0, len(storeIDs))
+	for _, id := range storeIDs {
+		addr, err := s.regionCache.getStoreByStoreID(id).initResolve(bo, s.regionCache)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		if len(addr) == 0 {
+			return errors.Errorf("store %d not found", id)
+		}
+		addrs = append(addrs, addr)
+	}
+	return errors.Trace(w.WarmupConns(ctx, addrs))
+}
+
 // GetRegionCache returns the region cache instance.
 func (s *KVStore) GetRegionCache() *RegionCache {
 	return s.regionCache
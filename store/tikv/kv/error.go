@@ -14,7 +14,11 @@
 package kv
 
 import (
+	"fmt"
+	"time"
+
 	"github.com/pingcap/errors"
+	"github.com/pingcap/kvproto/pkg/errorpb"
 	"github.com/pingcap/kvproto/pkg/kvrpcpb"
 	"github.com/pingcap/kvproto/pkg/pdpb"
 	mysql "github.com/pingcap/tidb/store/tikv/errno"
@@ -26,6 +30,25 @@ var (
 	ErrBodyMissing = errors.New("response body is missing")
 	// ErrTiDBShuttingDown is returned when TiDB is closing and send request to tikv fail, do not retry.
 	ErrTiDBShuttingDown = errors.New("tidb server shutting down")
+	// ErrTiKVDeadlineExceeded is returned when TiKV aborts a request because it runs longer than
+	// the max execution duration carried in the request context, do not retry.
+	ErrTiKVDeadlineExceeded = errors.New("tikv aborts the request because its deadline is exceeded")
+	// ErrScanTooBig is returned when a scanner reads more bytes than its limit.
+	ErrScanTooBig = errors.New("scan reads too many bytes")
+	// ErrScanDeadlineExceeded is returned when a scan takes longer than its max duration.
+	ErrScanDeadlineExceeded = errors.New("scan takes longer than its max duration")
+	// ErrScanMemoryQuotaExceeded is returned when the memory tracker of a scanner reports
+	// that the memory quota is exceeded.
+	ErrScanMemoryQuotaExceeded = errors.New("scan exceeds the memory quota")
+	// ErrDeadlinePartial is returned with the partial results of a best-effort read
+	// when its deadline is exceeded.
+	ErrDeadlinePartial = errors.New("scan deadline is exceeded, results are partial")
+	// ErrRegionCountEstimated is returned with a region count which is estimated
+	// because the region cache doesn't cover the whole range.
+	ErrRegionCountEstimated = errors.New("region count is estimated, the region cache is incomplete")
+	// ErrEmptyRange is returned when a scanner created with WithEmptyRangeError finds
+	// no pairs in its range.
+	ErrEmptyRange = errors.New("scan range is empty")
 )
 
 // MismatchClusterID represents the message that the cluster ID of the PD client does not match the PD.
@@ -119,6 +142,161 @@ func NewErrWriteConfictWithArgs(startTs, conflictTs, conflictCommitTs uint64, ke
 	return &ErrWriteConflict{WriteConflict: &conflict}
 }
 
+// ErrTooManyRegions is returned when a scan is going to touch more regions than its limit.
+type ErrTooManyRegions struct {
+	Limit int
+	Count int
+}
+
+func (e *ErrTooManyRegions) Error() string {
+	return fmt.Sprintf("scan touches %d regions, which exceeds the limit %d", e.Count, e.Limit)
+}
+
+// ErrTooManyLocks is returned when a scan is going to resolve more locks than its limit.
+type ErrTooManyLocks struct {
+	Limit int
+}
+
+func (e *ErrTooManyLocks) Error() string {
+	return fmt.Sprintf("scan is going to resolve more than %d locks", e.Limit)
+}
+
+// ErrRangeSpansRegions is returned when a scan restricted to a single region is
+// going to read Key, which is out of region RegionID.
+type ErrRangeSpansRegions struct {
+	RegionID uint64
+	Key      []byte
+}
+
+func (e *ErrRangeSpansRegions) Error() string {
+	return fmt.Sprintf("scan range spans more than region %d, key %s is out of it", e.RegionID, StrKey(e.Key))
+}
+
+// ErrSchemaChanged is returned when the fence token of a scan has advanced since the
+// scan started, e.g. because of a schema change.
+type ErrSchemaChanged struct {
+	StartToken   uint64
+	CurrentToken uint64
+}
+
+func (e *ErrSchemaChanged) Error() string {
+	return fmt.Sprintf("fence token advances from %d to %d during the scan", e.StartToken, e.CurrentToken)
+}
+
+// ErrKeyOutOfRange is returned when a scan with range verification receives Key,
+// which is out of its range [StartKey, EndKey).
+type ErrKeyOutOfRange struct {
+	Key      []byte
+	StartKey []byte
+	EndKey   []byte
+}
+
+func (e *ErrKeyOutOfRange) Error() string {
+	return fmt.Sprintf("key %s is out of scan range [%s, %s)", StrKey(e.Key), StrKey(e.StartKey), StrKey(e.EndKey))
+}
+
+// ErrDuplicateKey is returned when a scan with duplicate check returns Key more than
+// once.
+type ErrDuplicateKey struct {
+	Key []byte
+}
+
+func (e *ErrDuplicateKey) Error() string {
+	return fmt.Sprintf("key %s is returned more than once by the scan", StrKey(e.Key))
+}
+
+// ErrTxnLockWait is returned when a reader is set not to wait for locks and
+// meets a lock of a transaction which is still alive.
+type ErrTxnLockWait struct {
+	Key []byte
+	TTL uint64
+}
+
+func (e *ErrTxnLockWait) Error() string {
+	return fmt.Sprintf("key %s is locked by a live transaction, ttl: %d", StrKey(e.Key), e.TTL)
+}
+
+// ErrRegionErrorNotRetriable wraps a region error which can't be fixed by retrying
+// the request, e.g. the region keeps being reported as not found after the region
+// cache is reloaded.
+type ErrRegionErrorNotRetriable struct {
+	RegionID uint64
+	Err      *errorpb.Error
+}
+
+func (e *ErrRegionErrorNotRetriable) Error() string {
+	return fmt.Sprintf("region %d reports a non-retriable error: %s", e.RegionID, e.Err)
+}
+
+// ErrInconsistentSnapshot is returned when region RegionID is going to be read at
+// ReadTS rather than the snapshot version StartTS of the scan.
+type ErrInconsistentSnapshot struct {
+	RegionID uint64
+	StartTS  uint64
+	ReadTS   uint64
+}
+
+func (e *ErrInconsistentSnapshot) Error() string {
+	return fmt.Sprintf("region %d is read at %d, which is inconsistent with the snapshot %d", e.RegionID, e.ReadTS, e.StartTS)
+}
+
+// ErrRegionNotInitialized is returned when a region keeps being reported as not
+// initialized by TiKV after the retries, e.g. a scan right after the cluster is
+// bootstrapped, before the peers of the region receive their first snapshots.
+type ErrRegionNotInitialized struct {
+	RegionID uint64
+	Waited   time.Duration
+}
+
+func (e *ErrRegionNotInitialized) Error() string {
+	return fmt.Sprintf("region %d is still not initialized after waiting for %v", e.RegionID, e.Waited)
+}
+
+// ErrRegionMerged is returned by the scanners created with RegionMergeFail when
+// region RegionID has been merged into region MergedInto in the middle of the scan.
+type ErrRegionMerged struct {
+	RegionID   uint64
+	MergedInto uint64
+}
+
+func (e *ErrRegionMerged) Error() string {
+	return fmt.Sprintf("region %d has been merged into region %d", e.RegionID, e.MergedInto)
+}
+
+// ErrUnsupportedScanCursor is returned when decoding a scan cursor encoded in an
+// unknown format, e.g. by a newer version.
+type ErrUnsupportedScanCursor struct {
+	Format byte
+}
+
+func (e *ErrUnsupportedScanCursor) Error() string {
+	return fmt.Sprintf("unsupported scan cursor format %d", e.Format)
+}
+
+// ErrFutureTimestamp is returned by the scanners created with
+// WithFutureTimestampCheck when the scan version is ahead of the current timestamp
+// of PD.
+type ErrFutureTimestamp struct {
+	Version uint64
+	Current uint64
+}
+
+func (e *ErrFutureTimestamp) Error() string {
+	return fmt.Sprintf("scan version %d is ahead of the current timestamp %d", e.Version, e.Current)
+}
+
+// ErrResumeOrderViolation is returned by the scanners created with
+// WithResumeOrderCheck when the first key of a resumed scan isn't greater than the
+// last key returned before the scan was paused.
+type ErrResumeOrderViolation struct {
+	Key     []byte
+	LastKey []byte
+}
+
+func (e *ErrResumeOrderViolation) Error() string {
+	return fmt.Sprintf("resumed scan returns key %s, which isn't greater than the last returned key %s", StrKey(e.Key), StrKey(e.LastKey))
+}
+
 // ErrRetryable wraps *kvrpcpb.Retryable to implement the error interface.
 type ErrRetryable struct {
 	Retryable string
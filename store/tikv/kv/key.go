@@ -26,6 +26,21 @@ func NextKey(k []byte) []byte {
 	return buf
 }
 
+// PrefixNextKey returns the smallest key which is greater than all keys with prefix
+// k, i.e. the exclusive upper bound of the keys with prefix k. It returns nil, which
+// stands for +inf, if there is no such key.
+func PrefixNextKey(k []byte) []byte {
+	buf := make([]byte, len(k))
+	copy(buf, k)
+	for i := len(buf) - 1; i >= 0; i-- {
+		buf[i]++
+		if buf[i] != 0 {
+			return buf[:i+1]
+		}
+	}
+	return nil
+}
+
 // CmpKey returns the comparison result of two key.
 // The result will be 0 if a==b, -1 if a < b, and +1 if a > b.
 func CmpKey(k, another []byte) int {
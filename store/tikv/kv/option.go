@@ -21,9 +21,13 @@ const (
 	SchemaChecker
 	// IsolationLevel sets isolation level for current transaction. The default level is SI.
 	IsolationLevel
-	// Priority marks the priority of this transaction.
+	// Priority marks the priority of this transaction. TiKV also picks the read pool
+	// level of the reads by it, e.g. PriorityLow keeps heavy scans from starving point
+	// reads. kvrpcpb.Context has no separate read pool hint to set.
 	Priority
 	// NotFillCache makes this request do not touch the LRU cache of the underlying storage.
+	// KvGet and KvScan requests are never served by the coprocessor cache, so there is
+	// no separate option to bypass it.
 	NotFillCache
 	// SyncLog decides whether the WAL(write-ahead log) of this request should be synchronized.
 	SyncLog
@@ -57,10 +61,17 @@ const (
 	TxnScope
 	// StalenessReadOnly indicates whether the transaction is staleness read only transaction
 	IsStalenessReadOnly
-	// MatchStoreLabels indicates the labels the store should be matched
+	// MatchStoreLabels indicates the labels the store should be matched. Replica reads
+	// prefer the matched stores, e.g. the stores in the same zone, and fall back to the
+	// others if there is no matched store.
 	MatchStoreLabels
 	// KVFilter filters out the key-value pairs in the memBuf that is unnecessary to be committed
 	KVFilter
+	// MaxExecutionTime sets the max execution duration (in milliseconds) of scan requests, which is enforced by TiKV.
+	MaxExecutionTime
+	// BypassLocks sets the start timestamps ([]uint64) of the transactions whose locks are ignored by reads,
+	// e.g. the background transactions known to be safe to read through.
+	BypassLocks
 )
 
 // Priority value for transaction priority.
@@ -20,6 +20,11 @@ import (
 // StoreLimit will update from config reload and global variable set.
 var StoreLimit atomic.Int64
 
+// ScanVisibilityCheckRetries is the number of times a scan retries a failed
+// visibility check after reloading the GC safe point, 0 means no retry. It will
+// update from config.
+var ScanVisibilityCheckRetries atomic.Int64
+
 // ReplicaReadType is the type of replica to read data from
 type ReplicaReadType byte
 
@@ -30,6 +35,9 @@ const (
 	ReplicaReadFollower
 	// ReplicaReadMixed stands for 'read from leader and follower and learner'.
 	ReplicaReadMixed
+	// ReplicaReadLearner stands for 'read from learner', it falls back to 'read from
+	// follower' if there is no available learner.
+	ReplicaReadLearner
 )
 
 // IsFollowerRead checks if leader is going to be used to read data.
@@ -24,6 +24,10 @@ type Variables struct {
 	// Hook is used for test to verify the variable take effect.
 	Hook func(name string, vars *Variables)
 
+	// ScanPrefetchBatches is the number of batches the scanners fetch ahead in the
+	// background, 0 means the scanners don't prefetch.
+	ScanPrefetchBatches int
+
 	// Pointer to SessionVars.Killed
 	// Killed is a flag to indicate that this query is killed.
 	Killed *uint32
@@ -59,6 +59,8 @@ var (
 	TiKVPanicCounter                       *prometheus.CounterVec
 	TiKVForwardRequestCounter              *prometheus.CounterVec
 	TiKVTSFutureWaitDuration               prometheus.Histogram
+	TiKVScanBatchKeysHistogram             prometheus.Histogram
+	TiKVScanBatchSizeHistogram             prometheus.Histogram
 )
 
 // Label constants.
@@ -414,6 +416,24 @@ func initMetrics(namespace, subsystem string) {
 			Buckets:   prometheus.ExponentialBuckets(0.000005, 2, 30), // 5us ~ 2560s
 		})
 
+	TiKVScanBatchKeysHistogram = prometheus.NewHistogram(
+		prometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "scan_batch_keys",
+			Help:      "Bucketed histogram of the number of keys of the batches read by scanners.",
+			Buckets:   prometheus.ExponentialBuckets(1, 2, 16), // 1 ~ 32768
+		})
+
+	TiKVScanBatchSizeHistogram = prometheus.NewHistogram(
+		prometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "scan_batch_size_bytes",
+			Help:      "Bucketed histogram of the size of the batches read by scanners.",
+			Buckets:   prometheus.ExponentialBuckets(16, 4, 14), // 16B ~ 1G
+		})
+
 	initShortcuts()
 }
 
@@ -468,6 +488,8 @@ func RegisterMetrics() {
 	prometheus.MustRegister(TiKVPanicCounter)
 	prometheus.MustRegister(TiKVForwardRequestCounter)
 	prometheus.MustRegister(TiKVTSFutureWaitDuration)
+	prometheus.MustRegister(TiKVScanBatchKeysHistogram)
+	prometheus.MustRegister(TiKVScanBatchSizeHistogram)
 }
 
 // readCounter reads the value of a prometheus.Counter.
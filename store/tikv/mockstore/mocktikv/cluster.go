@@ -359,6 +359,14 @@ func (c *Cluster) AddPeer(regionID, storeID, peerID uint64) {
 	c.regions[regionID].addPeer(peerID, storeID)
 }
 
+// AddLearner adds a new learner Peer for the Region on the Store.
+func (c *Cluster) AddLearner(regionID, storeID, peerID uint64) {
+	c.Lock()
+	defer c.Unlock()
+
+	c.regions[regionID].addLearner(peerID, storeID)
+}
+
 // RemovePeer removes the Peer from the Region. Note that if the Peer is leader,
 // the Region will have no leader before calling ChangeLeader().
 func (c *Cluster) RemovePeer(regionID, storeID uint64) {
@@ -582,6 +590,13 @@ func (r *Region) addPeer(peerID, storeID uint64) {
 	r.incConfVer()
 }
 
+func (r *Region) addLearner(peerID, storeID uint64) {
+	peer := newPeerMeta(peerID, storeID)
+	peer.Role = metapb.PeerRole_Learner
+	r.Meta.Peers = append(r.Meta.Peers, peer)
+	r.incConfVer()
+}
+
 func (r *Region) removePeer(peerID uint64) {
 	for i, peer := range r.Meta.Peers {
 		if peer.GetId() == peerID {
@@ -271,7 +271,15 @@ func (c *RawKVClient) DeleteRange(startKey []byte, endKey []byte) error {
 // If you want to exclude the startKey or include the endKey, push a '\0' to the key. For example, to scan
 // (startKey, endKey], you can write:
 // `Scan(push(startKey, '\0'), push(endKey, '\0'), limit)`.
+// Expired entries are skipped by TiKV itself when TTL is enabled on the server; the
+// response carries no expiry, so they can't be returned separately.
 func (c *RawKVClient) Scan(startKey, endKey []byte, limit int) (keys [][]byte, values [][]byte, err error) {
+	return c.ScanCF("", startKey, endKey, limit)
+}
+
+// ScanCF is Scan on column family cf, e.g. to scan the data stored in a CF other than
+// the default one separately. An empty cf means the default CF.
+func (c *RawKVClient) ScanCF(cf string, startKey, endKey []byte, limit int) (keys [][]byte, values [][]byte, err error) {
 	start := time.Now()
 	defer func() { metrics.RawkvCmdHistogramWithRawScan.Observe(time.Since(start).Seconds()) }()
 
@@ -284,6 +292,7 @@ func (c *RawKVClient) Scan(startKey, endKey []byte, limit int) (keys [][]byte, v
 			StartKey: startKey,
 			EndKey:   endKey,
 			Limit:    uint32(limit - len(keys)),
+			Cf:       cf,
 		})
 		resp, loc, err := c.sendReq(startKey, req, false)
 		if err != nil {
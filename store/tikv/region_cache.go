@@ -18,6 +18,8 @@ import (
 	"context"
 	"fmt"
 	"math/rand"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"sync/atomic"
@@ -140,6 +142,22 @@ func (r *RegionStore) follower(seed uint32, op *storeSelectorOp) AccessIndex {
 		return r.workTiKVIdx
 	}
 
+	if len(op.labelWeights) > 0 {
+		candidates := make([]AccessIndex, 0, l-1)
+		for i := AccessIndex(0); i < AccessIndex(l); i++ {
+			storeIdx, s := r.accessStore(TiKVOnly, i)
+			if i == r.workTiKVIdx || r.storeEpochs[storeIdx] != atomic.LoadUint32(&s.epoch) || !r.filterStoreCandidate(i, op) {
+				continue
+			}
+			candidates = append(candidates, i)
+		}
+		candidates = r.preferWeightedStores(candidates, op)
+		if len(candidates) == 0 {
+			return r.workTiKVIdx
+		}
+		return candidates[seed%uint32(len(candidates))]
+	}
+
 	for retry := l - 1; retry > 0; retry-- {
 		followerIdx := AccessIndex(seed % (l - 1))
 		if followerIdx >= r.workTiKVIdx {
@@ -164,14 +182,38 @@ func (r *RegionStore) kvPeer(seed uint32, op *storeSelectorOp) AccessIndex {
 		}
 		candidates = append(candidates, AccessIndex(i))
 	}
+	candidates = r.preferWeightedStores(candidates, op)
 	if len(candidates) == 0 {
 		return r.workTiKVIdx
 	}
 	return candidates[seed%uint32(len(candidates))]
 }
 
+// preferWeightedStores returns the candidates whose stores have the highest total
+// label weight, or all candidates if there are no label weights.
+func (r *RegionStore) preferWeightedStores(candidates []AccessIndex, op *storeSelectorOp) []AccessIndex {
+	if len(op.labelWeights) == 0 || len(candidates) <= 1 {
+		return candidates
+	}
+	var preferred []AccessIndex
+	maxWeight := 0
+	for _, aidx := range candidates {
+		_, s := r.accessStore(TiKVOnly, aidx)
+		weight := s.labelWeight(op.labelWeights)
+		if len(preferred) == 0 || weight > maxWeight {
+			preferred, maxWeight = append(preferred[:0], aidx), weight
+		} else if weight == maxWeight {
+			preferred = append(preferred, aidx)
+		}
+	}
+	return preferred
+}
+
 func (r *RegionStore) filterStoreCandidate(aidx AccessIndex, op *storeSelectorOp) bool {
 	_, s := r.accessStore(TiKVOnly, aidx)
+	if op.storeID != 0 && s.storeID != op.storeID {
+		return false
+	}
 	// filter label unmatched store
 	return s.IsLabelsMatch(op.labels)
 }
@@ -286,6 +328,11 @@ type RegionCache struct {
 	notifyCheckCh chan struct{}
 	closeCh       chan struct{}
 
+	// reloadSf coalesces concurrent reloads of the same invalidated region.
+	reloadSf singleflight.Group
+	// scanTokens limits the number of concurrent scan requests sent to each region.
+	scanTokens *regionScanTokens
+
 	testingKnobs struct {
 		// Replace the requestLiveness function for test purpose. Note that in unit tests, if this is not set,
 		// requestLiveness always returns unreachable.
@@ -301,6 +348,7 @@ func NewRegionCache(pdClient pd.Client) *RegionCache {
 	c.mu.regions = make(map[RegionVerID]*Region)
 	c.mu.sorted = btree.New(btreeDegree)
 	c.storeMu.stores = make(map[uint64]*Store)
+	c.scanTokens = newRegionScanTokens()
 	c.notifyCheckCh = make(chan struct{}, 1)
 	c.closeCh = make(chan struct{})
 	interval := config.GetGlobalConfig().StoresRefreshInterval
@@ -399,7 +447,9 @@ func (c *RPCContext) String() string {
 }
 
 type storeSelectorOp struct {
-	labels []*metapb.StoreLabel
+	labels       []*metapb.StoreLabel
+	labelWeights []config.StoreLabelWeight
+	storeID      uint64
 }
 
 // StoreSelectorOption configures storeSelectorOp.
@@ -412,6 +462,22 @@ func WithMatchLabels(labels []*metapb.StoreLabel) StoreSelectorOption {
 	}
 }
 
+// WithLabelWeights indicates preferring the stores whose labels have the highest
+// total weight among the candidates of replica reads.
+func WithLabelWeights(weights []config.StoreLabelWeight) StoreSelectorOption {
+	return func(op *storeSelectorOp) {
+		op.labelWeights = weights
+	}
+}
+
+// WithStoreID indicates selecting the store with the id among the candidates of
+// replica reads, e.g. to read from a specific replica.
+func WithStoreID(storeID uint64) StoreSelectorOption {
+	return func(op *storeSelectorOp) {
+		op.storeID = storeID
+	}
+}
+
 // GetTiKVRPCContext returns RPCContext for a region. If it returns nil, the region
 // must be out of date and already dropped from cache.
 func (c *RegionCache) GetTiKVRPCContext(bo *Backoffer, id RegionVerID, replicaRead kv.ReplicaReadType, followerStoreSeed uint32, opts ...StoreSelectorOption) (*RPCContext, error) {
@@ -459,6 +525,8 @@ func (c *RegionCache) GetTiKVRPCContext(bo *Backoffer, id RegionVerID, replicaRe
 		store, peer, accessIdx, storeIdx = cachedRegion.FollowerStorePeer(regionStore, followerStoreSeed, options)
 	case kv.ReplicaReadMixed:
 		store, peer, accessIdx, storeIdx = cachedRegion.AnyStorePeer(regionStore, followerStoreSeed, options)
+	case kv.ReplicaReadLearner:
+		store, peer, accessIdx, storeIdx = cachedRegion.LearnerStorePeer(regionStore, followerStoreSeed, options)
 	default:
 		isLeaderReq = true
 		store, peer, accessIdx, storeIdx = cachedRegion.WorkStorePeer(regionStore)
@@ -637,7 +705,7 @@ func (c *RegionCache) findRegionByKey(bo *Backoffer, key []byte, isEndKey bool)
 	r = c.searchCachedRegion(key, isEndKey)
 	if r == nil {
 		// load region when it is not exists or expired.
-		lr, err := c.loadRegion(bo, key, isEndKey)
+		lr, err := c.loadRegionCoalesced(bo, key, isEndKey)
 		if err != nil {
 			// no region data, return error if failure.
 			return nil, err
@@ -665,6 +733,56 @@ func (c *RegionCache) findRegionByKey(bo *Backoffer, key []byte, isEndKey bool)
 	return r, nil
 }
 
+// loadRegionCoalesced loads the region of key from PD like loadRegion. If key is in
+// a cached region which has been invalidated, concurrent loads of the keys in that
+// region share one PD request, so that a stale region discovered by many requests
+// at the same time doesn't flood PD. The region is loaded again if the shared result
+// doesn't contain key, e.g. the stale region has been split.
+func (c *RegionCache) loadRegionCoalesced(bo *Backoffer, key []byte, isEndKey bool) (*Region, error) {
+	stale := c.searchStaleRegion(key, isEndKey)
+	if stale == nil {
+		return c.loadRegion(bo, key, isEndKey)
+	}
+	rsC := c.reloadSf.DoChan(strconv.FormatUint(stale.GetID(), 10), func() (interface{}, error) {
+		// The load outlives the caller if the caller returns early, so it can't use
+		// the Backoffer of the caller.
+		return c.loadRegion(NewBackofferWithVars(context.Background(), locateRegionMaxBackoff, nil), key, isEndKey)
+	})
+	select {
+	case <-bo.ctx.Done():
+		return nil, errors.Trace(bo.ctx.Err())
+	case rs := <-rsC:
+		if rs.Err != nil {
+			return nil, errors.Trace(rs.Err)
+		}
+		r := rs.Val.(*Region)
+		if !isEndKey && r.Contains(key) || isEndKey && r.ContainsByEnd(key) {
+			return r, nil
+		}
+		return c.loadRegion(bo, key, isEndKey)
+	}
+}
+
+// searchStaleRegion finds the cached region containing key no matter whether it's
+// still valid.
+func (c *RegionCache) searchStaleRegion(key []byte, isEndKey bool) *Region {
+	var r *Region
+	c.mu.RLock()
+	c.mu.sorted.DescendLessOrEqual(newBtreeSearchItem(key), func(item btree.Item) bool {
+		r = item.(*btreeItem).cachedRegion
+		if isEndKey && bytes.Equal(r.StartKey(), key) {
+			r = nil
+			return true
+		}
+		return false
+	})
+	c.mu.RUnlock()
+	if r != nil && (!isEndKey && r.Contains(key) || isEndKey && r.ContainsByEnd(key)) {
+		return r
+	}
+	return nil
+}
+
 // OnSendFail handles send request fail logic.
 func (c *RegionCache) OnSendFail(bo *Backoffer, ctx *RPCContext, scheduleReload bool, err error) {
 	metrics.RegionCacheCounterWithSendFail.Inc()
@@ -801,12 +919,19 @@ func (c *RegionCache) GroupKeysByRegion(bo *Backoffer, keys [][]byte, filter fun
 	groups := make(map[RegionVerID][][]byte)
 	var first RegionVerID
 	var lastLoc *KeyLocation
+	var prefetched bool
 	for i, k := range keys {
 		if lastLoc == nil || !lastLoc.Contains(k) {
-			var err error
-			lastLoc, err = c.LocateKey(bo, k)
-			if err != nil {
-				return nil, first, errors.Trace(err)
+			if lastLoc = c.tryLocateKey(k); lastLoc == nil {
+				if !prefetched {
+					c.loadMissingRegions(bo, keys[i:])
+					prefetched = true
+				}
+				var err error
+				lastLoc, err = c.LocateKey(bo, k)
+				if err != nil {
+					return nil, first, errors.Trace(err)
+				}
 			}
 			if filter != nil && filter(k, lastLoc.StartKey) {
 				continue
@@ -821,6 +946,102 @@ func (c *RegionCache) GroupKeysByRegion(bo *Backoffer, keys [][]byte, filter fun
 	return groups, first, nil
 }
 
+// LocateKeys locates the regions of keys like LocateKey, and returns the locations
+// in the order of keys. When a key misses the cache, the regions of the keys after
+// it missing from the cache are loaded from PD in batches, so that locating many
+// keys with a cold cache takes a few PD requests instead of a request per key.
+func (c *RegionCache) LocateKeys(bo *Backoffer, keys [][]byte) ([]*KeyLocation, error) {
+	locs := make([]*KeyLocation, len(keys))
+	var lastLoc *KeyLocation
+	var prefetched bool
+	for i, k := range keys {
+		if lastLoc == nil || !lastLoc.Contains(k) {
+			if lastLoc = c.tryLocateKey(k); lastLoc == nil {
+				if !prefetched {
+					c.loadMissingRegions(bo, keys[i:])
+					prefetched = true
+				}
+				var err error
+				lastLoc, err = c.LocateKey(bo, k)
+				if err != nil {
+					return nil, errors.Trace(err)
+				}
+			}
+		}
+		locs[i] = lastLoc
+	}
+	return locs, nil
+}
+
+// tryLocateKey locates the key like LocateKey but only from the cache. It returns
+// nil if the region of the key isn't cached or needs to be reloaded.
+func (c *RegionCache) tryLocateKey(key []byte) *KeyLocation {
+	r := c.searchCachedRegion(key, false)
+	if r == nil || r.checkNeedReload() {
+		return nil
+	}
+	return &KeyLocation{
+		Region:   r.VerID(),
+		StartKey: r.StartKey(),
+		EndKey:   r.EndKey(),
+	}
+}
+
+// loadMissingRegions loads the regions of the keys which aren't in the cache by
+// ScanRegions. Every request starts from the smallest key not loaded yet and loads
+// at most as many regions as the keys left, so the regions between sparse keys are
+// loaded only up to the number of keys. A single missing key is left to LocateKey.
+// The regions without a leader are skipped by ScanRegions, so LocateKey still loads
+// them one by one. It's only a prefetch, the errors are logged and ignored, and the
+// keys are left to LocateKey. It backs off with its own Backoffer, so a failed
+// prefetch doesn't use up the retries of the caller.
+func (c *RegionCache) loadMissingRegions(bo *Backoffer, keys [][]byte) {
+	bo = NewBackofferWithVars(bo.ctx, locateRegionMaxBackoff, bo.vars)
+	var (
+		missing [][]byte
+		last    *Region
+	)
+	for _, k := range keys {
+		if last != nil && last.Contains(k) {
+			continue
+		}
+		if last = c.searchCachedRegion(k, false); last == nil {
+			missing = append(missing, k)
+		}
+	}
+	if len(missing) < 2 {
+		return
+	}
+	sort.Slice(missing, func(i, j int) bool {
+		return bytes.Compare(missing[i], missing[j]) < 0
+	})
+	endKey := kv.NextKey(missing[len(missing)-1])
+	for i := 0; i < len(missing); {
+		limit := len(missing) - i
+		if limit > defaultRegionsPerBatch {
+			limit = defaultRegionsPerBatch
+		}
+		regions, err := c.BatchLoadRegionsWithKeyRange(bo, missing[i], endKey, limit)
+		if err != nil {
+			logutil.Logger(bo.ctx).Warn("batch load regions failed, locate the keys one by one",
+				zap.String("key", kv.StrKey(missing[i])),
+				zap.Int("limit", limit),
+				zap.Error(err))
+			return
+		}
+		// The last region of the cluster covers the rest of the keys. The rest are left
+		// to LocateKey if the last region doesn't end after the key, e.g. the regions
+		// are changing.
+		loadedEnd := regions[len(regions)-1].EndKey()
+		if len(loadedEnd) == 0 || bytes.Compare(loadedEnd, missing[i]) <= 0 {
+			break
+		}
+		for i < len(missing) && bytes.Compare(missing[i], loadedEnd) < 0 {
+			i++
+		}
+	}
+}
+
 type groupedMutations struct {
 	region    RegionVerID
 	mutations CommitterMutations
@@ -829,8 +1050,9 @@ type groupedMutations struct {
 // groupSortedMutationsByRegion separates keys into groups by their belonging Regions.
 func (c *RegionCache) groupSortedMutationsByRegion(bo *Backoffer, m CommitterMutations) ([]groupedMutations, error) {
 	var (
-		groups  []groupedMutations
-		lastLoc *KeyLocation
+		groups     []groupedMutations
+		lastLoc    *KeyLocation
+		prefetched bool
 	)
 	lastUpperBound := 0
 	for i := 0; i < m.Len(); i++ {
@@ -842,10 +1064,16 @@ func (c *RegionCache) groupSortedMutationsByRegion(bo *Backoffer, m CommitterMut
 				})
 				lastUpperBound = i
 			}
-			var err error
-			lastLoc, err = c.LocateKey(bo, m.GetKey(i))
-			if err != nil {
-				return nil, errors.Trace(err)
+			if lastLoc = c.tryLocateKey(m.GetKey(i)); lastLoc == nil {
+				if !prefetched {
+					c.loadMissingRegions(bo, m.Slice(i, m.Len()).GetKeys())
+					prefetched = true
+				}
+				var err error
+				lastLoc, err = c.LocateKey(bo, m.GetKey(i))
+				if err != nil {
+					return nil, errors.Trace(err)
+				}
 			}
 		}
 	}
@@ -1028,6 +1256,53 @@ func (c *RegionCache) searchCachedRegion(key []byte, isEndKey bool) *Region {
 	return nil
 }
 
+// countCachedRegions counts the regions in range [startKey, endKey) by the cached
+// regions, without loading any region from PD. Each part of the range which isn't
+// covered by the cache is counted as one region, and complete is false if there
+// is any.
+func (c *RegionCache) countCachedRegions(startKey, endKey []byte) (count int, complete bool) {
+	ts := time.Now().Unix()
+	key, finished := startKey, false
+	complete = true
+	visit := func(r *Region) bool {
+		// Expired regions are treated as uncached, without refreshing the TTL of the
+		// others like checkRegionCacheTTL, since they are not accessed.
+		if (len(r.EndKey()) > 0 && bytes.Compare(r.EndKey(), key) <= 0) || ts-atomic.LoadInt64(&r.lastAccess) > RegionCacheTTLSec {
+			return true
+		}
+		if bytes.Compare(r.StartKey(), key) > 0 {
+			// There is a gap before the region.
+			count++
+			complete = false
+			key = r.StartKey()
+			if len(endKey) > 0 && bytes.Compare(key, endKey) >= 0 {
+				finished = true
+				return false
+			}
+		}
+		count++
+		key = r.EndKey()
+		finished = len(key) == 0 || (len(endKey) > 0 && bytes.Compare(key, endKey) >= 0)
+		return !finished
+	}
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	pivot := newBtreeSearchItem(startKey)
+	c.mu.sorted.DescendLessOrEqual(pivot, func(item btree.Item) bool {
+		pivot = item.(*btreeItem)
+		return false
+	})
+	c.mu.sorted.AscendGreaterOrEqual(pivot, func(item btree.Item) bool {
+		return visit(item.(*btreeItem).cachedRegion)
+	})
+	if !finished {
+		count++
+		complete = false
+	}
+	return count, complete
+}
+
 // getRegionByIDFromCache tries to get region by regionID from cache. Like
 // `getCachedRegion`, it should be called with c.mu.RLock(), and the returned
 // Region should not be used after c.mu is RUnlock().
@@ -1510,6 +1785,25 @@ func (r *Region) AnyStorePeer(rs *RegionStore, followerStoreSeed uint32, op *sto
 	return r.getKvStorePeer(rs, rs.kvPeer(followerStoreSeed, op))
 }
 
+// LearnerStorePeer returns a learner store with learner peer. It returns a follower
+// store if there is no available learner.
+func (r *Region) LearnerStorePeer(rs *RegionStore, followerStoreSeed uint32, op *storeSelectorOp) (store *Store, peer *metapb.Peer, accessIdx AccessIndex, storeIdx int) {
+	candidates := make([]AccessIndex, 0, rs.accessStoreNum(TiKVOnly))
+	for i := 0; i < rs.accessStoreNum(TiKVOnly); i++ {
+		storeIdx, s := rs.accessStore(TiKVOnly, AccessIndex(i))
+		if r.meta.Peers[storeIdx].GetRole() != metapb.PeerRole_Learner ||
+			rs.storeEpochs[storeIdx] != atomic.LoadUint32(&s.epoch) || !rs.filterStoreCandidate(AccessIndex(i), op) {
+			continue
+		}
+		candidates = append(candidates, AccessIndex(i))
+	}
+	candidates = rs.preferWeightedStores(candidates, op)
+	if len(candidates) == 0 {
+		return r.FollowerStorePeer(rs, followerStoreSeed, op)
+	}
+	return r.getKvStorePeer(rs, candidates[followerStoreSeed%uint32(len(candidates))])
+}
+
 // RegionVerID is a unique ID that can identify a Region at a specific version.
 type RegionVerID struct {
 	id      uint64
@@ -1870,6 +2164,25 @@ func (s *Store) IsSameLabels(labels []*metapb.StoreLabel) bool {
 	return s.IsLabelsMatch(labels)
 }
 
+// GetLabels returns the labels of the store.
+func (s *Store) GetLabels() []*metapb.StoreLabel {
+	return s.labels
+}
+
+// labelWeight returns the total weight of the store's labels.
+func (s *Store) labelWeight(weights []config.StoreLabelWeight) int {
+	total := 0
+	for _, w := range weights {
+		for _, label := range s.labels {
+			if label.Key == w.Key && label.Value == w.Value {
+				total += w.Weight
+				break
+			}
+		}
+	}
+	return total
+}
+
 // IsLabelsMatch return whether the store's labels match the target labels
 func (s *Store) IsLabelsMatch(labels []*metapb.StoreLabel) bool {
 	if len(labels) < 1 {
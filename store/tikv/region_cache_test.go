@@ -18,6 +18,7 @@ import (
 	"errors"
 	"fmt"
 	"math/rand"
+	"sync"
 	"sync/atomic"
 	"testing"
 	"time"
@@ -26,6 +27,7 @@ import (
 	. "github.com/pingcap/check"
 	"github.com/pingcap/kvproto/pkg/errorpb"
 	"github.com/pingcap/kvproto/pkg/metapb"
+	"github.com/pingcap/tidb/store/tikv/config"
 	"github.com/pingcap/tidb/store/tikv/kv"
 	"github.com/pingcap/tidb/store/tikv/mockstore/mocktikv"
 	pd "github.com/tikv/pd/client"
@@ -121,6 +123,84 @@ func (s *testRegionCacheSuite) getAddr(c *C, key []byte, replicaRead kv.ReplicaR
 	return ctx.Addr
 }
 
+func (s *testRegionCacheSuite) TestLearnerRead(c *C) {
+	seed := rand.Uint32()
+	// Fallback to the follower if there is no learner.
+	c.Assert(s.getAddr(c, []byte("a"), kv.ReplicaReadLearner, seed), Equals, s.storeAddr(s.store2))
+
+	store3 := s.cluster.AllocID()
+	peer3 := s.cluster.AllocID()
+	s.cluster.AddStore(store3, s.storeAddr(store3))
+	s.cluster.AddLearner(s.region1, store3, peer3)
+	cache := NewRegionCache(s.cache.pdClient)
+	defer cache.Close()
+	loc, err := cache.LocateKey(s.bo, []byte("a"))
+	c.Assert(err, IsNil)
+	for i := uint32(0); i < 3; i++ {
+		ctx, err := cache.GetTiKVRPCContext(s.bo, loc.Region, kv.ReplicaReadLearner, seed+i)
+		c.Assert(err, IsNil)
+		c.Assert(ctx.Addr, Equals, s.storeAddr(store3))
+		c.Assert(ctx.Peer.GetId(), Equals, peer3)
+	}
+}
+
+// slowPDClient counts the GetRegion calls and makes them slow, so that concurrent
+// calls overlap.
+type slowPDClient struct {
+	pd.Client
+	getRegionCnt int32
+}
+
+func (c *slowPDClient) GetRegion(ctx context.Context, key []byte) (*pd.Region, error) {
+	atomic.AddInt32(&c.getRegionCnt, 1)
+	time.Sleep(50 * time.Millisecond)
+	return c.Client.GetRegion(ctx, key)
+}
+
+func (s *testRegionCacheSuite) TestCoalesceRegionReload(c *C) {
+	pdCli := &slowPDClient{Client: &CodecPDClient{mocktikv.NewPDClient(s.cluster)}}
+	cache := NewRegionCache(pdCli)
+	defer cache.Close()
+	loc, err := cache.LocateKey(s.bo, []byte("a"))
+	c.Assert(err, IsNil)
+	c.Assert(atomic.LoadInt32(&pdCli.getRegionCnt), Equals, int32(1))
+
+	// Concurrent reloads of the keys in an invalidated region share one request.
+	cache.InvalidateCachedRegion(loc.Region)
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			bo := NewBackofferWithVars(context.Background(), 5000, nil)
+			loc, err := cache.LocateKey(bo, []byte{'a' + byte(i)})
+			c.Assert(err, IsNil)
+			c.Assert(loc.Region.GetID(), Equals, s.region1)
+		}(i)
+	}
+	wg.Wait()
+	c.Assert(atomic.LoadInt32(&pdCli.getRegionCnt), Equals, int32(2))
+
+	// Keys which are not in the shared result are loaded by themselves.
+	region2 := s.cluster.AllocID()
+	newPeers := s.cluster.AllocIDs(2)
+	s.cluster.Split(s.region1, region2, []byte("f"), newPeers, newPeers[0])
+	loc, err = cache.LocateKey(s.bo, []byte("a"))
+	c.Assert(err, IsNil)
+	cache.InvalidateCachedRegion(loc.Region)
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func(key []byte, regionID uint64) {
+			defer wg.Done()
+			bo := NewBackofferWithVars(context.Background(), 5000, nil)
+			loc, err := cache.LocateKey(bo, key)
+			c.Assert(err, IsNil)
+			c.Assert(loc.Region.GetID(), Equals, regionID)
+		}([]byte{'a' + byte(i*10)}, []uint64{s.region1, region2}[i])
+	}
+	wg.Wait()
+}
+
 func (s *testRegionCacheSuite) TestStoreLabels(c *C) {
 	testcases := []struct {
 		storeID uint64
@@ -768,6 +848,59 @@ func (s *testRegionCacheSuite) TestLabelSelectorTiKVPeer(c *C) {
 	}
 }
 
+func (s *testRegionCacheSuite) TestLabelWeightsTiKVPeer(c *C) {
+	hdd := []*metapb.StoreLabel{{Key: "disk", Value: "hdd"}}
+	nvme := []*metapb.StoreLabel{{Key: "disk", Value: "nvme"}}
+	s.cluster.UpdateStoreLabels(s.store1, hdd)
+	s.cluster.UpdateStoreLabels(s.store2, nvme)
+	store3 := s.cluster.AllocID()
+	peer3 := s.cluster.AllocID()
+	s.cluster.AddStore(store3, s.storeAddr(store3))
+	s.cluster.AddPeer(s.region1, store3, peer3)
+	s.cluster.UpdateStoreLabels(store3, hdd)
+	loc, err := s.cache.LocateKey(s.bo, []byte("a"))
+	c.Assert(err, IsNil)
+
+	weights := WithLabelWeights([]config.StoreLabelWeight{
+		{Key: "disk", Value: "nvme", Weight: 10},
+		{Key: "disk", Value: "hdd", Weight: 1},
+	})
+	for seed := uint32(0); seed < 6; seed++ {
+		for _, t := range []kv.ReplicaReadType{kv.ReplicaReadMixed, kv.ReplicaReadFollower} {
+			ctx, err := s.cache.GetTiKVRPCContext(s.bo, loc.Region, t, seed, weights)
+			c.Assert(err, IsNil)
+			c.Assert(ctx.Store.storeID, Equals, s.store2)
+			c.Assert(ctx.Store.IsLabelsMatch(nvme), IsTrue)
+		}
+	}
+
+	// Label weights are combined with matched labels, and all followers are
+	// candidates if none of them has weighted labels.
+	ctx, err := s.cache.GetTiKVRPCContext(s.bo, loc.Region, kv.ReplicaReadMixed, 0, weights, WithMatchLabels(hdd))
+	c.Assert(err, IsNil)
+	c.Assert(ctx.Store.storeID, Not(Equals), s.store2)
+	followers := make(map[uint64]struct{})
+	for seed := uint32(0); seed < 6; seed++ {
+		ctx, err = s.cache.GetTiKVRPCContext(s.bo, loc.Region, kv.ReplicaReadFollower, seed,
+			WithLabelWeights([]config.StoreLabelWeight{{Key: "disk", Value: "ssd", Weight: 10}}))
+		c.Assert(err, IsNil)
+		followers[ctx.Store.storeID] = struct{}{}
+	}
+	c.Assert(followers, DeepEquals, map[uint64]struct{}{s.store2: {}, store3: {}})
+}
+
+func (s *testRegionCacheSuite) TestStoreIDTiKVPeer(c *C) {
+	loc, err := s.cache.LocateKey(s.bo, []byte("a"))
+	c.Assert(err, IsNil)
+	for seed := uint32(0); seed < 4; seed++ {
+		for _, storeID := range []uint64{s.store1, s.store2} {
+			ctx, err := s.cache.GetTiKVRPCContext(s.bo, loc.Region, kv.ReplicaReadMixed, seed, WithStoreID(storeID))
+			c.Assert(err, IsNil)
+			c.Assert(ctx.Store.storeID, Equals, storeID)
+		}
+	}
+}
+
 func (s *testRegionCacheSuite) TestSplit(c *C) {
 	seed := rand.Uint32()
 	r := s.getRegion(c, []byte("x"))
@@ -1156,6 +1289,97 @@ func (s *testRegionCacheSuite) TestBatchLoadRegions(c *C) {
 	s.checkCache(c, len(regions))
 }
 
+// countingPDClient counts the region requests to PD.
+type countingPDClient struct {
+	pd.Client
+	getRegionCnt   int32
+	scanRegionsCnt int32
+}
+
+func (c *countingPDClient) GetRegion(ctx context.Context, key []byte) (*pd.Region, error) {
+	atomic.AddInt32(&c.getRegionCnt, 1)
+	return c.Client.GetRegion(ctx, key)
+}
+
+func (c *countingPDClient) ScanRegions(ctx context.Context, startKey, endKey []byte, limit int) ([]*pd.Region, error) {
+	atomic.AddInt32(&c.scanRegionsCnt, 1)
+	return c.Client.ScanRegions(ctx, startKey, endKey, limit)
+}
+
+func (s *testRegionCacheSuite) TestLocateKeys(c *C) {
+	// Split at "a", "b", "c", "d"
+	regions := s.cluster.AllocIDs(4)
+	regions = append([]uint64{s.region1}, regions...)
+	for i := 0; i < 4; i++ {
+		peers := s.cluster.AllocIDs(2)
+		s.cluster.Split(regions[i], regions[i+1], []byte{'a' + byte(i)}, peers, peers[0])
+	}
+	pdCli := &countingPDClient{Client: &CodecPDClient{mocktikv.NewPDClient(s.cluster)}}
+	cache := NewRegionCache(pdCli)
+	defer cache.Close()
+
+	keys := [][]byte{[]byte("c1"), []byte("a1"), []byte(""), []byte("d1"), []byte("a2"), []byte("b")}
+	expected := []uint64{regions[3], regions[1], regions[0], regions[4], regions[1], regions[2]}
+	var locs []*KeyLocation
+	for i := 0; i < 2; i++ {
+		var err error
+		locs, err = cache.LocateKeys(s.bo, keys)
+		c.Assert(err, IsNil)
+		c.Assert(locs, HasLen, len(keys))
+		for j, loc := range locs {
+			c.Assert(loc.Region.GetID(), Equals, expected[j])
+			c.Assert(loc.Contains(keys[j]), IsTrue)
+		}
+		// The regions are loaded by one ScanRegions request, and then read from the
+		// cache.
+		c.Assert(atomic.LoadInt32(&pdCli.getRegionCnt), Equals, int32(0))
+		c.Assert(atomic.LoadInt32(&pdCli.scanRegionsCnt), Equals, int32(1))
+	}
+
+	// Only the regions missing from the cache are loaded.
+	cache.InvalidateCachedRegion(locs[3].Region)
+	groups, _, err := cache.GroupKeysByRegion(s.bo, [][]byte{[]byte("b1"), []byte("d1"), []byte("d2")}, nil)
+	c.Assert(err, IsNil)
+	c.Assert(groups, HasLen, 2)
+	c.Assert(atomic.LoadInt32(&pdCli.getRegionCnt), Equals, int32(0))
+	c.Assert(atomic.LoadInt32(&pdCli.scanRegionsCnt), Equals, int32(2))
+}
+
+func (s *testRegionCacheSuite) TestLocateKeysWithoutLeader(c *C) {
+	// Split at "a", "b", "c", "d"
+	regions := s.cluster.AllocIDs(4)
+	regions = append([]uint64{s.region1}, regions...)
+	for i := 0; i < 4; i++ {
+		peers := s.cluster.AllocIDs(2)
+		s.cluster.Split(regions[i], regions[i+1], []byte{'a' + byte(i)}, peers, peers[0])
+	}
+	pdCli := &countingPDClient{Client: &CodecPDClient{mocktikv.NewPDClient(s.cluster)}}
+	cache := NewRegionCache(pdCli)
+	defer cache.Close()
+
+	// The region without a leader is skipped by ScanRegions and loaded by LocateKey.
+	s.cluster.GiveUpLeader(regions[2])
+	keys := [][]byte{[]byte("a1"), []byte("b1"), []byte("c1")}
+	locs, err := cache.LocateKeys(s.bo, keys)
+	c.Assert(err, IsNil)
+	for i, loc := range locs {
+		c.Assert(loc.Region.GetID(), Equals, regions[i+1])
+	}
+	c.Assert(atomic.LoadInt32(&pdCli.scanRegionsCnt), Equals, int32(1))
+	c.Assert(atomic.LoadInt32(&pdCli.getRegionCnt), Equals, int32(1))
+
+	// ScanRegions fails if none of the regions has a leader, the keys are still
+	// located one by one.
+	s.cluster.GiveUpLeader(regions[4])
+	keys = [][]byte{[]byte("d2"), []byte("d1")}
+	locs, err = cache.LocateKeys(s.bo, keys)
+	c.Assert(err, IsNil)
+	c.Assert(locs[0].Region.GetID(), Equals, regions[4])
+	c.Assert(locs[1].Region.GetID(), Equals, regions[4])
+	c.Assert(atomic.LoadInt32(&pdCli.scanRegionsCnt), Equals, int32(2))
+	c.Assert(atomic.LoadInt32(&pdCli.getRegionCnt), Equals, int32(2))
+}
+
 func (s *testRegionCacheSuite) TestFollowerReadFallback(c *C) {
 	// 3 nodes and no.1 is leader.
 	store3 := s.cluster.AllocID()
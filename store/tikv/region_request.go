@@ -32,6 +32,7 @@ import (
 	"github.com/pingcap/kvproto/pkg/coprocessor"
 	"github.com/pingcap/kvproto/pkg/errorpb"
 	"github.com/pingcap/kvproto/pkg/kvrpcpb"
+	"github.com/pingcap/tidb/store/tikv/config"
 	"github.com/pingcap/tidb/store/tikv/kv"
 	"github.com/pingcap/tidb/store/tikv/logutil"
 	"github.com/pingcap/tidb/store/tikv/metrics"
@@ -39,6 +40,10 @@ import (
 	"github.com/pingcap/tidb/store/tikv/util"
 )
 
+// serverBusyReasonDeadlineExceeded is the reason of the `ServerIsBusy` error which TiKV reports
+// when a request is aborted due to exceeding its max execution duration.
+const serverBusyReasonDeadlineExceeded = "deadline is exceeded"
+
 // ShuttingDown is a flag to indicate tidb-server is exiting (Ctrl+C signal
 // receved for example). If this flag is set, tikv client should not retry on
 // network error because tidb-server expect tikv client to exit as soon as possible.
@@ -67,6 +72,8 @@ type RegionRequestSender struct {
 	failStoreIDs      map[uint64]struct{}
 	failProxyStoreIDs map[uint64]struct{}
 	RegionRequestRuntimeStats
+	// regionErrStats counts the region errors met by the sender if it's not nil.
+	regionErrStats *regionErrorStats
 }
 
 // RegionRequestRuntimeStats records the runtime stats of send region requests.
@@ -199,6 +206,11 @@ func (s *RegionRequestSender) getRPCContext(
 		if req.ReplicaReadSeed != nil {
 			seed = *req.ReplicaReadSeed
 		}
+		if req.ReplicaReadType.IsFollowerRead() {
+			if weights := config.GetGlobalConfig().TiKVClient.ReplicaReadLabelWeights; len(weights) > 0 {
+				opts = append(opts, WithLabelWeights(weights))
+			}
+		}
 		return s.regionCache.GetTiKVRPCContext(bo, regionID, req.ReplicaReadType, seed, opts...)
 	case tikvrpc.TiFlash:
 		return s.regionCache.GetTiFlashRPCContext(bo, regionID, true)
@@ -293,8 +305,16 @@ func (s *RegionRequestSender) SendReqCtx(
 
 		logutil.Eventf(bo.ctx, "send %s request to region %d at %s", req.Type, regionID.id, rpcCtx.Addr)
 		s.storeAddr = rpcCtx.Addr
-		var retry bool
+		var (
+			retry   bool
+			release func()
+		)
+		release, err = s.acquireScanToken(bo, req, rpcCtx)
+		if err != nil {
+			return nil, nil, err
+		}
 		resp, retry, err = s.sendReqToRegion(bo, rpcCtx, req, timeout)
+		release()
 		if err != nil {
 			return nil, nil, errors.Trace(err)
 		}
@@ -319,6 +339,13 @@ func (s *RegionRequestSender) SendReqCtx(
 			return nil, nil, errors.Trace(err)
 		}
 		if regionErr != nil {
+			if isDeadlineExceeded(req, regionErr) {
+				// TiKV has aborted the request because it runs longer than the max
+				// execution duration in the request context, retrying it won't help.
+				logutil.BgLogger().Warn("tikv reports `ServerIsBusy` because the deadline is exceeded",
+					zap.Stringer("ctx", rpcCtx))
+				return nil, nil, errors.Trace(kv.ErrTiKVDeadlineExceeded)
+			}
 			retry, err = s.onRegionError(bo, rpcCtx, req.ReplicaReadSeed, regionErr)
 			if err != nil {
 				return nil, nil, errors.Trace(err)
@@ -332,6 +359,15 @@ func (s *RegionRequestSender) SendReqCtx(
 	}
 }
 
+// isDeadlineExceeded checks whether regionErr is reported because the request runs longer
+// than its max execution duration. Only the requests with a max execution duration are
+// aborted for it, the other ones are busy for the reason and retried as usual.
+func isDeadlineExceeded(req *tikvrpc.Request, regionErr *errorpb.Error) bool {
+	serverIsBusy := regionErr.GetServerIsBusy()
+	return serverIsBusy != nil && serverIsBusy.GetReason() == serverBusyReasonDeadlineExceeded &&
+		req.Context.MaxExecutionDurationMs > 0
+}
+
 // RPCCancellerCtxKey is context key attach rpc send cancelFunc collector to ctx.
 type RPCCancellerCtxKey struct{}
 
@@ -533,6 +569,84 @@ func (s *RegionRequestSender) getStoreToken(st *Store, limit int64) error {
 
 }
 
+// acquireScanToken takes a scan token of the region of rpcCtx if req is a scan
+// request and the concurrent scans per region are limited. The token is only held
+// while the request is sent, so the backoff between retries doesn't hold it, and
+// every retry takes the token of the region it's sent to. The returned function
+// releases the token.
+func (s *RegionRequestSender) acquireScanToken(bo *Backoffer, req *tikvrpc.Request, rpcCtx *RPCContext) (func(), error) {
+	if req.Type != tikvrpc.CmdScan {
+		return func() {}, nil
+	}
+	limit := config.GetGlobalConfig().TiKVClient.RegionScanLimit
+	if limit <= 0 {
+		return func() {}, nil
+	}
+	return s.regionCache.scanTokens.acquire(bo.ctx, rpcCtx.Region.GetID(), limit)
+}
+
+// regionScanTokens limits the number of concurrent scan requests sent to each region.
+// The limit is read on every acquisition, and a region is forgotten once it has no
+// scan request in flight or waiting.
+type regionScanTokens struct {
+	sync.Mutex
+	regions map[uint64]*regionScanToken
+}
+
+type regionScanToken struct {
+	inflight int64
+	// refs is the number of requests in flight or waiting for a token.
+	refs int
+	// released is closed when a token is released, to wake up the waiting requests.
+	released chan struct{}
+}
+
+func newRegionScanTokens() *regionScanTokens {
+	return &regionScanTokens{regions: make(map[uint64]*regionScanToken)}
+}
+
+// acquire waits until the number of in-flight scan requests of the region is less
+// than limit. The returned function must be called to release the token.
+func (t *regionScanTokens) acquire(ctx context.Context, regionID uint64, limit int64) (func(), error) {
+	t.Lock()
+	token, ok := t.regions[regionID]
+	if !ok {
+		token = &regionScanToken{released: make(chan struct{})}
+		t.regions[regionID] = token
+	}
+	token.refs++
+	for token.inflight >= limit {
+		released := token.released
+		t.Unlock()
+		select {
+		case <-released:
+		case <-ctx.Done():
+			t.Lock()
+			t.unref(regionID, token)
+			t.Unlock()
+			return nil, errors.Trace(ctx.Err())
+		}
+		t.Lock()
+	}
+	token.inflight++
+	t.Unlock()
+	return func() {
+		t.Lock()
+		token.inflight--
+		close(token.released)
+		token.released = make(chan struct{})
+		t.unref(regionID, token)
+		t.Unlock()
+	}, nil
+}
+
+func (t *regionScanTokens) unref(regionID uint64, token *regionScanToken) {
+	token.refs--
+	if token.refs == 0 {
+		delete(t.regions, regionID)
+	}
+}
+
 func (s *RegionRequestSender) releaseStoreToken(st *Store) {
 	count := st.tokenCount.Load()
 	// Decreasing tokenCount is no thread safe, preferring this for avoiding check in loop.
@@ -613,6 +727,37 @@ func (s *RegionRequestSender) NeedReloadRegion(ctx *RPCContext) (need bool) {
 	return
 }
 
+// regionErrorStats counts region errors by the kinds which indicate different causes.
+type regionErrorStats struct {
+	// epochNotMatch is usually caused by region split or merge.
+	epochNotMatch int
+	// notLeader is usually caused by leader transfer.
+	notLeader int
+	// serverBusy is caused by overloaded stores, and suggestedBackoffMs sums up the
+	// backoff time suggested by them.
+	serverBusy         int
+	suggestedBackoffMs uint64
+	other              int
+}
+
+func (s *regionErrorStats) total() int {
+	return s.epochNotMatch + s.notLeader + s.serverBusy + s.other
+}
+
+func (s *regionErrorStats) record(e *errorpb.Error) {
+	switch {
+	case e.GetEpochNotMatch() != nil:
+		s.epochNotMatch++
+	case e.GetNotLeader() != nil:
+		s.notLeader++
+	case e.GetServerIsBusy() != nil:
+		s.serverBusy++
+		s.suggestedBackoffMs += e.GetServerIsBusy().GetBackoffMs()
+	default:
+		s.other++
+	}
+}
+
 func regionErrorToLabel(e *errorpb.Error) string {
 	if e.GetNotLeader() != nil {
 		return "not_leader"
@@ -642,6 +787,9 @@ func (s *RegionRequestSender) onRegionError(bo *Backoffer, ctx *RPCContext, seed
 	}
 
 	metrics.TiKVRegionErrorCounter.WithLabelValues(regionErrorToLabel(regionErr)).Inc()
+	if s.regionErrStats != nil {
+		s.regionErrStats.record(regionErr)
+	}
 	if notLeader := regionErr.GetNotLeader(); notLeader != nil {
 		// Retry if error is `NotLeader`.
 		logutil.BgLogger().Debug("tikv reports `NotLeader` retry later",
@@ -691,6 +839,10 @@ func (s *RegionRequestSender) onRegionError(bo *Backoffer, ctx *RPCContext, seed
 			zap.Stringer("ctx", ctx))
 		if ctx != nil && ctx.Store != nil && ctx.Store.storeType == tikvrpc.TiFlash {
 			err = bo.Backoff(boTiFlashServerBusy, errors.Errorf("server is busy, ctx: %v", ctx))
+		} else if backoffMs := regionErr.GetServerIsBusy().GetBackoffMs(); backoffMs > 0 {
+			// Sleep at least the backoff time suggested by TiKV, which knows better
+			// when it can serve the request than the exponential backoff.
+			err = bo.BackoffWithMinSleep(boTiKVServerBusy, int(backoffMs), errors.Errorf("server is busy, ctx: %v", ctx))
 		} else {
 			err = bo.Backoff(boTiKVServerBusy, errors.Errorf("server is busy, ctx: %v", ctx))
 		}
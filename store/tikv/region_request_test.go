@@ -263,6 +263,155 @@ func (s *testRegionRequestToSingleStoreSuite) TestSendReqCtx(c *C) {
 	c.Assert(ctx, NotNil)
 }
 
+func (s *testRegionRequestToSingleStoreSuite) TestRegionScanLimit(c *C) {
+	defer config.UpdateGlobal(func(conf *config.Config) {
+		conf.TiKVClient.RegionScanLimit = 2
+	})()
+
+	var inflight, maxInflight int32
+	client := &fnClient{fn: func(ctx context.Context, addr string, req *tikvrpc.Request, timeout time.Duration) (*tikvrpc.Response, error) {
+		n := atomic.AddInt32(&inflight, 1)
+		for {
+			old := atomic.LoadInt32(&maxInflight)
+			if n <= old || atomic.CompareAndSwapInt32(&maxInflight, old, n) {
+				break
+			}
+		}
+		time.Sleep(20 * time.Millisecond)
+		atomic.AddInt32(&inflight, -1)
+		return &tikvrpc.Response{Resp: &kvrpcpb.ScanResponse{}}, nil
+	}}
+	region, err := s.cache.LocateRegionByID(s.bo, s.region)
+	c.Assert(err, IsNil)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 6; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			req := tikvrpc.NewRequest(tikvrpc.CmdScan, &kvrpcpb.ScanRequest{})
+			sender := NewRegionRequestSender(s.cache, client)
+			_, err := sender.SendReq(NewBackofferWithVars(context.Background(), 1000, nil), req, region.Region, time.Second)
+			c.Check(err, IsNil)
+		}()
+	}
+	wg.Wait()
+	c.Assert(atomic.LoadInt32(&maxInflight), Equals, int32(2))
+	c.Assert(s.cache.scanTokens.regions, HasLen, 0)
+
+	// Waiting for a token can be canceled.
+	tokens := s.cache.scanTokens
+	release, err := tokens.acquire(context.Background(), s.region, 1)
+	c.Assert(err, IsNil)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err = tokens.acquire(ctx, s.region, 1)
+	c.Assert(errors.Cause(err), Equals, context.DeadlineExceeded)
+
+	// A larger limit applies to the region in use, and the tokens of other region
+	// caches are independent.
+	release2, err := tokens.acquire(context.Background(), s.region, 2)
+	c.Assert(err, IsNil)
+	cache := NewRegionCache(s.cache.pdClient)
+	defer cache.Close()
+	release3, err := cache.scanTokens.acquire(context.Background(), s.region, 1)
+	c.Assert(err, IsNil)
+	release3()
+	release2()
+	release()
+	c.Assert(tokens.regions, HasLen, 0)
+
+	// The token is only held while the request is sent, not in the backoff before
+	// the retry.
+	busy := make(chan struct{})
+	var sent int32
+	client = &fnClient{fn: func(ctx context.Context, addr string, req *tikvrpc.Request, timeout time.Duration) (*tikvrpc.Response, error) {
+		if atomic.AddInt32(&sent, 1) == 1 {
+			close(busy)
+			return &tikvrpc.Response{Resp: &kvrpcpb.ScanResponse{RegionError: &errorpb.Error{
+				ServerIsBusy: &errorpb.ServerIsBusy{Reason: "scheduler is busy"},
+			}}}, nil
+		}
+		return &tikvrpc.Response{Resp: &kvrpcpb.ScanResponse{}}, nil
+	}}
+	done := make(chan error, 1)
+	go func() {
+		req := tikvrpc.NewRequest(tikvrpc.CmdScan, &kvrpcpb.ScanRequest{})
+		sender := NewRegionRequestSender(s.cache, client)
+		_, err := sender.SendReq(NewBackofferWithVars(context.Background(), 10000, nil), req, region.Region, time.Second)
+		done <- err
+	}()
+	<-busy
+	ctx, cancel = context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	release, err = tokens.acquire(ctx, s.region, 1)
+	c.Assert(err, IsNil)
+	release()
+	c.Assert(<-done, IsNil)
+	c.Assert(atomic.LoadInt32(&sent), Equals, int32(2))
+	c.Assert(tokens.regions, HasLen, 0)
+}
+
+func (s *testRegionRequestToSingleStoreSuite) TestServerIsBusyBackoff(c *C) {
+	var busy int
+	client := &fnClient{fn: func(ctx context.Context, addr string, req *tikvrpc.Request, timeout time.Duration) (*tikvrpc.Response, error) {
+		if busy > 0 {
+			busy--
+			return &tikvrpc.Response{Resp: &kvrpcpb.ScanResponse{RegionError: &errorpb.Error{
+				ServerIsBusy: &errorpb.ServerIsBusy{Reason: "scheduler is busy", BackoffMs: 2500},
+			}}}, nil
+		}
+		return &tikvrpc.Response{Resp: &kvrpcpb.ScanResponse{}}, nil
+	}}
+	region, err := s.cache.LocateRegionByID(s.bo, s.region)
+	c.Assert(err, IsNil)
+
+	// ServerIsBusy is retried with its dedicated backoff, which sleeps at least the
+	// backoff time suggested by TiKV.
+	busy = 1
+	bo := NewBackofferWithVars(context.Background(), 5000, nil)
+	req := tikvrpc.NewRequest(tikvrpc.CmdScan, &kvrpcpb.ScanRequest{})
+	resp, err := NewRegionRequestSender(s.cache, client).SendReq(bo, req, region.Region, time.Second)
+	c.Assert(err, IsNil)
+	regionErr, err := resp.GetRegionError()
+	c.Assert(err, IsNil)
+	c.Assert(regionErr, IsNil)
+	c.Assert(bo.GetBackoffTimes(), DeepEquals, map[BackoffType]int{boTiKVServerBusy: 1})
+	c.Assert(bo.GetTotalSleep(), GreaterEqual, 2500)
+}
+
+func (s *testRegionRequestToSingleStoreSuite) TestServerIsBusyDeadlineExceeded(c *C) {
+	var busy int
+	client := &fnClient{fn: func(ctx context.Context, addr string, req *tikvrpc.Request, timeout time.Duration) (*tikvrpc.Response, error) {
+		if busy > 0 {
+			busy--
+			return &tikvrpc.Response{Resp: &kvrpcpb.ScanResponse{RegionError: &errorpb.Error{
+				ServerIsBusy: &errorpb.ServerIsBusy{Reason: serverBusyReasonDeadlineExceeded},
+			}}}, nil
+		}
+		return &tikvrpc.Response{Resp: &kvrpcpb.ScanResponse{}}, nil
+	}}
+	region, err := s.cache.LocateRegionByID(s.bo, s.region)
+	c.Assert(err, IsNil)
+
+	// The request with a max execution duration isn't retried.
+	busy = 1
+	req := tikvrpc.NewRequest(tikvrpc.CmdScan, &kvrpcpb.ScanRequest{}, kvrpcpb.Context{MaxExecutionDurationMs: 100})
+	_, err = NewRegionRequestSender(s.cache, client).SendReq(NewBackofferWithVars(context.Background(), 5000, nil), req, region.Region, time.Second)
+	c.Assert(errors.Cause(err), Equals, kv.ErrTiKVDeadlineExceeded)
+
+	// The other requests are busy for the reason and retried.
+	busy = 1
+	bo := NewBackofferWithVars(context.Background(), 5000, nil)
+	req = tikvrpc.NewRequest(tikvrpc.CmdScan, &kvrpcpb.ScanRequest{})
+	resp, err := NewRegionRequestSender(s.cache, client).SendReq(bo, req, region.Region, time.Second)
+	c.Assert(err, IsNil)
+	regionErr, err := resp.GetRegionError()
+	c.Assert(err, IsNil)
+	c.Assert(regionErr, IsNil)
+	c.Assert(bo.GetBackoffTimes(), DeepEquals, map[BackoffType]int{boTiKVServerBusy: 1})
+}
+
 func (s *testRegionRequestToSingleStoreSuite) TestOnSendFailedWithCancelled(c *C) {
 	req := tikvrpc.NewRequest(tikvrpc.CmdRawPut, &kvrpcpb.RawPutRequest{
 		Key:   []byte("key"),
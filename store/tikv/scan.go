@@ -16,52 +16,696 @@ package tikv
 import (
 	"bytes"
 	"context"
+	"sync"
+	"time"
 
 	"github.com/pingcap/errors"
 	pb "github.com/pingcap/kvproto/pkg/kvrpcpb"
+	"github.com/pingcap/kvproto/pkg/metapb"
 	tidbkv "github.com/pingcap/tidb/kv"
 	"github.com/pingcap/tidb/store/tikv/kv"
-	"github.com/pingcap/tidb/store/tikv/logutil"
+	"github.com/pingcap/tidb/store/tikv/metrics"
 	"github.com/pingcap/tidb/store/tikv/tikvrpc"
-	"go.uber.org/zap"
+	"google.golang.org/grpc"
 )
 
 // Scanner support tikv scan
+//
+// A whole batch is received and decoded before its first pair is returned, so the
+// peak memory of a scan over large values is about batchSize times the value size.
+// Use a smaller batch size or WithMaxTotalBytes to bound it.
+//
+// The keys and values are returned exactly as written by the clients, in bytewise
+// order of the keys, or the reverse of it for reverse scans.
 type Scanner struct {
-	snapshot     *KVSnapshot
-	batchSize    int
-	cache        []*pb.KvPair
-	idx          int
-	nextStartKey []byte
-	endKey       []byte
-
-	// Use for reverse scan.
-	nextEndKey []byte
-	reverse    bool
+	// scanRequester sends the scan requests, and Scanner walks through the pairs of
+	// the responses.
+	scanRequester
 
+	cache []*pb.KvPair
+	idx   int
 	valid bool
-	eof   bool
+
+	// maxTotalBytes is the max number of bytes the scanner can read, 0 means no limit.
+	maxTotalBytes int
+	totalBytes    int
+
+	// lockWaitBeforeResolve is the max time in milliseconds to wait for a lock to be
+	// released by its owner before resolving it, 0 means resolving locks immediately.
+	lockWaitBeforeResolve int
+	// fenceToken returns the current fence token, and the scan is aborted once it
+	// exceeds startFenceToken, as the token when the scan starts.
+	fenceToken      func() uint64
+	startFenceToken uint64
+
+	// resumed is closed by Resume if the scanner is paused, it's nil otherwise.
+	pauseMu sync.Mutex
+	resumed chan struct{}
+
+	// duplicates finds the keys returned more than once if it's not nil.
+	duplicates *duplicateChecker
+
+	// controller limits the in-flight batch requests of the scanner together with
+	// the other scanners sharing it.
+	controller *concurrencyController
+
+	// fairLockWait makes the scanner wait for locks in the lock wait queue of the store.
+	fairLockWait bool
+
+	// skippedNotExist is the number of keys skipped because they don't exist after
+	// their locks are resolved.
+	skippedNotExist int
+
+	// keyFilter skips the keys it returns false for, skippedByFilter counts them.
+	keyFilter       func(key []byte) bool
+	skippedByFilter int
+
+	// skipDeadLetter makes the scanner skip the keys whose locks are sent to the dead
+	// letter sink, skippedDeadLetter counts them.
+	skipDeadLetter    bool
+	skippedDeadLetter int
+
+	// bandwidthLimiter caps the read bandwidth of the scanner with the others sharing it.
+	bandwidthLimiter BandwidthLimiter
+
+	// killable makes the scanner registered as a scan of connection connID, and
+	// killCancel cancels its context. killed is set to 1 once it's killed.
+	killable   bool
+	connID     uint64
+	killCancel context.CancelFunc
+	killed     uint32
+
+	// memTracker tracks the memory held by cache, whose size is cacheBytes.
+	memTracker MemoryTracker
+	cacheBytes int64
+
+	// heartbeat reports the progress of the scanner on a timer if it's not nil.
+	heartbeat *scanHeartbeat
+
+	// batchSizer adapts batchSize to the speed of the consumer if it's not nil.
+	batchSizer *batchSizer
+
+	// regionPos is the position of the scanner inside the region it's reading.
+	regionPos RegionPosition
+
+	// batchShrinker caps batchSize as the deadline approaches if it's not nil.
+	batchShrinker *batchShrinker
+
+	// emptyRangeErr makes the scanner fail with ErrEmptyRange if the range has no
+	// pairs when it's created.
+	emptyRangeErr bool
+
+	// stripKeyspace makes the scanner return the keys without the keyspace prefix.
+	stripKeyspace bool
+
+	// lazyStart makes the scanner read the first pair on the first Next instead of
+	// on creation, unstarted is set until then.
+	lazyStart bool
+	unstarted bool
+
+	// lastKey is the key of the last pair the scanner has moved past.
+	lastKey []byte
+	// checkResumeOrder makes a resumed scanner check that its first key is greater
+	// than resumedAfter, the last key returned before the scan was resumed.
+	checkResumeOrder bool
+	resumedAfter     []byte
+
+	// shouldStop is called with every returned pair, and the scan stops at the next
+	// Next once it returns true, which sets stopped.
+	shouldStop func(key, value []byte) bool
+	stopped    bool
+
+	// fingerprint is the hash of the inputs deciding the returned pairs.
+	fingerprint string
+
+	// initArgs are the arguments the scanner is created with. The scan restarts with
+	// them on an inconsistency up to maxRestarts times, restarts counts the restarts,
+	// and onRestart is called with every restart.
+	initArgs    scannerArgs
+	maxRestarts int
+	restarts    int
+	onRestart   func(restart int, cause error)
+
+	// prefetchBatches is the number of batches the scanner fetches ahead in the
+	// background, prefetching are the batches in flight or fetched in order.
+	prefetchBatches int
+	prefetching     []*scanPrefetch
+}
+
+// MemoryTracker is used by a scanner to report the memory held by its buffered
+// batches, so that range reads are accounted in the memory quota of the caller.
+type MemoryTracker interface {
+	// Consume records bytes are allocated. It returns false if the quota is exceeded.
+	Consume(bytes int64) bool
+	// Release records bytes are freed.
+	Release(bytes int64)
+}
+
+// BandwidthLimiter is a token bucket of bytes, which can be shared by the scanners
+// of all sessions to cap their total read bandwidth. *rate.Limiter of
+// golang.org/x/time/rate implements it.
+type BandwidthLimiter interface {
+	// WaitN blocks until n bytes can be read, n is never larger than Burst.
+	WaitN(ctx context.Context, n int) error
+	// Burst returns the max number of bytes of a WaitN call.
+	Burst() int
+}
+
+// ScannerStats contains the statistics of a scanner. They are collected on the
+// client.
+type ScannerStats struct {
+	// Regions is the number of regions the scanner has touched.
+	Regions int
+	// Bytes is the total size of keys and values the scanner has read.
+	Bytes int
+	// SkippedNotExist is the number of keys which are skipped because they don't
+	// exist. A large number indicates the range is full of deleted keys.
+	SkippedNotExist int
+	// SkippedByFilter is the number of keys which are skipped by the key filter.
+	SkippedByFilter int
+	// SkippedDeadLetter is the number of keys which are skipped because their locks
+	// can't be resolved and are sent to the dead letter sink.
+	SkippedDeadLetter int
+	// EpochNotMatchErrors is the number of EpochNotMatch errors the scanner has
+	// met, which are usually caused by region split or merge.
+	EpochNotMatchErrors int
+	// NotLeaderErrors is the number of NotLeader errors the scanner has met, which
+	// are usually caused by leader transfer.
+	NotLeaderErrors int
+	// ServerBusyErrors is the number of ServerIsBusy errors the scanner has met,
+	// which are the load hints of overloaded stores. Clients can throttle themselves
+	// by them, e.g. pause for SuggestedBackoff before scanning more.
+	ServerBusyErrors int
+	// SuggestedBackoff is the total backoff time suggested by the ServerIsBusy errors.
+	SuggestedBackoff time.Duration
+	// OtherRegionErrors is the number of the other region errors the scanner has met.
+	OtherRegionErrors int
+	// RegionProfiles is the timing breakdown of the regions in scan order, which is
+	// only recorded by the scanners created with WithRegionProfile.
+	RegionProfiles []RegionProfile
+	// BatchSize is the size of the next batch, which changes during the scan for the
+	// scanners created with WithAdaptiveBatchSize.
+	BatchSize int
+	// RPCLatency is the histogram of WithLatencyHistogram, nil if there is none.
+	RPCLatency *LatencyHistogram
+	// Tags are the tags of WithTags, nil if there are none.
+	Tags map[string]string
+	// Hotspots are the hotspot regions detected by WithHotspotThrottle in the order
+	// they are detected.
+	Hotspots []HotspotRegion
+	// Restarts is the number of times the scan has restarted by WithAtomicRestart.
+	// The other stats count the scan since the latest restart.
+	Restarts int
+}
+
+// ScannerOption configures a Scanner.
+type ScannerOption func(s *Scanner)
+
+// WithMaxRegions limits the number of regions a scanner can touch. The scanner returns
+// ErrTooManyRegions when it's going to scan more regions than n. 0 means no limit.
+func WithMaxRegions(n int) ScannerOption {
+	return func(s *Scanner) {
+		s.maxRegions = n
+	}
+}
+
+// ScanCacheHint tells TiKV whether the blocks read by a scan should be filled into
+// its block cache. KvScan always reads the versions of a key newest-first, so the
+// fill-cache flag of the requests is the only cache control a scan has.
+type ScanCacheHint int
+
+const (
+	// ScanCacheDefault follows the kv.NotFillCache option of the snapshot.
+	ScanCacheDefault ScanCacheHint = iota
+	// ScanCacheFill fills the read blocks into the cache, which helps scans over
+	// recently written ranges that are read again soon.
+	ScanCacheFill
+	// ScanCacheBypass doesn't fill the read blocks into the cache, so that one-pass
+	// scans over cold ranges don't evict the hot blocks of other reads.
+	ScanCacheBypass
+)
+
+// WithCacheHint sets whether the requests of the scanner fill the block cache of
+// TiKV. It overrides the kv.NotFillCache option of the snapshot for this scanner.
+func WithCacheHint(hint ScanCacheHint) ScannerOption {
+	return func(s *Scanner) {
+		s.cacheHint = hint
+	}
+}
+
+// WithMaxLocksResolved limits the number of locks a scanner can resolve, so that a
+// scan over a huge uncommitted transaction doesn't turn into a storm of cleanup
+// writes. The scanner returns ErrTooManyLocks when it's going to resolve more locks
+// than n. 0 means no limit.
+func WithMaxLocksResolved(n int) ScannerOption {
+	return func(s *Scanner) {
+		s.maxLocksResolved = n
+	}
+}
+
+// WithSingleRegion makes the scanner return ErrRangeSpansRegions instead of moving to
+// the next region when the range spills out of the region it starts from. It guards
+// the tests and tools which expect the range to be in exactly one region.
+func WithSingleRegion() ScannerOption {
+	return func(s *Scanner) {
+		s.singleRegion = true
+	}
+}
+
+// WithKeyOnly makes the scanner return keys only, no matter whether the snapshot
+// is KeyOnly.
+func WithKeyOnly() ScannerOption {
+	return func(s *Scanner) {
+		s.keyOnly = true
+	}
+}
+
+// WithMaxTotalBytes limits the total size of keys and values a scanner can read. The
+// scanner returns ErrScanTooBig and closes once it has read more than n bytes. 0 means
+// no limit.
+func WithMaxTotalBytes(n int) ScannerOption {
+	return func(s *Scanner) {
+		s.maxTotalBytes = n
+	}
+}
+
+// WithLockNoWait makes the scanner return ErrTxnLockWait immediately when it meets a
+// lock of a live transaction, instead of backing off until the lock is resolved.
+// Expired locks are still resolved.
+func WithLockNoWait() ScannerOption {
+	return func(s *Scanner) {
+		s.lockNoWait = true
+	}
+}
+
+// WithLockWaitBeforeResolve makes the scanner wait for at most ms milliseconds and
+// read the key again when it meets a lock, so that locks of short transactions can be
+// released by their owners without being resolved. The lock is resolved only if the
+// key is still locked after the wait.
+func WithLockWaitBeforeResolve(ms int) ScannerOption {
+	return func(s *Scanner) {
+		s.lockWaitBeforeResolve = ms
+	}
+}
+
+// WithFairLockWait makes the scanners meeting the same lock wait for it in FIFO
+// order instead of backing off independently. Only the scanner which has waited the
+// longest backs off until the lock is released or resolved, and the others read
+// the key in turn after it, which avoids starvation under high contention.
+func WithFairLockWait() ScannerOption {
+	return func(s *Scanner) {
+		s.fairLockWait = true
+	}
+}
+
+// WithRegionProfile makes the scanner record the timing breakdown of every region it
+// scans, which is returned by the RegionProfiles of Stats. It's for performance
+// investigations, e.g. finding the slow region of a slow scan.
+func WithRegionProfile() ScannerOption {
+	return func(s *Scanner) {
+		s.profiling = true
+	}
+}
+
+// WithFenceToken makes the scanner abort with ErrSchemaChanged if the token returned
+// by fenceToken, e.g. the schema version, has advanced since the scanner is created.
+// The token is checked before every batch is fetched, so that long-running scans
+// don't keep reading under a changed schema.
+func WithFenceToken(fenceToken func() uint64) ScannerOption {
+	return func(s *Scanner) {
+		s.fenceToken = fenceToken
+	}
+}
+
+// WithRangeVerification makes the scanner check that every key it receives is in
+// its range, and return ErrKeyOutOfRange with the first foreign key otherwise. It
+// catches the bugs of the protocol or request routing which return keys of other
+// ranges, e.g. in consistency tests.
+func WithRangeVerification() ScannerOption {
+	return func(s *Scanner) {
+		s.verifyRange = true
+	}
+}
+
+// WithFutureTimestampCheck makes the scanner fetch a timestamp from PD before the
+// first request, and fail with ErrFutureTimestamp if the snapshot version is ahead
+// of it, e.g. to catch the snapshots built from a skewed clock or a broken TSO early.
+// It costs a TSO request per scan.
+func WithFutureTimestampCheck() ScannerOption {
+	return func(s *Scanner) {
+		s.checkFutureTS = true
+	}
+}
+
+// WithEmptyRangeError makes the creation of the scanner fail with ErrEmptyRange if
+// the range has no pairs, instead of returning a scanner which is already invalid.
+func WithEmptyRangeError() ScannerOption {
+	return func(s *Scanner) {
+		s.emptyRangeErr = true
+	}
+}
+
+// WithTSORefresh makes the scanner move to a snapshot at a new TSO from PD once
+// interval has passed since the scan or the last move, e.g. for multi-hour exports
+// which would otherwise fall behind the GC safe point. The move happens only as the
+// scan enters a new region, so the pairs of a region are consistent with each other,
+// but the regions are read at different versions and the scan as a whole is NOT a
+// consistent snapshot. The snapshot of the caller isn't changed, and the Cursor of
+// the scanner carries the version of the latest region.
+func WithTSORefresh(interval time.Duration) ScannerOption {
+	return func(s *Scanner) {
+		s.tsoRefreshInterval = interval
+		s.lastTSORefresh = time.Now()
+	}
+}
+
+// RegionMergePolicy tells a scanner what to do when a region it's reading is merged
+// away, which TiKV reports as RegionNotFound.
+type RegionMergePolicy int
+
+const (
+	// RegionMergeContinue continues the scan at once from the merged region which
+	// covers the next key, without backoff, skipped or duplicated keys.
+	RegionMergeContinue RegionMergePolicy = iota
+	// RegionMergeFail fails the scan with ErrRegionMerged, e.g. for the scans which
+	// must not span the boundaries of the regions they start with.
+	RegionMergeFail
+)
+
+// WithRegionMergePolicy sets what the scanner does when a region it's reading is
+// merged away. The default is RegionMergeContinue.
+func WithRegionMergePolicy(policy RegionMergePolicy) ScannerOption {
+	return func(s *Scanner) {
+		s.mergePolicy = policy
+	}
+}
+
+// WithSnapshotVerification makes the scanner check that every region is read at the
+// snapshot version of the scan, and return ErrInconsistentSnapshot otherwise, e.g.
+// for consistency tests of replica reads. ScanResponse doesn't report the version
+// TiKV reads at, so the version of the requests is checked as they are finally
+// sent, after request interceptors and retries.
+func WithSnapshotVerification() ScannerOption {
+	return func(s *Scanner) {
+		s.verifySnapshot = true
+	}
+}
+
+// WithKeyFilter makes the scanner skip the keys filter returns false for, without
+// resolving their locks. The filter runs on the client, so the skipped pairs are
+// still read and transferred by TiKV, and counted in the Bytes of Stats. Use a
+// tighter range or WithKeyOnly to reduce the cost of scans filtering out most keys.
+func WithKeyFilter(filter func(key []byte) bool) ScannerOption {
+	return func(s *Scanner) {
+		s.keyFilter = filter
+	}
+}
+
+// WithStopCondition makes the scanner stop early once shouldStop returns true, e.g.
+// when a running aggregate accumulated by shouldStop reaches a threshold. It's
+// called with every pair returned by the scanner, the pair stays current after it
+// returns true, and the next Next closes the scanner as if the range were
+// exhausted, without fetching more batches.
+func WithStopCondition(shouldStop func(key, value []byte) bool) ScannerOption {
+	return func(s *Scanner) {
+		s.shouldStop = shouldStop
+	}
+}
+
+// WithLockDeadLetter makes the scanner send the locks it fails to resolve to sink
+// together with the errors, e.g. the locks whose primaries are on lost regions, so
+// that they can be investigated separately. Each lock of a key gets a resolution
+// budget of its own, once it's exhausted, the scan skips the key if skip is true,
+// or fails otherwise. Locks which fail a whole batch are sent to sink too, but they
+// always fail the scan, since the other keys of the batch are unknown.
+func WithLockDeadLetter(sink func(lock *Lock, err error), skip bool) ScannerOption {
+	return func(s *Scanner) {
+		s.deadLetter = sink
+		s.skipDeadLetter = skip
+	}
+}
+
+// WithDuplicateCheck makes the scanner check whether any key is returned more than
+// once in the whole scan, and return ErrDuplicateKey if so, e.g. for health checks
+// against replication or split bugs. The returned keys are tracked in at most
+// budget bytes of memory, and the duplicates of the keys beyond it may be missed.
+func WithDuplicateCheck(budget int) ScannerOption {
+	return func(s *Scanner) {
+		s.duplicates = newDuplicateChecker(budget)
+	}
+}
+
+// WithContext makes the scanner send requests with ctx, so that the scan can be
+// canceled. context.Background() is used by default. Once ctx is done, the backoffs
+// of the scan return promptly, the scanner is closed, and the cause of the error is
+// ctx.Err(), i.e. context.Canceled or context.DeadlineExceeded.
+func WithContext(ctx context.Context) ScannerOption {
+	return func(s *Scanner) {
+		s.ctx = ctx
+	}
+}
+
+// WithTags attaches caller-supplied tags to the scan, e.g. the query ID and the
+// operator ID, so that the activity of one scan can be correlated across the logs
+// of a busy server. The tags are logged as field scanTags of every log line of the
+// scanner, and reported by Stats. tags shouldn't be modified after it's passed.
+func WithTags(tags map[string]string) ScannerOption {
+	return func(s *Scanner) {
+		s.tags = tags
+	}
+}
+
+// WithMaxDuration limits the total time a scan can take, including backoff and lock
+// resolution. Once the scanner has been used for longer than d since it's created,
+// it returns ErrScanDeadlineExceeded and closes. 0 means no limit.
+func WithMaxDuration(d time.Duration) ScannerOption {
+	return func(s *Scanner) {
+		s.maxDuration = d
+	}
+}
+
+// WithKeyspace restricts the scanner to the keys with prefix keyspace. The scan range
+// is clamped to the keyspace, so that the scanner reaches EOF at the upper bound of
+// the keyspace instead of reading the next one, even if the range is unbounded.
+func WithKeyspace(keyspace []byte) ScannerOption {
+	return func(s *Scanner) {
+		s.keyspace = keyspace
+	}
+}
+
+// WithStrippedKeyspace makes the scanner return the keys without the prefix of
+// WithKeyspace, e.g. for tenants which address their keys relative to their
+// keyspaces. The keys of the pairs read after their locks are resolved are stripped
+// in the same way, as the lock errors carry the full keys. The scan range, cursors
+// and the keys of errors are still the full keys.
+func WithStrippedKeyspace() ScannerOption {
+	return func(s *Scanner) {
+		s.stripKeyspace = true
+	}
+}
+
+// WithLazyStart makes the scanner send no requests on creation, so that many
+// scanners can be created cheaply and read on demand. The scanner isn't valid until
+// the first pair is read by the first Next, NextChunk or NextChunkBestEffort call,
+// and errors on creation, e.g. of WithEmptyRangeError, are returned by that call
+// instead. So a lazy scanner is iterated by calling Next before reading each pair.
+// It's for scanners used directly: the scanners built on Scanner, e.g.
+// GapScanner, expect the first pair to be read on creation.
+func WithLazyStart() ScannerOption {
+	return func(s *Scanner) {
+		s.lazyStart = true
+	}
+}
+
+// WithResumeOrderCheck makes a scanner created by ResumeScanner check that its
+// first key is greater than the LastKey of the cursor, and fail with
+// ErrResumeOrderViolation otherwise. It catches the bugs of encoding or passing
+// cursors, which would make the paginated reads return duplicates or skip keys
+// silently. Cursors without LastKey, e.g. of scans which haven't returned any
+// pair, aren't checked.
+func WithResumeOrderCheck() ScannerOption {
+	return func(s *Scanner) {
+		s.checkResumeOrder = true
+	}
+}
+
+// WithMemoryTracker makes the scanner report the memory of the batches it buffers to
+// tracker. Once the tracker reports that the quota is exceeded, the scanner stops
+// fetching batches, returns ErrScanMemoryQuotaExceeded and closes.
+func WithMemoryTracker(tracker MemoryTracker) ScannerOption {
+	return func(s *Scanner) {
+		s.memTracker = tracker
+	}
+}
+
+// WithBandwidthLimiter makes the scanner draw the bytes of every batch it reads from
+// limiter, and block until they are available before returning the batch. Sharing
+// one limiter among scanners caps their total read bandwidth, no matter how many of
+// them are scanning.
+func WithBandwidthLimiter(limiter BandwidthLimiter) ScannerOption {
+	return func(s *Scanner) {
+		s.bandwidthLimiter = limiter
+	}
+}
+
+// WithRequestInterceptor makes the scanner call intercept with every scan request
+// before it's sent, including the retried ones, so that the request can be modified,
+// e.g. to set experimental fields of the context. The interceptor must not break the
+// invariants of the protocol, such as changing the range, the version or the limit
+// of the request, otherwise the scanner may return wrong results.
+func WithRequestInterceptor(intercept func(req *tikvrpc.Request)) ScannerOption {
+	return func(s *Scanner) {
+		s.interceptRequest = intercept
+	}
+}
+
+// WithCallOptions makes the scanner send the scan requests with the extra gRPC call
+// options, e.g. custom metadata or wait-for-ready, without changing the options of
+// the client for others. The requests are sent by unary calls instead of batch
+// commands then. It's an advanced knob for testing transport-level behaviors, the
+// options which conflict with the client, such as the ones changing the codec or
+// the message size limits, can break the requests.
+func WithCallOptions(opts ...grpc.CallOption) ScannerOption {
+	return func(s *Scanner) {
+		s.callOptions = opts
+	}
+}
+
+// WithServerCancellation makes the scanner send the scan requests by unary calls
+// instead of batch commands, so that once the context of the scanner is canceled,
+// e.g. by WithContext or by killing the connection, the in-flight request is
+// canceled on TiKV too, so that TiKV can stop processing it instead of wasting its
+// resources on the abandoned scan. It trades the throughput of batching for the
+// cancellation.
+func WithServerCancellation() ScannerOption {
+	return func(s *Scanner) {
+		s.serverCancel = true
+	}
+}
+
+// RegionInfo describes the region which serves a batch of the scanner.
+type RegionInfo struct {
+	Region   RegionVerID
+	StartKey []byte
+	EndKey   []byte
+}
+
+// Epoch returns the epoch of the region, which is changed by splits, merges and
+// membership changes of the region. Data cached per region is stale once the epoch
+// of the region serving it changes.
+func (r RegionInfo) Epoch() *metapb.RegionEpoch {
+	return &metapb.RegionEpoch{ConfVer: r.Region.GetConfVer(), Version: r.Region.GetVer()}
+}
+
+func newScanner(snapshot *KVSnapshot, startKey []byte, endKey []byte, batchSize int, reverse bool, opts ...ScannerOption) (*Scanner, error) {
+	scanner := &Scanner{}
+	err := scanner.reset(snapshot, startKey, endKey, batchSize, reverse, opts...)
+	return scanner, errors.Trace(err)
+}
+
+// newRangeScanner creates a forward Scanner for range r of the snapshot. An empty
+// EndKey means the range is unbounded, like the endKey of newScanner.
+func newRangeScanner(snapshot *KVSnapshot, r kv.KeyRange, batchSize int, opts ...ScannerOption) (*Scanner, error) {
+	scanner, err := newScanner(snapshot, r.StartKey, r.EndKey, batchSize, false, opts...)
+	return scanner, errors.Trace(err)
+}
+
+// NewRangeScanner creates a Scanner for range r of the snapshot, which scans
+// forward from r.StartKey to r.EndKey exclusively. An empty EndKey means the range
+// has no upper bound.
+func (s *KVSnapshot) NewRangeScanner(r kv.KeyRange, batchSize int, opts ...ScannerOption) (*Scanner, error) {
+	scanner, err := newRangeScanner(s, r, batchSize, opts...)
+	return scanner, errors.Trace(err)
 }
 
-func newScanner(snapshot *KVSnapshot, startKey []byte, endKey []byte, batchSize int, reverse bool) (*Scanner, error) {
+// Reset reinitializes the scanner in place to scan forward from startKey on the
+// given snapshot, so that scanners can be pooled (e.g. by sync.Pool) instead of
+// being allocated for every short scan. The buffer of the batch cache is kept for
+// the next scan, and all options of the previous scan are cleared. The caller must
+// not hold references to keys or values returned by the scanner before Reset.
+func (s *Scanner) Reset(snapshot *KVSnapshot, startKey []byte, batchSize int) error {
+	return errors.Trace(s.reset(snapshot, startKey, nil, batchSize, false))
+}
+
+func (s *Scanner) reset(snapshot *KVSnapshot, startKey []byte, endKey []byte, batchSize int, reverse bool, opts ...ScannerOption) error {
 	// It must be > 1. Otherwise scanner won't skipFirst.
 	if batchSize <= 1 {
 		batchSize = scanBatchSize
 	}
-	scanner := &Scanner{
-		snapshot:     snapshot,
-		batchSize:    batchSize,
-		valid:        true,
-		nextStartKey: startKey,
-		endKey:       endKey,
-		reverse:      reverse,
-		nextEndKey:   endKey,
+	s.stopPrefetch()
+	s.releaseCache()
+	s.unregisterKill()
+	s.heartbeat.close()
+	s.setCache(nil)
+	cache := s.cache
+	*s = Scanner{
+		scanRequester: scanRequester{
+			ctx:          context.Background(),
+			snapshot:     snapshot,
+			batchSize:    batchSize,
+			nextStartKey: startKey,
+			endKey:       endKey,
+			reverse:      reverse,
+			nextEndKey:   endKey,
+			keyOnly:      snapshot.keyOnly,
+			sampleStep:   snapshot.sampleStep,
+		},
+		cache:    cache,
+		valid:    true,
+		initArgs: scannerArgs{snapshot, startKey, endKey, batchSize, reverse, opts},
+	}
+	if snapshot.vars != nil {
+		s.prefetchBatches = snapshot.vars.ScanPrefetchBatches
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	// Options may change the batch size.
+	s.setBatchSize(s.batchSize)
+	s.setDeadline()
+	s.registerKill()
+	s.heartbeat.start()
+	s.clampToKeyspace()
+	s.rangeStart = s.nextStartKey
+	s.fingerprint = s.computeFingerprint()
+	if s.fenceToken != nil {
+		s.startFenceToken = s.fenceToken()
+	}
+	if s.lazyStart {
+		s.valid, s.unstarted = false, true
+		return nil
 	}
-	err := scanner.Next()
+	return errors.Trace(s.start())
+}
+
+// start checks the snapshot and reads the first pair.
+func (s *Scanner) start() error {
+	// The snapshot may be set to a historical version, fail fast if it has been
+	// GC'd instead of sending requests that are doomed to be rejected.
+	err := s.checkStartVisibility()
+	if err != nil {
+		s.Close()
+		return errors.Trace(err)
+	}
+	err = s.Next()
 	if tidbkv.IsErrNotFound(err) {
-		return scanner, nil
+		err = nil
 	}
-	return scanner, errors.Trace(err)
+	if err == nil && !s.valid && s.emptyRangeErr {
+		s.Close()
+		return errors.Trace(kv.ErrEmptyRange)
+	}
+	return errors.Trace(err)
+}
+
+// startLazily reads the first pair of a lazy scanner if it hasn't been read. It
+// returns whether the scanner is started by the call.
+func (s *Scanner) startLazily() (bool, error) {
+	if !s.unstarted {
+		return false, nil
+	}
+	s.valid, s.unstarted = true, false
+	return true, errors.Trace(s.start())
 }
 
 // Valid return valid.
@@ -72,12 +716,31 @@ func (s *Scanner) Valid() bool {
 // Key return key.
 func (s *Scanner) Key() []byte {
 	if s.valid {
-		return s.cache[s.idx].Key
+		return s.userKey(s.cache[s.idx].Key)
 	}
 	return nil
 }
 
-// Value return value.
+// userKey returns key as it's returned to the caller, which is stripped of the
+// keyspace prefix if WithStrippedKeyspace is set.
+func (s *Scanner) userKey(key []byte) []byte {
+	if s.stripKeyspace && bytes.HasPrefix(key, s.keyspace) {
+		return key[len(s.keyspace):]
+	}
+	return key
+}
+
+// userPair returns the current pair as it's returned to the caller.
+func (s *Scanner) userPair() *pb.KvPair {
+	pair := s.cache[s.idx]
+	if !s.stripKeyspace {
+		return pair
+	}
+	return &pb.KvPair{Key: s.userKey(pair.Key), Value: pair.Value}
+}
+
+// Value return value. It's empty for key-only scans, i.e. of KeyOnly snapshots or
+// WithKeyOnly, whose locked keys are resolved without reading their values.
 func (s *Scanner) Value() []byte {
 	if s.valid {
 		return s.cache[s.idx].Value
@@ -87,22 +750,43 @@ func (s *Scanner) Value() []byte {
 
 // Next return next element.
 func (s *Scanner) Next() error {
-	bo := NewBackofferWithVars(context.WithValue(context.Background(), TxnStartKey, s.snapshot.version), scannerNextMaxBackoff, s.snapshot.vars)
+	return errors.Trace(s.restartOn(s.next()))
+}
+
+func (s *Scanner) next() error {
+	if started, err := s.startLazily(); started || err != nil {
+		return errors.Trace(err)
+	}
 	if !s.valid {
 		return errors.New("scanner iterator is invalid")
 	}
+	if s.idx < len(s.cache) {
+		s.lastKey = s.cache[s.idx].Key
+	}
+	if s.stopped {
+		s.Close()
+		s.eof = true
+		return nil
+	}
+	s.batchSizer.enterNext()
+	defer s.batchSizer.leaveNext()
+	bo, cancel := s.newBackoffer()
+	defer cancel()
 	var err error
 	for {
 		s.idx++
 		if s.idx >= len(s.cache) {
-			if s.eof {
+			// The eof is only known once the batch in flight is taken.
+			if len(s.prefetching) == 0 && s.eof {
 				s.Close()
 				return nil
 			}
-			err = s.getData(bo)
+			if err = s.checkDeadline(nil); err == nil {
+				err = s.getData(bo)
+			}
 			if err != nil {
 				s.Close()
-				return errors.Trace(err)
+				return errors.Trace(s.checkKilled(s.checkDeadline(s.checkCanceled(err))))
 			}
 			if s.idx >= len(s.cache) {
 				continue
@@ -112,191 +796,706 @@ func (s *Scanner) Next() error {
 		current := s.cache[s.idx]
 		if (!s.reverse && (len(s.endKey) > 0 && kv.CmpKey(current.Key, s.endKey) >= 0)) ||
 			(s.reverse && len(s.nextStartKey) > 0 && kv.CmpKey(current.Key, s.nextStartKey) < 0) {
-			s.eof = true
+			// Close stops the batch in flight before eof is set.
 			s.Close()
+			s.eof = true
 			return nil
 		}
+		if s.keyFilter != nil && !s.keyFilter(current.Key) {
+			s.skippedByFilter++
+			continue
+		}
 		// Try to resolve the lock
 		if current.GetError() != nil {
-			// 'current' would be modified if the lock being resolved
-			if err := s.resolveCurrentLock(bo, current); err != nil {
+			s.waitPrefetch()
+			// 'current' would be modified if the lock being released or resolved
+			lockStart := time.Now()
+			exists, err := s.handleLockOrDeadLetter(bo, current)
+			if profile := s.regionProfile(s.curRegion.Region.GetID()); profile != nil {
+				profile.LockResolveTime += time.Since(lockStart)
+			}
+			if err == errSkipDeadLetter {
+				s.skippedDeadLetter++
+				continue
+			}
+			if err != nil {
 				s.Close()
-				return errors.Trace(err)
+				return errors.Trace(s.checkKilled(s.checkDeadline(s.checkCanceled(err))))
 			}
-
-			// The check here does not violate the KeyOnly semantic, because current's value
-			// is filled by resolveCurrentLock which fetches the value by snapshot.get, so an empty
-			// value stands for NotExist
-			if len(current.Value) == 0 {
+			if !exists {
+				s.skippedNotExist++
 				continue
 			}
 		}
+		if s.checkResumeOrder && s.resumedAfter != nil {
+			if kv.CmpKey(current.Key, s.resumedAfter) <= 0 {
+				s.Close()
+				return errors.Trace(&kv.ErrResumeOrderViolation{Key: current.Key, LastKey: s.resumedAfter})
+			}
+			s.resumedAfter = nil
+		}
+		if s.duplicates != nil && s.duplicates.add(current.Key) {
+			s.Close()
+			return errors.Trace(&kv.ErrDuplicateKey{Key: current.Key})
+		}
+		s.heartbeat.addRow()
+		if s.shouldStop != nil && s.shouldStop(s.Key(), current.Value) {
+			s.stopped = true
+		}
+		return nil
+	}
+}
+
+// NextChunk returns up to n pairs starting from the current one and moves the
+// scanner past them. Only the last chunk may contain less than n pairs, and an
+// empty chunk is returned once the scanner is exhausted.
+func (s *Scanner) NextChunk(n int) ([]*pb.KvPair, error) {
+	if n <= 0 {
+		return nil, errors.Errorf("invalid chunk size %d", n)
+	}
+	if _, err := s.startLazily(); err != nil {
+		return nil, errors.Trace(err)
+	}
+	chunk := make([]*pb.KvPair, 0, n)
+	for s.valid && len(chunk) < n {
+		chunk = append(chunk, s.userPair())
+		if err := s.Next(); err != nil {
+			return nil, errors.Trace(err)
+		}
+	}
+	return chunk, nil
+}
+
+// NextEncodedChunk is NextChunk with the chunk returned as an encoded
+// kvrpcpb.ScanResponse, which can be forwarded as is, e.g. by proxies, to the
+// clients decoding it by ScanResponse.Unmarshal. The responses of TiKV are decoded by
+// the gRPC client before the locks in them are resolved, so the raw response bytes
+// aren't available, and the chunk is encoded once here instead.
+func (s *Scanner) NextEncodedChunk(n int) ([]byte, error) {
+	chunk, err := s.NextChunk(n)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	resp := &pb.ScanResponse{Pairs: chunk}
+	data, err := resp.Marshal()
+	return data, errors.Trace(err)
+}
+
+// RemainingRegions returns the number of regions the scanner hasn't finished, which
+// includes the region the next batch is read from. It's counted by the region
+// cache without requests to PD, e.g. for the ETA of scans over many regions. If the
+// cache doesn't cover the rest of the range, each uncovered part is counted as one
+// region, and the estimate is returned with ErrRegionCountEstimated.
+func (s *Scanner) RemainingRegions() (int, error) {
+	s.waitPrefetch()
+	if s.eof {
+		return 0, nil
+	}
+	startKey, endKey := s.nextStartKey, s.endKey
+	if s.reverse {
+		endKey = s.nextEndKey
+	}
+	count, complete := s.snapshot.store.regionCache.countCachedRegions(startKey, endKey)
+	if !complete {
+		return count, errors.Trace(kv.ErrRegionCountEstimated)
+	}
+	return count, nil
+}
+
+// Stats returns the statistics of the scanner so far.
+func (s *Scanner) Stats() ScannerStats {
+	s.waitPrefetch()
+	return ScannerStats{
+		Regions:             s.regionCount,
+		Bytes:               s.totalBytes,
+		SkippedNotExist:     s.skippedNotExist,
+		SkippedByFilter:     s.skippedByFilter,
+		SkippedDeadLetter:   s.skippedDeadLetter,
+		EpochNotMatchErrors: s.regionErrStats.epochNotMatch,
+		NotLeaderErrors:     s.regionErrStats.notLeader,
+		ServerBusyErrors:    s.regionErrStats.serverBusy,
+		SuggestedBackoff:    time.Duration(s.regionErrStats.suggestedBackoffMs) * time.Millisecond,
+		OtherRegionErrors:   s.regionErrStats.other,
+		RegionProfiles:      append([]RegionProfile(nil), s.profiles...),
+		BatchSize:           s.batchSize,
+		RPCLatency:          s.latency,
+		Tags:                s.tags,
+		Hotspots:            s.hotspots.snapshot(),
+		Restarts:            s.restarts,
+	}
+}
+
+// CurrentRegion returns the region which serves the current key-value pair.
+func (s *Scanner) CurrentRegion() RegionInfo {
+	s.waitPrefetch()
+	return s.curRegion
+}
+
+// Pause stops the scanner from fetching new batches until Resume is called, e.g. for
+// users to throttle a scan interactively. The batch being fetched is still received
+// and its pairs can be read, but Next blocks once a new batch is needed, until the
+// scanner is resumed or the context of the scanner is done. Pause and Resume can be
+// called from other goroutines than the one using the scanner.
+func (s *Scanner) Pause() {
+	s.pauseMu.Lock()
+	defer s.pauseMu.Unlock()
+	if s.resumed == nil {
+		s.resumed = make(chan struct{})
+	}
+}
+
+// Resume lets a paused scanner fetch batches again.
+func (s *Scanner) Resume() {
+	s.pauseMu.Lock()
+	defer s.pauseMu.Unlock()
+	if s.resumed != nil {
+		close(s.resumed)
+		s.resumed = nil
+	}
+}
+
+func (s *Scanner) waitResumed(ctx context.Context) error {
+	s.pauseMu.Lock()
+	resumed := s.resumed
+	s.pauseMu.Unlock()
+	if resumed == nil {
+		return nil
+	}
+	select {
+	case <-resumed:
 		return nil
+	case <-ctx.Done():
+		return errors.Trace(ctx.Err())
 	}
 }
 
-// Close close iterator.
+// Close close iterator. The retries of the scan are logged in a summary line.
 func (s *Scanner) Close() {
-	s.valid = false
+	s.stopPrefetch()
+	s.valid, s.unstarted = false, false
+	s.releaseCache()
+	s.unregisterKill()
+	s.heartbeat.close()
+	s.logRetrySummary()
 }
 
-func (s *Scanner) startTS() uint64 {
-	return s.snapshot.version
+// lockingScanner is a Scanner which acquires pessimistic locks on the keys before
+// returning them. It is used by range reads of `SELECT ... FOR UPDATE`.
+// The keys are locked as they are scanned from the snapshot, and then their values
+// are read again at the ForUpdateTS of the lockCtx, so the returned values are the
+// latest ones which are protected by the locks. The keys deleted since the snapshot
+// stay locked, but they are not returned.
+type lockingScanner struct {
+	*Scanner
+	ctx     context.Context
+	txn     *KVTxn
+	lockCtx *kv.LockCtx
+	// lockedUntil is the last key of the latest locked batch.
+	lockedUntil []byte
+	// deleted holds the locked keys which are deleted at the ForUpdateTS.
+	deleted map[string]struct{}
 }
 
-func (s *Scanner) resolveCurrentLock(bo *Backoffer, current *pb.KvPair) error {
-	ctx := context.Background()
-	val, err := s.snapshot.get(ctx, bo, current.Key)
+func newLockingScanner(ctx context.Context, txn *KVTxn, lockCtx *kv.LockCtx, startKey []byte, endKey []byte, batchSize int, reverse bool) (*lockingScanner, error) {
+	scanner, err := newScanner(txn.snapshot, startKey, endKey, batchSize, reverse)
 	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	s := &lockingScanner{
+		Scanner: scanner,
+		ctx:     ctx,
+		txn:     txn,
+		lockCtx: lockCtx,
+		deleted: make(map[string]struct{}),
+	}
+	if err = s.lockCurrent(); err != nil {
+		return nil, errors.Trace(err)
+	}
+	return s, nil
+}
+
+// Next return next element.
+func (s *lockingScanner) Next() error {
+	if err := s.Scanner.Next(); err != nil {
 		return errors.Trace(err)
 	}
-	current.Error = nil
-	current.Value = val
-	return nil
+	return errors.Trace(s.lockCurrent())
 }
 
-func (s *Scanner) getData(bo *Backoffer) error {
-	logutil.BgLogger().Debug("txn getData",
-		zap.String("nextStartKey", kv.StrKey(s.nextStartKey)),
-		zap.String("nextEndKey", kv.StrKey(s.nextEndKey)),
-		zap.Bool("reverse", s.reverse),
-		zap.Uint64("txnStartTS", s.startTS()))
-	sender := NewRegionRequestSender(s.snapshot.store.regionCache, s.snapshot.store.client)
-	var reqEndKey, reqStartKey []byte
-	var loc *KeyLocation
-	var err error
-	for {
-		if !s.reverse {
-			loc, err = s.snapshot.store.regionCache.LocateKey(bo, s.nextStartKey)
-		} else {
-			loc, err = s.snapshot.store.regionCache.LocateEndKey(bo, s.nextEndKey)
+// lockCurrent locks the current key together with the following keys in the current
+// batch, so that there is only one lock request for a batch in most cases. The keys
+// deleted at the ForUpdateTS are locked but skipped.
+func (s *lockingScanner) lockCurrent() error {
+	for s.Valid() {
+		if !s.currentLocked() {
+			if err := s.lockBatch(); err != nil {
+				s.Close()
+				return errors.Trace(err)
+			}
 		}
-		if err != nil {
+		if _, ok := s.deleted[string(s.cache[s.idx].Key)]; !ok {
+			return nil
+		}
+		if err := s.Scanner.Next(); err != nil {
 			return errors.Trace(err)
 		}
+	}
+	return nil
+}
 
-		if !s.reverse {
-			reqEndKey = s.endKey
-			if len(reqEndKey) > 0 && len(loc.EndKey) > 0 && bytes.Compare(loc.EndKey, reqEndKey) < 0 {
-				reqEndKey = loc.EndKey
-			}
-		} else {
-			reqStartKey = s.nextStartKey
-			if len(reqStartKey) == 0 ||
-				(len(loc.StartKey) > 0 && bytes.Compare(loc.StartKey, reqStartKey) > 0) {
-				reqStartKey = loc.StartKey
+func (s *lockingScanner) currentLocked() bool {
+	if s.lockedUntil == nil {
+		return false
+	}
+	cmp := kv.CmpKey(s.cache[s.idx].Key, s.lockedUntil)
+	return (!s.reverse && cmp <= 0) || (s.reverse && cmp >= 0)
+}
+
+// lockBatch locks the keys from the current one until the first key which is out of
+// range or still has a lock to resolve. Such a key may not exist after its lock is
+// resolved, so it's locked in a later batch once Next has resolved it.
+func (s *lockingScanner) lockBatch() error {
+	pairs := make([]*pb.KvPair, 0, len(s.cache)-s.idx)
+	keys := make([][]byte, 0, len(s.cache)-s.idx)
+	for i, pair := range s.cache[s.idx:] {
+		if (!s.reverse && len(s.endKey) > 0 && kv.CmpKey(pair.Key, s.endKey) >= 0) ||
+			(s.reverse && len(s.nextStartKey) > 0 && kv.CmpKey(pair.Key, s.nextStartKey) < 0) {
+			break
+		}
+		// The current key has been resolved by Next.
+		if i > 0 && pair.GetError() != nil {
+			break
+		}
+		pairs = append(pairs, pair)
+		keys = append(keys, pair.Key)
+	}
+	if err := s.txn.LockKeys(s.ctx, s.lockCtx, keys...); err != nil {
+		return errors.Trace(err)
+	}
+	s.lockedUntil = keys[len(keys)-1]
+	if !s.txn.IsPessimistic() || s.lockCtx.ForUpdateTS <= s.snapshot.version {
+		return nil
+	}
+	// The keys may have been written since the snapshot is read, read the values which
+	// are protected by the locks.
+	// The values are read even if the scan is key only, to tell the deleted keys.
+	snapshot := s.snapshot.withVersion(s.lockCtx.ForUpdateTS)
+	snapshot.keyOnly = false
+	values, err := snapshot.BatchGet(s.ctx, keys)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	for _, pair := range pairs {
+		if val, ok := values[string(pair.Key)]; !ok {
+			s.deleted[string(pair.Key)] = struct{}{}
+		} else if !s.keyOnly {
+			pair.Value = val
+		}
+	}
+	return nil
+}
+
+// handleCurrentLock reads current again after its lock is released or resolved, and
+// returns whether the key exists.
+func (s *Scanner) handleCurrentLock(bo *Backoffer, current *pb.KvPair) (bool, error) {
+	if s.fairLockWait {
+		done, err := s.waitLockTurn(bo, current)
+		if err != nil {
+			return false, errors.Trace(err)
+		}
+		defer done()
+	}
+	if s.lockWaitBeforeResolve > 0 {
+		released, err := s.rereadAfterLockWait(bo, current)
+		if err != nil {
+			return false, errors.Trace(err)
+		}
+		if released {
+			exists := len(current.Value) > 0
+			if s.keyOnly {
+				current.Value = nil
 			}
+			return exists, nil
 		}
-		sreq := &pb.ScanRequest{
-			Context: &pb.Context{
-				Priority:       s.snapshot.priority,
-				NotFillCache:   s.snapshot.notFillCache,
-				IsolationLevel: IsolationLevelToPB(s.snapshot.isolationLevel),
-			},
-			StartKey:   s.nextStartKey,
-			EndKey:     reqEndKey,
-			Limit:      uint32(s.batchSize),
-			Version:    s.startTS(),
-			KeyOnly:    s.snapshot.keyOnly,
-			SampleStep: s.snapshot.sampleStep,
-		}
-		if s.reverse {
-			sreq.StartKey = s.nextEndKey
-			sreq.EndKey = reqStartKey
-			sreq.Reverse = true
+	}
+	if s.lockNoWait {
+		if err := s.checkLockNoWait(bo, current); err != nil {
+			return false, errors.Trace(err)
+		}
+	}
+	if err := s.countResolvedLock(); err != nil {
+		return false, errors.Trace(err)
+	}
+	exists, err := s.resolveCurrentLock(bo, current)
+	return exists, errors.Trace(err)
+}
+
+// errSkipDeadLetter tells that the lock of the current key is sent to the dead letter
+// sink, and the key should be skipped.
+var errSkipDeadLetter = errors.New("lock is sent to the dead letter sink")
+
+// handleLockOrDeadLetter is handleCurrentLock, which resolves the lock by a budget
+// of its own and sends it to the dead letter sink if it can't be resolved.
+func (s *Scanner) handleLockOrDeadLetter(bo *Backoffer, current *pb.KvPair) (bool, error) {
+	if s.deadLetter == nil {
+		return s.handleCurrentLock(bo, current)
+	}
+	lock, err := extractLockFromKeyErr(current.Error)
+	if err != nil {
+		return false, errors.Trace(err)
+	}
+	lockBo, cancel := s.newBackoffer()
+	defer cancel()
+	exists, err := s.handleCurrentLock(lockBo, current)
+	if err == nil || !s.lockUnresolvable(err) {
+		return exists, errors.Trace(err)
+	}
+	s.deadLetter(lock, err)
+	if s.skipDeadLetter {
+		return false, errSkipDeadLetter
+	}
+	return false, errors.Trace(err)
+}
+
+// rereadAfterLockWait waits for a while and reads the locked key again without
+// resolving the lock. It fills current and returns true if the lock has been released.
+func (s *Scanner) rereadAfterLockWait(bo *Backoffer, current *pb.KvPair) (bool, error) {
+	err := bo.BackoffWithMaxSleep(BoTxnLockFast, s.lockWaitBeforeResolve, errors.Errorf("key is locked during scanning"))
+	if err != nil {
+		return false, errors.Trace(err)
+	}
+	sender := NewRegionRequestSender(s.snapshot.store.regionCache, s.snapshot.store.client)
+	for {
+		loc, err := s.snapshot.store.regionCache.LocateKey(bo, current.Key)
+		if err != nil {
+			return false, errors.Trace(err)
 		}
 		s.snapshot.mu.RLock()
-		req := tikvrpc.NewReplicaReadRequest(tikvrpc.CmdScan, sreq, s.snapshot.mu.replicaRead, &s.snapshot.replicaReadSeed, pb.Context{
+		req := tikvrpc.NewReplicaReadRequest(tikvrpc.CmdGet, &pb.GetRequest{
+			Key:     current.Key,
+			Version: s.startTS(),
+		}, s.snapshot.mu.replicaRead, &s.snapshot.replicaReadSeed, pb.Context{
 			Priority:     s.snapshot.priority,
-			NotFillCache: s.snapshot.notFillCache,
+			NotFillCache: s.notFillCache(),
 			TaskId:       s.snapshot.mu.taskID,
 		})
 		s.snapshot.mu.RUnlock()
-		resp, err := sender.SendReq(bo, req, loc.Region, ReadTimeoutMedium)
+		resp, err := sender.SendReq(bo, req, loc.Region, ReadTimeoutShort)
 		if err != nil {
-			return errors.Trace(err)
+			return false, errors.Trace(err)
 		}
 		regionErr, err := resp.GetRegionError()
 		if err != nil {
-			return errors.Trace(err)
+			return false, errors.Trace(err)
 		}
 		if regionErr != nil {
-			logutil.BgLogger().Debug("scanner getData failed",
-				zap.Stringer("regionErr", regionErr))
 			err = bo.Backoff(BoRegionMiss, errors.New(regionErr.String()))
 			if err != nil {
-				return errors.Trace(err)
+				return false, errors.Trace(err)
 			}
 			continue
 		}
 		if resp.Resp == nil {
-			return errors.Trace(kv.ErrBodyMissing)
+			return false, errors.Trace(kv.ErrBodyMissing)
 		}
-		cmdScanResp := resp.Resp.(*pb.ScanResponse)
+		cmdGetResp := resp.Resp.(*pb.GetResponse)
+		if keyErr := cmdGetResp.GetError(); keyErr != nil {
+			if _, err = extractLockFromKeyErr(keyErr); err != nil {
+				return false, errors.Trace(err)
+			}
+			return false, nil
+		}
+		current.Error = nil
+		current.Value = cmdGetResp.GetValue()
+		return true, nil
+	}
+}
 
-		err = s.snapshot.store.CheckVisibility(s.startTS())
+// checkLockNoWait resolves the lock on current if it's expired, otherwise it
+// returns ErrTxnLockWait.
+func (s *Scanner) checkLockNoWait(bo *Backoffer, current *pb.KvPair) error {
+	lock, err := extractLockFromKeyErr(current.GetError())
+	if err != nil {
+		return errors.Trace(err)
+	}
+	msBeforeExpired, _, err := newLockResolver(s.snapshot.store).ResolveLocks(bo, s.snapshot.version, []*Lock{lock})
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if msBeforeExpired > 0 {
+		return errors.Trace(&kv.ErrTxnLockWait{Key: lock.Key, TTL: lock.TTL})
+	}
+	return nil
+}
+
+func (s *Scanner) resolveCurrentLock(bo *Backoffer, current *pb.KvPair) (bool, error) {
+	if s.keyOnly {
+		exists, err := s.keyExists(bo, current.Key)
 		if err != nil {
-			return errors.Trace(err)
+			return false, errors.Trace(err)
 		}
+		current.Error = nil
+		return exists, nil
+	}
+	val, err := s.snapshot.get(s.ctx, bo, current.Key)
+	if err != nil {
+		return false, errors.Trace(err)
+	}
+	current.Error = nil
+	current.Value = val
+	return len(val) > 0, nil
+}
 
-		// When there is a response-level key error, the returned pairs are incomplete.
-		// We should resolve the lock first and then retry the same request.
-		if keyErr := cmdScanResp.GetError(); keyErr != nil {
+// resolveBatchLocks resolves the locks of the locked pairs of a batch together and
+// fills in their values, so that the locked keys are read by a BatchGet request per
+// region, which are sent concurrently, instead of a point get per key. The keys
+// which don't exist once their locks are resolved are removed from the batch. It
+// only handles the batches with more than one lock of the scans resolving locks
+// right away, the other locks are handled one by one in Next. So are the locks of
+// the scans with a key filter, which is called in key order by Next and leaves the
+// locks of the keys it skips.
+func (s *Scanner) resolveBatchLocks(bo *Backoffer, region RegionInfo, pairs []*pb.KvPair) ([]*pb.KvPair, error) {
+	if s.keyOnly || s.keyFilter != nil || s.lockWaitBeforeResolve > 0 || s.lockNoWait || s.fairLockWait || s.deadLetter != nil {
+		return pairs, nil
+	}
+	var keys [][]byte
+	for _, pair := range pairs {
+		if pair.GetError() != nil {
+			keys = append(keys, pair.Key)
+		}
+	}
+	// The locks beyond the budget of WithMaxLocksResolved are left to Next, which
+	// resolves the locks in the budget before failing the scan.
+	if len(keys) < 2 || (s.maxLocksResolved > 0 && s.locksResolved+len(keys) > s.maxLocksResolved) {
+		return pairs, nil
+	}
+	s.waitPrefetch()
+	s.locksResolved += len(keys)
+	start := time.Now()
+	var mu sync.Mutex
+	values := make(map[string][]byte, len(keys))
+	err := s.snapshot.batchGetKeysByRegions(bo, keys, func(k, v []byte) {
+		mu.Lock()
+		values[string(k)] = v
+		mu.Unlock()
+	})
+	if profile := s.regionProfile(region.Region.GetID()); profile != nil {
+		profile.LockResolveTime += time.Since(start)
+	}
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	resolved := pairs[:0]
+	for _, pair := range pairs {
+		if pair.GetError() != nil {
+			pair.Error, pair.Value = nil, values[string(pair.Key)]
+			if len(pair.Value) == 0 {
+				s.skippedNotExist++
+				continue
+			}
+		}
+		resolved = append(resolved, pair)
+	}
+	return resolved, nil
+}
+
+// keyExists checks whether key exists by a key-only scan of the single key, which
+// resolves the locks on the key like a point get without fetching its value.
+func (s *Scanner) keyExists(bo *Backoffer, key []byte) (bool, error) {
+	cli := NewClientHelper(s.snapshot.store, s.snapshot.resolvedLocks)
+	s.snapshot.mu.RLock()
+	req := tikvrpc.NewReplicaReadRequest(tikvrpc.CmdScan, &pb.ScanRequest{
+		StartKey: key,
+		EndKey:   kv.NextKey(key),
+		Limit:    1,
+		Version:  s.startTS(),
+		KeyOnly:  true,
+	}, s.snapshot.mu.replicaRead, &s.snapshot.replicaReadSeed, pb.Context{
+		Priority:     s.snapshot.priority,
+		NotFillCache: s.notFillCache(),
+		TaskId:       s.snapshot.mu.taskID,
+	})
+	s.snapshot.mu.RUnlock()
+	for {
+		loc, err := s.snapshot.store.regionCache.LocateKey(bo, key)
+		if err != nil {
+			return false, errors.Trace(err)
+		}
+		resp, _, _, err := cli.SendReqCtx(bo, req, loc.Region, ReadTimeoutShort, tikvrpc.TiKV, "")
+		if err != nil {
+			return false, errors.Trace(err)
+		}
+		regionErr, err := resp.GetRegionError()
+		if err != nil {
+			return false, errors.Trace(err)
+		}
+		if regionErr != nil {
+			err = bo.Backoff(BoRegionMiss, errors.New(regionErr.String()))
+			if err != nil {
+				return false, errors.Trace(err)
+			}
+			continue
+		}
+		if resp.Resp == nil {
+			return false, errors.Trace(kv.ErrBodyMissing)
+		}
+		cmdScanResp := resp.Resp.(*pb.ScanResponse)
+		keyErr := cmdScanResp.GetError()
+		if keyErr == nil && len(cmdScanResp.Pairs) > 0 {
+			keyErr = cmdScanResp.Pairs[0].GetError()
+		}
+		if keyErr != nil {
 			lock, err := extractLockFromKeyErr(keyErr)
 			if err != nil {
-				return errors.Trace(err)
+				return false, errors.Trace(err)
 			}
-			msBeforeExpired, _, err := newLockResolver(s.snapshot.store).ResolveLocks(bo, s.snapshot.version, []*Lock{lock})
+			msBeforeExpired, err := cli.ResolveLocks(bo, s.startTS(), []*Lock{lock})
 			if err != nil {
-				return errors.Trace(err)
+				return false, errors.Trace(err)
 			}
 			if msBeforeExpired > 0 {
-				err = bo.BackoffWithMaxSleep(BoTxnLockFast, int(msBeforeExpired), errors.Errorf("key is locked during scanning"))
+				err = bo.BackoffWithMaxSleep(BoTxnLockFast, int(msBeforeExpired), errors.New(keyErr.String()))
 				if err != nil {
-					return errors.Trace(err)
+					return false, errors.Trace(err)
 				}
 			}
 			continue
 		}
+		return len(cmdScanResp.Pairs) > 0, nil
+	}
+}
 
-		kvPairs := cmdScanResp.Pairs
-		// Check if kvPair contains error, it should be a Lock.
-		for _, pair := range kvPairs {
-			if keyErr := pair.GetError(); keyErr != nil && len(pair.Key) == 0 {
-				lock, err := extractLockFromKeyErr(keyErr)
-				if err != nil {
-					return errors.Trace(err)
-				}
-				pair.Key = lock.Key
-			}
+func (s *Scanner) getData(bo *Backoffer) error {
+	if err := s.waitResumed(bo.ctx); err != nil {
+		return errors.Trace(err)
+	}
+	if s.fenceToken != nil {
+		if token := s.fenceToken(); token > s.startFenceToken {
+			return errors.Trace(&kv.ErrSchemaChanged{StartToken: s.startFenceToken, CurrentToken: token})
+		}
+	}
+	var (
+		resp    *pb.ScanResponse
+		err     error
+		region  RegionInfo
+		readKey []byte
+	)
+	fetchStart := time.Now()
+	if len(s.prefetching) > 0 {
+		p := s.takePrefetched()
+		resp, err, region, readKey = p.resp, p.err, p.region, p.readKey
+	} else {
+		s.adaptBatchSize()
+		fetchStart = time.Now()
+		resp, err = s.nextControlledResponse(bo)
+		region, readKey = s.curRegion, s.readKey()
+	}
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if s.batchSizer != nil {
+		s.batchSizer.fetchTime += time.Since(fetchStart)
+	}
+	if s.verifyRange {
+		if key := s.keyOutOfRange(resp.Pairs); key != nil {
+			return errors.Trace(&kv.ErrKeyOutOfRange{Key: key, StartKey: s.rangeStart, EndKey: s.endKey})
+		}
+	}
+	var cacheBytes int
+	for _, pair := range resp.Pairs {
+		cacheBytes += len(pair.Key) + len(pair.Value)
+	}
+	s.totalBytes += cacheBytes
+	metrics.TiKVScanBatchKeysHistogram.Observe(float64(len(resp.Pairs)))
+	metrics.TiKVScanBatchSizeHistogram.Observe(float64(cacheBytes))
+	if s.regionPos.Region.Region != region.Region {
+		s.regionPos = RegionPosition{Region: region}
+	}
+	s.regionPos.Rows += len(resp.Pairs)
+	s.regionPos.Bytes += cacheBytes
+	s.heartbeat.setRead(readKey, s.totalBytes)
+	if s.maxTotalBytes > 0 && s.totalBytes > s.maxTotalBytes {
+		return errors.Trace(kv.ErrScanTooBig)
+	}
+	if s.bandwidthLimiter != nil {
+		if err = waitBandwidth(bo.ctx, s.bandwidthLimiter, cacheBytes); err != nil {
+			return errors.Trace(err)
+		}
+	}
+	if resp.Pairs, err = s.resolveBatchLocks(bo, region, resp.Pairs); err != nil {
+		return errors.Trace(err)
+	}
+	s.releaseCache()
+	s.setCache(resp.Pairs)
+	if s.memTracker != nil {
+		s.cacheBytes = int64(cacheBytes)
+		if !s.memTracker.Consume(s.cacheBytes) {
+			return errors.Trace(kv.ErrScanMemoryQuotaExceeded)
 		}
+	}
+	s.startPrefetch()
+	return nil
+}
 
-		s.cache, s.idx = kvPairs, 0
-		if len(kvPairs) < s.batchSize {
-			// No more data in current Region. Next getData() starts
-			// from current Region's endKey.
-			if !s.reverse {
-				s.nextStartKey = loc.EndKey
-			} else {
-				s.nextEndKey = reqStartKey
-			}
-			if (!s.reverse && (len(loc.EndKey) == 0 || (len(s.endKey) > 0 && kv.CmpKey(s.nextStartKey, s.endKey) >= 0))) ||
-				(s.reverse && (len(loc.StartKey) == 0 || (len(s.nextStartKey) > 0 && kv.CmpKey(s.nextStartKey, s.nextEndKey) >= 0))) {
-				// Current Region is the last one.
-				s.eof = true
-			}
-			return nil
+// adaptBatchSize adapts the batch size of the next fetch.
+func (s *Scanner) adaptBatchSize() {
+	// Empty batches, e.g. of empty regions, don't tell the speed of the consumer.
+	if s.batchSizer != nil && len(s.cache) > 0 {
+		s.setBatchSize(s.batchSizer.nextSize(s.batchSize))
+	}
+	if s.batchShrinker != nil {
+		s.setBatchSize(s.batchShrinker.limit(s.batchSize, s.earliestDeadline()))
+	}
+}
+
+// nextControlledResponse is nextResponse in a slot of the concurrency controller of
+// the scanner if there is one.
+func (s *Scanner) nextControlledResponse(bo *Backoffer) (*pb.ScanResponse, error) {
+	if s.controller == nil {
+		return s.nextResponse(bo)
+	}
+	if err := s.controller.acquire(bo.ctx); err != nil {
+		return nil, errors.Trace(err)
+	}
+	start, regionErrs := time.Now(), s.regionErrStats.total()
+	resp, err := s.nextResponse(bo)
+	s.controller.release(time.Since(start), err != nil || s.regionErrStats.total() > regionErrs)
+	return resp, errors.Trace(err)
+}
+
+// waitBandwidth waits for n bytes from limiter, in pieces of at most its burst.
+func waitBandwidth(ctx context.Context, limiter BandwidthLimiter, n int) error {
+	burst := limiter.Burst()
+	if burst <= 0 {
+		return errors.Errorf("invalid bandwidth limiter burst %d", burst)
+	}
+	for n > 0 {
+		piece := n
+		if piece > burst {
+			piece = burst
 		}
-		// next getData() starts from the last key in kvPairs (but skip
-		// it by appending a '\x00' to the key). Note that next getData()
-		// may get an empty response if the Region in fact does not have
-		// more data.
-		lastKey := kvPairs[len(kvPairs)-1].GetKey()
-		if !s.reverse {
-			s.nextStartKey = kv.NextKey(lastKey)
-		} else {
-			s.nextEndKey = lastKey
+		if err := limiter.WaitN(ctx, piece); err != nil {
+			return errors.Trace(err)
 		}
-		return nil
+		n -= piece
+	}
+	return nil
+}
+
+// setCache copies the pairs of a batch into the cache, whose buffer is reused by the
+// following batches and by Reset.
+func (s *Scanner) setCache(pairs []*pb.KvPair) {
+	// Drop the references to the previous batch, which may be longer.
+	for i := range s.cache {
+		s.cache[i] = nil
+	}
+	s.cache, s.idx = append(s.cache[:0], pairs...), 0
+}
+
+// releaseCache reports the memory of the current batch as released.
+func (s *Scanner) releaseCache() {
+	if s.memTracker != nil && s.cacheBytes > 0 {
+		s.memTracker.Release(s.cacheBytes)
 	}
+	s.cacheBytes = 0
 }
@@ -0,0 +1,213 @@
+// Copyright 2021 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tikv
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/pingcap/errors"
+)
+
+// concurrencyController limits the number of in-flight batch requests of a group of
+// scanners by additive-increase/multiplicative-decrease. The limit grows by one
+// after a limit of requests succeed in time, and is halved once a request meets
+// region errors or takes more than twice the smoothed latency.
+type concurrencyController struct {
+	mu        sync.Mutex
+	limit     int
+	maxLimit  int
+	inflight  int
+	successes int
+	// avgLatency is the exponentially weighted moving average of the latency of the
+	// requests which succeed in time.
+	avgLatency time.Duration
+	// released is closed and replaced whenever a slot is released.
+	released chan struct{}
+}
+
+func newConcurrencyController(maxLimit int) *concurrencyController {
+	return &concurrencyController{limit: 1, maxLimit: maxLimit, released: make(chan struct{})}
+}
+
+// Limit returns the current limit of in-flight requests.
+func (c *concurrencyController) Limit() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.limit
+}
+
+// acquire waits for a slot of in-flight requests until ctx is done.
+func (c *concurrencyController) acquire(ctx context.Context) error {
+	for {
+		c.mu.Lock()
+		if c.inflight < c.limit {
+			c.inflight++
+			c.mu.Unlock()
+			return nil
+		}
+		released := c.released
+		c.mu.Unlock()
+		select {
+		case <-released:
+		case <-ctx.Done():
+			return errors.Trace(ctx.Err())
+		}
+	}
+}
+
+// release releases a slot and adjusts the limit by the latency of the request and
+// whether it has met errors.
+func (c *concurrencyController) release(latency time.Duration, failed bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.inflight--
+	if failed || (c.avgLatency > 0 && latency > 2*c.avgLatency) {
+		c.limit = (c.limit + 1) / 2
+		c.successes = 0
+	} else {
+		if c.avgLatency == 0 {
+			c.avgLatency = latency
+		} else {
+			c.avgLatency += (latency - c.avgLatency) / 8
+		}
+		if c.successes++; c.successes >= c.limit && c.limit < c.maxLimit {
+			c.limit++
+			c.successes = 0
+		}
+	}
+	close(c.released)
+	c.released = make(chan struct{})
+}
+
+// WithAdaptiveBatchSize makes the scanner adapt its batch size to the speed of its
+// consumer, within [minSize, maxSize]. Once a batch is consumed, the batch size is
+// halved if the consumer spent longer on it between Next calls than the scanner spent
+// fetching it, and doubled if the consumer spent less than half of that. So slow
+// consumers don't hold large batches fetched ahead of demand, while fast ones take
+// fewer round trips. The current batch size is reported by ScannerStats.BatchSize.
+func WithAdaptiveBatchSize(minSize, maxSize int) ScannerOption {
+	return func(s *Scanner) {
+		// It must be > 1. Otherwise scanner won't skipFirst.
+		if minSize <= 1 {
+			minSize = 2
+		}
+		if maxSize < minSize {
+			maxSize = minSize
+		}
+		s.batchSizer = &batchSizer{minSize: minSize, maxSize: maxSize}
+		s.setBatchSize(s.batchSizer.clamp(s.batchSize))
+	}
+}
+
+// batchSizer adapts the batch size of a scanner to the time its consumer spends on a
+// batch compared with the time the scanner spends fetching it.
+type batchSizer struct {
+	minSize int
+	maxSize int
+	// consumeTime is the time spent by the consumer between Next calls on the
+	// current batch, and fetchTime is the time spent fetching it, including the empty
+	// batches fetched before it.
+	consumeTime time.Duration
+	fetchTime   time.Duration
+	// returned is when Next returns the last time, it's zero before the first return.
+	returned time.Time
+}
+
+// enterNext records the time the consumer has spent since Next returned.
+func (b *batchSizer) enterNext() {
+	if b == nil || b.returned.IsZero() {
+		return
+	}
+	b.consumeTime += time.Since(b.returned)
+}
+
+// leaveNext records the time Next returns.
+func (b *batchSizer) leaveNext() {
+	if b == nil {
+		return
+	}
+	b.returned = time.Now()
+}
+
+// nextSize returns the size of the next batch, given that the current batch of size
+// has been consumed, and starts measuring the next batch.
+func (b *batchSizer) nextSize(size int) int {
+	if b.fetchTime > 0 {
+		switch {
+		case b.consumeTime > b.fetchTime:
+			size /= 2
+		case 2*b.consumeTime < b.fetchTime:
+			size *= 2
+		}
+	}
+	b.consumeTime, b.fetchTime = 0, 0
+	return b.clamp(size)
+}
+
+func (b *batchSizer) clamp(size int) int {
+	if size < b.minSize {
+		return b.minSize
+	}
+	if size > b.maxSize {
+		return b.maxSize
+	}
+	return size
+}
+
+// WithDeadlineBatchShrink makes the scanner fetch smaller batches as the deadline of
+// the scan approaches, i.e. the max duration of the scanner or the deadline of its
+// context, whichever is earlier. The batch size is scaled down by the fraction of the
+// time left, but not below minSize, so that partial results, e.g. of
+// NextChunkBestEffort, are returned promptly instead of a large batch overrunning
+// the deadline.
+func WithDeadlineBatchShrink(minSize int) ScannerOption {
+	return func(s *Scanner) {
+		// It must be > 1. Otherwise scanner won't skipFirst.
+		if minSize <= 1 {
+			minSize = 2
+		}
+		s.batchShrinker = &batchShrinker{start: time.Now(), fullSize: s.batchSize, minSize: minSize}
+	}
+}
+
+// batchShrinker caps the batch size of a scanner by the time left before its deadline.
+type batchShrinker struct {
+	start    time.Time
+	fullSize int
+	minSize  int
+}
+
+// limit returns size capped by the fraction of the time left before deadline.
+func (b *batchShrinker) limit(size int, deadline time.Time) int {
+	if deadline.IsZero() {
+		return size
+	}
+	total, left := deadline.Sub(b.start), time.Until(deadline)
+	if total <= 0 || left >= total {
+		return size
+	}
+	limit := b.minSize
+	if left > 0 {
+		limit = int((int64(b.fullSize)*int64(left) + int64(total) - 1) / int64(total))
+	}
+	if limit < b.minSize {
+		limit = b.minSize
+	}
+	if size > limit {
+		return limit
+	}
+	return size
+}
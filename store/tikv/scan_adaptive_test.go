@@ -0,0 +1,126 @@
+// Copyright 2021 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tikv
+
+import (
+	"context"
+	"time"
+
+	. "github.com/pingcap/check"
+)
+
+type testConcurrencyControllerSuite struct {
+}
+
+var _ = Suite(&testConcurrencyControllerSuite{})
+
+func (s *testConcurrencyControllerSuite) TestAIMD(c *C) {
+	ctrl := newConcurrencyController(3)
+	ctx := context.Background()
+	succeed := func(n int, latency time.Duration) {
+		for i := 0; i < n; i++ {
+			c.Assert(ctrl.acquire(ctx), IsNil)
+			ctrl.release(latency, false)
+		}
+	}
+
+	// The limit increases by one after a limit of requests succeed, up to the max.
+	c.Assert(ctrl.Limit(), Equals, 1)
+	succeed(1, time.Millisecond)
+	c.Assert(ctrl.Limit(), Equals, 2)
+	succeed(2, time.Millisecond)
+	c.Assert(ctrl.Limit(), Equals, 3)
+	succeed(10, time.Millisecond)
+	c.Assert(ctrl.Limit(), Equals, 3)
+
+	// Slow or failed requests halve the limit.
+	c.Assert(ctrl.acquire(ctx), IsNil)
+	ctrl.release(10*time.Millisecond, false)
+	c.Assert(ctrl.Limit(), Equals, 2)
+	c.Assert(ctrl.acquire(ctx), IsNil)
+	ctrl.release(time.Millisecond, true)
+	c.Assert(ctrl.Limit(), Equals, 1)
+	c.Assert(ctrl.acquire(ctx), IsNil)
+	ctrl.release(time.Millisecond, true)
+	c.Assert(ctrl.Limit(), Equals, 1)
+
+	// Requests beyond the limit wait for a slot.
+	c.Assert(ctrl.acquire(ctx), IsNil)
+	timeoutCtx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	c.Assert(ctrl.acquire(timeoutCtx), NotNil)
+	acquired := make(chan error, 1)
+	go func() { acquired <- ctrl.acquire(ctx) }()
+	ctrl.release(time.Millisecond, false)
+	c.Assert(<-acquired, IsNil)
+}
+
+type testBatchSizerSuite struct {
+}
+
+var _ = Suite(&testBatchSizerSuite{})
+
+func (s *testBatchSizerSuite) TestNextSize(c *C) {
+	b := &batchSizer{minSize: 2, maxSize: 16}
+	nextSize := func(size int, consume, fetch time.Duration) int {
+		b.consumeTime, b.fetchTime = consume, fetch
+		return b.nextSize(size)
+	}
+
+	// Fast consumers double the size up to the max.
+	c.Assert(nextSize(4, time.Millisecond, 10*time.Millisecond), Equals, 8)
+	c.Assert(nextSize(12, time.Millisecond, 10*time.Millisecond), Equals, 16)
+	// Slow consumers halve the size down to the min.
+	c.Assert(nextSize(8, 20*time.Millisecond, 10*time.Millisecond), Equals, 4)
+	c.Assert(nextSize(3, 20*time.Millisecond, 10*time.Millisecond), Equals, 2)
+	// The size is kept if the consumer is about as fast as fetching.
+	c.Assert(nextSize(8, 8*time.Millisecond, 10*time.Millisecond), Equals, 8)
+	c.Assert(b.consumeTime, Equals, time.Duration(0))
+	c.Assert(b.fetchTime, Equals, time.Duration(0))
+}
+
+func (s *testBatchSizerSuite) TestBatchSizeFloor(c *C) {
+	// Requests of limit 0 return no pairs, so the batch size never drops below 1.
+	var r scanRequester
+	for _, size := range []int{0, -1} {
+		r.setBatchSize(size)
+		c.Assert(r.batchSize, Equals, 1)
+	}
+	r.setBatchSize(8)
+	c.Assert(r.batchSize, Equals, 8)
+
+	// Halving the adaptive batch size stops at the min.
+	b := &batchSizer{minSize: 2, maxSize: 16}
+	size := 16
+	for i := 0; i < 10; i++ {
+		b.consumeTime, b.fetchTime = 20*time.Millisecond, 10*time.Millisecond
+		size = b.nextSize(size)
+	}
+	c.Assert(size, Equals, 2)
+}
+
+func (s *testBatchSizerSuite) TestBatchShrinkLimit(c *C) {
+	b := &batchShrinker{start: time.Now().Add(-600 * time.Millisecond), fullSize: 100, minSize: 2}
+	// No deadline, no limit.
+	c.Assert(b.limit(100, time.Time{}), Equals, 100)
+	// About 40% of the time is left.
+	limit := b.limit(100, time.Now().Add(400*time.Millisecond))
+	c.Assert(limit, LessEqual, 40)
+	c.Assert(limit, Greater, 30)
+	// Smaller sizes are kept.
+	c.Assert(b.limit(10, time.Now().Add(400*time.Millisecond)), Equals, 10)
+	// The size never drops below the min, even after the deadline.
+	c.Assert(b.limit(100, time.Now().Add(time.Millisecond)), Equals, 2)
+	c.Assert(b.limit(100, time.Now().Add(-time.Millisecond)), Equals, 2)
+}
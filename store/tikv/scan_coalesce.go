@@ -0,0 +1,204 @@
+// Copyright 2021 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tikv
+
+import (
+	"math"
+	"math/big"
+
+	"github.com/pingcap/errors"
+	"github.com/pingcap/tidb/store/tikv/kv"
+)
+
+// CoalescedRangeScanner scans many small ranges which are clustered closely, e.g.
+// the point ranges of composite index lookups. Adjacent ranges whose gap is at most
+// maxGap are read by one scan, and the keys in the gaps are skipped on the client
+// without resolving their locks. It trades over-fetching the gaps for fewer
+// requests than one scan per range.
+//
+// The gap between two ranges is the distance from the end of the first one to the
+// start of the second one, taken as big-endian numbers after padding the shorter
+// key with zeros. E.g. the gap between ranges ["k1", PrefixNextKey("k1")) and
+// ["k5", PrefixNextKey("k5")) is 3, but the gap is 256 times larger if the ranges
+// end with Key.Next, which appends a zero byte.
+type CoalescedRangeScanner struct {
+	snapshot  *KVSnapshot
+	ranges    []kv.KeyRange
+	groups    [][2]int
+	batchSize int
+	opts      []ScannerOption
+
+	group   int
+	scanner *Scanner
+	// cur is the range of the key being filtered or returned by scanner.
+	cur int
+}
+
+// NewCoalescedRangeScanner creates a CoalescedRangeScanner for ranges on the
+// snapshot, which must be sorted and not overlap with each other. Only the last
+// range may have an empty EndKey.
+func (s *KVSnapshot) NewCoalescedRangeScanner(ranges []kv.KeyRange, maxGap uint64, batchSize int, opts ...ScannerOption) (*CoalescedRangeScanner, error) {
+	for i := range ranges {
+		if i+1 == len(ranges) {
+			break
+		}
+		end, next := ranges[i].EndKey, ranges[i+1].StartKey
+		if len(end) == 0 || kv.CmpKey(end, next) > 0 {
+			return nil, errors.Errorf("ranges %d and %d are not sorted or overlap", i, i+1)
+		}
+	}
+	c := &CoalescedRangeScanner{
+		snapshot:  s,
+		ranges:    ranges,
+		batchSize: batchSize,
+		opts:      opts,
+		group:     -1,
+	}
+	for i := range ranges {
+		if i > 0 && keyGap(ranges[i-1].EndKey, ranges[i].StartKey) <= maxGap {
+			c.groups[len(c.groups)-1][1] = i
+		} else {
+			c.groups = append(c.groups, [2]int{i, i})
+		}
+	}
+	if err := c.nextGroup(); err != nil {
+		return nil, errors.Trace(err)
+	}
+	return c, nil
+}
+
+// keyGap returns the distance from key a to key b, which is not less than a, as
+// big-endian numbers of the same length, or math.MaxUint64 if it doesn't fit.
+func keyGap(a, b []byte) uint64 {
+	n := len(a)
+	if len(b) > n {
+		n = len(b)
+	}
+	p := 0
+	for p < len(a) && p < len(b) && a[p] == b[p] {
+		p++
+	}
+	pad := func(key []byte) []byte {
+		padded := make([]byte, n-p)
+		if p < len(key) {
+			copy(padded, key[p:])
+		}
+		return padded
+	}
+	gap := new(big.Int).Sub(new(big.Int).SetBytes(pad(b)), new(big.Int).SetBytes(pad(a)))
+	if !gap.IsUint64() {
+		return math.MaxUint64
+	}
+	return gap.Uint64()
+}
+
+// nextGroup moves to the first group after the current one which has a key in its
+// ranges.
+func (c *CoalescedRangeScanner) nextGroup() error {
+	for c.group+1 < len(c.groups) {
+		c.group++
+		first, last := c.groups[c.group][0], c.groups[c.group][1]
+		c.cur = first
+		opts := append(c.opts[:len(c.opts):len(c.opts)], c.filterGaps)
+		scanner, err := newScanner(c.snapshot, c.ranges[first].StartKey, c.ranges[last].EndKey, c.batchSize, false, opts...)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		if scanner.Valid() {
+			c.scanner = scanner
+			return nil
+		}
+		scanner.Close()
+	}
+	c.scanner = nil
+	return nil
+}
+
+// filterGaps composes the key filter which skips the keys in the gaps between the
+// ranges of the group with the key filter of the options.
+func (c *CoalescedRangeScanner) filterGaps(s *Scanner) {
+	filter := s.keyFilter
+	s.keyFilter = func(key []byte) bool {
+		for len(c.ranges[c.cur].EndKey) > 0 && kv.CmpKey(key, c.ranges[c.cur].EndKey) >= 0 {
+			c.cur++
+		}
+		if kv.CmpKey(key, c.ranges[c.cur].StartKey) < 0 {
+			return false
+		}
+		return filter == nil || filter(key)
+	}
+}
+
+// Valid returns whether the scanner has a current key-value pair.
+func (c *CoalescedRangeScanner) Valid() bool {
+	return c.scanner != nil
+}
+
+// Key returns the current key.
+func (c *CoalescedRangeScanner) Key() []byte {
+	if c.scanner == nil {
+		return nil
+	}
+	return c.scanner.Key()
+}
+
+// Value returns the current value.
+func (c *CoalescedRangeScanner) Value() []byte {
+	if c.scanner == nil {
+		return nil
+	}
+	return c.scanner.Value()
+}
+
+// Range returns the index of the range of the current key, -1 if there is none.
+func (c *CoalescedRangeScanner) Range() int {
+	if c.scanner == nil {
+		return -1
+	}
+	return c.cur
+}
+
+// Scans returns the number of scans the ranges are coalesced into.
+func (c *CoalescedRangeScanner) Scans() int {
+	return len(c.groups)
+}
+
+// Next moves the scanner to the next key-value pair in the ranges.
+func (c *CoalescedRangeScanner) Next() error {
+	if c.scanner == nil {
+		return nil
+	}
+	if err := c.scanner.Next(); err != nil {
+		c.Close()
+		return errors.Trace(err)
+	}
+	if c.scanner.Valid() {
+		return nil
+	}
+	c.scanner.Close()
+	if err := c.nextGroup(); err != nil {
+		c.Close()
+		return errors.Trace(err)
+	}
+	return nil
+}
+
+// Close closes the scanner.
+func (c *CoalescedRangeScanner) Close() {
+	if c.scanner != nil {
+		c.scanner.Close()
+		c.scanner = nil
+	}
+	c.group = len(c.groups)
+}
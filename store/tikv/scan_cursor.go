@@ -0,0 +1,281 @@
+// Copyright 2021 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tikv
+
+import (
+	"context"
+	"encoding/binary"
+
+	"github.com/pingcap/errors"
+	pb "github.com/pingcap/kvproto/pkg/kvrpcpb"
+	"github.com/pingcap/tidb/store/tikv/kv"
+)
+
+// scanCursorFormatV1 is the format of encoded scan cursors without the last key:
+//
+//	format (1 byte) | flags (1 byte) | version (8 bytes, big endian) | len(nextStartKey) (uvarint) | nextStartKey
+//
+// scanCursorFormatV2 appends the last key to it:
+//
+//	... | len(nextStartKey) (uvarint) | nextStartKey | len(lastKey) (uvarint) | lastKey
+//
+// Cursors without the last key are still encoded in V1, so that they can be
+// decoded by older versions. Add a new format instead of changing these ones, so
+// that durably stored cursors can always be decoded after upgrading.
+const (
+	scanCursorFormatV1 byte = 1
+	scanCursorFormatV2 byte = 2
+)
+
+const scanCursorFlagEOF byte = 1
+
+// ScanCursor records the position of a forward scan, so that the scan can be
+// resumed later, e.g. by another process, at the same snapshot.
+type ScanCursor struct {
+	// Version is the snapshot version of the scan.
+	Version uint64
+	// NextStartKey is the first key which hasn't been returned by the scan.
+	NextStartKey []byte
+	// EOF means the scan has finished.
+	EOF bool
+	// LastKey is the last key returned before the cursor, nil if there is none. It's
+	// checked against the first key of the resumed scan by WithResumeOrderCheck.
+	LastKey []byte
+}
+
+// Cursor returns the position of the scanner. Resuming from the cursor returns the
+// current key-value pair again if the scanner is valid. Only forward scans can be
+// resumed.
+func (s *Scanner) Cursor() (*ScanCursor, error) {
+	if s.reverse {
+		return nil, errors.New("reverse scans can't be resumed")
+	}
+	s.waitPrefetch()
+	cursor := &ScanCursor{
+		Version: s.startTS(),
+		EOF:     !s.valid && !s.unstarted,
+		LastKey: append([]byte(nil), s.lastKey...),
+	}
+	if len(cursor.LastKey) == 0 {
+		cursor.LastKey = nil
+	}
+	if s.valid {
+		cursor.NextStartKey = append([]byte(nil), s.cache[s.idx].Key...)
+	} else if s.unstarted {
+		cursor.NextStartKey = append([]byte(nil), s.rangeStart...)
+	}
+	return cursor, nil
+}
+
+// RegionPosition is the approximate position of a scanner inside the region it's
+// reading, e.g. for the progress of scans over very large regions.
+type RegionPosition struct {
+	Region RegionInfo
+	// Rows is the number of pairs read from the region so far, including the ones
+	// buffered but not returned yet.
+	Rows int
+	// Bytes is the total size of keys and values read from the region so far.
+	Bytes int
+}
+
+// RegionPosition returns the position of the scanner inside the region it's reading.
+// TiKV doesn't report the positions of scans inside regions, so the position is
+// counted by the scanner from the pairs it has read. It's for progress only, scans
+// are resumed from Cursor, which is the exact next key and restarts mid-region.
+func (s *Scanner) RegionPosition() RegionPosition {
+	s.waitPrefetch()
+	return s.regionPos
+}
+
+// NextChunkBestEffort is NextChunk for reads under a deadline. If the deadline of
+// the context or the max duration of the scanner is exceeded, the pairs read so far
+// are returned with ErrDeadlinePartial instead of failing the read, together with
+// a cursor to resume the rest of the scan later. The cursor is returned on success
+// too. Only forward scans can be read in this way.
+func (s *Scanner) NextChunkBestEffort(n int) ([]*pb.KvPair, *ScanCursor, error) {
+	if s.reverse {
+		return nil, nil, errors.New("reverse scans can't be resumed")
+	}
+	if n <= 0 {
+		return nil, nil, errors.Errorf("invalid chunk size %d", n)
+	}
+	if _, err := s.startLazily(); err != nil {
+		return nil, nil, errors.Trace(err)
+	}
+	chunk := make([]*pb.KvPair, 0, n)
+	for s.valid && len(chunk) < n {
+		pair := s.cache[s.idx]
+		chunk = append(chunk, s.userPair())
+		if err := s.Next(); err != nil {
+			if !s.deadlineExceeded(err) {
+				return nil, nil, errors.Trace(err)
+			}
+			cursor := &ScanCursor{Version: s.startTS(), NextStartKey: kv.NextKey(pair.Key), LastKey: pair.Key}
+			return chunk, cursor, errors.Trace(kv.ErrDeadlinePartial)
+		}
+	}
+	cursor, err := s.Cursor()
+	return chunk, cursor, errors.Trace(err)
+}
+
+func (s *Scanner) deadlineExceeded(err error) bool {
+	cause := errors.Cause(err)
+	return cause == kv.ErrScanDeadlineExceeded || cause == context.DeadlineExceeded || s.ctx.Err() == context.DeadlineExceeded
+}
+
+// Encode encodes the cursor in a self-describing format which can be decoded by
+// DecodeScanCursor of the current and future versions.
+func (c *ScanCursor) Encode() []byte {
+	buf := make([]byte, 0, 10+2*binary.MaxVarintLen64+len(c.NextStartKey)+len(c.LastKey))
+	var flags byte
+	if c.EOF {
+		flags |= scanCursorFlagEOF
+	}
+	format := scanCursorFormatV1
+	if len(c.LastKey) > 0 {
+		format = scanCursorFormatV2
+	}
+	buf = append(buf, format, flags)
+	var num [binary.MaxVarintLen64]byte
+	binary.BigEndian.PutUint64(num[:], c.Version)
+	buf = append(buf, num[:8]...)
+	buf = appendCursorKey(buf, c.NextStartKey)
+	if format == scanCursorFormatV2 {
+		buf = appendCursorKey(buf, c.LastKey)
+	}
+	return buf
+}
+
+func appendCursorKey(buf, key []byte) []byte {
+	var num [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(num[:], uint64(len(key)))
+	buf = append(buf, num[:n]...)
+	return append(buf, key...)
+}
+
+// readCursorKey reads a key appended by appendCursorKey, and returns the rest of data.
+func readCursorKey(data []byte) ([]byte, []byte, error) {
+	keyLen, n := binary.Uvarint(data)
+	if n <= 0 || uint64(len(data)-n) < keyLen {
+		return nil, nil, errors.New("invalid scan cursor: bad key length")
+	}
+	var key []byte
+	if keyLen > 0 {
+		key = append([]byte(nil), data[n:n+int(keyLen)]...)
+	}
+	return key, data[n+int(keyLen):], nil
+}
+
+// DecodeScanCursor decodes a cursor encoded by ScanCursor.Encode. It returns
+// ErrUnsupportedScanCursor if the cursor is encoded by a newer version in a format
+// unknown to this version.
+func DecodeScanCursor(data []byte) (*ScanCursor, error) {
+	if len(data) == 0 {
+		return nil, errors.New("invalid scan cursor: empty data")
+	}
+	format := data[0]
+	if format != scanCursorFormatV1 && format != scanCursorFormatV2 {
+		return nil, errors.Trace(&kv.ErrUnsupportedScanCursor{Format: format})
+	}
+	data = data[1:]
+	if len(data) < 9 {
+		return nil, errors.New("invalid scan cursor: truncated header")
+	}
+	flags := data[0]
+	if flags&^scanCursorFlagEOF != 0 {
+		return nil, errors.Errorf("invalid scan cursor: unknown flags %#x", flags)
+	}
+	cursor := &ScanCursor{
+		Version: binary.BigEndian.Uint64(data[1:9]),
+		EOF:     flags&scanCursorFlagEOF != 0,
+	}
+	var err error
+	cursor.NextStartKey, data, err = readCursorKey(data[9:])
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	if format == scanCursorFormatV2 {
+		if cursor.LastKey, data, err = readCursorKey(data); err != nil {
+			return nil, errors.Trace(err)
+		}
+	}
+	if len(data) > 0 {
+		return nil, errors.New("invalid scan cursor: bad key length")
+	}
+	return cursor, nil
+}
+
+// ResumeScanner creates a Scanner which continues the scan recorded by cursor,
+// reading range [cursor.NextStartKey, endKey) at the snapshot of the cursor.
+//
+// The cursor doesn't record the batch size of the scan, so the resumed scan may use
+// a different one, e.g. a smaller one on a node with less memory. The state of the
+// options tuning the batch size, like WithAdaptiveBatchSize, isn't carried over
+// either, and starts again from batchSize.
+func (s *KVStore) ResumeScanner(cursor *ScanCursor, endKey []byte, batchSize int, opts ...ScannerOption) (*Scanner, error) {
+	snapshot := s.GetSnapshot(cursor.Version)
+	if cursor.EOF {
+		scanner := &Scanner{scanRequester: scanRequester{snapshot: snapshot, eof: true}}
+		return scanner, nil
+	}
+	resumedAfter := func(s *Scanner) { s.resumedAfter = cursor.LastKey }
+	opts = append(opts[:len(opts):len(opts)], resumedAfter)
+	scanner, err := newScanner(snapshot, cursor.NextStartKey, endKey, batchSize, false, opts...)
+	return scanner, errors.Trace(err)
+}
+
+// ScanPage reads a page of at most pageSize pairs from range [startKey, endKey) of
+// the snapshot, for stateless servers which can't keep a Scanner between requests.
+// It returns the pairs with an opaque cursor of the rest of the range, which is
+// passed to KVStore.ScanNextPage to read the next page at the same snapshot. The
+// cursor is nil once the range is exhausted.
+func (s *KVSnapshot) ScanPage(startKey, endKey []byte, pageSize int, opts ...ScannerOption) ([]*pb.KvPair, []byte, error) {
+	if pageSize <= 0 {
+		return nil, nil, errors.Errorf("invalid page size %d", pageSize)
+	}
+	// Read one more pair, so that the start of the next page is usually known
+	// without another request.
+	scanner, err := newScanner(s, startKey, endKey, pageSize+1, false, opts...)
+	if err != nil {
+		return nil, nil, errors.Trace(err)
+	}
+	defer scanner.Close()
+	pairs, err := scanner.NextChunk(pageSize)
+	if err != nil {
+		return nil, nil, errors.Trace(err)
+	}
+	cursor, err := scanner.Cursor()
+	if err != nil {
+		return nil, nil, errors.Trace(err)
+	}
+	if cursor.EOF {
+		return pairs, nil, nil
+	}
+	return pairs, cursor.Encode(), nil
+}
+
+// ScanNextPage reads the next page of at most pageSize pairs of a scan by the cursor
+// returned by KVSnapshot.ScanPage or ScanNextPage, at the snapshot of the cursor.
+// endKey must be the end key of the first page.
+func (s *KVStore) ScanNextPage(cursor []byte, endKey []byte, pageSize int, opts ...ScannerOption) ([]*pb.KvPair, []byte, error) {
+	c, err := DecodeScanCursor(cursor)
+	if err != nil {
+		return nil, nil, errors.Trace(err)
+	}
+	if c.EOF {
+		return nil, nil, nil
+	}
+	pairs, next, err := s.GetSnapshot(c.Version).ScanPage(c.NextStartKey, endKey, pageSize, opts...)
+	return pairs, next, errors.Trace(err)
+}
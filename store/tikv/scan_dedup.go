@@ -0,0 +1,77 @@
+// Copyright 2021 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tikv
+
+import (
+	"hash/fnv"
+)
+
+const (
+	duplicateCheckHashes = 4
+	// duplicateCheckKeyOverhead is the estimated memory of a key in the exact set
+	// besides its bytes.
+	duplicateCheckKeyOverhead = 32
+)
+
+// duplicateChecker finds the keys returned more than once by a scan. A bloom filter
+// tells whether a key may have been returned, and the keys it reports are confirmed
+// by an exact set of the returned keys. A quarter of the memory budget is used by the
+// bloom filter and the rest by the exact set. Once the exact set is full, the later
+// keys are only added to the bloom filter, and their duplicates can't be confirmed
+// and are not reported.
+type duplicateChecker struct {
+	bits       []uint64
+	exact      map[string]struct{}
+	exactBytes int
+	exactLimit int
+}
+
+func newDuplicateChecker(budget int) *duplicateChecker {
+	words := budget / 4 / 8
+	if words < 1 {
+		words = 1
+	}
+	return &duplicateChecker{
+		bits:       make([]uint64, words),
+		exact:      make(map[string]struct{}),
+		exactLimit: budget - words*8,
+	}
+}
+
+// add records key as returned, it returns true if the key has been returned before.
+func (d *duplicateChecker) add(key []byte) bool {
+	h := fnv.New64a()
+	_, _ = h.Write(key)
+	sum := h.Sum64()
+	h1, h2 := uint32(sum), uint32(sum>>32)
+	nbits := uint32(len(d.bits) * 64)
+	mayContain := true
+	for i := uint32(0); i < duplicateCheckHashes; i++ {
+		bit := (h1 + i*h2) % nbits
+		if d.bits[bit/64]&(1<<(bit%64)) == 0 {
+			mayContain = false
+			d.bits[bit/64] |= 1 << (bit % 64)
+		}
+	}
+	if mayContain {
+		if _, ok := d.exact[string(key)]; ok {
+			return true
+		}
+	}
+	if size := len(key) + duplicateCheckKeyOverhead; d.exactBytes+size <= d.exactLimit {
+		d.exact[string(key)] = struct{}{}
+		d.exactBytes += size
+	}
+	return false
+}
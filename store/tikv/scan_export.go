@@ -0,0 +1,85 @@
+// Copyright 2021 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tikv
+
+import (
+	"encoding/binary"
+	"io"
+
+	"github.com/pingcap/errors"
+)
+
+// ExportFormat is the encoding of the key-value pairs read from an ExportReader.
+type ExportFormat int
+
+const (
+	// ExportFormatLengthPrefixed encodes each pair as
+	// uvarint(len(key)) | key | uvarint(len(value)) | value.
+	ExportFormatLengthPrefixed ExportFormat = iota
+)
+
+// exportReader encodes the pairs of a scanner on demand. Only the pair being read
+// is buffered, and the buffer is reused across pairs.
+type exportReader struct {
+	scanner *Scanner
+	buf     []byte
+	off     int
+	err     error
+}
+
+// ExportReader returns a reader of the remaining key-value pairs of the scanner
+// encoded in format. The scanner is advanced as the reader is consumed, and it
+// shouldn't be used by others meanwhile. The reader returns io.EOF once the scanner
+// is exhausted, or the error of the scan if it fails.
+func (s *Scanner) ExportReader(format ExportFormat) (io.Reader, error) {
+	if format != ExportFormatLengthPrefixed {
+		return nil, errors.Errorf("unsupported export format: %d", format)
+	}
+	return &exportReader{scanner: s}, nil
+}
+
+func (r *exportReader) Read(p []byte) (int, error) {
+	n := 0
+	for n < len(p) {
+		if r.off == len(r.buf) {
+			if r.err != nil {
+				break
+			}
+			if !r.scanner.Valid() {
+				r.err = io.EOF
+				break
+			}
+			r.buf = appendLengthPrefixed(r.buf[:0], r.scanner.Key())
+			r.buf = appendLengthPrefixed(r.buf, r.scanner.Value())
+			r.off = 0
+			if err := r.scanner.Next(); err != nil {
+				r.err = errors.Trace(err)
+			}
+		}
+		c := copy(p[n:], r.buf[r.off:])
+		n += c
+		r.off += c
+	}
+	if n > 0 {
+		return n, nil
+	}
+	return 0, r.err
+}
+
+func appendLengthPrefixed(buf, data []byte) []byte {
+	var num [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(num[:], uint64(len(data)))
+	buf = append(buf, num[:n]...)
+	return append(buf, data...)
+}
@@ -0,0 +1,92 @@
+// Copyright 2021 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tikv
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"hash"
+	"sort"
+)
+
+// fingerprintVersion is the version of the inputs hashed by Fingerprint, which is
+// bumped once the inputs change, so that the old fingerprints don't collide.
+const fingerprintVersion = 1
+
+// Fingerprint returns a stable hex-encoded hash of the inputs which decide the
+// pairs returned by the scanner, e.g. as the key of a read-through cache of scan
+// results. Two scanners with the same fingerprint return the same pairs if both of
+// them finish without errors.
+//
+// The inputs are the snapshot version, the isolation level, the locks bypassed,
+// the range and its direction, the keyspace, and the options changing the returned
+// pairs, e.g. KeyOnly, SampleStep and WithStrippedKeyspace. The options which only
+// change how the pairs are read or fail the scan, e.g. the batch size, the limits,
+// the deadlines and the replica read, aren't hashed. The inputs are taken when the
+// scanner is created, so it doesn't change during the scan.
+//
+// It's empty if the pairs can't be identified by the inputs, i.e. the scanner has
+// a key filter, a stop condition or a request interceptor, whose functions can't
+// be hashed, skips dead letters, which depend on the lock resolution, or refreshes
+// its snapshot by WithTSORefresh.
+func (s *Scanner) Fingerprint() string {
+	return s.fingerprint
+}
+
+// computeFingerprint computes the fingerprint of the scanner from its inputs.
+func (s *Scanner) computeFingerprint() string {
+	if s.keyFilter != nil || s.shouldStop != nil || s.interceptRequest != nil || s.skipDeadLetter || s.tsoRefreshInterval > 0 {
+		return ""
+	}
+	h := sha256.New()
+	writeFingerprintUint(h, fingerprintVersion)
+	writeFingerprintUint(h, s.snapshot.version)
+	writeFingerprintUint(h, uint64(s.snapshot.isolationLevel))
+	bypassLocks := append([]uint64(nil), s.snapshot.bypassLocks...)
+	sort.Slice(bypassLocks, func(i, j int) bool { return bypassLocks[i] < bypassLocks[j] })
+	writeFingerprintUint(h, uint64(len(bypassLocks)))
+	for _, ts := range bypassLocks {
+		writeFingerprintUint(h, ts)
+	}
+	writeFingerprintBytes(h, s.rangeStart)
+	writeFingerprintBytes(h, s.endKey)
+	writeFingerprintBool(h, s.reverse)
+	writeFingerprintBytes(h, s.keyspace)
+	writeFingerprintBool(h, s.stripKeyspace)
+	writeFingerprintBool(h, s.keyOnly)
+	writeFingerprintUint(h, uint64(s.sampleStep))
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func writeFingerprintUint(h hash.Hash, v uint64) {
+	var num [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(num[:], v)
+	h.Write(num[:n])
+}
+
+// writeFingerprintBytes writes the length-prefixed b, so that the adjacent inputs
+// can't be confused by moving bytes between them.
+func writeFingerprintBytes(h hash.Hash, b []byte) {
+	writeFingerprintUint(h, uint64(len(b)))
+	h.Write(b)
+}
+
+func writeFingerprintBool(h hash.Hash, v bool) {
+	if v {
+		writeFingerprintUint(h, 1)
+	} else {
+		writeFingerprintUint(h, 0)
+	}
+}
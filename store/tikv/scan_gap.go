@@ -0,0 +1,99 @@
+// Copyright 2021 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tikv
+
+import (
+	"github.com/pingcap/errors"
+	"github.com/pingcap/tidb/store/tikv/kv"
+)
+
+// KeySuccessor returns the key expected right after key in a dense range, e.g. the
+// record key of the next handle for the records of auto-increment IDs.
+type KeySuccessor func(key []byte) []byte
+
+// GapScanner scans a range whose keys are expected to be dense, and reports the
+// ranges of the expected keys which are absent as it iterates, e.g. for integrity
+// checks of monotonic key sequences. A gap is reported once the key after it is
+// read, as range [next(previous key), key). Keys before the first key and after
+// the last key of the range aren't expected, as there is no key to expect them
+// from, and keys less than the expected key, i.e. extra keys, aren't reported.
+type GapScanner struct {
+	scanner  *Scanner
+	next     KeySuccessor
+	onGap    func(gap kv.KeyRange)
+	expected []byte
+	gaps     int
+}
+
+// NewGapScanner creates a GapScanner for range [startKey, endKey) of the snapshot,
+// which expects the key next(key) after every key, and calls onGap with the gaps.
+func (s *KVSnapshot) NewGapScanner(startKey, endKey []byte, batchSize int, next KeySuccessor, onGap func(gap kv.KeyRange), opts ...ScannerOption) (*GapScanner, error) {
+	scanner, err := newScanner(s, startKey, endKey, batchSize, false, opts...)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	g := &GapScanner{scanner: scanner, next: next, onGap: onGap}
+	g.checkGap()
+	return g, nil
+}
+
+// checkGap reports the gap before the current key, and expects the key after it.
+func (g *GapScanner) checkGap() {
+	if !g.scanner.Valid() {
+		return
+	}
+	key := g.scanner.Key()
+	if g.expected != nil && kv.CmpKey(key, g.expected) > 0 {
+		g.gaps++
+		if g.onGap != nil {
+			g.onGap(kv.KeyRange{StartKey: g.expected, EndKey: append([]byte(nil), key...)})
+		}
+	}
+	g.expected = g.next(key)
+}
+
+// Valid returns whether the scanner has a current key-value pair.
+func (g *GapScanner) Valid() bool {
+	return g.scanner.Valid()
+}
+
+// Key returns the current key.
+func (g *GapScanner) Key() []byte {
+	return g.scanner.Key()
+}
+
+// Value returns the current value.
+func (g *GapScanner) Value() []byte {
+	return g.scanner.Value()
+}
+
+// Gaps returns the number of gaps found so far.
+func (g *GapScanner) Gaps() int {
+	return g.gaps
+}
+
+// Next moves the scanner to the next key-value pair, and reports the gap before it
+// if there is one.
+func (g *GapScanner) Next() error {
+	if err := g.scanner.Next(); err != nil {
+		return errors.Trace(err)
+	}
+	g.checkGap()
+	return nil
+}
+
+// Close closes the scanner.
+func (g *GapScanner) Close() {
+	g.scanner.Close()
+}
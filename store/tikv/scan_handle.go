@@ -0,0 +1,97 @@
+// Copyright 2021 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tikv
+
+import (
+	"github.com/pingcap/errors"
+	tidbkv "github.com/pingcap/tidb/kv"
+)
+
+// HandleDecoder decodes a record key into its row handle, e.g. tablecodec.DecodeRowKey.
+type HandleDecoder func(key []byte) (tidbkv.Handle, error)
+
+// HandleIterator scans a record range and decodes the keys into row handles as it
+// iterates, so that the callers don't decode the keys themselves. A key which can't
+// be decoded, e.g. a malformed key in the range, doesn't fail the scan: its Handle
+// is nil and HandleErr tells why, while Key still returns the raw key.
+type HandleIterator struct {
+	scanner   *Scanner
+	decode    HandleDecoder
+	handle    tidbkv.Handle
+	handleErr error
+}
+
+// NewHandleIterator creates a HandleIterator for range [startKey, endKey) of the
+// snapshot, which decodes the keys by decode.
+func (s *KVSnapshot) NewHandleIterator(startKey, endKey []byte, batchSize int, decode HandleDecoder, opts ...ScannerOption) (*HandleIterator, error) {
+	scanner, err := newScanner(s, startKey, endKey, batchSize, false, opts...)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	it := &HandleIterator{scanner: scanner, decode: decode}
+	it.decodeHandle()
+	return it, nil
+}
+
+func (it *HandleIterator) decodeHandle() {
+	it.handle, it.handleErr = nil, nil
+	if it.scanner.Valid() {
+		it.handle, it.handleErr = it.decode(it.scanner.Key())
+		if it.handleErr != nil {
+			it.handle = nil
+		}
+	}
+}
+
+// Valid returns whether the iterator has a current key-value pair.
+func (it *HandleIterator) Valid() bool {
+	return it.scanner.Valid()
+}
+
+// Key returns the current raw key.
+func (it *HandleIterator) Key() []byte {
+	return it.scanner.Key()
+}
+
+// Value returns the current value.
+func (it *HandleIterator) Value() []byte {
+	return it.scanner.Value()
+}
+
+// Handle returns the handle decoded from the current key, nil if the key can't be
+// decoded.
+func (it *HandleIterator) Handle() tidbkv.Handle {
+	return it.handle
+}
+
+// HandleErr returns the error of decoding the current key, nil if it's decoded.
+func (it *HandleIterator) HandleErr() error {
+	return it.handleErr
+}
+
+// Next moves the iterator to the next key-value pair.
+func (it *HandleIterator) Next() error {
+	if err := it.scanner.Next(); err != nil {
+		it.handle, it.handleErr = nil, nil
+		return errors.Trace(err)
+	}
+	it.decodeHandle()
+	return nil
+}
+
+// Close closes the iterator.
+func (it *HandleIterator) Close() {
+	it.scanner.Close()
+	it.handle, it.handleErr = nil, nil
+}
@@ -0,0 +1,107 @@
+// Copyright 2021 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tikv
+
+import (
+	"sync"
+	"time"
+)
+
+// ScanProgress is the progress of a scanner reported by heartbeats.
+type ScanProgress struct {
+	// Cursor is the key the next batch is read from, which is the end key of the
+	// next batch for reverse scans.
+	Cursor []byte
+	// Rows is the number of pairs the scanner has returned.
+	Rows int
+	// Bytes is the total size of keys and values the scanner has read, including the
+	// pairs skipped by filters.
+	Bytes int
+}
+
+// WithHeartbeat makes the scanner call fn with its progress every interval until it's
+// closed, even if no pair is returned in the meantime, e.g. due to a key filter
+// matching few keys, so that monitors can tell slow scans from hung ones. fn is
+// called in a separate goroutine, which doesn't block the requests of the scanner,
+// and it must not call the methods of the scanner.
+func WithHeartbeat(interval time.Duration, fn func(ScanProgress)) ScannerOption {
+	return func(s *Scanner) {
+		s.heartbeat = &scanHeartbeat{interval: interval, fn: fn}
+	}
+}
+
+type scanHeartbeat struct {
+	interval time.Duration
+	fn       func(ScanProgress)
+
+	mu       sync.Mutex
+	progress ScanProgress
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+func (h *scanHeartbeat) start() {
+	if h == nil || h.stop != nil {
+		return
+	}
+	h.stop, h.done = make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(h.done)
+		ticker := time.NewTicker(h.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				h.mu.Lock()
+				progress := h.progress
+				h.mu.Unlock()
+				h.fn(progress)
+			case <-h.stop:
+				return
+			}
+		}
+	}()
+}
+
+// close stops the heartbeats and waits for the running callback to return.
+func (h *scanHeartbeat) close() {
+	if h == nil || h.stop == nil {
+		return
+	}
+	select {
+	case <-h.stop:
+	default:
+		close(h.stop)
+	}
+	<-h.done
+}
+
+func (h *scanHeartbeat) addRow() {
+	if h == nil {
+		return
+	}
+	h.mu.Lock()
+	h.progress.Rows++
+	h.mu.Unlock()
+}
+
+func (h *scanHeartbeat) setRead(cursor []byte, bytes int) {
+	if h == nil {
+		return
+	}
+	h.mu.Lock()
+	h.progress.Cursor, h.progress.Bytes = cursor, bytes
+	h.mu.Unlock()
+}
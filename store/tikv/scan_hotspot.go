@@ -0,0 +1,134 @@
+// Copyright 2021 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tikv
+
+import (
+	"context"
+	"time"
+
+	"github.com/pingcap/errors"
+)
+
+// hotspotStreak is the number of consecutive slow or busy requests to a region
+// which make the region a hotspot.
+const hotspotStreak = 3
+
+// HotspotRegion is a region detected as a hotspot by a scan.
+type HotspotRegion struct {
+	RegionID uint64
+	// SlowRequests is the number of the slow or busy requests to the region since the
+	// streak of them which made it a hotspot.
+	SlowRequests int
+	// Delay is the current delay before every request to the region.
+	Delay time.Duration
+}
+
+// WithHotspotThrottle makes the scanner detect the hotspot regions, which answer
+// hotspotStreak requests in a row slower than slowThreshold or with ServerIsBusy,
+// and throttle the requests to them instead of hammering them. Once a region is
+// detected, a warning is logged, and the scanner waits before every request to the
+// region. The delay starts from slowThreshold and doubles on every further slow
+// request up to maxDelay, and halves on every fast one. The hotspots are reported
+// by ScannerStats.Hotspots, e.g. for operators to rebalance the regions.
+func WithHotspotThrottle(slowThreshold, maxDelay time.Duration) ScannerOption {
+	return func(s *Scanner) {
+		if maxDelay < slowThreshold {
+			maxDelay = slowThreshold
+		}
+		s.hotspots = &hotspotDetector{
+			slowThreshold: slowThreshold,
+			maxDelay:      maxDelay,
+			regions:       make(map[uint64]*regionHeat),
+		}
+	}
+}
+
+// regionHeat is the state of a region tracked by hotspotDetector.
+type regionHeat struct {
+	streak int
+	// hotspot is the index of the region in hotspotDetector.hotspots, -1 if the
+	// region isn't a hotspot.
+	hotspot int
+}
+
+// hotspotDetector tracks the latency and the ServerIsBusy errors of the requests
+// to every region, and the throttling delays of the hotspots.
+type hotspotDetector struct {
+	slowThreshold time.Duration
+	maxDelay      time.Duration
+	regions       map[uint64]*regionHeat
+	// hotspots are the detected hotspots in the order they are detected.
+	hotspots []HotspotRegion
+}
+
+// record records a request to the region, and returns whether the region is
+// detected as a hotspot by the request.
+func (d *hotspotDetector) record(regionID uint64, latency time.Duration, busy bool) bool {
+	heat, ok := d.regions[regionID]
+	if !ok {
+		heat = &regionHeat{hotspot: -1}
+		d.regions[regionID] = heat
+	}
+	var hotspot *HotspotRegion
+	if heat.hotspot >= 0 {
+		hotspot = &d.hotspots[heat.hotspot]
+	}
+	if !busy && latency <= d.slowThreshold {
+		heat.streak = 0
+		if hotspot != nil {
+			hotspot.Delay /= 2
+		}
+		return false
+	}
+	heat.streak++
+	if hotspot != nil {
+		hotspot.SlowRequests++
+		if hotspot.Delay *= 2; hotspot.Delay < d.slowThreshold {
+			hotspot.Delay = d.slowThreshold
+		} else if hotspot.Delay > d.maxDelay {
+			hotspot.Delay = d.maxDelay
+		}
+		return false
+	}
+	if heat.streak < hotspotStreak {
+		return false
+	}
+	heat.hotspot = len(d.hotspots)
+	d.hotspots = append(d.hotspots, HotspotRegion{RegionID: regionID, SlowRequests: heat.streak, Delay: d.slowThreshold})
+	return true
+}
+
+// throttle waits for the delay of the region if it's a hotspot.
+func (d *hotspotDetector) throttle(ctx context.Context, regionID uint64) error {
+	heat, ok := d.regions[regionID]
+	if !ok || heat.hotspot < 0 || d.hotspots[heat.hotspot].Delay <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(d.hotspots[heat.hotspot].Delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return errors.Trace(ctx.Err())
+	}
+}
+
+// snapshot returns a copy of the detected hotspots, nil if detection is disabled.
+func (d *hotspotDetector) snapshot() []HotspotRegion {
+	if d == nil {
+		return nil
+	}
+	return append([]HotspotRegion(nil), d.hotspots...)
+}
@@ -0,0 +1,63 @@
+// Copyright 2021 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tikv
+
+import (
+	"time"
+
+	. "github.com/pingcap/check"
+)
+
+type testHotspotSuite struct {
+}
+
+var _ = Suite(&testHotspotSuite{})
+
+func (s *testHotspotSuite) TestHotspotDetector(c *C) {
+	scanner := &Scanner{}
+	WithHotspotThrottle(10*time.Millisecond, 35*time.Millisecond)(scanner)
+	d := scanner.hotspots
+	slow, fast := 20*time.Millisecond, time.Millisecond
+
+	// A fast request breaks the streak.
+	c.Assert(d.record(1, slow, false), IsFalse)
+	c.Assert(d.record(1, slow, false), IsFalse)
+	c.Assert(d.record(1, fast, false), IsFalse)
+	c.Assert(d.record(1, slow, false), IsFalse)
+	c.Assert(d.record(1, fast, true), IsFalse)
+	c.Assert(d.record(2, slow, false), IsFalse)
+	c.Assert(d.snapshot(), HasLen, 0)
+	// ServerIsBusy counts as slow even if the request is fast.
+	c.Assert(d.record(1, fast, true), IsTrue)
+	c.Assert(d.snapshot(), DeepEquals, []HotspotRegion{{RegionID: 1, SlowRequests: 3, Delay: 10 * time.Millisecond}})
+
+	// The delay doubles up to the max delay, and halves on fast requests.
+	d.record(1, slow, false)
+	d.record(1, slow, false)
+	c.Assert(d.snapshot()[0].Delay, Equals, 35*time.Millisecond)
+	d.record(1, fast, false)
+	c.Assert(d.snapshot()[0].Delay, Equals, 17500*time.Microsecond)
+	c.Assert(d.snapshot()[0].SlowRequests, Equals, 5)
+	d.record(1, slow, false)
+	c.Assert(d.snapshot()[0].Delay, Equals, 35*time.Millisecond)
+
+	d.record(2, slow, false)
+	c.Assert(d.record(2, slow, false), IsTrue)
+	hotspots := d.snapshot()
+	c.Assert(hotspots, HasLen, 2)
+	c.Assert(hotspots[1].RegionID, Equals, uint64(2))
+
+	var disabled *hotspotDetector
+	c.Assert(disabled.snapshot(), IsNil)
+}
@@ -0,0 +1,76 @@
+// Copyright 2021 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tikv
+
+import (
+	"bytes"
+	"context"
+	"sort"
+
+	"github.com/pingcap/errors"
+)
+
+// IndexBuilder returns the index keys of a row of the primary data, e.g. the keys
+// of one index of a table built from a record.
+type IndexBuilder func(key, value []byte) ([][]byte, error)
+
+// IndexScanProgress is called by CollectIndexKeys after every batch of rows, with
+// the number of rows scanned so far and the key of the last one.
+type IndexScanProgress func(rows int, lastKey []byte)
+
+// CollectIndexKeys scans the primary data in range [startKey, endKey) at the
+// snapshot, and collects the index keys build returns for every row, e.g. to
+// compare them with the actual index for index checks and repairs. The keys are
+// returned in ascending order without duplicates. The scan is aborted once ctx is
+// canceled or build fails. progress is optional.
+func (s *KVSnapshot) CollectIndexKeys(ctx context.Context, startKey, endKey []byte, build IndexBuilder, progress IndexScanProgress) ([][]byte, error) {
+	scanner, err := newScanner(s, startKey, endKey, scanBatchSize, false, WithContext(ctx))
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	defer scanner.Close()
+	var (
+		indexKeys [][]byte
+		rows      int
+	)
+	for scanner.Valid() {
+		if err = ctx.Err(); err != nil {
+			return nil, errors.Trace(err)
+		}
+		chunk, err := scanner.NextChunk(scanBatchSize)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		for _, pair := range chunk {
+			keys, err := build(pair.Key, pair.Value)
+			if err != nil {
+				return nil, errors.Trace(err)
+			}
+			indexKeys = append(indexKeys, keys...)
+		}
+		rows += len(chunk)
+		if progress != nil && len(chunk) > 0 {
+			progress(rows, chunk[len(chunk)-1].Key)
+		}
+	}
+
+	sort.Slice(indexKeys, func(i, j int) bool { return bytes.Compare(indexKeys[i], indexKeys[j]) < 0 })
+	unique := indexKeys[:0]
+	for i, key := range indexKeys {
+		if i == 0 || !bytes.Equal(key, indexKeys[i-1]) {
+			unique = append(unique, key)
+		}
+	}
+	return unique, nil
+}
@@ -0,0 +1,179 @@
+// Copyright 2021 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tikv
+
+import (
+	"github.com/pingcap/errors"
+	"github.com/pingcap/tidb/store/tikv/kv"
+)
+
+// RegionInterleavedScanner scans range [startKey, endKey) region by region in
+// turns, reading at most rowCap rows from a region before moving to the next
+// region, and continuing the region in the next turn. It keeps any region from
+// dominating the scan, and at most rowCap pairs of a region are buffered at a time.
+// The pairs are in key order within a region, but not across regions.
+//
+// The turns are fetched one at a time, not in parallel, so a slow region delays
+// only its own turns rather than all the regions after it: the other regions make
+// progress by a turn each between two turns of the slow region.
+//
+// The regions are taken from the region cache when the scanner is created, and
+// each of them is read by its own Scanner bounded by the range of the region. A
+// turn requests at most rowCap rows, so a region is at its eof only when its
+// Scanner reaches the end of the region, not when a turn returns rowCap rows. The
+// boundaries don't move during the scan: a region split or merged meanwhile is
+// read as a range by its Scanner, which follows the new regions covering the range.
+type RegionInterleavedScanner struct {
+	snapshot *KVSnapshot
+	ranges   []kv.KeyRange
+	rowCap   int
+	opts     []ScannerOption
+
+	scanners []*Scanner
+	done     []bool
+	// cur is the region of the current pair, -1 if there is none, and taken is the
+	// number of rows read from it in the current turn.
+	cur   int
+	taken int
+}
+
+// NewRegionInterleavedScanner creates a RegionInterleavedScanner for range
+// [startKey, endKey) of the snapshot, which reads at most rowCap rows from a region
+// in a turn.
+func (s *KVSnapshot) NewRegionInterleavedScanner(startKey, endKey []byte, rowCap int, opts ...ScannerOption) (*RegionInterleavedScanner, error) {
+	if rowCap <= 0 {
+		return nil, errors.Errorf("invalid row cap %d", rowCap)
+	}
+	regions, err := s.regionsInRange(startKey, endKey)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	ranges := make([]kv.KeyRange, len(regions))
+	for i, region := range regions {
+		ranges[i] = kv.KeyRange{StartKey: region.StartKey, EndKey: region.EndKey}
+		if i == 0 {
+			ranges[i].StartKey = startKey
+		}
+		if i == len(regions)-1 {
+			ranges[i].EndKey = endKey
+		}
+	}
+	// newScanner takes batch size 1 as the default batch size, so set it by an option.
+	limitCap := func(s *Scanner) { s.setBatchSize(rowCap) }
+	r := &RegionInterleavedScanner{
+		snapshot: s,
+		ranges:   ranges,
+		rowCap:   rowCap,
+		opts:     append(opts[:len(opts):len(opts)], limitCap),
+		scanners: make([]*Scanner, len(ranges)),
+		done:     make([]bool, len(ranges)),
+		cur:      -1,
+	}
+	if err = r.nextTurn(0); err != nil {
+		r.Close()
+		return nil, errors.Trace(err)
+	}
+	return r, nil
+}
+
+// nextTurn gives the turn to the first region from the i-th one in round-robin
+// order which isn't at its eof.
+func (r *RegionInterleavedScanner) nextTurn(i int) error {
+	r.cur, r.taken = -1, 0
+	for n := 0; n < len(r.ranges); n, i = n+1, (i+1)%len(r.ranges) {
+		if r.done[i] {
+			continue
+		}
+		if r.scanners[i] == nil {
+			scanner, err := newRangeScanner(r.snapshot, r.ranges[i], r.rowCap, r.opts...)
+			if err != nil {
+				return errors.Trace(err)
+			}
+			r.scanners[i] = scanner
+		}
+		if r.scanners[i].Valid() {
+			r.cur = i
+			return nil
+		}
+		r.finish(i)
+	}
+	return nil
+}
+
+func (r *RegionInterleavedScanner) finish(i int) {
+	r.done[i] = true
+	if r.scanners[i] != nil {
+		r.scanners[i].Close()
+		r.scanners[i] = nil
+	}
+}
+
+// Valid returns whether the scanner has a current key-value pair.
+func (r *RegionInterleavedScanner) Valid() bool {
+	return r.cur >= 0
+}
+
+// Key returns the current key.
+func (r *RegionInterleavedScanner) Key() []byte {
+	if r.cur < 0 {
+		return nil
+	}
+	return r.scanners[r.cur].Key()
+}
+
+// Value returns the current value.
+func (r *RegionInterleavedScanner) Value() []byte {
+	if r.cur < 0 {
+		return nil
+	}
+	return r.scanners[r.cur].Value()
+}
+
+// Region returns the index of the region of the current pair in the regions of the
+// range when the scanner is created, -1 if there is none.
+func (r *RegionInterleavedScanner) Region() int {
+	return r.cur
+}
+
+// Next moves the scanner to the next key-value pair, which is in the next region
+// once rowCap rows have been read from the current region in this turn.
+func (r *RegionInterleavedScanner) Next() error {
+	if r.cur < 0 {
+		return nil
+	}
+	cur := r.cur
+	if err := r.scanners[cur].Next(); err != nil {
+		r.Close()
+		return errors.Trace(err)
+	}
+	r.taken++
+	if !r.scanners[cur].Valid() {
+		r.finish(cur)
+	} else if r.taken < r.rowCap {
+		return nil
+	}
+	if err := r.nextTurn((cur + 1) % len(r.ranges)); err != nil {
+		r.Close()
+		return errors.Trace(err)
+	}
+	return nil
+}
+
+// Close closes the scanner.
+func (r *RegionInterleavedScanner) Close() {
+	for i := range r.scanners {
+		r.finish(i)
+	}
+	r.cur = -1
+}
@@ -0,0 +1,136 @@
+// Copyright 2021 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tikv
+
+import (
+	"bytes"
+	"context"
+	"sort"
+
+	"github.com/pingcap/errors"
+	pb "github.com/pingcap/kvproto/pkg/kvrpcpb"
+)
+
+// KeysScanner reads a set of exact keys and returns the existing ones in ascending
+// key order, as if they were scanned, e.g. for sorted point-set reads with the
+// iterator API instead of the map of BatchGet. The keys are read by BatchGet in
+// batches of batchSize keys in key order, and each batch is grouped by region and
+// read by a BatchGet request per region, so no range is scanned.
+type KeysScanner struct {
+	ctx       context.Context
+	snapshot  *KVSnapshot
+	keys      [][]byte
+	batchSize int
+
+	// cache are the existing pairs of the latest batch, and idx is the current one.
+	cache []*pb.KvPair
+	idx   int
+}
+
+// NewKeysScanner creates a KeysScanner reading keys from the snapshot. The keys
+// are copied and sorted, and the duplicates are read once.
+func (s *KVSnapshot) NewKeysScanner(ctx context.Context, keys [][]byte, batchSize int) (*KeysScanner, error) {
+	scanner, err := newKeysScanner(ctx, s, keys, batchSize)
+	return scanner, errors.Trace(err)
+}
+
+func newKeysScanner(ctx context.Context, snapshot *KVSnapshot, keys [][]byte, batchSize int) (*KeysScanner, error) {
+	if batchSize <= 0 {
+		batchSize = scanBatchSize
+	}
+	sorted := append([][]byte(nil), keys...)
+	sort.Slice(sorted, func(i, j int) bool { return bytes.Compare(sorted[i], sorted[j]) < 0 })
+	unique := sorted[:0]
+	for i, key := range sorted {
+		if i == 0 || !bytes.Equal(key, sorted[i-1]) {
+			unique = append(unique, key)
+		}
+	}
+	k := &KeysScanner{
+		ctx:       ctx,
+		snapshot:  snapshot,
+		keys:      unique,
+		batchSize: batchSize,
+	}
+	if err := k.fill(); err != nil {
+		return nil, errors.Trace(err)
+	}
+	return k, nil
+}
+
+// fill reads the batches of the remaining keys until one of them has an existing
+// pair or no key remains.
+func (k *KeysScanner) fill() error {
+	k.cache, k.idx = k.cache[:0], 0
+	for len(k.cache) == 0 && len(k.keys) > 0 {
+		n := k.batchSize
+		if n > len(k.keys) {
+			n = len(k.keys)
+		}
+		batch := k.keys[:n]
+		values, err := k.snapshot.BatchGet(k.ctx, batch)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		for _, key := range batch {
+			if value, ok := values[string(key)]; ok {
+				k.cache = append(k.cache, &pb.KvPair{Key: key, Value: value})
+			}
+		}
+		k.keys = k.keys[n:]
+	}
+	return nil
+}
+
+// Valid returns whether the scanner has a current key-value pair.
+func (k *KeysScanner) Valid() bool {
+	return k.idx < len(k.cache)
+}
+
+// Key returns the current key.
+func (k *KeysScanner) Key() []byte {
+	if !k.Valid() {
+		return nil
+	}
+	return k.cache[k.idx].Key
+}
+
+// Value returns the current value.
+func (k *KeysScanner) Value() []byte {
+	if !k.Valid() {
+		return nil
+	}
+	return k.cache[k.idx].Value
+}
+
+// Next moves the scanner to the next existing key, and reads the next batch of
+// keys once the current batch is exhausted.
+func (k *KeysScanner) Next() error {
+	if !k.Valid() {
+		return nil
+	}
+	if k.idx++; k.idx < len(k.cache) {
+		return nil
+	}
+	if err := k.fill(); err != nil {
+		k.Close()
+		return errors.Trace(err)
+	}
+	return nil
+}
+
+// Close closes the scanner.
+func (k *KeysScanner) Close() {
+	k.cache, k.idx, k.keys = nil, 0, nil
+}
@@ -0,0 +1,112 @@
+// Copyright 2021 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tikv
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+
+	"github.com/pingcap/tidb/store/tikv/kv"
+)
+
+// scanKillRegistry keeps the cancel functions of the running scanners by the
+// connections they belong to, so that killing a connection cancels its scans.
+type scanKillRegistry struct {
+	mu    sync.Mutex
+	scans map[uint64]map[*Scanner]func()
+}
+
+func (r *scanKillRegistry) register(connID uint64, s *Scanner, kill func()) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.scans == nil {
+		r.scans = make(map[uint64]map[*Scanner]func())
+	}
+	if r.scans[connID] == nil {
+		r.scans[connID] = make(map[*Scanner]func())
+	}
+	r.scans[connID][s] = kill
+}
+
+func (r *scanKillRegistry) unregister(connID uint64, s *Scanner) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	scans := r.scans[connID]
+	delete(scans, s)
+	if len(scans) == 0 {
+		delete(r.scans, connID)
+	}
+}
+
+// kill cancels the scans of the connection and returns the number of them.
+func (r *scanKillRegistry) kill(connID uint64) int {
+	r.mu.Lock()
+	scans := r.scans[connID]
+	delete(r.scans, connID)
+	r.mu.Unlock()
+	for _, kill := range scans {
+		kill()
+	}
+	return len(scans)
+}
+
+// KillScans cancels the running scanners created with WithConnID(connID), e.g. when
+// the connection is killed by `KILL <connID>`. The in-flight requests and backoff of
+// the scanners are aborted at once, and the scanners return ErrQueryInterrupted. It
+// returns the number of the canceled scanners.
+func (s *KVStore) KillScans(connID uint64) int {
+	return s.scanKills.kill(connID)
+}
+
+// WithConnID registers the scanner as a scan of connection connID until it's closed,
+// so that it can be canceled by KVStore.KillScans.
+func WithConnID(connID uint64) ScannerOption {
+	return func(s *Scanner) {
+		s.connID = connID
+		s.killable = true
+	}
+}
+
+// registerKill makes the context of the scanner cancelable by KillScans.
+func (s *Scanner) registerKill() {
+	if !s.killable {
+		return
+	}
+	var cancel context.CancelFunc
+	s.ctx, cancel = context.WithCancel(s.ctx)
+	s.killCancel = cancel
+	s.snapshot.store.scanKills.register(s.connID, s, func() {
+		atomic.StoreUint32(&s.killed, 1)
+		cancel()
+	})
+}
+
+func (s *Scanner) unregisterKill() {
+	if s.killCancel == nil {
+		return
+	}
+	s.snapshot.store.scanKills.unregister(s.connID, s)
+	s.killCancel()
+	s.killCancel = nil
+}
+
+// checkKilled returns ErrQueryInterrupted if the scanner has been killed, otherwise
+// it returns err.
+func (s *Scanner) checkKilled(err error) error {
+	if err != nil && atomic.LoadUint32(&s.killed) == 1 {
+		return kv.ErrQueryInterrupted
+	}
+	return err
+}
@@ -0,0 +1,114 @@
+// Copyright 2021 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tikv
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// LatencyHistogram records latencies into buckets with fixed upper bounds, e.g. the
+// latencies of the scan requests of one query for tail-latency analysis. It's safe
+// for concurrent use, so the scanners of a query can share one histogram.
+type LatencyHistogram struct {
+	mu     sync.Mutex
+	bounds []time.Duration
+	// counts[i] is the number of latencies in (bounds[i-1], bounds[i]], and the last
+	// one counts the latencies above all bounds.
+	counts []int64
+	total  int64
+	max    time.Duration
+}
+
+// NewLatencyHistogram creates a LatencyHistogram whose buckets are bounded by
+// bounds, which are sorted if they aren't.
+func NewLatencyHistogram(bounds []time.Duration) *LatencyHistogram {
+	bounds = append([]time.Duration(nil), bounds...)
+	sort.Slice(bounds, func(i, j int) bool { return bounds[i] < bounds[j] })
+	return &LatencyHistogram{bounds: bounds, counts: make([]int64, len(bounds)+1)}
+}
+
+// ExponentialLatencyBounds returns n bucket bounds starting from start, each of
+// which is factor times the previous one, so that the relative error of the
+// quantiles is the same across the range like in HdrHistogram.
+func ExponentialLatencyBounds(start time.Duration, factor float64, n int) []time.Duration {
+	bounds := make([]time.Duration, 0, n)
+	bound := float64(start)
+	for i := 0; i < n; i++ {
+		bounds = append(bounds, time.Duration(bound))
+		bound *= factor
+	}
+	return bounds
+}
+
+// Record records a latency.
+func (h *LatencyHistogram) Record(d time.Duration) {
+	i := sort.Search(len(h.bounds), func(i int) bool { return h.bounds[i] >= d })
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.counts[i]++
+	h.total++
+	if d > h.max {
+		h.max = d
+	}
+}
+
+// Count returns the number of recorded latencies.
+func (h *LatencyHistogram) Count() int64 {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.total
+}
+
+// Max returns the max recorded latency.
+func (h *LatencyHistogram) Max() time.Duration {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.max
+}
+
+// Quantile returns the upper bound of the bucket holding the q-th quantile of the
+// recorded latencies, e.g. 0.99 for p99. The max latency is returned if it's less
+// than the bound or above all bounds, and 0 if nothing is recorded.
+func (h *LatencyHistogram) Quantile(q float64) time.Duration {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.total == 0 {
+		return 0
+	}
+	rank := int64(q*float64(h.total) + 0.5)
+	if rank < 1 {
+		rank = 1
+	}
+	var seen int64
+	for i, count := range h.counts {
+		if seen += count; seen < rank {
+			continue
+		}
+		if i < len(h.bounds) && h.bounds[i] < h.max {
+			return h.bounds[i]
+		}
+		break
+	}
+	return h.max
+}
+
+// WithLatencyHistogram makes the scanner record the latency of every scan request
+// into h, excluding the backoff of retries. It's reported as RPCLatency of Stats.
+func WithLatencyHistogram(h *LatencyHistogram) ScannerOption {
+	return func(s *Scanner) {
+		s.latency = h
+	}
+}
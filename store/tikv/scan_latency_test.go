@@ -0,0 +1,51 @@
+// Copyright 2021 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tikv
+
+import (
+	"time"
+
+	. "github.com/pingcap/check"
+)
+
+type testLatencyHistogramSuite struct {
+}
+
+var _ = Suite(&testLatencyHistogramSuite{})
+
+func (s *testLatencyHistogramSuite) TestQuantile(c *C) {
+	bounds := ExponentialLatencyBounds(time.Millisecond, 2, 4)
+	c.Assert(bounds, DeepEquals, []time.Duration{time.Millisecond, 2 * time.Millisecond, 4 * time.Millisecond, 8 * time.Millisecond})
+	h := NewLatencyHistogram(bounds)
+	c.Assert(h.Quantile(0.5), Equals, time.Duration(0))
+
+	for i := 0; i < 90; i++ {
+		h.Record(500 * time.Microsecond)
+	}
+	for i := 0; i < 9; i++ {
+		h.Record(3 * time.Millisecond)
+	}
+	h.Record(20 * time.Millisecond)
+	c.Assert(h.Count(), Equals, int64(100))
+	c.Assert(h.Max(), Equals, 20*time.Millisecond)
+	c.Assert(h.Quantile(0.5), Equals, time.Millisecond)
+	c.Assert(h.Quantile(0.95), Equals, 4*time.Millisecond)
+	// The latencies above all bounds are reported by the max.
+	c.Assert(h.Quantile(0.999), Equals, 20*time.Millisecond)
+
+	// The bound isn't reported if all latencies in its bucket are less than it.
+	h = NewLatencyHistogram(bounds)
+	h.Record(1500 * time.Microsecond)
+	c.Assert(h.Quantile(0.5), Equals, 1500*time.Microsecond)
+}
@@ -0,0 +1,190 @@
+// Copyright 2021 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tikv
+
+import (
+	"bytes"
+	"context"
+	"sort"
+	"sync"
+	"sync/atomic"
+
+	"github.com/pingcap/errors"
+	"github.com/pingcap/kvproto/pkg/kvrpcpb"
+	"github.com/pingcap/tidb/store/tikv/kv"
+	"github.com/pingcap/tidb/store/tikv/logutil"
+	"github.com/pingcap/tidb/store/tikv/tikvrpc"
+	"go.uber.org/zap"
+)
+
+// scanLockLimit is the max number of locks returned by a single ScanLock request.
+const scanLockLimit = ResolvedCacheSize / 2
+
+// ScanLocks returns all locks in range [startKey, endKey) whose start_ts is not
+// greater than maxTS. An empty endKey means the range is unbounded. The locks
+// are only listed, they are not resolved.
+func (s *KVStore) ScanLocks(startKey, endKey []byte, maxTS uint64) ([]*Lock, error) {
+	var locks []*Lock
+	key := startKey
+	for {
+		bo := NewBackofferWithVars(context.Background(), GcResolveLockMaxBackoff, nil)
+		_, regionLocks, next, err := s.scanRegionLocks(bo, key, endKey, maxTS)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		locks = append(locks, regionLocks...)
+		if isScanLockFinished(next, endKey) {
+			return locks, nil
+		}
+		key = next
+	}
+}
+
+// ResolveLocksInRange resolves the locks in range [startKey, endKey) whose start_ts
+// is not greater than safepoint and whose transactions have finished or expired,
+// e.g. the locks left by a crashed coordinator. The transaction status is checked
+// with the current TS like a reader meeting the locks does, so the locks of live
+// transactions are kept and returned instead of being rolled back, whatever
+// safepoint is. Regions are processed by at most concurrency workers, and the
+// progress is logged periodically. It returns the number of resolved locks and the
+// live locks in key order.
+func (s *KVStore) ResolveLocksInRange(ctx context.Context, startKey, endKey []byte, safepoint uint64, concurrency int) (int, []*Lock, error) {
+	var (
+		resolved int64
+		mu       sync.Mutex
+		live     []*Lock
+	)
+	handler := func(ctx context.Context, r kv.KeyRange) (RangeTaskStat, error) {
+		var stat RangeTaskStat
+		key := r.StartKey
+		bo := NewBackofferWithVars(ctx, GcResolveLockMaxBackoff, nil)
+		for {
+			loc, locks, next, err := s.scanRegionLocks(bo, key, r.EndKey, safepoint)
+			if err != nil {
+				return stat, errors.Trace(err)
+			}
+			var expired, regionLive []*Lock
+			for _, l := range locks {
+				status, err := s.lockResolver.getTxnStatusFromLock(bo, l, 0, false)
+				if err != nil {
+					return stat, errors.Trace(err)
+				}
+				if status.ttl == 0 {
+					expired = append(expired, l)
+				} else {
+					regionLive = append(regionLive, l)
+				}
+			}
+			// The statuses of the finished transactions are cached, so the locks are
+			// resolved without checking them again.
+			if _, _, err = s.lockResolver.ResolveLocks(bo, 0, expired); err != nil {
+				return stat, errors.Trace(err)
+			}
+			atomic.AddInt64(&resolved, int64(len(expired)))
+			if len(regionLive) > 0 {
+				mu.Lock()
+				live = append(live, regionLive...)
+				mu.Unlock()
+			}
+			if bytes.Equal(next, loc.EndKey) {
+				stat.CompletedRegions++
+			}
+			if isScanLockFinished(next, r.EndKey) {
+				return stat, nil
+			}
+			key = next
+			bo = NewBackofferWithVars(ctx, GcResolveLockMaxBackoff, nil)
+		}
+	}
+
+	runner := NewRangeTaskRunner("resolve-locks-in-range", s, concurrency, handler)
+	err := runner.RunOnRange(ctx, startKey, endKey)
+	sort.Slice(live, func(i, j int) bool {
+		return bytes.Compare(live[i].Key, live[j].Key) < 0
+	})
+	if err != nil {
+		return int(atomic.LoadInt64(&resolved)), live, errors.Trace(err)
+	}
+	logutil.Logger(ctx).Info("resolve locks in range finished",
+		zap.String("startKey", kv.StrKey(startKey)),
+		zap.String("endKey", kv.StrKey(endKey)),
+		zap.Uint64("safePoint", safepoint),
+		zap.Int("regions", runner.CompletedRegions()),
+		zap.Int64("resolved locks", resolved),
+		zap.Int("live locks", len(live)))
+	return int(resolved), live, nil
+}
+
+// scanRegionLocks scans locks from key in the region containing key, retrying
+// on region errors. It returns the region, the locks in [key, endKey) and the
+// key to continue scanning from.
+func (s *KVStore) scanRegionLocks(bo *Backoffer, key, endKey []byte, maxTS uint64) (*KeyLocation, []*Lock, []byte, error) {
+	for {
+		loc, err := s.regionCache.LocateKey(bo, key)
+		if err != nil {
+			return nil, nil, nil, errors.Trace(err)
+		}
+		regionEnd := loc.EndKey
+		if len(endKey) > 0 && (len(regionEnd) == 0 || bytes.Compare(regionEnd, endKey) > 0) {
+			regionEnd = endKey
+		}
+		req := tikvrpc.NewRequest(tikvrpc.CmdScanLock, &kvrpcpb.ScanLockRequest{
+			MaxVersion: maxTS,
+			StartKey:   key,
+			EndKey:     regionEnd,
+			Limit:      scanLockLimit,
+		})
+		resp, err := s.SendReq(bo, req, loc.Region, ReadTimeoutMedium)
+		if err != nil {
+			return nil, nil, nil, errors.Trace(err)
+		}
+		regionErr, err := resp.GetRegionError()
+		if err != nil {
+			return nil, nil, nil, errors.Trace(err)
+		}
+		if regionErr != nil {
+			err = bo.Backoff(BoRegionMiss, errors.New(regionErr.String()))
+			if err != nil {
+				return nil, nil, nil, errors.Trace(err)
+			}
+			continue
+		}
+		if resp.Resp == nil {
+			return nil, nil, nil, errors.Trace(kv.ErrBodyMissing)
+		}
+		locksResp := resp.Resp.(*kvrpcpb.ScanLockResponse)
+		if locksResp.GetError() != nil {
+			return nil, nil, nil, errors.Errorf("unexpected scanlock error: %s", locksResp)
+		}
+		locksInfo := locksResp.GetLocks()
+		locks := make([]*Lock, 0, len(locksInfo))
+		for _, info := range locksInfo {
+			// The server may return locks of the whole region, filter out the
+			// ones outside the requested range.
+			if bytes.Compare(info.Key, key) < 0 || (len(regionEnd) > 0 && bytes.Compare(info.Key, regionEnd) >= 0) {
+				continue
+			}
+			locks = append(locks, NewLock(info))
+		}
+		next := loc.EndKey
+		if len(locksInfo) >= scanLockLimit {
+			next = kv.NextKey(locksInfo[len(locksInfo)-1].Key)
+		}
+		return loc, locks, next, nil
+	}
+}
+
+func isScanLockFinished(next, endKey []byte) bool {
+	return len(next) == 0 || (len(endKey) > 0 && bytes.Compare(next, endKey) >= 0)
+}
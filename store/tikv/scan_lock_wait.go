@@ -0,0 +1,97 @@
+// Copyright 2021 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tikv
+
+import (
+	"sync"
+
+	"github.com/pingcap/errors"
+	pb "github.com/pingcap/kvproto/pkg/kvrpcpb"
+)
+
+type lockWaitKey struct {
+	key   string
+	txnID uint64
+}
+
+// lockWaitQueue queues the scanners waiting for the same lock in FIFO order. Only
+// the head of a queue waits for the lock to be released or resolved, the others
+// are parked until the waiters ahead of them have read the key, so that the
+// scanners which have waited the longest read first.
+type lockWaitQueue struct {
+	mu      sync.Mutex
+	waiters map[lockWaitKey][]chan struct{}
+}
+
+// enqueue adds a waiter of the lock to the tail of its queue. The returned channel
+// is closed when it's the turn of the waiter, and it must be passed to leave once
+// the waiter has finished.
+func (q *lockWaitQueue) enqueue(lock *Lock) chan struct{} {
+	k := lockWaitKey{key: string(lock.Key), txnID: lock.TxnID}
+	turn := make(chan struct{})
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.waiters == nil {
+		q.waiters = make(map[lockWaitKey][]chan struct{})
+	}
+	waiters := q.waiters[k]
+	if len(waiters) == 0 {
+		close(turn)
+	}
+	q.waiters[k] = append(waiters, turn)
+	return turn
+}
+
+// leave removes a waiter of the lock from its queue, and hands the turn over to the
+// next waiter if it's the head.
+func (q *lockWaitQueue) leave(lock *Lock, turn chan struct{}) {
+	k := lockWaitKey{key: string(lock.Key), txnID: lock.TxnID}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	waiters := q.waiters[k]
+	for i, w := range waiters {
+		if w != turn {
+			continue
+		}
+		waiters = append(waiters[:i:i], waiters[i+1:]...)
+		if i == 0 && len(waiters) > 0 {
+			close(waiters[0])
+		}
+		break
+	}
+	if len(waiters) == 0 {
+		delete(q.waiters, k)
+	} else {
+		q.waiters[k] = waiters
+	}
+}
+
+// waitLockTurn queues the scanner for the lock on current and blocks until it's the
+// turn of the scanner. The returned function must be called after the key is read.
+func (s *Scanner) waitLockTurn(bo *Backoffer, current *pb.KvPair) (func(), error) {
+	lock, err := extractLockFromKeyErr(current.GetError())
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	queue := &s.snapshot.store.lockWaiters
+	turn := queue.enqueue(lock)
+	done := func() { queue.leave(lock, turn) }
+	select {
+	case <-turn:
+		return done, nil
+	case <-bo.ctx.Done():
+		done()
+		return nil, errors.Trace(bo.ctx.Err())
+	}
+}
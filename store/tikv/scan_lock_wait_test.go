@@ -0,0 +1,52 @@
+// Copyright 2021 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tikv
+
+import (
+	. "github.com/pingcap/check"
+)
+
+type testLockWaitQueueSuite struct {
+}
+
+var _ = Suite(&testLockWaitQueueSuite{})
+
+func isClosed(ch chan struct{}) bool {
+	select {
+	case <-ch:
+		return true
+	default:
+		return false
+	}
+}
+
+func (s *testLockWaitQueueSuite) TestFIFO(c *C) {
+	var q lockWaitQueue
+	lock := &Lock{Key: []byte("k"), TxnID: 1}
+	other := &Lock{Key: []byte("k"), TxnID: 2}
+
+	first, second, third := q.enqueue(lock), q.enqueue(lock), q.enqueue(lock)
+	c.Assert(isClosed(first), IsTrue)
+	c.Assert(isClosed(second), IsFalse)
+	// Waiters of other locks are not blocked.
+	c.Assert(isClosed(q.enqueue(other)), IsTrue)
+
+	// A waiter leaving before its turn doesn't affect the order.
+	q.leave(lock, second)
+	c.Assert(isClosed(third), IsFalse)
+	q.leave(lock, first)
+	c.Assert(isClosed(third), IsTrue)
+	q.leave(lock, third)
+	c.Assert(q.waiters, HasLen, 1)
+}
@@ -0,0 +1,155 @@
+// Copyright 2021 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tikv
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"hash"
+
+	"github.com/pingcap/errors"
+	"github.com/pingcap/tidb/store/tikv/kv"
+)
+
+// MerkleNode is a node of a Merkle tree over a range, whose hash covers the
+// key-value pairs in its range. The leaves cover the sub-ranges the tree is
+// partitioned into, and every other node covers the ranges of its children.
+type MerkleNode struct {
+	Range kv.KeyRange
+	// Hash is the SHA-256 hash of the pairs in the range for leaves, and of the
+	// hashes of the children for the other nodes.
+	Hash []byte
+	// Keys is the number of keys in the range.
+	Keys     int64
+	Children []*MerkleNode
+}
+
+// BuildMerkleTree scans range [startKey, endKey) and builds a binary Merkle tree
+// over it, whose leaves are the sub-ranges split by boundaries, e.g. for comparing
+// two clusters or snapshots by DiffMerkleTrees without transferring all data. The
+// boundaries must be sorted, and the ones out of the range are ignored. If
+// boundaries is nil, the range is split by the regions of the snapshot.
+//
+// Two trees can be compared leaf by leaf only if they are split by the same
+// boundaries, so pass the same boundaries for the trees of clusters whose regions
+// differ. TiKV can't hash values on the server, so the values are read and hashed
+// by the scanner, set KeyOnly option of the snapshot to compare the keys only.
+func (s *KVSnapshot) BuildMerkleTree(startKey, endKey []byte, boundaries [][]byte, opts ...ScannerOption) (*MerkleNode, error) {
+	if boundaries == nil {
+		var err error
+		if boundaries, err = s.regionBoundaries(startKey, endKey); err != nil {
+			return nil, errors.Trace(err)
+		}
+	}
+	leaves := []*MerkleNode{{Range: kv.KeyRange{StartKey: startKey}}}
+	for i, boundary := range boundaries {
+		if i > 0 && kv.CmpKey(boundaries[i-1], boundary) > 0 {
+			return nil, errors.Errorf("boundaries %d and %d are not sorted", i-1, i)
+		}
+		if kv.CmpKey(boundary, startKey) <= 0 || (len(endKey) > 0 && kv.CmpKey(boundary, endKey) >= 0) ||
+			kv.CmpKey(boundary, leaves[len(leaves)-1].Range.StartKey) == 0 {
+			continue
+		}
+		leaves[len(leaves)-1].Range.EndKey = boundary
+		leaves = append(leaves, &MerkleNode{Range: kv.KeyRange{StartKey: boundary}})
+	}
+	leaves[len(leaves)-1].Range.EndKey = endKey
+
+	scanner, err := newScanner(s, startKey, endKey, scanBatchSize, false, opts...)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	defer scanner.Close()
+	i, h := 0, sha256.New()
+	for scanner.Valid() {
+		for len(leaves[i].Range.EndKey) > 0 && kv.CmpKey(scanner.Key(), leaves[i].Range.EndKey) >= 0 {
+			leaves[i].Hash = h.Sum(nil)
+			i, h = i+1, sha256.New()
+		}
+		hashPair(h, scanner.Key(), scanner.Value())
+		leaves[i].Keys++
+		if err = scanner.Next(); err != nil {
+			return nil, errors.Trace(err)
+		}
+	}
+	for ; i < len(leaves); i, h = i+1, sha256.New() {
+		leaves[i].Hash = h.Sum(nil)
+	}
+	return buildMerkleLevels(leaves), nil
+}
+
+// hashPair writes the length-prefixed key and the hash of the value to h, so that
+// the pairs can't be confused by moving bytes between keys and values.
+func hashPair(h hash.Hash, key, value []byte) {
+	var num [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(num[:], uint64(len(key)))
+	h.Write(num[:n])
+	h.Write(key)
+	valueHash := sha256.Sum256(value)
+	h.Write(valueHash[:])
+}
+
+// buildMerkleLevels builds the levels above nodes pair by pair, and returns the
+// root. The last node of an odd level is moved up as is.
+func buildMerkleLevels(nodes []*MerkleNode) *MerkleNode {
+	for len(nodes) > 1 {
+		parents := make([]*MerkleNode, 0, (len(nodes)+1)/2)
+		for i := 0; i < len(nodes); i += 2 {
+			if i+1 == len(nodes) {
+				parents = append(parents, nodes[i])
+				break
+			}
+			left, right := nodes[i], nodes[i+1]
+			h := sha256.New()
+			h.Write(left.Hash)
+			h.Write(right.Hash)
+			parents = append(parents, &MerkleNode{
+				Range:    kv.KeyRange{StartKey: left.Range.StartKey, EndKey: right.Range.EndKey},
+				Hash:     h.Sum(nil),
+				Keys:     left.Keys + right.Keys,
+				Children: []*MerkleNode{left, right},
+			})
+		}
+		nodes = parents
+	}
+	return nodes[0]
+}
+
+// DiffMerkleTrees compares two Merkle trees built by BuildMerkleTree, and returns
+// the ranges of the leaves whose hashes differ, descending only into the subtrees
+// whose hashes differ. If the trees are split differently, the smallest subtree
+// covering the differences and the different splits is returned.
+func DiffMerkleTrees(a, b *MerkleNode) []kv.KeyRange {
+	if bytes.Equal(a.Hash, b.Hash) {
+		return nil
+	}
+	if len(a.Children) == 0 || len(a.Children) != len(b.Children) || !sameRange(a.Range, b.Range) {
+		return []kv.KeyRange{a.Range}
+	}
+	for i := range a.Children {
+		if !sameRange(a.Children[i].Range, b.Children[i].Range) {
+			return []kv.KeyRange{a.Range}
+		}
+	}
+	var diffs []kv.KeyRange
+	for i := range a.Children {
+		diffs = append(diffs, DiffMerkleTrees(a.Children[i], b.Children[i])...)
+	}
+	return diffs
+}
+
+func sameRange(a, b kv.KeyRange) bool {
+	return bytes.Equal(a.StartKey, b.StartKey) && bytes.Equal(a.EndKey, b.EndKey)
+}
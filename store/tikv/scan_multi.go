@@ -0,0 +1,193 @@
+// Copyright 2021 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tikv
+
+import (
+	"bytes"
+	"context"
+	"sort"
+
+	"github.com/pingcap/errors"
+	"github.com/pingcap/tidb/store/tikv/kv"
+)
+
+// MultiRangeScanner scans several ranges at the same snapshot version, e.g. the
+// record range of a table and the range of one of its indexes, so that the
+// results of the ranges are consistent with each other. Each range is served by
+// its own Scanner and can be advanced independently, while all of them share
+// one context: once it is canceled, or any range fails, all ranges are closed.
+type MultiRangeScanner struct {
+	ctx      context.Context
+	cancel   context.CancelFunc
+	scanners []*Scanner
+}
+
+// NewMultiRangeScanner creates a MultiRangeScanner for ranges on the snapshot. The
+// i-th range is accessed by index i of the returned scanner.
+func (s *KVSnapshot) NewMultiRangeScanner(ctx context.Context, ranges []kv.KeyRange, batchSize int) (*MultiRangeScanner, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	m := &MultiRangeScanner{
+		ctx:      ctx,
+		cancel:   cancel,
+		scanners: make([]*Scanner, 0, len(ranges)),
+	}
+	for _, r := range ranges {
+		scanner, err := newRangeScanner(s, r, batchSize, WithContext(ctx))
+		if err != nil {
+			m.Close()
+			return nil, errors.Trace(err)
+		}
+		m.scanners = append(m.scanners, scanner)
+	}
+	return m, nil
+}
+
+// Len returns the number of ranges.
+func (m *MultiRangeScanner) Len() int {
+	return len(m.scanners)
+}
+
+// Valid returns whether the i-th range has a current key-value pair.
+func (m *MultiRangeScanner) Valid(i int) bool {
+	return m.scanners[i].Valid()
+}
+
+// Key returns the current key of the i-th range.
+func (m *MultiRangeScanner) Key(i int) []byte {
+	return m.scanners[i].Key()
+}
+
+// Value returns the current value of the i-th range.
+func (m *MultiRangeScanner) Value(i int) []byte {
+	return m.scanners[i].Value()
+}
+
+// Next moves the i-th range to its next key-value pair. All ranges are closed if
+// the context is canceled or the scan fails.
+func (m *MultiRangeScanner) Next(i int) error {
+	if err := m.ctx.Err(); err != nil {
+		m.Close()
+		return errors.Trace(err)
+	}
+	if err := m.scanners[i].Next(); err != nil {
+		m.Close()
+		return errors.Trace(err)
+	}
+	return nil
+}
+
+// Close closes all ranges.
+func (m *MultiRangeScanner) Close() {
+	m.cancel()
+	for _, scanner := range m.scanners {
+		scanner.Close()
+	}
+}
+
+// AnyKeyInRanges reports whether any key exists in each of ranges at the snapshot,
+// e.g. for existence checks of anti-joins. Each range is probed by key-only scans
+// of one key, which stop at the first key found. Ranges starting in the same region
+// are probed one by one, while different regions are probed concurrently.
+func (s *KVSnapshot) AnyKeyInRanges(ranges []kv.KeyRange) ([]bool, error) {
+	bo := NewBackofferWithVars(context.Background(), locateRegionMaxBackoff, s.vars)
+	groups := make(map[RegionVerID][]int)
+	for i, r := range ranges {
+		loc, err := s.store.regionCache.LocateKey(bo, r.StartKey)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		groups[loc.Region] = append(groups[loc.Region], i)
+	}
+
+	// newScanner takes batch size 1 as the default batch size, so set it by an option.
+	limitOne := func(s *Scanner) { s.setBatchSize(1) }
+	found := make([]bool, len(ranges))
+	errCh := make(chan error, len(groups))
+	for _, group := range groups {
+		go func(group []int) {
+			for _, i := range group {
+				scanner, err := newRangeScanner(s, ranges[i], 1, WithKeyOnly(), limitOne)
+				if err != nil {
+					errCh <- err
+					return
+				}
+				found[i] = scanner.Valid()
+				scanner.Close()
+			}
+			errCh <- nil
+		}(group)
+	}
+	var err error
+	for range groups {
+		if e := <-errCh; e != nil && err == nil {
+			err = e
+		}
+	}
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	return found, nil
+}
+
+// KeyPresence tells which of the expected keys of ScanKeyPresence exist.
+type KeyPresence struct {
+	Present [][]byte
+	Absent  [][]byte
+}
+
+// ScanKeyPresence scans range [startKey, endKey) at the snapshot, and reports which
+// of the expected keys exist in it, e.g. for referential integrity checks. The keys
+// out of the range are absent. Only the part of the range between the smallest and
+// the largest expected keys is scanned, and the keys are reported in ascending order
+// without duplicates.
+func (s *KVSnapshot) ScanKeyPresence(startKey, endKey []byte, expected [][]byte) (*KeyPresence, error) {
+	keys := make([][]byte, len(expected))
+	copy(keys, expected)
+	sort.Slice(keys, func(i, j int) bool { return bytes.Compare(keys[i], keys[j]) < 0 })
+	presence := &KeyPresence{}
+	var inRange [][]byte
+	for i, key := range keys {
+		if i > 0 && bytes.Equal(key, keys[i-1]) {
+			continue
+		}
+		if bytes.Compare(key, startKey) < 0 || (len(endKey) > 0 && bytes.Compare(key, endKey) >= 0) {
+			presence.Absent = append(presence.Absent, key)
+			continue
+		}
+		inRange = append(inRange, key)
+	}
+	if len(inRange) == 0 {
+		return presence, nil
+	}
+
+	scanner, err := newScanner(s, inRange[0], kv.NextKey(inRange[len(inRange)-1]), scanBatchSize, false, WithKeyOnly())
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	defer scanner.Close()
+	for _, key := range inRange {
+		for scanner.Valid() && bytes.Compare(scanner.Key(), key) < 0 {
+			if err = scanner.Next(); err != nil {
+				return nil, errors.Trace(err)
+			}
+		}
+		if scanner.Valid() && bytes.Equal(scanner.Key(), key) {
+			presence.Present = append(presence.Present, key)
+		} else {
+			presence.Absent = append(presence.Absent, key)
+		}
+	}
+	sort.Slice(presence.Absent, func(i, j int) bool { return bytes.Compare(presence.Absent[i], presence.Absent[j]) < 0 })
+	return presence, nil
+}
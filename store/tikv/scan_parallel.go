@@ -0,0 +1,254 @@
+// Copyright 2021 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tikv
+
+import (
+	"bytes"
+	"container/heap"
+	"context"
+	"sync"
+
+	"github.com/pingcap/errors"
+)
+
+// OrderedParallelScanner scans a range with several workers in parallel and
+// merges their results, so that the key-value pairs are still returned in
+// ascending key order. Each worker scans its own sub-range of consecutive regions
+// and hands over one pair at a time, so at most one pending pair per worker is
+// held besides the batches of the underlying Scanners.
+type OrderedParallelScanner struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+	heads  scanMergeHeap
+	cur    *scanMergeItem
+
+	concurrency int
+	controller  *concurrencyController
+}
+
+type scanResult struct {
+	key   []byte
+	value []byte
+	err   error
+}
+
+type scanMergeItem struct {
+	scanResult
+	ch <-chan scanResult
+}
+
+type scanMergeHeap []*scanMergeItem
+
+func (h scanMergeHeap) Len() int           { return len(h) }
+func (h scanMergeHeap) Less(i, j int) bool { return bytes.Compare(h[i].key, h[j].key) < 0 }
+func (h scanMergeHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+
+func (h *scanMergeHeap) Push(x interface{}) {
+	*h = append(*h, x.(*scanMergeItem))
+}
+
+func (h *scanMergeHeap) Pop() interface{} {
+	old := *h
+	item := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return item
+}
+
+// NewOrderedParallelScanner creates an OrderedParallelScanner for range
+// [startKey, endKey) on the snapshot. The regions in the range are divided into at
+// most concurrency sub-ranges, each of which is scanned by a worker with the
+// given batchSize.
+func (s *KVSnapshot) NewOrderedParallelScanner(ctx context.Context, startKey, endKey []byte, concurrency, batchSize int) (*OrderedParallelScanner, error) {
+	p, err := s.newOrderedParallelScanner(ctx, startKey, endKey, concurrency, batchSize, false)
+	return p, errors.Trace(err)
+}
+
+// NewAdaptiveOrderedParallelScanner is NewOrderedParallelScanner with the number of
+// in-flight batch requests tuned automatically. The workers start with one batch
+// request in flight, which is increased up to maxConcurrency while the cluster serves
+// them fast, and decreased once the latency or region errors rise.
+func (s *KVSnapshot) NewAdaptiveOrderedParallelScanner(ctx context.Context, startKey, endKey []byte, maxConcurrency, batchSize int) (*OrderedParallelScanner, error) {
+	p, err := s.newOrderedParallelScanner(ctx, startKey, endKey, maxConcurrency, batchSize, true)
+	return p, errors.Trace(err)
+}
+
+func (s *KVSnapshot) newOrderedParallelScanner(ctx context.Context, startKey, endKey []byte, concurrency, batchSize int, adaptive bool) (*OrderedParallelScanner, error) {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	boundaries, err := s.regionBoundaries(startKey, endKey)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	p := &OrderedParallelScanner{ctx: ctx, cancel: cancel}
+	regions := len(boundaries) + 1
+	if concurrency > regions {
+		concurrency = regions
+	}
+	p.concurrency = concurrency
+	if adaptive {
+		p.controller = newConcurrencyController(concurrency)
+	}
+	rangeStart := startKey
+	for i := 0; i < concurrency; i++ {
+		rangeEnd := endKey
+		if i < concurrency-1 {
+			rangeEnd = boundaries[(i+1)*regions/concurrency-1]
+		}
+		ch := make(chan scanResult)
+		p.wg.Add(1)
+		go p.runWorker(s, rangeStart, rangeEnd, batchSize, ch)
+		p.heads = append(p.heads, &scanMergeItem{ch: ch})
+		rangeStart = rangeEnd
+	}
+
+	// Wait for the first pair of all workers before building the heap.
+	heads := p.heads
+	p.heads = p.heads[:0]
+	for _, item := range heads {
+		ok, err := p.receive(item)
+		if err != nil {
+			p.Close()
+			return nil, errors.Trace(err)
+		}
+		if ok {
+			p.heads = append(p.heads, item)
+		}
+	}
+	heap.Init(&p.heads)
+	p.pop()
+	return p, nil
+}
+
+func (p *OrderedParallelScanner) runWorker(snapshot *KVSnapshot, startKey, endKey []byte, batchSize int, ch chan<- scanResult) {
+	defer p.wg.Done()
+	defer close(ch)
+	send := func(r scanResult) bool {
+		select {
+		case ch <- r:
+			return true
+		case <-p.ctx.Done():
+			return false
+		}
+	}
+	scanner, err := newScanner(snapshot, startKey, endKey, batchSize, false, WithContext(p.ctx), func(s *Scanner) {
+		s.controller = p.controller
+	})
+	if err != nil {
+		send(scanResult{err: err})
+		return
+	}
+	defer scanner.Close()
+	for scanner.Valid() {
+		if !send(scanResult{key: scanner.Key(), value: scanner.Value()}) {
+			return
+		}
+		if err = scanner.Next(); err != nil {
+			send(scanResult{err: err})
+			return
+		}
+	}
+}
+
+// receive fetches the next pair of the worker of item into item. It returns false
+// if the worker has finished.
+func (p *OrderedParallelScanner) receive(item *scanMergeItem) (bool, error) {
+	var (
+		r  scanResult
+		ok bool
+	)
+	select {
+	case r, ok = <-item.ch:
+	case <-p.ctx.Done():
+		return false, errors.Trace(p.ctx.Err())
+	}
+	if !ok {
+		return false, nil
+	}
+	if r.err != nil {
+		return false, errors.Trace(r.err)
+	}
+	item.scanResult = r
+	return true, nil
+}
+
+func (p *OrderedParallelScanner) pop() {
+	p.cur = nil
+	if len(p.heads) > 0 {
+		p.cur = heap.Pop(&p.heads).(*scanMergeItem)
+	}
+}
+
+// Valid returns whether the scanner has a current key-value pair.
+func (p *OrderedParallelScanner) Valid() bool {
+	return p.cur != nil
+}
+
+// Key returns the current key.
+func (p *OrderedParallelScanner) Key() []byte {
+	if p.cur == nil {
+		return nil
+	}
+	return p.cur.key
+}
+
+// Value returns the current value.
+func (p *OrderedParallelScanner) Value() []byte {
+	if p.cur == nil {
+		return nil
+	}
+	return p.cur.value
+}
+
+// Next moves the scanner to the next key-value pair in key order. All workers are
+// stopped if the context is canceled or any of them fails.
+func (p *OrderedParallelScanner) Next() error {
+	if p.cur == nil {
+		return errors.New("scanner iterator is invalid")
+	}
+	if err := p.ctx.Err(); err != nil {
+		p.Close()
+		return errors.Trace(err)
+	}
+	item := p.cur
+	ok, err := p.receive(item)
+	if err != nil {
+		p.Close()
+		return errors.Trace(err)
+	}
+	if ok {
+		heap.Push(&p.heads, item)
+	}
+	p.pop()
+	return nil
+}
+
+// Concurrency returns the number of batch requests the workers can send at the same
+// time, which changes over time if the scanner is adaptive.
+func (p *OrderedParallelScanner) Concurrency() int {
+	if p.controller != nil {
+		return p.controller.Limit()
+	}
+	return p.concurrency
+}
+
+// Close stops all workers and waits for them to exit.
+func (p *OrderedParallelScanner) Close() {
+	p.cancel()
+	p.wg.Wait()
+	p.cur = nil
+	p.heads = nil
+}
@@ -0,0 +1,247 @@
+// Copyright 2021 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tikv
+
+import (
+	"bytes"
+	"container/heap"
+	"context"
+
+	"github.com/pingcap/errors"
+	"github.com/pingcap/tidb/store/tikv/kv"
+)
+
+// PartitionRange is the key range of a partition of a logical table.
+type PartitionRange struct {
+	kv.KeyRange
+	// Prefix is the common prefix of the keys of the partition, e.g. the record
+	// prefix of the physical table. It's stripped before the keys of different
+	// partitions are compared by ordered scans.
+	Prefix []byte
+}
+
+// PartitionScanner scans the ranges of the partitions of a logical table as one
+// scan at the same snapshot version. Ordered scans merge the partitions by their
+// keys without the partition prefixes, so that e.g. the rows of all partitions are
+// returned in handle order; the pairs with the same key are returned in partition
+// order. Unordered scans read the partitions one after another. The scanners of
+// the partitions share one context: once it is canceled, or any partition fails,
+// the whole scan is closed.
+type PartitionScanner struct {
+	ctx       context.Context
+	cancel    context.CancelFunc
+	snapshot  *KVSnapshot
+	ranges    []PartitionRange
+	batchSize int
+	ordered   bool
+
+	// scanners are the scanners of the partitions, nil if the partition isn't
+	// opened yet or is closed.
+	scanners []*Scanner
+	// heads are the partitions with a current pair, used by ordered scans.
+	heads partitionHeap
+	// cur is the partition of the current pair, -1 if there is none.
+	cur int
+}
+
+type partitionHeap struct {
+	parts []int
+	p     *PartitionScanner
+}
+
+func (h partitionHeap) Len() int { return len(h.parts) }
+
+func (h partitionHeap) Less(i, j int) bool {
+	pi, pj := h.parts[i], h.parts[j]
+	if cmp := bytes.Compare(h.p.suffix(pi), h.p.suffix(pj)); cmp != 0 {
+		return cmp < 0
+	}
+	return pi < pj
+}
+
+func (h partitionHeap) Swap(i, j int) { h.parts[i], h.parts[j] = h.parts[j], h.parts[i] }
+
+func (h *partitionHeap) Push(x interface{}) {
+	h.parts = append(h.parts, x.(int))
+}
+
+func (h *partitionHeap) Pop() interface{} {
+	old := h.parts
+	part := old[len(old)-1]
+	h.parts = old[:len(old)-1]
+	return part
+}
+
+// NewPartitionScanner creates a PartitionScanner for the partition ranges on the
+// snapshot. Ordered scans open all partitions at once, while unordered scans open
+// a partition only after the previous one is exhausted.
+func (s *KVSnapshot) NewPartitionScanner(ctx context.Context, ranges []PartitionRange, batchSize int, ordered bool) (*PartitionScanner, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	p := &PartitionScanner{
+		ctx:       ctx,
+		cancel:    cancel,
+		snapshot:  s,
+		ranges:    ranges,
+		batchSize: batchSize,
+		ordered:   ordered,
+		scanners:  make([]*Scanner, len(ranges)),
+		cur:       -1,
+	}
+	p.heads.p = p
+	var err error
+	if ordered {
+		for i := range ranges {
+			if err = p.open(i); err != nil {
+				break
+			}
+			if p.scanners[i].Valid() {
+				p.heads.parts = append(p.heads.parts, i)
+			} else {
+				p.closePartition(i)
+			}
+		}
+		heap.Init(&p.heads)
+		p.setOrderedCur()
+	} else {
+		err = p.advanceUnordered(0)
+	}
+	if err != nil {
+		p.Close()
+		return nil, errors.Trace(err)
+	}
+	return p, nil
+}
+
+func (p *PartitionScanner) open(i int) error {
+	scanner, err := newRangeScanner(p.snapshot, p.ranges[i].KeyRange, p.batchSize, WithContext(p.ctx))
+	if err != nil {
+		return errors.Trace(err)
+	}
+	p.scanners[i] = scanner
+	return nil
+}
+
+func (p *PartitionScanner) closePartition(i int) {
+	if p.scanners[i] != nil {
+		p.scanners[i].Close()
+		p.scanners[i] = nil
+	}
+}
+
+// suffix returns the current key of the i-th partition without its prefix.
+func (p *PartitionScanner) suffix(i int) []byte {
+	key := p.scanners[i].Key()
+	if prefix := p.ranges[i].Prefix; bytes.HasPrefix(key, prefix) {
+		return key[len(prefix):]
+	}
+	return key
+}
+
+func (p *PartitionScanner) setOrderedCur() {
+	p.cur = -1
+	if p.heads.Len() > 0 {
+		p.cur = p.heads.parts[0]
+	}
+}
+
+// advanceUnordered moves the unordered scan to the first partition from the i-th
+// one which has a current pair.
+func (p *PartitionScanner) advanceUnordered(i int) error {
+	p.cur = -1
+	for ; i < len(p.ranges); i++ {
+		if p.scanners[i] == nil {
+			if err := p.open(i); err != nil {
+				return errors.Trace(err)
+			}
+		}
+		if p.scanners[i].Valid() {
+			p.cur = i
+			return nil
+		}
+		p.closePartition(i)
+	}
+	return nil
+}
+
+// Valid returns whether the scanner has a current key-value pair.
+func (p *PartitionScanner) Valid() bool {
+	return p.cur >= 0
+}
+
+// Key returns the current key.
+func (p *PartitionScanner) Key() []byte {
+	if !p.Valid() {
+		return nil
+	}
+	return p.scanners[p.cur].Key()
+}
+
+// Value returns the current value.
+func (p *PartitionScanner) Value() []byte {
+	if !p.Valid() {
+		return nil
+	}
+	return p.scanners[p.cur].Value()
+}
+
+// Partition returns the index of the partition range of the current pair, -1 if
+// there is none.
+func (p *PartitionScanner) Partition() int {
+	return p.cur
+}
+
+// Next moves the scanner to the next key-value pair. The scan is closed if the
+// context is canceled or the scan fails.
+func (p *PartitionScanner) Next() error {
+	if !p.Valid() {
+		return nil
+	}
+	if err := p.ctx.Err(); err != nil {
+		p.Close()
+		return errors.Trace(err)
+	}
+	cur := p.cur
+	if err := p.scanners[cur].Next(); err != nil {
+		p.Close()
+		return errors.Trace(err)
+	}
+	if p.scanners[cur].Valid() {
+		if p.ordered {
+			heap.Fix(&p.heads, 0)
+			p.setOrderedCur()
+		}
+		return nil
+	}
+	p.closePartition(cur)
+	if p.ordered {
+		heap.Pop(&p.heads)
+		p.setOrderedCur()
+		return nil
+	}
+	if err := p.advanceUnordered(cur + 1); err != nil {
+		p.Close()
+		return errors.Trace(err)
+	}
+	return nil
+}
+
+// Close closes the scan of all partitions.
+func (p *PartitionScanner) Close() {
+	p.cancel()
+	for i := range p.scanners {
+		p.closePartition(i)
+	}
+	p.heads.parts = nil
+	p.cur = -1
+}
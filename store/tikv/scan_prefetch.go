@@ -0,0 +1,128 @@
+// Copyright 2021 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tikv
+
+import (
+	"context"
+
+	pb "github.com/pingcap/kvproto/pkg/kvrpcpb"
+)
+
+// WithPrefetch makes the scanner fetch up to batches batches ahead in the
+// background as soon as it receives a batch, so that the round trips of the next
+// batches overlap with the processing of the current one by the caller. It
+// overrides ScanPrefetchBatches of the variables of the snapshot, and 0 disables
+// prefetching. Next takes the prefetched batches in order once the current one is
+// exhausted, and their errors are returned then, as if they're fetched by Next.
+// The region errors are retried in the background like the synchronous fetches,
+// and the locks in the batches are resolved by Next.
+//
+// The batches are fetched one after another, as every batch starts from the end of
+// the previous one. The prefetched batches are accounted by WithMemoryTracker only
+// once they're taken, and a paused scanner still prefetches them. The batch size is
+// adapted, e.g. by WithAdaptiveBatchSize, only when no batch is in flight. The
+// methods reading the state of the requests, e.g. Stats and Cursor, and the lock
+// resolution of Next wait for the batches in flight. Close cancels them and waits
+// for them to exit.
+func WithPrefetch(batches int) ScannerOption {
+	return func(s *Scanner) {
+		s.prefetchBatches = batches
+	}
+}
+
+// scanPrefetch is a batch fetched in the background.
+type scanPrefetch struct {
+	cancel context.CancelFunc
+	// done is closed once resp and err are set.
+	done chan struct{}
+	resp *pb.ScanResponse
+	err  error
+	// region and readKey are the region serving the batch and the key the scan has
+	// read to with it, as the state of the requests moves on with the next batches.
+	region  RegionInfo
+	readKey []byte
+	// eof is whether the scan ends with the batch or before it.
+	eof bool
+}
+
+// startPrefetch starts fetching the next batches in the background until
+// prefetchBatches batches are in flight or fetched. The state of the requests, i.e.
+// scanRequester, is owned by the batches in flight until they're done, and each
+// batch is fetched once the batch before it is done.
+func (s *Scanner) startPrefetch() {
+	if len(s.prefetching) >= s.prefetchBatches {
+		return
+	}
+	if len(s.prefetching) == 0 {
+		if s.eof {
+			return
+		}
+		s.adaptBatchSize()
+	}
+	for len(s.prefetching) < s.prefetchBatches {
+		var prev *scanPrefetch
+		if n := len(s.prefetching); n > 0 {
+			prev = s.prefetching[n-1]
+		}
+		ctx, cancel := context.WithCancel(s.ctx)
+		p := &scanPrefetch{cancel: cancel, done: make(chan struct{})}
+		s.prefetching = append(s.prefetching, p)
+		go func() {
+			defer close(p.done)
+			if prev != nil {
+				<-prev.done
+				// The scan fails at the error of the previous batch, or ends with it.
+				if prev.err != nil || prev.eof {
+					p.resp, p.eof = &pb.ScanResponse{}, true
+					return
+				}
+			}
+			bo, cancelBo := s.newBackofferWithContext(ctx)
+			defer cancelBo()
+			p.resp, p.err = s.nextControlledResponse(bo)
+			p.region, p.readKey, p.eof = s.curRegion, s.readKey(), s.eof
+		}()
+	}
+}
+
+// waitPrefetch waits for the batches in flight, so that the state of the requests
+// can be read.
+func (s *Scanner) waitPrefetch() {
+	if n := len(s.prefetching); n > 0 {
+		<-s.prefetching[n-1].done
+	}
+}
+
+// takePrefetched waits for the first prefetched batch and removes it from the
+// queue. The batches after it are dropped if the scan fails or ends with it.
+func (s *Scanner) takePrefetched() *scanPrefetch {
+	p := s.prefetching[0]
+	<-p.done
+	p.cancel()
+	s.prefetching[0] = nil
+	s.prefetching = s.prefetching[1:]
+	if p.err != nil || p.eof {
+		s.stopPrefetch()
+	}
+	return p
+}
+
+// stopPrefetch cancels the batches in flight and waits for them to exit.
+func (s *Scanner) stopPrefetch() {
+	for _, p := range s.prefetching {
+		p.cancel()
+	}
+	s.waitPrefetch()
+	s.prefetching = nil
+}
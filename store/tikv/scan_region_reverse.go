@@ -0,0 +1,149 @@
+// Copyright 2021 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tikv
+
+import (
+	"github.com/pingcap/errors"
+	"github.com/pingcap/tidb/store/tikv/kv"
+)
+
+// RegionReverseScanner scans range [startKey, endKey) region by region forward,
+// and reads every region in reverse, e.g. for reading the newest rows of every
+// time bucket first when the buckets are split into regions.
+//
+// The ordering guarantee is: the range is split by the boundaries of its regions
+// in the region cache when the scanner is created, the sub-ranges are read in
+// ascending order, and the pairs of a sub-range are returned in descending order
+// of the keys. The sub-ranges don't change during the scan, so a region split
+// meanwhile is still read as one sub-range in reverse, and a region merged
+// meanwhile is still read as the separate sub-ranges.
+type RegionReverseScanner struct {
+	snapshot  *KVSnapshot
+	ranges    []kv.KeyRange
+	batchSize int
+	opts      []ScannerOption
+
+	// cur is the index of the sub-range being read by scanner.
+	cur     int
+	scanner *Scanner
+}
+
+// NewRegionReverseScanner creates a RegionReverseScanner for range
+// [startKey, endKey) of the snapshot.
+func (s *KVSnapshot) NewRegionReverseScanner(startKey, endKey []byte, batchSize int, opts ...ScannerOption) (*RegionReverseScanner, error) {
+	regions, err := s.regionsInRange(startKey, endKey)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	ranges := make([]kv.KeyRange, len(regions))
+	for i, region := range regions {
+		ranges[i] = kv.KeyRange{StartKey: region.StartKey, EndKey: region.EndKey}
+		if i == 0 {
+			ranges[i].StartKey = startKey
+		}
+		if i == len(regions)-1 {
+			ranges[i].EndKey = endKey
+		}
+	}
+	r := &RegionReverseScanner{
+		snapshot:  s,
+		ranges:    ranges,
+		batchSize: batchSize,
+		opts:      opts,
+		cur:       -1,
+	}
+	if err = r.nextRange(); err != nil {
+		return nil, errors.Trace(err)
+	}
+	return r, nil
+}
+
+// nextRange moves to the first sub-range after the current one which has a pair.
+func (r *RegionReverseScanner) nextRange() error {
+	for r.cur+1 < len(r.ranges) {
+		r.cur++
+		rg := r.ranges[r.cur]
+		scanner, err := newScanner(r.snapshot, rg.StartKey, rg.EndKey, r.batchSize, true, r.opts...)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		if scanner.Valid() {
+			r.scanner = scanner
+			return nil
+		}
+		scanner.Close()
+	}
+	r.scanner = nil
+	return nil
+}
+
+// Valid returns whether the scanner has a current key-value pair.
+func (r *RegionReverseScanner) Valid() bool {
+	return r.scanner != nil
+}
+
+// Key returns the current key.
+func (r *RegionReverseScanner) Key() []byte {
+	if r.scanner == nil {
+		return nil
+	}
+	return r.scanner.Key()
+}
+
+// Value returns the current value.
+func (r *RegionReverseScanner) Value() []byte {
+	if r.scanner == nil {
+		return nil
+	}
+	return r.scanner.Value()
+}
+
+// Region returns the index of the sub-range of the current pair, i.e. of its
+// region when the scanner is created, -1 if there is none.
+func (r *RegionReverseScanner) Region() int {
+	if r.scanner == nil {
+		return -1
+	}
+	return r.cur
+}
+
+// Next moves the scanner to the next key-value pair, which is the last pair of the
+// next sub-range once the current sub-range is exhausted.
+func (r *RegionReverseScanner) Next() error {
+	if r.scanner == nil {
+		return nil
+	}
+	if err := r.scanner.Next(); err != nil {
+		r.Close()
+		return errors.Trace(err)
+	}
+	if r.scanner.Valid() {
+		return nil
+	}
+	r.scanner.Close()
+	if err := r.nextRange(); err != nil {
+		r.Close()
+		return errors.Trace(err)
+	}
+	return nil
+}
+
+// Close closes the scanner.
+func (r *RegionReverseScanner) Close() {
+	if r.scanner != nil {
+		r.scanner.Close()
+		r.scanner = nil
+	}
+	r.cur = len(r.ranges)
+}
@@ -0,0 +1,131 @@
+// Copyright 2021 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tikv
+
+import (
+	"bytes"
+
+	pb "github.com/pingcap/kvproto/pkg/kvrpcpb"
+	"github.com/pingcap/tidb/store/tikv/kv"
+	"github.com/pingcap/tidb/store/tikv/tikvrpc"
+	"go.uber.org/zap"
+)
+
+// ReplicaDivergence tells which replicas of a region returned a diverged batch to a
+// scanner created with WithAnomalyReread.
+type ReplicaDivergence struct {
+	RegionID uint64
+	// StartKey is the start key of the request of the batch.
+	StartKey []byte
+	// Diverged is the stores whose batches fail the range verification or differ
+	// from the batch of Healthy.
+	Diverged []uint64
+	// Healthy is the store whose batch is used by the scanner, or 0 if none of the
+	// replicas returns a batch passing the verification.
+	Healthy uint64
+}
+
+// WithAnomalyReread makes the scanner verify the ranges of the batches like
+// WithRangeVerification, and re-read a batch from every other replica of the region
+// once it fails the verification. The first batch passing the verification is used,
+// and report is called with the replicas whose batches diverge from it, e.g. for
+// consistency checkers repairing the diverged replicas. The scanner returns
+// ErrKeyOutOfRange if none of the replicas passes.
+func WithAnomalyReread(report func(*ReplicaDivergence)) ScannerOption {
+	return func(s *Scanner) {
+		s.verifyRange = true
+		s.rereadReport = report
+	}
+}
+
+// keyOutOfRange returns the first key of pairs out of the range of the scanner, or
+// nil if all of them are in range.
+func (s *scanRequester) keyOutOfRange(pairs []*pb.KvPair) []byte {
+	for _, pair := range pairs {
+		if kv.CmpKey(pair.Key, s.rangeStart) < 0 || (len(s.endKey) > 0 && kv.CmpKey(pair.Key, s.endKey) >= 0) {
+			return pair.Key
+		}
+	}
+	return nil
+}
+
+// rereadFromReplicas sends req, whose batch pairs from the store of rpcCtx fails the
+// range verification, to the other replicas of the region. It returns the first
+// batch passing the verification, or pairs if there is no such batch.
+func (s *scanRequester) rereadFromReplicas(bo *Backoffer, req *tikvrpc.Request, rpcCtx *RPCContext, pairs []*pb.KvPair) []*pb.KvPair {
+	divergence := &ReplicaDivergence{
+		RegionID: rpcCtx.Region.GetID(),
+		StartKey: req.Scan().GetStartKey(),
+		Diverged: []uint64{rpcCtx.Peer.GetStoreId()},
+	}
+	var healthy []*pb.KvPair
+	for _, peer := range rpcCtx.Meta.GetPeers() {
+		if peer.GetStoreId() == rpcCtx.Peer.GetStoreId() {
+			continue
+		}
+		replicaPairs, ok := s.scanReplica(bo, req, rpcCtx.Region, peer.GetStoreId())
+		if !ok {
+			continue
+		}
+		if s.keyOutOfRange(replicaPairs) == nil && healthy == nil {
+			healthy, divergence.Healthy = replicaPairs, peer.GetStoreId()
+			continue
+		}
+		if s.keyOutOfRange(replicaPairs) != nil || !samePairs(replicaPairs, healthy) {
+			divergence.Diverged = append(divergence.Diverged, peer.GetStoreId())
+		}
+	}
+	s.rereadReport(divergence)
+	if healthy == nil {
+		return pairs
+	}
+	return healthy
+}
+
+// scanReplica sends req to the replica of the region in the store. It returns false
+// if the replica can't serve the request.
+func (s *scanRequester) scanReplica(bo *Backoffer, req *tikvrpc.Request, region RegionVerID, storeID uint64) ([]*pb.KvPair, bool) {
+	sreq := *req.Scan()
+	replicaReq := tikvrpc.NewReplicaReadRequest(tikvrpc.CmdScan, &sreq, kv.ReplicaReadMixed, nil, req.Context)
+	replicaReq.CallOptions = req.CallOptions
+	sender := NewRegionRequestSender(s.snapshot.store.regionCache, s.snapshot.store.client)
+	resp, rpcCtx, err := sender.SendReqCtx(bo, replicaReq, region, ReadTimeoutMedium, tikvrpc.TiKV, WithStoreID(storeID))
+	if err != nil || rpcCtx == nil || rpcCtx.Peer.GetStoreId() != storeID {
+		s.logger().Info("scan replica failed",
+			zap.Uint64("region", region.GetID()),
+			zap.Uint64("store", storeID),
+			zap.Error(err))
+		return nil, false
+	}
+	if regionErr, err := resp.GetRegionError(); err != nil || regionErr != nil || resp.Resp == nil {
+		return nil, false
+	}
+	cmdScanResp := resp.Resp.(*pb.ScanResponse)
+	if cmdScanResp.GetError() != nil {
+		return nil, false
+	}
+	return cmdScanResp.Pairs, true
+}
+
+func samePairs(a, b []*pb.KvPair) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !bytes.Equal(a[i].Key, b[i].Key) || !bytes.Equal(a[i].Value, b[i].Value) {
+			return false
+		}
+	}
+	return true
+}
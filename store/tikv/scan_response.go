@@ -0,0 +1,858 @@
+// Copyright 2021 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tikv
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/pingcap/errors"
+	"github.com/pingcap/failpoint"
+	"github.com/pingcap/kvproto/pkg/errorpb"
+	pb "github.com/pingcap/kvproto/pkg/kvrpcpb"
+	"github.com/pingcap/tidb/store/tikv/kv"
+	"github.com/pingcap/tidb/store/tikv/logutil"
+	"github.com/pingcap/tidb/store/tikv/oracle"
+	"github.com/pingcap/tidb/store/tikv/tikvrpc"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+)
+
+// scanRequester sends scan requests batch by batch and keeps track of where the
+// next request starts. It retries region errors and resolves response-level locks,
+// the pairs of a response are left to the caller.
+type scanRequester struct {
+	// ctx is used by the requests of the scanner, canceling it aborts the scan.
+	ctx          context.Context
+	snapshot     *KVSnapshot
+	batchSize    int
+	nextStartKey []byte
+	endKey       []byte
+
+	// Use for reverse scan.
+	nextEndKey []byte
+	reverse    bool
+
+	keyOnly    bool
+	sampleStep uint32
+
+	// cacheHint overrides whether the requests fill the block cache of TiKV.
+	cacheHint ScanCacheHint
+
+	eof bool
+
+	// curRegion is the region which serves the latest response.
+	curRegion RegionInfo
+
+	// maxRegions is the max number of regions the scanner can touch, 0 means no limit.
+	maxRegions   int
+	regionCount  int
+	lastRegionID uint64
+	// singleRegion makes the scan fail if it's going to move to a second region.
+	singleRegion bool
+
+	// maxLocksResolved is the max number of locks the scanner can resolve, 0 means
+	// no limit.
+	maxLocksResolved int
+	locksResolved    int
+
+	// deadLetter receives the locks the scanner fails to resolve.
+	deadLetter func(lock *Lock, err error)
+
+	// lockNoWait makes the scanner return ErrTxnLockWait instead of waiting for live locks.
+	lockNoWait bool
+
+	// notInitializedSleep is the total backoff time in milliseconds waiting for
+	// regions to be initialized.
+	notInitializedSleep int
+
+	// lastRegionErr is the region error of the latest request if it failed, which is
+	// used to find out region errors that can't be fixed by retrying.
+	lastRegionErr *errorpb.Error
+	lastErrRegion uint64
+
+	// maxDuration is the max time the scan can take, 0 means no limit.
+	maxDuration time.Duration
+	deadline    time.Time
+
+	// keyspace is the prefix of the keys the scan is restricted to.
+	keyspace []byte
+
+	regionErrStats regionErrorStats
+	retryStats     scanRetryStats
+
+	// profiles records the timing breakdown of the regions if profiling is enabled.
+	profiling bool
+	profiles  []RegionProfile
+
+	// interceptRequest is called with every scan request before it's sent.
+	interceptRequest func(req *tikvrpc.Request)
+	// callOptions are the extra gRPC call options of the scan requests.
+	callOptions []grpc.CallOption
+	// serverCancel makes the scan requests sent by unary calls, which are canceled
+	// on the server once their contexts are canceled.
+	serverCancel bool
+	// verifySnapshot makes the scanner check the read version of every request.
+	verifySnapshot bool
+	// mergePolicy tells what to do once a region is merged away during the scan.
+	mergePolicy RegionMergePolicy
+	// verifyRange makes the scanner check that the received keys are in range
+	// [rangeStart, endKey).
+	verifyRange bool
+	rangeStart  []byte
+	// rereadReport receives the replicas which return keys out of range, and makes
+	// the scanner re-read the batches from the other replicas.
+	rereadReport func(*ReplicaDivergence)
+	// checkFutureTS makes the scanner check that the scan version isn't ahead of
+	// PD's TSO before the first request.
+	checkFutureTS bool
+	// latency records the latency of every request if it's not nil.
+	latency *LatencyHistogram
+	// tsoRefreshInterval is how often the scanner moves its snapshot to a new TSO,
+	// 0 means never. lastTSORefresh is when it's moved last time.
+	tsoRefreshInterval time.Duration
+	lastTSORefresh     time.Time
+	// tags are the caller-supplied tags of the scan, which are attached to its logs.
+	tags map[string]string
+	// hotspots detects and throttles the hotspot regions if it's not nil.
+	hotspots *hotspotDetector
+}
+
+// logger returns the logger of the scan, which attaches the tags of the scan to the
+// log lines.
+func (s *scanRequester) logger() *zap.Logger {
+	if len(s.tags) == 0 {
+		return logutil.BgLogger()
+	}
+	return logutil.BgLogger().With(zap.Any("scanTags", s.tags))
+}
+
+// RegionProfile is the timing breakdown of a scan in a region.
+type RegionProfile struct {
+	RegionID uint64
+	// RPCTime is the time of the scan requests sent to the region, excluding the
+	// backoff in them.
+	RPCTime time.Duration
+	// BackoffTime is the backoff time of the requests, e.g. for region errors.
+	BackoffTime time.Duration
+	// LockResolveTime is the time of waiting for and resolving the locks met in
+	// the region.
+	LockResolveTime time.Duration
+	// Rows is the number of pairs returned by the region, including the locked ones.
+	Rows int
+}
+
+// regionProfile returns the profile of the region, or nil if profiling is disabled.
+func (s *scanRequester) regionProfile(regionID uint64) *RegionProfile {
+	if !s.profiling {
+		return nil
+	}
+	for i := len(s.profiles) - 1; i >= 0; i-- {
+		if s.profiles[i].RegionID == regionID {
+			return &s.profiles[i]
+		}
+	}
+	s.profiles = append(s.profiles, RegionProfile{RegionID: regionID})
+	return &s.profiles[len(s.profiles)-1]
+}
+
+// scanRetryStats summarizes the region error retries of a scan, which are logged
+// once at the end of the scan instead of one by one.
+type scanRetryStats struct {
+	retries int
+	// backoff is the total backoff time of the scan in milliseconds.
+	backoff int
+	regions map[uint64]struct{}
+}
+
+func (s *scanRetryStats) recordRetry(regionID uint64) {
+	s.retries++
+	if s.regions == nil {
+		s.regions = make(map[uint64]struct{})
+	}
+	s.regions[regionID] = struct{}{}
+}
+
+// logRetrySummary logs the retries and backoff of the scan if there is any, and resets
+// the stats so that they are only logged once.
+func (s *scanRequester) logRetrySummary() {
+	stats := &s.retryStats
+	if stats.retries == 0 && stats.backoff == 0 {
+		return
+	}
+	s.logger().Info("scan retry summary",
+		zap.Int("retries", stats.retries),
+		zap.Duration("backoff", time.Duration(stats.backoff)*time.Millisecond),
+		zap.Int("regions", len(stats.regions)),
+		zap.Uint64("txnStartTS", s.startTS()))
+	*stats = scanRetryStats{}
+}
+
+// ScanResponseIterator iterates over the raw ScanResponses of a range batch by
+// batch, for callers which need more than the key-value pairs a Scanner returns.
+// Region errors and response-level locks are still handled by the iterator, but
+// locks of single pairs are returned as is in the pairs.
+type ScanResponseIterator struct {
+	scanRequester
+}
+
+// NewScanResponseIterator creates a ScanResponseIterator for range [startKey, endKey)
+// of the snapshot. Only the options of sending requests take effect, i.e.
+// WithContext, WithKeyOnly, WithMaxRegions, WithSingleRegion, WithLockNoWait,
+// WithMaxDuration, WithKeyspace, WithRequestInterceptor and WithTags.
+func (s *KVSnapshot) NewScanResponseIterator(startKey, endKey []byte, batchSize int, reverse bool, opts ...ScannerOption) (*ScanResponseIterator, error) {
+	if batchSize <= 0 {
+		return nil, errors.Errorf("invalid batch size %d", batchSize)
+	}
+	scanner := &Scanner{scanRequester: scanRequester{
+		ctx:          context.Background(),
+		snapshot:     s,
+		batchSize:    batchSize,
+		nextStartKey: startKey,
+		endKey:       endKey,
+		reverse:      reverse,
+		nextEndKey:   endKey,
+		keyOnly:      s.keyOnly,
+		sampleStep:   s.sampleStep,
+	}}
+	for _, opt := range opts {
+		opt(scanner)
+	}
+	scanner.setBatchSize(scanner.batchSize)
+	scanner.setDeadline()
+	scanner.clampToKeyspace()
+	if err := scanner.checkStartVisibility(); err != nil {
+		return nil, errors.Trace(err)
+	}
+	return &ScanResponseIterator{scanRequester: scanner.scanRequester}, nil
+}
+
+// Next returns the next ScanResponse, or nil if the range is exhausted. The pairs
+// of the response may exceed the end of the range, they should be filtered by the
+// caller.
+func (it *ScanResponseIterator) Next() (*pb.ScanResponse, error) {
+	if it.eof {
+		it.logRetrySummary()
+		return nil, nil
+	}
+	if err := it.checkDeadline(nil); err != nil {
+		it.eof = true
+		return nil, errors.Trace(err)
+	}
+	bo, cancel := it.newBackoffer()
+	defer cancel()
+	resp, err := it.nextResponse(bo)
+	if err != nil {
+		it.eof = true
+		it.logRetrySummary()
+		return nil, errors.Trace(it.checkDeadline(err))
+	}
+	return resp, nil
+}
+
+// CurrentRegion returns the region which serves the latest response.
+func (it *ScanResponseIterator) CurrentRegion() RegionInfo {
+	return it.curRegion
+}
+
+func (s *scanRequester) startTS() uint64 {
+	return s.snapshot.version
+}
+
+// readKey returns the key the scan has read to, i.e. the start of the next request
+// of forward scans and the end of the next request of reverse scans.
+func (s *scanRequester) readKey() []byte {
+	if s.reverse {
+		return s.nextEndKey
+	}
+	return s.nextStartKey
+}
+
+func (s *scanRequester) setDeadline() {
+	if s.maxDuration > 0 {
+		s.deadline = time.Now().Add(s.maxDuration)
+	}
+}
+
+// clampToKeyspace narrows the scan range to the keyspace. The scan is finished at
+// once if the range doesn't overlap with the keyspace.
+func (s *scanRequester) clampToKeyspace() {
+	if len(s.keyspace) == 0 {
+		return
+	}
+	if kv.CmpKey(s.nextStartKey, s.keyspace) < 0 {
+		s.nextStartKey = s.keyspace
+	}
+	upper := kv.PrefixNextKey(s.keyspace)
+	if len(upper) > 0 && (len(s.endKey) == 0 || kv.CmpKey(s.endKey, upper) > 0) {
+		s.endKey, s.nextEndKey = upper, upper
+	}
+	if len(s.endKey) > 0 && kv.CmpKey(s.nextStartKey, s.endKey) >= 0 {
+		s.eof = true
+	}
+}
+
+// newBackoffer creates a Backoffer for a round of requests. The context of the
+// Backoffer expires at the deadline of the scan, so that a long backoff doesn't
+// overrun it.
+func (s *scanRequester) newBackoffer() (*Backoffer, context.CancelFunc) {
+	return s.newBackofferWithContext(s.ctx)
+}
+
+// newBackofferWithContext is newBackoffer with ctx, which is derived from the
+// context of the scan.
+func (s *scanRequester) newBackofferWithContext(ctx context.Context) (*Backoffer, context.CancelFunc) {
+	ctx = context.WithValue(ctx, TxnStartKey, s.snapshot.version)
+	cancel := func() {}
+	if !s.deadline.IsZero() {
+		ctx, cancel = context.WithDeadline(ctx, s.deadline)
+	}
+	return NewBackofferWithVars(ctx, scannerNextMaxBackoff, s.snapshot.vars), cancel
+}
+
+// earliestDeadline returns the earlier of the deadline of the scan and the deadline
+// of its context, or zero if there is neither.
+func (s *scanRequester) earliestDeadline() time.Time {
+	deadline := s.deadline
+	if ctxDeadline, ok := s.ctx.Deadline(); ok && (deadline.IsZero() || ctxDeadline.Before(deadline)) {
+		deadline = ctxDeadline
+	}
+	return deadline
+}
+
+// checkDeadline returns ErrScanDeadlineExceeded if the deadline of the scan has
+// passed, otherwise it returns err. Errors caused by the expired context of the
+// Backoffer are reported as ErrScanDeadlineExceeded in this way.
+func (s *scanRequester) checkDeadline(err error) error {
+	if !s.deadline.IsZero() && !time.Now().Before(s.deadline) {
+		return kv.ErrScanDeadlineExceeded
+	}
+	return err
+}
+
+// checkCanceled returns the error of the context of the scan annotated with err if
+// the context is done, so that the callers can tell a canceled scan from a failed
+// one by errors.Cause, e.g. when a backoff is cut short by the cancellation and
+// returns the error it backs off for.
+func (s *scanRequester) checkCanceled(err error) error {
+	if ctxErr := s.ctx.Err(); err != nil && ctxErr != nil && errors.Cause(err) != ctxErr {
+		return errors.Annotate(ctxErr, err.Error())
+	}
+	return err
+}
+
+// checkVisibility checks that the snapshot of the scan hasn't been GC'd. The cached
+// safe point may be stale or bumped right after the scan started, so a failed check
+// is retried up to kv.ScanVisibilityCheckRetries times after reloading the safe
+// point.
+func (s *scanRequester) checkVisibility(bo *Backoffer) error {
+	store := s.snapshot.store
+	for retries := kv.ScanVisibilityCheckRetries.Load(); ; retries-- {
+		err := store.CheckVisibility(s.startTS())
+		if err == nil || retries <= 0 || !(kv.ErrGCTooEarly.Equal(err) || kv.ErrPDServerTimeout.Equal(err)) {
+			return errors.Trace(err)
+		}
+		if err = bo.Backoff(BoPDRPC, err); err != nil {
+			return errors.Trace(err)
+		}
+		if err = store.reloadSafePoint(); err != nil {
+			return errors.Trace(err)
+		}
+	}
+}
+
+// checkStartVisibility is checkVisibility for a scan which hasn't sent any
+// request yet, after checking the scan version against PD's TSO if it's enabled.
+func (s *scanRequester) checkStartVisibility() error {
+	bo, cancel := s.newBackoffer()
+	defer cancel()
+	err := s.checkFutureTimestamp(bo)
+	if err == nil {
+		err = s.checkVisibility(bo)
+	}
+	if err != nil {
+		return s.checkDeadline(err)
+	}
+	return nil
+}
+
+// refreshTSO moves the scan to a snapshot at a new TSO if the refresh interval has
+// passed since the last refresh. It's called before the scan enters a region, so
+// every region is read at one version.
+func (s *scanRequester) refreshTSO(bo *Backoffer) error {
+	if s.tsoRefreshInterval <= 0 || time.Since(s.lastTSORefresh) < s.tsoRefreshInterval {
+		return nil
+	}
+	ts, err := s.snapshot.store.getTimestampWithRetry(bo, oracle.GlobalTxnScope)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	s.logger().Info("scan moves to a new snapshot",
+		zap.Uint64("oldStartTS", s.startTS()),
+		zap.Uint64("newStartTS", ts))
+	s.snapshot = s.snapshot.withVersion(ts)
+	s.lastTSORefresh = time.Now()
+	return nil
+}
+
+// checkFutureTimestamp returns ErrFutureTimestamp if the scan version is ahead of
+// the current global timestamp of PD. The max timestamp, which reads the latest
+// data, is never in the future.
+func (s *scanRequester) checkFutureTimestamp(bo *Backoffer) error {
+	if !s.checkFutureTS || s.startTS() == maxTimestamp {
+		return nil
+	}
+	current, err := s.snapshot.store.getTimestampWithRetry(bo, oracle.GlobalTxnScope)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if s.startTS() > current {
+		return errors.Trace(&kv.ErrFutureTimestamp{Version: s.startTS(), Current: current})
+	}
+	return nil
+}
+
+// nextResponse sends the next scan request and moves the start of the next request
+// past the returned pairs. The keys of locked pairs are filled if TiKV leaves them
+// empty.
+func (s *scanRequester) nextResponse(bo *Backoffer) (*pb.ScanResponse, error) {
+	s.logger().Debug("txn getData",
+		zap.String("nextStartKey", kv.StrKey(s.nextStartKey)),
+		zap.String("nextEndKey", kv.StrKey(s.nextEndKey)),
+		zap.Bool("reverse", s.reverse),
+		zap.Uint64("txnStartTS", s.startTS()))
+	sender := NewRegionRequestSender(s.snapshot.store.regionCache, s.snapshot.store.client)
+	sender.regionErrStats = &s.regionErrStats
+	beforeSleep := bo.totalSleep
+	defer func() { s.retryStats.backoff += bo.totalSleep - beforeSleep }()
+	var reqEndKey, reqStartKey []byte
+	var loc *KeyLocation
+	var err error
+	for {
+		if !s.reverse {
+			loc, err = s.snapshot.store.regionCache.LocateKey(bo, s.nextStartKey)
+		} else {
+			loc, err = s.snapshot.store.regionCache.LocateEndKey(bo, s.nextEndKey)
+		}
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		profile := s.regionProfile(loc.Region.GetID())
+		if loc.Region.GetID() != s.lastRegionID {
+			if s.singleRegion && s.regionCount > 0 {
+				key := s.nextStartKey
+				if s.reverse {
+					key = s.nextEndKey
+				}
+				return nil, errors.Trace(&kv.ErrRangeSpansRegions{RegionID: s.lastRegionID, Key: key})
+			}
+			s.lastRegionID = loc.Region.GetID()
+			s.regionCount++
+			if s.maxRegions > 0 && s.regionCount > s.maxRegions {
+				return nil, errors.Trace(&kv.ErrTooManyRegions{Limit: s.maxRegions, Count: s.regionCount})
+			}
+			if s.regionCount > 1 {
+				if err = s.refreshTSO(bo); err != nil {
+					return nil, errors.Trace(err)
+				}
+			}
+		}
+
+		if !s.reverse {
+			reqEndKey = s.endKey
+			if len(reqEndKey) > 0 && len(loc.EndKey) > 0 && bytes.Compare(loc.EndKey, reqEndKey) < 0 {
+				reqEndKey = loc.EndKey
+			}
+		} else {
+			reqStartKey = s.nextStartKey
+			if len(reqStartKey) == 0 ||
+				(len(loc.StartKey) > 0 && bytes.Compare(loc.StartKey, reqStartKey) > 0) {
+				reqStartKey = loc.StartKey
+			}
+		}
+		sreq := &pb.ScanRequest{
+			Context: &pb.Context{
+				Priority:       s.snapshot.priority,
+				NotFillCache:   s.notFillCache(),
+				IsolationLevel: IsolationLevelToPB(s.snapshot.isolationLevel),
+			},
+			StartKey:   s.nextStartKey,
+			EndKey:     reqEndKey,
+			Limit:      uint32(s.batchSize),
+			Version:    s.startTS(),
+			KeyOnly:    s.keyOnly,
+			SampleStep: s.sampleStep,
+		}
+		if s.reverse {
+			sreq.StartKey = s.nextEndKey
+			sreq.EndKey = reqStartKey
+			sreq.Reverse = true
+		}
+		s.snapshot.mu.RLock()
+		req := tikvrpc.NewReplicaReadRequest(tikvrpc.CmdScan, sreq, s.snapshot.mu.replicaRead, &s.snapshot.replicaReadSeed, pb.Context{
+			Priority:               s.snapshot.priority,
+			NotFillCache:           s.notFillCache(),
+			TaskId:                 s.snapshot.mu.taskID,
+			MaxExecutionDurationMs: s.snapshot.maxExecutionTime,
+			ResolvedLocks:          s.snapshot.resolvedLocks.GetAll(),
+		})
+		var ops []StoreSelectorOption
+		if len(s.snapshot.mu.matchStoreLabels) > 0 {
+			// Replica reads prefer the stores with the labels, e.g. the ones in the
+			// same zone, and fall back to the others if there is no such store.
+			ops = append(ops, WithMatchLabels(s.snapshot.mu.matchStoreLabels))
+		}
+		s.snapshot.mu.RUnlock()
+		req.CallOptions = s.callOptions
+		req.NoBatch = s.serverCancel
+		if s.interceptRequest != nil {
+			s.interceptRequest(req)
+		}
+		if version := req.Scan().GetVersion(); s.verifySnapshot && version != s.startTS() {
+			return nil, errors.Trace(&kv.ErrInconsistentSnapshot{RegionID: loc.Region.GetID(), StartTS: s.startTS(), ReadTS: version})
+		}
+		if s.hotspots != nil {
+			if err = s.hotspots.throttle(bo.ctx, loc.Region.GetID()); err != nil {
+				return nil, errors.Trace(err)
+			}
+		}
+		sendStart, sendSleep, busyErrs := time.Now(), bo.totalSleep, s.regionErrStats.serverBusy
+		resp, rpcCtx, err := sender.SendReqCtx(bo, req, loc.Region, ReadTimeoutMedium, tikvrpc.TiKV, ops...)
+		if profile != nil || s.latency != nil || s.hotspots != nil {
+			backoff := time.Duration(bo.totalSleep-sendSleep) * time.Millisecond
+			rpcTime := time.Since(sendStart) - backoff
+			if profile != nil {
+				profile.RPCTime += rpcTime
+				profile.BackoffTime += backoff
+			}
+			if s.latency != nil {
+				s.latency.Record(rpcTime)
+			}
+			if s.hotspots != nil && s.hotspots.record(loc.Region.GetID(), rpcTime, s.regionErrStats.serverBusy > busyErrs) {
+				s.logger().Warn("scan detects a hotspot region, throttle the requests to it",
+					zap.Uint64("region", loc.Region.GetID()),
+					zap.Duration("slowThreshold", s.hotspots.slowThreshold),
+					zap.Int("serverBusyErrors", s.regionErrStats.serverBusy))
+			}
+		}
+		// Replace the response with an injected fault. Slow responses can be
+		// simulated by the `sleep` action of the failpoint.
+		failpoint.Inject("mockScanResponseFault", func(val failpoint.Value) {
+			kind, _ := val.(string)
+			switch kind {
+			case "regionError":
+				resp, err = &tikvrpc.Response{Resp: &pb.ScanResponse{
+					RegionError: &errorpb.Error{EpochNotMatch: &errorpb.EpochNotMatch{}},
+				}}, nil
+			case "regionNotInitialized":
+				resp, err = &tikvrpc.Response{Resp: &pb.ScanResponse{
+					RegionError: &errorpb.Error{Message: fmt.Sprintf("region %d not initialized yet", loc.Region.GetID())},
+				}}, nil
+			case "lockError":
+				// An expired lock of a transaction that never exists, it's
+				// rolled back by the lock resolver.
+				resp, err = &tikvrpc.Response{Resp: &pb.ScanResponse{
+					Error: &pb.KeyError{Locked: &pb.LockInfo{
+						Key:         sreq.StartKey,
+						PrimaryLock: sreq.StartKey,
+						LockVersion: 1,
+					}},
+				}}, nil
+			case "timeout":
+				resp, err = nil, errors.Trace(kv.ErrTiKVServerTimeout)
+			}
+		})
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		regionErr, err := resp.GetRegionError()
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		if regionErr != nil {
+			if regionErr.GetRegionNotFound() != nil {
+				merged, err := s.locateMergedRegion(bo, loc)
+				if err != nil {
+					return nil, errors.Trace(err)
+				}
+				if merged != nil {
+					if s.mergePolicy == RegionMergeFail {
+						return nil, errors.Trace(&kv.ErrRegionMerged{RegionID: loc.Region.GetID(), MergedInto: merged.Region.GetID()})
+					}
+					// Continue from nextStartKey (nextEndKey for reverse scan) in the merged
+					// region at once, which has been loaded into the region cache.
+					s.lastRegionErr = nil
+					continue
+				}
+			}
+			if isTerminalRegionError(regionErr, s.lastRegionErr) && loc.Region.GetID() == s.lastErrRegion {
+				return nil, errors.Trace(&kv.ErrRegionErrorNotRetriable{RegionID: loc.Region.GetID(), Err: regionErr})
+			}
+			s.lastRegionErr, s.lastErrRegion = regionErr, loc.Region.GetID()
+			s.retryStats.recordRetry(loc.Region.GetID())
+			backoffSleep := bo.totalSleep
+			if isRegionNotInitialized(regionErr) {
+				err = s.backoffRegionNotInitialized(bo, loc.Region.GetID(), regionErr)
+			} else {
+				err = bo.Backoff(BoRegionMiss, errors.New(regionErr.String()))
+			}
+			if profile != nil {
+				profile.BackoffTime += time.Duration(bo.totalSleep-backoffSleep) * time.Millisecond
+			}
+			if err != nil {
+				return nil, errors.Trace(err)
+			}
+			// Retry from nextStartKey (nextEndKey for reverse scan), which is never moved
+			// before a batch is received successfully, so the keys that have been delivered
+			// are not read again even if the region cache is invalidated or the region is split.
+			continue
+		}
+		s.lastRegionErr = nil
+		if resp.Resp == nil {
+			return nil, errors.Trace(kv.ErrBodyMissing)
+		}
+		cmdScanResp := resp.Resp.(*pb.ScanResponse)
+
+		err = s.checkVisibility(bo)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+
+		// When there is a response-level key error, the returned pairs are incomplete.
+		// We should resolve the lock first and then retry the same request.
+		if keyErr := cmdScanResp.GetError(); keyErr != nil {
+			lockStart := time.Now()
+			err = s.resolveResponseLock(bo, keyErr)
+			if profile != nil {
+				profile.LockResolveTime += time.Since(lockStart)
+			}
+			if err != nil {
+				return nil, errors.Trace(err)
+			}
+			continue
+		}
+
+		kvPairs := cmdScanResp.Pairs
+		// Check if kvPair contains error, it should be a Lock.
+		for _, pair := range kvPairs {
+			if keyErr := pair.GetError(); keyErr != nil && len(pair.Key) == 0 {
+				lock, err := extractLockFromKeyErr(keyErr)
+				if err != nil {
+					return nil, errors.Trace(err)
+				}
+				pair.Key = lock.Key
+			}
+		}
+		if s.rereadReport != nil && rpcCtx != nil && s.keyOutOfRange(kvPairs) != nil {
+			kvPairs = s.rereadFromReplicas(bo, req, rpcCtx, kvPairs)
+			cmdScanResp.Pairs = kvPairs
+		}
+		s.curRegion = RegionInfo{Region: loc.Region, StartKey: loc.StartKey, EndKey: loc.EndKey}
+		if profile != nil {
+			profile.Rows += len(kvPairs)
+		}
+		if len(kvPairs) < s.batchSize {
+			// No more data in current Region. Next request starts
+			// from current Region's endKey.
+			if !s.reverse {
+				s.nextStartKey = loc.EndKey
+			} else {
+				s.nextEndKey = reqStartKey
+			}
+			if (!s.reverse && (len(loc.EndKey) == 0 || (len(s.endKey) > 0 && kv.CmpKey(s.nextStartKey, s.endKey) >= 0))) ||
+				(s.reverse && (len(loc.StartKey) == 0 || (len(s.nextStartKey) > 0 && kv.CmpKey(s.nextStartKey, s.nextEndKey) >= 0))) {
+				// Current Region is the last one.
+				s.eof = true
+			}
+			return cmdScanResp, nil
+		}
+		// next request starts from the last key in kvPairs (but skip
+		// it by appending a '\x00' to the key). Note that next request
+		// may get an empty response if the Region in fact does not have
+		// more data.
+		lastKey := kvPairs[len(kvPairs)-1].GetKey()
+		if !s.reverse {
+			s.nextStartKey = kv.NextKey(lastKey)
+		} else {
+			s.nextEndKey = lastKey
+		}
+		return cmdScanResp, nil
+	}
+}
+
+// setBatchSize sets the limit of the scan requests. It must be at least 1, otherwise
+// the requests return no pairs and the scan never ends, so a smaller size is a bug,
+// which is logged and clamped to 1.
+func (s *scanRequester) setBatchSize(size int) {
+	if size >= 1 {
+		s.batchSize = size
+		return
+	}
+	s.logger().Warn("invalid scan batch size, use 1 instead", zap.Int("batchSize", size))
+	s.batchSize = 1
+}
+
+// resolveResponseLock resolves the lock of a response-level key error, and waits for
+// it if it can't be resolved yet.
+func (s *scanRequester) resolveResponseLock(bo *Backoffer, keyErr *pb.KeyError) error {
+	lock, err := extractLockFromKeyErr(keyErr)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if err = s.countResolvedLock(); err != nil {
+		return errors.Trace(err)
+	}
+	var msBeforeExpired int64
+	if s.lockNoWait {
+		// The lock must not be pushed, which hides whether it's alive.
+		msBeforeExpired, _, err = newLockResolver(s.snapshot.store).ResolveLocks(bo, s.snapshot.version, []*Lock{lock})
+	} else {
+		msBeforeExpired, err = s.snapshot.resolveLocks(bo, []*Lock{lock})
+	}
+	if err != nil {
+		if s.deadLetter != nil && s.lockUnresolvable(err) {
+			s.deadLetter(lock, err)
+		}
+		return errors.Trace(err)
+	}
+	if msBeforeExpired > 0 && s.lockNoWait {
+		return errors.Trace(&kv.ErrTxnLockWait{Key: lock.Key, TTL: lock.TTL})
+	}
+	if msBeforeExpired > 0 {
+		err = bo.BackoffWithMaxSleep(BoTxnLockFast, int(msBeforeExpired), errors.Errorf("key is locked during scanning"))
+		if err != nil {
+			return errors.Trace(err)
+		}
+	}
+	return nil
+}
+
+// notFillCache returns the NotFillCache flag of the requests of the scanner.
+func (s *scanRequester) notFillCache() bool {
+	switch s.cacheHint {
+	case ScanCacheFill:
+		return false
+	case ScanCacheBypass:
+		return true
+	default:
+		return s.snapshot.notFillCache
+	}
+}
+
+// lockUnresolvable reports whether err, which fails the resolution of a lock, is
+// caused by the lock itself rather than the scan, e.g. its cancellation or limits.
+func (s *scanRequester) lockUnresolvable(err error) bool {
+	if s.ctx.Err() != nil || s.checkDeadline(nil) != nil {
+		return false
+	}
+	switch cause := errors.Cause(err).(type) {
+	case *kv.ErrTooManyLocks, *kv.ErrTxnLockWait:
+		return false
+	default:
+		return cause != kv.ErrQueryInterrupted
+	}
+}
+
+// countResolvedLock counts a lock the scanner is going to resolve. It returns
+// ErrTooManyLocks if the limit of resolved locks is exceeded.
+func (s *scanRequester) countResolvedLock() error {
+	s.locksResolved++
+	if s.maxLocksResolved > 0 && s.locksResolved > s.maxLocksResolved {
+		return errors.Trace(&kv.ErrTooManyLocks{Limit: s.maxLocksResolved})
+	}
+	return nil
+}
+
+// isRegionNotInitialized reports whether regionErr is reported by a peer which
+// hasn't been initialized, e.g. right after the cluster is bootstrapped. TiKV has
+// no dedicated field for it in the region error, only the message tells it.
+func isRegionNotInitialized(regionErr *errorpb.Error) bool {
+	return strings.Contains(regionErr.GetMessage(), "not initialized")
+}
+
+// backoffRegionNotInitialized waits for the region to be initialized. It returns
+// ErrRegionNotInitialized once the scan has waited for regions to be initialized
+// for longer than ScanRegionNotInitializedMaxBackoff.
+func (s *scanRequester) backoffRegionNotInitialized(bo *Backoffer, regionID uint64, regionErr *errorpb.Error) error {
+	maxSleep := int(atomic.LoadUint64(&ScanRegionNotInitializedMaxBackoff))
+	if s.notInitializedSleep >= maxSleep {
+		return errors.Trace(&kv.ErrRegionNotInitialized{
+			RegionID: regionID,
+			Waited:   time.Duration(s.notInitializedSleep) * time.Millisecond,
+		})
+	}
+	backoffSleep := bo.totalSleep
+	err := bo.BackoffWithMaxSleep(boRegionNotInitialized, maxSleep-s.notInitializedSleep, errors.New(regionErr.String()))
+	s.notInitializedSleep += bo.totalSleep - backoffSleep
+	return errors.Trace(err)
+}
+
+// locateMergedRegion locates the region which covers the next key of the scan after
+// region loc is reported as not found, e.g. because it has been merged into a
+// neighbor. It returns nil if the key is still covered by the same region, which is
+// not a merge. The region of loc has been dropped from the region cache by the
+// sender, so the location is reloaded from PD.
+func (s *scanRequester) locateMergedRegion(bo *Backoffer, loc *KeyLocation) (*KeyLocation, error) {
+	cache := s.snapshot.store.regionCache
+	var (
+		merged *KeyLocation
+		err    error
+	)
+	if !s.reverse {
+		merged, err = cache.LocateKey(bo, s.nextStartKey)
+	} else {
+		merged, err = cache.LocateEndKey(bo, s.nextEndKey)
+	}
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	if merged.Region.GetID() == loc.Region.GetID() {
+		return nil, nil
+	}
+	// A right neighbor absorbing the region stays cached with its old range and
+	// epoch besides the merged one, and the rest of the scan would be sent to the
+	// stale range and rejected by EpochNotMatch.
+	if len(loc.EndKey) > 0 && merged.Contains(loc.EndKey) {
+		if r := cache.searchCachedRegion(loc.EndKey, false); r != nil && r.VerID() != merged.Region {
+			cache.InvalidateCachedRegion(r.VerID())
+		}
+	}
+	return merged, nil
+}
+
+// isTerminalRegionError reports whether regionErr can't be fixed by retrying, given
+// that the previous request to the same region failed with last. The region cache
+// has been invalidated by the RegionRequestSender when these errors are returned,
+// so the retry is sent to the region reloaded from PD. RegionNotFound and
+// KeyNotInRegion are terminal if the reloaded region reports the same error again,
+// which means PD and TiKV disagree on the region, e.g. the region has been
+// destroyed. Other errors such as EpochNotMatch are retried until the backoffer
+// runs out.
+func isTerminalRegionError(regionErr, last *errorpb.Error) bool {
+	if last == nil {
+		return false
+	}
+	switch {
+	case regionErr.GetRegionNotFound() != nil:
+		return last.GetRegionNotFound() != nil
+	case regionErr.GetKeyNotInRegion() != nil:
+		return last.GetKeyNotInRegion() != nil
+	default:
+		return false
+	}
+}
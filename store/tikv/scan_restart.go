@@ -0,0 +1,86 @@
+// Copyright 2021 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tikv
+
+import (
+	"github.com/pingcap/errors"
+	"github.com/pingcap/tidb/store/tikv/kv"
+	"go.uber.org/zap"
+)
+
+// WithAtomicRestart makes the scanner restart the whole scan from the beginning of
+// its range at the same snapshot version once it detects an inconsistency, i.e.
+// ErrInconsistentSnapshot, ErrKeyOutOfRange, ErrDuplicateKey or ErrRegionMerged,
+// up to maxRestarts times, instead of failing with the pairs returned so far. It's
+// for the strict-consistency consumers which prefer a clean restart to reconciling
+// a partially inconsistent read. The inconsistencies are detected by the options
+// checking them, e.g. WithSnapshotVerification or WithDuplicateCheck.
+//
+// All state of the scanner is reset on a restart, as if it's created again with
+// the same options, and onRestart is called with the number of the restart and
+// its cause before the scanner moves back to the first pair. The caller must
+// discard the pairs it has received by then, so the pairs are usually buffered
+// until the scan finishes. A restart costs as much as the scan up to the
+// inconsistency: every pair is read, and every lock is resolved, again, and the
+// snapshot version may be GC'd during long restarts, which fails the scan.
+// Scanners created by WithTSORefresh restart at the version they start with.
+func WithAtomicRestart(maxRestarts int, onRestart func(restart int, cause error)) ScannerOption {
+	return func(s *Scanner) {
+		s.maxRestarts = maxRestarts
+		s.onRestart = onRestart
+	}
+}
+
+// scannerArgs are the arguments a scanner is created with.
+type scannerArgs struct {
+	snapshot  *KVSnapshot
+	startKey  []byte
+	endKey    []byte
+	batchSize int
+	reverse   bool
+	opts      []ScannerOption
+}
+
+// isScanInconsistency returns whether err is an inconsistency of the read which
+// can be fixed by restarting the scan.
+func isScanInconsistency(err error) bool {
+	switch errors.Cause(err).(type) {
+	case *kv.ErrInconsistentSnapshot, *kv.ErrKeyOutOfRange, *kv.ErrDuplicateKey, *kv.ErrRegionMerged:
+		return true
+	}
+	return false
+}
+
+// restartOn restarts the scan from the beginning if err is an inconsistency and
+// the scanner can restart once more. It returns err otherwise.
+func (s *Scanner) restartOn(err error) error {
+	if err == nil || s.restarts >= s.maxRestarts || !isScanInconsistency(err) {
+		return err
+	}
+	args, restarts := s.initArgs, s.restarts+1
+	s.logger().Warn("scan restarts from the beginning on an inconsistency",
+		zap.Int("restart", restarts),
+		zap.Uint64("startTS", args.snapshot.version),
+		zap.Error(err))
+	if s.onRestart != nil {
+		s.onRestart(restarts, err)
+	}
+	opts := append(args.opts[:len(args.opts):len(args.opts)], func(s *Scanner) { s.restarts = restarts })
+	if err = s.reset(args.snapshot, args.startKey, args.endKey, args.batchSize, args.reverse, opts...); err != nil {
+		return errors.Trace(err)
+	}
+	// A lazy scanner reads its first pair now, as the caller has asked for a pair.
+	_, err = s.startLazily()
+	return errors.Trace(err)
+}
@@ -0,0 +1,416 @@
+// Copyright 2021 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tikv
+
+import (
+	"bytes"
+	"context"
+	"math"
+	"math/rand"
+	"sort"
+	"time"
+
+	"github.com/pingcap/errors"
+	pb "github.com/pingcap/kvproto/pkg/kvrpcpb"
+	"github.com/pingcap/tidb/store/tikv/kv"
+	"github.com/pingcap/tidb/store/tikv/tikvrpc"
+)
+
+// ReservoirSample is a uniform random sample of the key-value pairs in a range.
+type ReservoirSample struct {
+	// Pairs are the sampled key-value pairs in random order. The values are empty
+	// if the snapshot is KeyOnly.
+	Pairs []*pb.KvPair
+	// Seen is the number of key-value pairs scanned.
+	Seen int64
+}
+
+// ReservoirSample scans the range [startKey, endKey) and returns a uniform random sample
+// of at most k key-value pairs by reservoir sampling, without knowing the number of rows
+// in the range in advance. Set the KeyOnly option of the snapshot to sample keys only.
+func (s *KVSnapshot) ReservoirSample(startKey, endKey []byte, k int) (*ReservoirSample, error) {
+	return s.reservoirSample(startKey, endKey, k)
+}
+
+func (s *KVSnapshot) reservoirSample(startKey, endKey []byte, k int, opts ...ScannerOption) (*ReservoirSample, error) {
+	if k <= 0 {
+		return nil, errors.Errorf("invalid sample size %d", k)
+	}
+	scanner, err := newScanner(s, startKey, endKey, scanBatchSize, false, opts...)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	defer scanner.Close()
+
+	rng := rand.New(rand.NewSource(time.Now().UnixNano()))
+	sample := &ReservoirSample{Pairs: make([]*pb.KvPair, 0, k)}
+	for scanner.Valid() {
+		sample.Seen++
+		idx := int64(len(sample.Pairs))
+		if idx >= int64(k) {
+			idx = rng.Int63n(sample.Seen)
+		}
+		if idx < int64(k) {
+			// Copy the pair, so the sample doesn't hold the whole scan response.
+			pair := &pb.KvPair{
+				Key:   append([]byte(nil), scanner.Key()...),
+				Value: append([]byte(nil), scanner.Value()...),
+			}
+			if idx == int64(len(sample.Pairs)) {
+				sample.Pairs = append(sample.Pairs, pair)
+			} else {
+				sample.Pairs[idx] = pair
+			}
+		}
+		if err = scanner.Next(); err != nil {
+			return nil, errors.Trace(err)
+		}
+	}
+	return sample, nil
+}
+
+// SuggestSplitKeys proposes split keys which divide the range [startKey, endKey) into
+// at most n parts holding about the same number of keys, so that the range can be
+// pre-split before bulk loading. The keys are chosen from a sample of at most
+// sampleSize keys, and keys which are already region boundaries are left out.
+func (s *KVSnapshot) SuggestSplitKeys(startKey, endKey []byte, n, sampleSize int) ([][]byte, error) {
+	if n <= 1 {
+		return nil, nil
+	}
+	sample, err := s.reservoirSample(startKey, endKey, sampleSize, WithKeyOnly())
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	if len(sample.Pairs) == 0 {
+		return nil, nil
+	}
+	keys := make([][]byte, 0, len(sample.Pairs))
+	for _, pair := range sample.Pairs {
+		keys = append(keys, pair.Key)
+	}
+	sort.Slice(keys, func(i, j int) bool { return bytes.Compare(keys[i], keys[j]) < 0 })
+
+	regionStarts, err := s.regionBoundaries(startKey, endKey)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	boundaries := make(map[string]bool, len(regionStarts))
+	for _, key := range regionStarts {
+		boundaries[string(key)] = true
+	}
+	var splitKeys [][]byte
+	for i := 1; i < n; i++ {
+		key := keys[i*len(keys)/n]
+		if bytes.Equal(key, startKey) || boundaries[string(key)] {
+			continue
+		}
+		if len(splitKeys) > 0 && bytes.Equal(splitKeys[len(splitKeys)-1], key) {
+			continue
+		}
+		splitKeys = append(splitKeys, key)
+	}
+	return splitKeys, nil
+}
+
+// regionBoundaries returns the start keys of the regions in range [startKey, endKey)
+// in order, except the first one.
+func (s *KVSnapshot) regionBoundaries(startKey, endKey []byte) ([][]byte, error) {
+	regions, err := s.regionsInRange(startKey, endKey)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	boundaries := make([][]byte, 0, len(regions)-1)
+	for _, region := range regions[1:] {
+		boundaries = append(boundaries, region.StartKey)
+	}
+	return boundaries, nil
+}
+
+// regionsInRange returns the regions overlapping range [startKey, endKey) in order
+// by the region cache.
+func (s *KVSnapshot) regionsInRange(startKey, endKey []byte) ([]RegionInfo, error) {
+	bo := NewBackofferWithVars(context.Background(), locateRegionMaxBackoff, s.vars)
+	var regions []RegionInfo
+	key := startKey
+	for {
+		loc, err := s.store.regionCache.LocateKey(bo, key)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		regions = append(regions, RegionInfo{Region: loc.Region, StartKey: loc.StartKey, EndKey: loc.EndKey})
+		key = loc.EndKey
+		if len(key) == 0 || (len(endKey) > 0 && kv.CmpKey(key, endKey) >= 0) {
+			return regions, nil
+		}
+	}
+}
+
+// RegionSize is the estimated size of the data of a region within a range.
+type RegionSize struct {
+	Region RegionInfo
+	Keys   int64
+	Bytes  int64
+	// Oversized reports whether Bytes exceeds the threshold given to
+	// EstimateRegionSizes.
+	Oversized bool
+}
+
+// EstimateRegionSizes estimates the number and size of the key-value pairs of each
+// region overlapping range [startKey, endKey), e.g. to find the regions to split for
+// load balancing. The regions are taken from the region cache before the scan, and
+// every sampleStep-th pair is read by a sampled scan and counted sampleStep times;
+// a sampleStep of 1 reads every pair for the exact sizes. The regions whose estimated
+// size exceeds maxBytes are marked Oversized, 0 means no limit. The estimates are
+// best-effort: pairs of a region split or merged during the scan are counted to the
+// region known before the scan, sampled scans skip the locked pairs, and only the
+// part of a region within the range is counted. The sizes are returned in the order of the regions.
+func (s *KVSnapshot) EstimateRegionSizes(startKey, endKey []byte, sampleStep int, maxBytes int64) ([]RegionSize, error) {
+	if sampleStep <= 0 || int64(sampleStep) > math.MaxUint32 {
+		return nil, errors.Errorf("invalid sample step %d", sampleStep)
+	}
+	regions, err := s.regionsInRange(startKey, endKey)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	sizes := make([]RegionSize, len(regions))
+	for i, region := range regions {
+		sizes[i].Region = region
+	}
+	// A step of 1 is a plain scan, which also resolves the locks on the way.
+	var opts []ScannerOption
+	if sampleStep > 1 {
+		opts = append(opts, withSampleStep(uint32(sampleStep)))
+	}
+	scanner, err := newScanner(s, startKey, endKey, scanBatchSize, false, opts...)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	defer scanner.Close()
+
+	step := int64(sampleStep)
+	idx := 0
+	for scanner.Valid() {
+		key := scanner.Key()
+		for idx < len(sizes)-1 && kv.CmpKey(key, sizes[idx].Region.EndKey) >= 0 {
+			idx++
+		}
+		sizes[idx].Keys += step
+		sizes[idx].Bytes += step * int64(len(key)+len(scanner.Value()))
+		if err = scanner.Next(); err != nil {
+			return nil, errors.Trace(err)
+		}
+	}
+	for i := range sizes {
+		sizes[i].Oversized = maxBytes > 0 && sizes[i].Bytes > maxBytes
+	}
+	return sizes, nil
+}
+
+// withSampleStep makes the scanner read one of every step pairs, no matter the
+// SampleStep option of the snapshot.
+func withSampleStep(step uint32) ScannerOption {
+	return func(s *Scanner) {
+		s.sampleStep = step
+	}
+}
+
+// KeyVersionCount is the number of MVCC versions of a key.
+type KeyVersionCount struct {
+	Key []byte
+	// Versions is the number of write records of the key, including the ones of
+	// deletions, rollbacks and locks, since all of them are read by TiKV when the
+	// key is read.
+	Versions int
+}
+
+// ScanVersionCounts reports the number of MVCC versions of at most limit keys in
+// range [startKey, endKey) which exist at the snapshot, e.g. to find the keys with
+// excessive version churn. It's a diagnostic which reads the MVCC info of every key
+// by the debug API, so it shouldn't be used on large ranges. Historical values are
+// not returned.
+func (s *KVSnapshot) ScanVersionCounts(startKey, endKey []byte, limit int) ([]KeyVersionCount, error) {
+	if limit <= 0 {
+		return nil, errors.Errorf("invalid limit %d", limit)
+	}
+	scanner, err := newScanner(s, startKey, endKey, scanBatchSize, false, WithKeyOnly())
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	defer scanner.Close()
+
+	var counts []KeyVersionCount
+	bo := NewBackofferWithVars(context.Background(), scannerNextMaxBackoff, s.vars)
+	for scanner.Valid() && len(counts) < limit {
+		key := append([]byte(nil), scanner.Key()...)
+		info, err := s.getMvccInfo(bo, key)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		counts = append(counts, KeyVersionCount{Key: key, Versions: len(info.GetWrites())})
+		if err = scanner.Next(); err != nil {
+			return nil, errors.Trace(err)
+		}
+	}
+	return counts, nil
+}
+
+// KeyDelete is a deletion of a key.
+type KeyDelete struct {
+	Key      []byte
+	CommitTS uint64
+}
+
+// ScanDeletes reports at most limit deletions of the keys in range [startKey,
+// endKey), which are committed after fromVersion and no later than the snapshot,
+// e.g. to estimate the space GC can reclaim after fromVersion becomes the safe
+// point. KvScan skips deleted keys, so the keys are found by scanning range at
+// fromVersion, and the deletions of the keys written after fromVersion are not
+// reported. Like ScanVersionCounts, it reads the MVCC info of every key by the
+// debug API, so it shouldn't be used on large ranges.
+func (s *KVSnapshot) ScanDeletes(startKey, endKey []byte, fromVersion uint64, limit int) ([]KeyDelete, error) {
+	if limit <= 0 {
+		return nil, errors.Errorf("invalid limit %d", limit)
+	}
+	if fromVersion >= s.version {
+		return nil, errors.Errorf("from version %d is not older than the snapshot %d", fromVersion, s.version)
+	}
+	from := s.store.GetSnapshot(fromVersion)
+	from.vars = s.vars
+	scanner, err := newScanner(from, startKey, endKey, scanBatchSize, false, WithKeyOnly())
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	defer scanner.Close()
+
+	var deletes []KeyDelete
+	bo := NewBackofferWithVars(context.Background(), scannerNextMaxBackoff, s.vars)
+	for scanner.Valid() && len(deletes) < limit {
+		key := append([]byte(nil), scanner.Key()...)
+		info, err := s.getMvccInfo(bo, key)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		for _, write := range info.GetWrites() {
+			commitTS := write.GetCommitTs()
+			if write.GetType() == pb.Op_Del && commitTS > fromVersion && commitTS <= s.version && len(deletes) < limit {
+				deletes = append(deletes, KeyDelete{Key: key, CommitTS: commitTS})
+			}
+		}
+		if err = scanner.Next(); err != nil {
+			return nil, errors.Trace(err)
+		}
+	}
+	return deletes, nil
+}
+
+func (s *KVSnapshot) getMvccInfo(bo *Backoffer, key []byte) (*pb.MvccInfo, error) {
+	req := tikvrpc.NewRequest(tikvrpc.CmdMvccGetByKey, &pb.MvccGetByKeyRequest{Key: key})
+	for {
+		loc, err := s.store.regionCache.LocateKey(bo, key)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		resp, err := s.store.SendReq(bo, req, loc.Region, ReadTimeoutMedium)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		regionErr, err := resp.GetRegionError()
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		if regionErr != nil {
+			if err = bo.Backoff(BoRegionMiss, errors.New(regionErr.String())); err != nil {
+				return nil, errors.Trace(err)
+			}
+			continue
+		}
+		if resp.Resp == nil {
+			return nil, errors.Trace(kv.ErrBodyMissing)
+		}
+		mvccResp := resp.Resp.(*pb.MvccGetByKeyResponse)
+		if mvccResp.GetError() != "" {
+			return nil, errors.Errorf("unexpected MvccGetByKey error: %s", mvccResp.GetError())
+		}
+		return mvccResp.GetInfo(), nil
+	}
+}
+
+// StoreDistribution is the number and size of the key-value pairs of a range whose
+// regions are led by a store.
+type StoreDistribution struct {
+	// StoreID is the leader store of the regions, 0 if the leader is unknown.
+	StoreID uint64
+	Keys    int
+	Bytes   int
+}
+
+// ScanStoreDistribution scans range [startKey, endKey) and tallies the pairs by the
+// leader stores of their regions, e.g. to find the skew of data across stores. The
+// leaders are taken from the region cache as the pairs are read, so a pair whose
+// region changes its leader during the scan is counted to the leader known then.
+// The distribution is returned in ascending order of the store IDs.
+func (s *KVSnapshot) ScanStoreDistribution(startKey, endKey []byte) ([]StoreDistribution, error) {
+	scanner, err := newScanner(s, startKey, endKey, scanBatchSize, false)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	defer scanner.Close()
+	bo := NewBackofferWithVars(context.Background(), locateRegionMaxBackoff, s.vars)
+	stores := make(map[uint64]*StoreDistribution)
+	var (
+		region  RegionVerID
+		storeID uint64
+	)
+	for scanner.Valid() {
+		if cur := scanner.CurrentRegion().Region; cur != region {
+			region = cur
+			if storeID, err = s.leaderStoreOf(bo, region); err != nil {
+				return nil, errors.Trace(err)
+			}
+		}
+		dist := stores[storeID]
+		if dist == nil {
+			dist = &StoreDistribution{StoreID: storeID}
+			stores[storeID] = dist
+		}
+		dist.Keys++
+		dist.Bytes += len(scanner.Key()) + len(scanner.Value())
+		if err = scanner.Next(); err != nil {
+			return nil, errors.Trace(err)
+		}
+	}
+	dists := make([]StoreDistribution, 0, len(stores))
+	for _, dist := range stores {
+		dists = append(dists, *dist)
+	}
+	sort.Slice(dists, func(i, j int) bool { return dists[i].StoreID < dists[j].StoreID })
+	return dists, nil
+}
+
+// leaderStoreOf returns the leader store of the region by the region cache. The
+// region is reloaded if it has been evicted from the cache.
+func (s *KVSnapshot) leaderStoreOf(bo *Backoffer, id RegionVerID) (uint64, error) {
+	cache := s.store.regionCache
+	r := cache.getCachedRegionWithRLock(id)
+	if r == nil {
+		loc, err := cache.LocateRegionByID(bo, id.GetID())
+		if err != nil {
+			return 0, errors.Trace(err)
+		}
+		if r = cache.getCachedRegionWithRLock(loc.Region); r == nil {
+			return 0, nil
+		}
+	}
+	return r.GetLeaderStoreID(), nil
+}
@@ -0,0 +1,132 @@
+// Copyright 2021 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tikv
+
+import (
+	"context"
+	"sync"
+
+	"github.com/pingcap/errors"
+	pb "github.com/pingcap/kvproto/pkg/kvrpcpb"
+)
+
+// ScanTee feeds the key-value pairs of one scan to several consumers, e.g. a
+// primary consumer and a validator of a live migration, so that the range is read
+// once for all of them. Every consumer receives the pairs in scan order from its
+// own channel, which buffers a bounded number of pairs, so the slowest consumer
+// governs the rate of the scan once its buffer is full. The pairs are shared by
+// the consumers and must not be modified.
+type ScanTee struct {
+	scanner *Scanner
+	ctx     context.Context
+	cancel  context.CancelFunc
+	outs    []chan *pb.KvPair
+	stops   []chan struct{}
+	stopped []sync.Once
+	done    chan struct{}
+	err     error
+}
+
+// Tee starts feeding the remaining pairs of the scanner to n consumers, each of
+// which buffers at most buffer pairs. The scanner is advanced and closed by the
+// tee, and it shouldn't be used by others meanwhile.
+func (s *Scanner) Tee(ctx context.Context, n, buffer int) (*ScanTee, error) {
+	if n <= 0 {
+		return nil, errors.Errorf("invalid number of consumers %d", n)
+	}
+	if buffer < 0 {
+		return nil, errors.Errorf("invalid buffer size %d", buffer)
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	t := &ScanTee{
+		scanner: s,
+		ctx:     ctx,
+		cancel:  cancel,
+		outs:    make([]chan *pb.KvPair, n),
+		stops:   make([]chan struct{}, n),
+		stopped: make([]sync.Once, n),
+		done:    make(chan struct{}),
+	}
+	for i := range t.outs {
+		t.outs[i] = make(chan *pb.KvPair, buffer)
+		t.stops[i] = make(chan struct{})
+	}
+	go t.run()
+	return t, nil
+}
+
+func (t *ScanTee) run() {
+	defer func() {
+		t.scanner.Close()
+		for _, out := range t.outs {
+			close(out)
+		}
+		close(t.done)
+	}()
+	active := make([]bool, len(t.outs))
+	for i := range active {
+		active[i] = true
+	}
+	remaining := len(active)
+	for t.scanner.Valid() && remaining > 0 {
+		// Copy the pair, so the consumers don't hold the whole scan response.
+		pair := &pb.KvPair{
+			Key:   append([]byte(nil), t.scanner.Key()...),
+			Value: append([]byte(nil), t.scanner.Value()...),
+		}
+		for i, out := range t.outs {
+			if !active[i] {
+				continue
+			}
+			select {
+			case out <- pair:
+			case <-t.stops[i]:
+				active[i] = false
+				remaining--
+			case <-t.ctx.Done():
+				t.err = errors.Trace(t.ctx.Err())
+				return
+			}
+		}
+		if err := t.scanner.Next(); err != nil {
+			t.err = errors.Trace(err)
+			return
+		}
+	}
+}
+
+// Pairs returns the channel of the i-th consumer. It's closed once the scan is
+// finished, fails, or is closed.
+func (t *ScanTee) Pairs(i int) <-chan *pb.KvPair {
+	return t.outs[i]
+}
+
+// Stop tells the tee that the i-th consumer has stopped early, so that no more
+// pairs are sent to it. The scan goes on for the other consumers, and is finished
+// once all consumers have stopped.
+func (t *ScanTee) Stop(i int) {
+	t.stopped[i].Do(func() { close(t.stops[i]) })
+}
+
+// Wait waits for the scan to end, and returns its error.
+func (t *ScanTee) Wait() error {
+	<-t.done
+	return t.err
+}
+
+// Close aborts the scan and waits for it to end.
+func (t *ScanTee) Close() {
+	t.cancel()
+	<-t.done
+}
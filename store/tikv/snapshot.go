@@ -42,6 +42,10 @@ const (
 	scanBatchSize = 256
 	batchGetSize  = 5120
 	maxTimestamp  = math.MaxUint64
+
+	// boundaryKeyBatchSize is the batch size used by FirstKey and LastKey, it's the
+	// smallest batch size a scanner accepts.
+	boundaryKeyBatchSize = 2
 )
 
 // KVSnapshot implements the tidbkv.Snapshot interface.
@@ -55,7 +59,11 @@ type KVSnapshot struct {
 	keyOnly         bool
 	vars            *kv.Variables
 	replicaReadSeed uint32
-	resolvedLocks   *util.TSSet
+	// resolvedLocks are the transactions whose locks are known not to block the
+	// reads of the snapshot, e.g. their min commit ts has been pushed. It's shared by
+	// the point gets and scanners of the snapshot, and sent with their requests, so a
+	// lock resolved by one of them isn't resolved again by the others.
+	resolvedLocks *util.TSSet
 
 	// Cache the result of BatchGet.
 	// The invariance is that calling BatchGet multiple times using the same start ts,
@@ -78,6 +86,12 @@ type KVSnapshot struct {
 	}
 	sampleStep uint32
 	txnScope   string
+	// maxExecutionTime is the max execution duration in milliseconds of scan requests.
+	// 0 means no limit on the TiKV side.
+	maxExecutionTime uint64
+	// bypassLocks are the start timestamps of the transactions whose locks are
+	// treated as non-existent, which are sent with the resolved locks.
+	bypassLocks []uint64
 }
 
 // newTiKVSnapshot creates a snapshot of an TiKV store.
@@ -110,6 +124,31 @@ func (s *KVSnapshot) setSnapshotTS(ts uint64) {
 	s.mu.Unlock()
 	// And also the minCommitTS pushed information.
 	s.resolvedLocks = util.NewTSSet(5)
+	s.resolvedLocks.Put(s.bypassLocks...)
+}
+
+// withVersion returns a new snapshot at version ts with the options and runtime
+// stats of the snapshot. The cache isn't copied.
+func (s *KVSnapshot) withVersion(ts uint64) *KVSnapshot {
+	snapshot := newTiKVSnapshot(s.store, ts, s.replicaReadSeed)
+	snapshot.isolationLevel = s.isolationLevel
+	snapshot.priority = s.priority
+	snapshot.notFillCache = s.notFillCache
+	snapshot.syncLog = s.syncLog
+	snapshot.keyOnly = s.keyOnly
+	snapshot.vars = s.vars
+	snapshot.sampleStep = s.sampleStep
+	snapshot.txnScope = s.txnScope
+	snapshot.maxExecutionTime = s.maxExecutionTime
+	snapshot.bypassLocks = s.bypassLocks
+	snapshot.resolvedLocks.Put(s.bypassLocks...)
+	s.mu.RLock()
+	snapshot.mu.replicaRead = s.mu.replicaRead
+	snapshot.mu.taskID = s.mu.taskID
+	snapshot.mu.matchStoreLabels = s.mu.matchStoreLabels
+	snapshot.mu.stats = s.mu.stats
+	s.mu.RUnlock()
+	return snapshot
 }
 
 // BatchGet gets all the keys' value from kv-server and returns a map contains key/value pairs.
@@ -531,6 +570,77 @@ func (s *KVSnapshot) IterReverse(k []byte) (unionstore.Iterator, error) {
 	return scanner, errors.Trace(err)
 }
 
+// IterWithContext is Iter whose requests are bound to ctx, so that canceling ctx,
+// e.g. when the query is killed or times out, stops the scan promptly with the
+// error of ctx instead of retrying until the backoff is exhausted. See WithContext.
+func (s *KVSnapshot) IterWithContext(ctx context.Context, k []byte, upperBound []byte) (unionstore.Iterator, error) {
+	scanner, err := newScanner(s, k, upperBound, scanBatchSize, false, WithContext(ctx))
+	return scanner, errors.Trace(err)
+}
+
+// IterReverseWithContext is IterReverse whose requests are bound to ctx, see
+// IterWithContext.
+func (s *KVSnapshot) IterReverseWithContext(ctx context.Context, k []byte) (unionstore.Iterator, error) {
+	scanner, err := newScanner(s, nil, k, scanBatchSize, true, WithContext(ctx))
+	return scanner, errors.Trace(err)
+}
+
+// FirstKey returns the first key-value pair in range [startKey, endKey), or
+// ErrNotExist if the range is empty. It reads a single small batch in most cases,
+// which is cheaper than iterating with Iter for MIN-like reads.
+func (s *KVSnapshot) FirstKey(startKey, endKey []byte) ([]byte, []byte, error) {
+	return s.boundaryKey(startKey, endKey, false)
+}
+
+// LastKey returns the last key-value pair in range [startKey, endKey), or
+// ErrNotExist if the range is empty. See FirstKey.
+func (s *KVSnapshot) LastKey(startKey, endKey []byte) ([]byte, []byte, error) {
+	return s.boundaryKey(startKey, endKey, true)
+}
+
+// KeyBounds returns the smallest and the largest keys in range [startKey, endKey), or
+// ErrNotExist if the range is empty, e.g. for tight bounds of range partitioning. They
+// are read by a forward and a reverse key-only scan of one key in parallel, which
+// share ctx and are both canceled once either of them fails.
+func (s *KVSnapshot) KeyBounds(ctx context.Context, startKey, endKey []byte) ([]byte, []byte, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var maxKey []byte
+	maxErrCh := make(chan error, 1)
+	go func() {
+		var err error
+		maxKey, _, err = s.boundaryKey(startKey, endKey, true, WithContext(ctx), WithKeyOnly())
+		if err != nil {
+			cancel()
+		}
+		maxErrCh <- err
+	}()
+	minKey, _, err := s.boundaryKey(startKey, endKey, false, WithContext(ctx), WithKeyOnly())
+	if err != nil {
+		cancel()
+	}
+	// Report the error which cancels the other scan rather than the cancellation.
+	if maxErr := <-maxErrCh; maxErr != nil && (err == nil || errors.Cause(err) == context.Canceled) {
+		err = maxErr
+	}
+	if err != nil {
+		return nil, nil, errors.Trace(err)
+	}
+	return minKey, maxKey, nil
+}
+
+func (s *KVSnapshot) boundaryKey(startKey, endKey []byte, reverse bool, opts ...ScannerOption) ([]byte, []byte, error) {
+	scanner, err := newScanner(s, startKey, endKey, boundaryKeyBatchSize, reverse, opts...)
+	if err != nil {
+		return nil, nil, errors.Trace(err)
+	}
+	defer scanner.Close()
+	if !scanner.Valid() {
+		return nil, nil, tidbkv.ErrNotExist
+	}
+	return scanner.Key(), scanner.Value(), nil
+}
+
 // SetOption sets an option with a value, when val is nil, uses the default
 // value of this option. Only ReplicaRead is supported for snapshot
 func (s *KVSnapshot) SetOption(opt int, val interface{}) {
@@ -571,6 +681,11 @@ func (s *KVSnapshot) SetOption(opt int, val interface{}) {
 		s.mu.Unlock()
 	case kv.TxnScope:
 		s.txnScope = val.(string)
+	case kv.MaxExecutionTime:
+		s.maxExecutionTime = val.(uint64)
+	case kv.BypassLocks:
+		s.bypassLocks = val.([]uint64)
+		s.resolvedLocks.Put(s.bypassLocks...)
 	}
 }
 
@@ -600,6 +715,14 @@ func (s *KVSnapshot) SnapCacheSize() int {
 	return len(s.mu.cached)
 }
 
+// resolveLocks resolves locks met by the reads of the snapshot, and records the
+// transactions pushed in the resolved locks of the snapshot. It returns the time in
+// milliseconds to wait for the locks to expire, 0 if they don't block the reads.
+func (s *KVSnapshot) resolveLocks(bo *Backoffer, locks []*Lock) (int64, error) {
+	msBeforeExpired, err := NewClientHelper(s.store, s.resolvedLocks).ResolveLocks(bo, s.version, locks)
+	return msBeforeExpired, errors.Trace(err)
+}
+
 func extractLockFromKeyErr(keyErr *pb.KeyError) (*Lock, error) {
 	if locked := keyErr.GetLocked(); locked != nil {
 		return NewLock(locked), nil
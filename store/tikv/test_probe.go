@@ -21,6 +21,7 @@ import (
 	"github.com/pingcap/errors"
 	"github.com/pingcap/kvproto/pkg/kvrpcpb"
 	pb "github.com/pingcap/kvproto/pkg/kvrpcpb"
+	"github.com/pingcap/kvproto/pkg/metapb"
 	"github.com/pingcap/tidb/store/tikv/tikvrpc"
 	"github.com/pingcap/tidb/store/tikv/unionstore"
 	pd "github.com/tikv/pd/client"
@@ -132,8 +133,8 @@ func (txn TxnProbe) BatchGetSingleRegion(bo *Backoffer, region RegionVerID, keys
 }
 
 // NewScanner returns a scanner to iterate given key range.
-func (txn TxnProbe) NewScanner(start, end []byte, batchSize int, reverse bool) (*Scanner, error) {
-	return newScanner(txn.GetSnapshot(), start, end, batchSize, reverse)
+func (txn TxnProbe) NewScanner(start, end []byte, batchSize int, reverse bool, opts ...ScannerOption) (*Scanner, error) {
+	return newScanner(txn.GetSnapshot(), start, end, batchSize, reverse, opts...)
 }
 
 // GetStartTime returns the time when txn starts.
@@ -564,3 +565,46 @@ func (c RawKVClientProbe) SetPDClient(client pd.Client) {
 func (c RawKVClientProbe) SetRPCClient(client Client) {
 	c.rpcClient = client
 }
+
+// SyntheticRegion is a region preloaded into the region cache by PreloadRegions.
+type SyntheticRegion struct {
+	ID       uint64
+	StartKey []byte
+	EndKey   []byte
+	// LeaderStoreID is the store of the leader peer, whose address is LeaderAddr.
+	LeaderStoreID uint64
+	LeaderAddr    string
+}
+
+// PreloadRegions inserts synthetic regions into the region cache, with the stores of
+// their leaders resolved to the given addresses, so that tests can walk known
+// regions without PD. The peer IDs are the same as the region IDs.
+func (s StoreProbe) PreloadRegions(regions []SyntheticRegion) error {
+	c := s.regionCache
+	for _, r := range regions {
+		c.storeMu.Lock()
+		if _, ok := c.storeMu.stores[r.LeaderStoreID]; !ok {
+			c.storeMu.stores[r.LeaderStoreID] = &Store{
+				addr:      r.LeaderAddr,
+				storeID:   r.LeaderStoreID,
+				state:     uint64(resolved),
+				storeType: tikvrpc.TiKV,
+			}
+		}
+		c.storeMu.Unlock()
+		region := &Region{meta: &metapb.Region{
+			Id:          r.ID,
+			StartKey:    r.StartKey,
+			EndKey:      r.EndKey,
+			RegionEpoch: &metapb.RegionEpoch{ConfVer: 1, Version: 1},
+			Peers:       []*metapb.Peer{{Id: r.ID, StoreId: r.LeaderStoreID}},
+		}}
+		if err := region.init(c); err != nil {
+			return errors.Trace(err)
+		}
+		c.mu.Lock()
+		c.insertRegionToCache(region)
+		c.mu.Unlock()
+	}
+	return nil
+}
@@ -19,9 +19,11 @@ import (
 	"fmt"
 	"math"
 	"runtime"
+	"sync/atomic"
 	"time"
 
 	. "github.com/pingcap/check"
+	"github.com/pingcap/errors"
 	"github.com/pingcap/failpoint"
 	"github.com/pingcap/kvproto/pkg/kvrpcpb"
 	tidbkv "github.com/pingcap/tidb/kv"
@@ -152,6 +154,33 @@ func (s *testLockSuite) TestScanLockResolveWithSeekKeyOnly(c *C) {
 	}
 }
 
+func (s *testLockSuite) TestScanLockResolveKeyOnlyWithoutGet(c *C) {
+	s.putAlphabets(c)
+	s.lockKey(c, []byte("c"), []byte("cc"), []byte("z1"), []byte("z1"), true)
+	s.putKV(c, []byte("bar"), []byte("bar"))
+	s.lockKey(c, []byte("bar"), nil, []byte("z4"), []byte("z4"), true)
+
+	// Locks are resolved without reading the values of the locked keys.
+	c.Assert(failpoint.Enable("github.com/pingcap/tidb/store/tikv/beforeSendPointGet", "panic"), IsNil)
+	defer func() {
+		c.Assert(failpoint.Disable("github.com/pingcap/tidb/store/tikv/beforeSendPointGet"), IsNil)
+	}()
+	txn, err := s.store.Begin()
+	c.Assert(err, IsNil)
+	txn.SetOption(kv.KeyOnly, true)
+	iter, err := txn.Iter([]byte("a"), nil)
+	c.Assert(err, IsNil)
+	var keys []string
+	for iter.Valid() {
+		keys = append(keys, string(iter.Key()))
+		c.Assert(iter.Next(), IsNil)
+	}
+	// The deleted key "bar" is skipped.
+	c.Assert(keys[:3], DeepEquals, []string{"a", "b", "c"})
+	c.Assert(keys[len(keys)-3:], DeepEquals, []string{"z", "z1", "z4"})
+	c.Assert(keys, HasLen, 28)
+}
+
 func (s *testLockSuite) TestScanLockResolveWithBatchGet(c *C) {
 	s.putAlphabets(c)
 	s.prepareAlphabetLocks(c)
@@ -641,3 +670,464 @@ func (s *testLockSuite) TestBatchResolveTxnFallenBackFromAsyncCommit(c *C) {
 	_, err = t3.Get(context.Background(), []byte("fb2"))
 	errMsgMustContain(c, err, "key not exist")
 }
+
+func (s *testLockSuite) TestScanLocks(c *C) {
+	s.prepareAlphabetLocks(c)
+
+	locks, err := s.store.ScanLocks([]byte("c"), []byte("z3"), math.MaxUint64)
+	c.Assert(err, IsNil)
+	var keys []string
+	for _, l := range locks {
+		keys = append(keys, string(l.Key))
+	}
+	c.Assert(keys, DeepEquals, []string{"c", "d", "foo", "z2"})
+
+	// The whole keyspace.
+	locks, err = s.store.ScanLocks(nil, nil, math.MaxUint64)
+	c.Assert(err, IsNil)
+	c.Assert(locks, HasLen, 6)
+
+	// Locks are not touched by scanning.
+	locks, err = s.store.ScanLocks([]byte("c"), []byte("z3"), math.MaxUint64)
+	c.Assert(err, IsNil)
+	c.Assert(locks, HasLen, 4)
+
+	locks, err = s.store.ScanLocks(nil, nil, 0)
+	c.Assert(err, IsNil)
+	c.Assert(locks, HasLen, 0)
+}
+
+func (s *testLockSuite) TestResolveLocksInRange(c *C) {
+	s.prepareAlphabetLocks(c)
+
+	// Only the lock of the committed transaction is resolved, the locks of the live
+	// transactions are kept and returned whatever the safepoint is.
+	resolved, live, err := s.store.ResolveLocksInRange(context.Background(), []byte("c"), []byte("z3"), math.MaxUint64, 2)
+	c.Assert(err, IsNil)
+	c.Assert(resolved, Equals, 1)
+	var keys []string
+	for _, l := range live {
+		keys = append(keys, string(l.Key))
+	}
+	c.Assert(keys, DeepEquals, []string{"d", "foo", "z2"})
+	locks, err := s.store.ScanLocks([]byte("c"), []byte("z3"), math.MaxUint64)
+	c.Assert(err, IsNil)
+	c.Assert(locks, HasLen, 3)
+
+	txn, err := s.store.Begin()
+	c.Assert(err, IsNil)
+	c.Assert(txn.Set([]byte("e"), []byte("e")), IsNil)
+	s.prewriteTxnWithTTL(c, txn, 1)
+	time.Sleep(50 * time.Millisecond)
+	// Locks newer than the safepoint are kept.
+	resolved, live, err = s.store.ResolveLocksInRange(context.Background(), []byte("c"), []byte("z3"), 0, 2)
+	c.Assert(err, IsNil)
+	c.Assert(resolved, Equals, 0)
+	c.Assert(live, HasLen, 0)
+	// The locks of the expired transactions are resolved.
+	resolved, live, err = s.store.ResolveLocksInRange(context.Background(), []byte("c"), []byte("z3"), math.MaxUint64, 2)
+	c.Assert(err, IsNil)
+	c.Assert(resolved, Equals, 1)
+	c.Assert(live, HasLen, 3)
+	locks, err = s.store.ScanLocks([]byte("c"), []byte("z3"), math.MaxUint64)
+	c.Assert(err, IsNil)
+	c.Assert(locks, HasLen, 3)
+
+	txn, err = s.store.Begin()
+	c.Assert(err, IsNil)
+	v, err := txn.Get(context.Background(), []byte("c"))
+	c.Assert(err, IsNil)
+	c.Assert(v, BytesEquals, []byte("c"))
+	_, err = txn.Get(context.Background(), []byte("e"))
+	c.Assert(tidbkv.IsErrNotFound(err), IsTrue)
+}
+
+func (s *testLockSuite) TestScanLockNoWait(c *C) {
+	s.putAlphabets(c)
+	s.lockKey(c, []byte("c"), []byte("cc"), []byte("z1"), []byte("z1"), false)
+
+	txn, err := s.store.Begin()
+	c.Assert(err, IsNil)
+	scanner, err := txn.NewScanner([]byte("a"), []byte("e"), 10, false, tikv.WithLockNoWait())
+	c.Assert(err, IsNil)
+	c.Assert(scanner.Key(), BytesEquals, []byte("a"))
+	c.Assert(scanner.Next(), IsNil)
+	c.Assert(scanner.Key(), BytesEquals, []byte("b"))
+	err = scanner.Next()
+	e, ok := errors.Cause(err).(*kv.ErrTxnLockWait)
+	c.Assert(ok, IsTrue)
+	c.Assert(e.Key, BytesEquals, []byte("c"))
+	c.Assert(e.TTL, Greater, uint64(0))
+	c.Assert(scanner.Valid(), IsFalse)
+}
+
+func (s *testLockSuite) TestScanSkippedNotExist(c *C) {
+	s.putKV(c, []byte("bar"), []byte("bar"))
+	s.putKV(c, []byte("baz"), []byte("baz"))
+	s.lockKey(c, []byte("bar"), nil, []byte("z1"), []byte("z1"), true)
+	s.lockKey(c, []byte("foo"), nil, []byte("z2"), []byte("z2"), true)
+
+	txn, err := s.store.Begin()
+	c.Assert(err, IsNil)
+	scanner, err := txn.NewScanner([]byte("a"), []byte("g"), 10, false)
+	c.Assert(err, IsNil)
+	c.Assert(scanner.Key(), BytesEquals, []byte("baz"))
+	c.Assert(scanner.Next(), IsNil)
+	c.Assert(scanner.Valid(), IsFalse)
+	stats := scanner.Stats()
+	c.Assert(stats.SkippedNotExist, Equals, 2)
+	c.Assert(stats.Regions, Equals, 1)
+	c.Assert(stats.Bytes, Greater, 0)
+}
+
+func (s *testLockSuite) TestScanLockWaitBeforeResolve(c *C) {
+	s.putAlphabets(c)
+
+	txn1, err := s.store.Begin()
+	c.Assert(err, IsNil)
+	c.Assert(txn1.Set([]byte("c"), []byte("cc")), IsNil)
+	c.Assert(txn1.Set([]byte("z1"), []byte("z1")), IsNil)
+	committer, err := txn1.NewCommitter(0)
+	c.Assert(err, IsNil)
+	committer.SetPrimaryKey([]byte("z1"))
+	ctx := context.Background()
+	c.Assert(committer.PrewriteAllMutations(ctx), IsNil)
+	commitTS, err := s.store.GetOracle().GetTimestamp(ctx, &oracle.Option{TxnScope: oracle.GlobalTxnScope})
+	c.Assert(err, IsNil)
+
+	txn2, err := s.store.Begin()
+	c.Assert(err, IsNil)
+	scanner, err := txn2.NewScanner([]byte("a"), []byte("e"), 10, false, tikv.WithLockWaitBeforeResolve(500))
+	c.Assert(err, IsNil)
+	done := make(chan error, 1)
+	go func() {
+		committer.SetCommitTS(commitTS)
+		done <- committer.CommitMutations(ctx)
+	}()
+	var values []string
+	for scanner.Valid() {
+		values = append(values, string(scanner.Value()))
+		c.Assert(scanner.Next(), IsNil)
+	}
+	c.Assert(values, DeepEquals, []string{"a", "b", "cc", "d"})
+	// The lock is not resolved by the reader, so the commit with a smaller commitTS succeeds.
+	c.Assert(<-done, IsNil)
+}
+
+func (s *testLockSuite) TestScanMaxLocksResolved(c *C) {
+	s.putAlphabets(c)
+	s.lockKey(c, []byte("c"), []byte("cc"), []byte("z1"), []byte("z1"), true)
+	s.lockKey(c, []byte("d"), []byte("dd"), []byte("z2"), []byte("z2"), true)
+
+	scan := func() ([]string, error) {
+		txn, err := s.store.Begin()
+		c.Assert(err, IsNil)
+		scanner, err := txn.NewScanner([]byte("a"), []byte("f"), 10, false, tikv.WithMaxLocksResolved(1))
+		if err != nil {
+			return nil, err
+		}
+		var values []string
+		for scanner.Valid() {
+			values = append(values, string(scanner.Value()))
+			if err = scanner.Next(); err != nil {
+				return nil, err
+			}
+		}
+		return values, nil
+	}
+	// The lock on "c" is resolved, and the scan fails at the lock on "d".
+	_, err := scan()
+	e, ok := errors.Cause(err).(*kv.ErrTooManyLocks)
+	c.Assert(ok, IsTrue)
+	c.Assert(e.Limit, Equals, 1)
+	// Only the lock on "d" is left.
+	values, err := scan()
+	c.Assert(err, IsNil)
+	c.Assert(values, DeepEquals, []string{"a", "b", "cc", "dd", "e"})
+}
+
+func (s *testLockSuite) TestScanLockDeadLetter(c *C) {
+	s.putAlphabets(c)
+	s.lockKey(c, []byte("c"), []byte("cc"), []byte("z1"), []byte("z1"), false)
+	// The status of the lock's transaction can never be checked.
+	hooked := &hookedClient{Client: s.store.GetTiKVClient(), onSend: func(req *tikvrpc.Request) (*tikvrpc.Response, error) {
+		if req.Type == tikvrpc.CmdCheckTxnStatus {
+			return &tikvrpc.Response{}, nil
+		}
+		return nil, nil
+	}}
+	s.store.SetTiKVClient(hooked)
+
+	for _, skip := range []bool{true, false} {
+		var deadLetters [][]byte
+		sink := func(lock *tikv.Lock, err error) {
+			c.Assert(errors.Cause(err), Equals, kv.ErrBodyMissing)
+			deadLetters = append(deadLetters, lock.Key)
+		}
+		txn, err := s.store.Begin()
+		c.Assert(err, IsNil)
+		scanner, err := txn.NewScanner([]byte("a"), []byte("e"), 10, false, tikv.WithLockDeadLetter(sink, skip))
+		c.Assert(err, IsNil)
+		var keys []byte
+		for scanner.Valid() {
+			keys = append(keys, scanner.Key()...)
+			if err = scanner.Next(); err != nil {
+				break
+			}
+		}
+		c.Assert(deadLetters, DeepEquals, [][]byte{[]byte("c")})
+		if skip {
+			c.Assert(err, IsNil)
+			c.Assert(string(keys), Equals, "abd")
+			c.Assert(scanner.Stats().SkippedDeadLetter, Equals, 1)
+		} else {
+			c.Assert(errors.Cause(err), Equals, kv.ErrBodyMissing)
+			c.Assert(string(keys), Equals, "ab")
+		}
+	}
+}
+
+func (s *testLockSuite) TestScanBypassLocks(c *C) {
+	s.putAlphabets(c)
+	bypassed, _ := s.lockKey(c, []byte("c"), []byte("cc"), []byte("z1"), []byte("z1"), true)
+	s.lockKey(c, []byte("d"), []byte("dd"), []byte("z2"), []byte("z2"), true)
+	var checked, got []string
+	hooked := &hookedClient{Client: s.store.GetTiKVClient(), onSend: func(req *tikvrpc.Request) (*tikvrpc.Response, error) {
+		switch req.Type {
+		case tikvrpc.CmdCheckTxnStatus:
+			checked = append(checked, string(req.CheckTxnStatus().PrimaryKey))
+		case tikvrpc.CmdGet:
+			got = append(got, string(req.Get().Key))
+		}
+		return nil, nil
+	}}
+	s.store.SetTiKVClient(hooked)
+
+	txn, err := s.store.Begin()
+	c.Assert(err, IsNil)
+	txn.GetSnapshot().SetOption(kv.BypassLocks, []uint64{bypassed})
+	// The lock on "c" is ignored, so its old value is read, while the lock on "d" is resolved.
+	scanner, err := txn.NewScanner([]byte("a"), []byte("f"), 10, false)
+	c.Assert(err, IsNil)
+	var values []string
+	for scanner.Valid() {
+		values = append(values, string(scanner.Value()))
+		c.Assert(scanner.Next(), IsNil)
+	}
+	c.Assert(values, DeepEquals, []string{"a", "b", "c", "dd", "e"})
+	// Only the locked key "d" is read again by point gets.
+	for _, key := range got {
+		c.Assert(key, Equals, "d")
+	}
+	val, err := txn.Get(context.TODO(), []byte("c"))
+	c.Assert(err, IsNil)
+	c.Assert(string(val), Equals, "c")
+	c.Assert(checked, DeepEquals, []string{"z2"})
+
+	// Without bypassing, the lock on "c" is resolved too.
+	txn, err = s.store.Begin()
+	c.Assert(err, IsNil)
+	val, err = txn.Get(context.TODO(), []byte("c"))
+	c.Assert(err, IsNil)
+	c.Assert(string(val), Equals, "cc")
+	c.Assert(checked, DeepEquals, []string{"z2", "z1"})
+}
+
+func (s *testLockSuite) TestScanSharedResolvedLocks(c *C) {
+	s.putAlphabets(c)
+	// The transaction locks "c" and "x" and isn't committed, so its min commit ts is
+	// pushed by the reads.
+	s.lockKey(c, []byte("c"), []byte("cc"), []byte("x"), []byte("xx"), false)
+	var checked []string
+	hooked := &hookedClient{Client: s.store.GetTiKVClient(), onSend: func(req *tikvrpc.Request) (*tikvrpc.Response, error) {
+		if req.Type == tikvrpc.CmdCheckTxnStatus {
+			checked = append(checked, string(req.CheckTxnStatus().PrimaryKey))
+		}
+		return nil, nil
+	}}
+	s.store.SetTiKVClient(hooked)
+
+	scan := func(txn tikv.TxnProbe, startKey, endKey string) string {
+		scanner, err := txn.NewScanner([]byte(startKey), []byte(endKey), 10, false)
+		c.Assert(err, IsNil)
+		var values []byte
+		for scanner.Valid() {
+			values = append(values, scanner.Value()...)
+			c.Assert(scanner.Next(), IsNil)
+		}
+		return string(values)
+	}
+	txn, err := s.store.Begin()
+	c.Assert(err, IsNil)
+	c.Assert(scan(txn, "a", "e"), Equals, "abcd")
+	c.Assert(checked, DeepEquals, []string{"x"})
+	// The other scanners of the snapshot don't resolve the lock on "x" again.
+	c.Assert(scan(txn, "w", "z"), Equals, "wxy")
+	c.Assert(scan(txn, "b", "d"), Equals, "bc")
+	c.Assert(checked, DeepEquals, []string{"x"})
+
+	// The scanners of another snapshot resolve the lock again.
+	txn, err = s.store.Begin()
+	c.Assert(err, IsNil)
+	c.Assert(scan(txn, "w", "z"), Equals, "wxy")
+	c.Assert(checked, DeepEquals, []string{"x", "x"})
+}
+
+func (s *testLockSuite) TestScanFairLockWait(c *C) {
+	s.putAlphabets(c)
+	s.lockKey(c, []byte("c"), []byte("cc"), []byte("z1"), []byte("z1"), true)
+
+	// All the scanners meeting the lock read the committed value in turn.
+	const scanners = 3
+	results := make(chan []string, scanners)
+	for i := 0; i < scanners; i++ {
+		go func() {
+			txn, err := s.store.Begin()
+			c.Assert(err, IsNil)
+			scanner, err := txn.NewScanner([]byte("a"), []byte("e"), 10, false, tikv.WithFairLockWait())
+			c.Assert(err, IsNil)
+			var values []string
+			for scanner.Valid() {
+				values = append(values, string(scanner.Value()))
+				c.Assert(scanner.Next(), IsNil)
+			}
+			results <- values
+		}()
+	}
+	for i := 0; i < scanners; i++ {
+		c.Assert(<-results, DeepEquals, []string{"a", "b", "cc", "d"})
+	}
+}
+
+func (s *testLockSuite) TestIterForUpdateLatestValues(c *C) {
+	for _, k := range []string{"a", "b", "c", "d"} {
+		s.putKV(c, []byte(k), []byte(k))
+	}
+	// The secondary lock of deleted key "ab" is left behind the committed primary.
+	s.lockKey(c, []byte("ab"), nil, []byte("a"), []byte("aa"), true)
+
+	txn1, err := s.store.Begin()
+	c.Assert(err, IsNil)
+	txn1.SetOption(kv.Pessimistic, true)
+	// "b" is updated and "c" is deleted after txn1 starts.
+	txn2, err := s.store.Begin()
+	c.Assert(err, IsNil)
+	c.Assert(txn2.Set([]byte("b"), []byte("bb")), IsNil)
+	c.Assert(txn2.Delete([]byte("c")), IsNil)
+	c.Assert(txn2.Commit(context.Background()), IsNil)
+
+	forUpdateTS, err := s.store.GetOracle().GetTimestamp(context.Background(), &oracle.Option{TxnScope: oracle.GlobalTxnScope})
+	c.Assert(err, IsNil)
+	lockCtx := &kv.LockCtx{ForUpdateTS: forUpdateTS, WaitStartTime: time.Now()}
+	iter, err := txn1.IterForUpdate(context.Background(), lockCtx, []byte("a"), []byte("e"))
+	c.Assert(err, IsNil)
+	var keys, values []string
+	for iter.Valid() {
+		keys = append(keys, string(iter.Key()))
+		values = append(values, string(iter.Value()))
+		c.Assert(iter.Next(), IsNil)
+	}
+	c.Assert(keys, DeepEquals, []string{"a", "b", "d"})
+	c.Assert(values, DeepEquals, []string{"aa", "bb", "d"})
+	// "ab" doesn't exist once its lock is resolved, it's not locked.
+	_, err = txn1.GetMemBuffer().GetFlags([]byte("ab"))
+	c.Assert(err, NotNil)
+	flags, err := txn1.GetMemBuffer().GetFlags([]byte("b"))
+	c.Assert(err, IsNil)
+	c.Assert(flags.HasLocked(), IsTrue)
+	// "c" is locked as it's scanned from the snapshot, though it's not returned.
+	flags, err = txn1.GetMemBuffer().GetFlags([]byte("c"))
+	c.Assert(err, IsNil)
+	c.Assert(flags.HasLocked(), IsTrue)
+	c.Assert(txn1.Rollback(), IsNil)
+}
+
+func (s *testLockSuite) TestIterForUpdate(c *C) {
+	s.putAlphabets(c)
+
+	txn1, err := s.store.Begin()
+	c.Assert(err, IsNil)
+	txn1.SetOption(kv.Pessimistic, true)
+	lockCtx := &kv.LockCtx{ForUpdateTS: txn1.StartTS(), WaitStartTime: time.Now()}
+	iter, err := txn1.IterForUpdate(context.Background(), lockCtx, []byte("c"), []byte("s"))
+	c.Assert(err, IsNil)
+	for ch := byte('c'); ch < byte('s'); ch++ {
+		c.Assert(iter.Key(), BytesEquals, []byte{ch})
+		flags, err := txn1.GetMemBuffer().GetFlags([]byte{ch})
+		c.Assert(err, IsNil)
+		c.Assert(flags.HasLocked(), IsTrue)
+		c.Assert(iter.Next(), IsNil)
+	}
+	c.Assert(iter.Valid(), IsFalse)
+	_, err = txn1.GetMemBuffer().GetFlags([]byte("s"))
+	c.Assert(err, NotNil)
+
+	// Keys in the range are locked by txn1.
+	txn2, err := s.store.Begin()
+	c.Assert(err, IsNil)
+	txn2.SetOption(kv.Pessimistic, true)
+	lockCtx = &kv.LockCtx{ForUpdateTS: txn2.StartTS(), WaitStartTime: time.Now(), LockWaitTime: tikv.LockNoWait}
+	err = txn2.LockKeys(context.Background(), lockCtx, []byte("k"))
+	c.Assert(err, NotNil)
+	// Keys out of the range are not locked.
+	lockCtx = &kv.LockCtx{ForUpdateTS: txn2.StartTS(), WaitStartTime: time.Now(), LockWaitTime: tikv.LockNoWait}
+	c.Assert(txn2.LockKeys(context.Background(), lockCtx, []byte("t")), IsNil)
+	c.Assert(txn2.Rollback(), IsNil)
+	c.Assert(txn1.Rollback(), IsNil)
+}
+
+func (s *testLockSuite) TestScanBatchLockResolution(c *C) {
+	s.putAlphabets(c)
+	_, err := s.store.SplitRegions(context.Background(), [][]byte{[]byte("h")}, false, nil)
+	c.Assert(err, IsNil)
+
+	// Leave the secondary locks of several keys in both regions behind a committed
+	// primary, including the lock of deleted key "e".
+	txn, err := s.store.Begin()
+	c.Assert(err, IsNil)
+	for _, k := range []string{"b", "c", "d", "i", "j"} {
+		c.Assert(txn.Set([]byte(k), []byte(k+k)), IsNil)
+	}
+	c.Assert(txn.Delete([]byte("e")), IsNil)
+	committer, err := txn.NewCommitter(0)
+	c.Assert(err, IsNil)
+	committer.SetPrimaryKey([]byte("b"))
+	ctx := context.Background()
+	c.Assert(committer.PrewriteAllMutations(ctx), IsNil)
+	commitTS, err := s.store.GetOracle().GetTimestamp(ctx, &oracle.Option{TxnScope: oracle.GlobalTxnScope})
+	c.Assert(err, IsNil)
+	committer.SetCommitTS(commitTS)
+	c.Assert(committer.CommitMutations(ctx), IsNil)
+
+	// The BatchGet requests of different regions are sent concurrently.
+	var gets, batchGets int64
+	hooked := &hookedClient{Client: s.store.GetTiKVClient(), onSend: func(req *tikvrpc.Request) (*tikvrpc.Response, error) {
+		switch req.Type {
+		case tikvrpc.CmdGet:
+			atomic.AddInt64(&gets, 1)
+		case tikvrpc.CmdBatchGet:
+			atomic.AddInt64(&batchGets, 1)
+		}
+		return nil, nil
+	}}
+	s.store.SetTiKVClient(hooked)
+
+	txn, err = s.store.Begin()
+	c.Assert(err, IsNil)
+	scanner, err := txn.NewScanner([]byte("a"), []byte("{"), 26, false)
+	c.Assert(err, IsNil)
+	var keys, values []byte
+	for scanner.Valid() {
+		keys = append(keys, scanner.Key()...)
+		values = append(values, scanner.Value()...)
+		c.Assert(scanner.Next(), IsNil)
+	}
+	c.Assert(string(keys), Equals, "abcdfghijklmnopqrstuvwxyz")
+	c.Assert(string(values), Equals, "abbccddfghiijjklmnopqrstuvwxyz")
+	c.Assert(scanner.Stats().SkippedNotExist, Equals, 1)
+	// The locked keys of the batch are read by BatchGet requests per region instead
+	// of a point get per key: one meets the locks, and one reads the keys again once
+	// the locks are resolved.
+	c.Assert(atomic.LoadInt64(&gets), Equals, int64(0))
+	c.Assert(atomic.LoadInt64(&batchGets), Equals, int64(4))
+}
@@ -22,6 +22,7 @@ import (
 	"github.com/pingcap/tidb/store/mockstore/unistore"
 	"github.com/pingcap/tidb/store/tikv"
 	"github.com/pingcap/tidb/store/tikv/mockstore/cluster"
+	"github.com/pingcap/tidb/store/tikv/tikvrpc"
 )
 
 type testRawKVSuite struct {
@@ -251,6 +252,32 @@ func (s *testRawKVSuite) TestScan(c *C) {
 	check()
 }
 
+func (s *testRawKVSuite) TestScanCF(c *C) {
+	client, pdClient, cluster, err := unistore.New("")
+	c.Assert(err, IsNil)
+	unistore.BootstrapWithSingleStore(cluster)
+	var cfs []string
+	hooked := &hookedClient{Client: client, onSend: func(req *tikvrpc.Request) (*tikvrpc.Response, error) {
+		if req.Type == tikvrpc.CmdRawScan {
+			cfs = append(cfs, req.RawScan().Cf)
+		}
+		return nil, nil
+	}}
+	rawClient := tikv.RawKVClientProbe{RawKVClient: &tikv.RawKVClient{}}
+	rawClient.SetPDClient(pdClient)
+	rawClient.SetRegionCache(tikv.NewRegionCache(pdClient))
+	rawClient.SetRPCClient(hooked)
+	defer rawClient.Close()
+	c.Assert(rawClient.Put([]byte("k1"), []byte("v1")), IsNil)
+
+	keys, _, err := rawClient.ScanCF("write", []byte("k"), nil, 10)
+	c.Assert(err, IsNil)
+	c.Assert(len(keys), Equals, 1)
+	_, _, err = rawClient.Scan([]byte("k"), nil, 10)
+	c.Assert(err, IsNil)
+	c.Assert(cfs, DeepEquals, []string{"write", ""})
+}
+
 func (s *testRawKVSuite) TestReverseScan(c *C) {
 	s.mustPut(c, []byte("k1"), []byte("v1"))
 	s.mustPut(c, []byte("k3"), []byte("v3"))
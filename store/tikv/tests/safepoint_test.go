@@ -121,3 +121,57 @@ func (s *testSafePointSuite) TestSafePoint(c *C) {
 	isBehind = isFallBehind || isMayFallBehind
 	c.Assert(isBehind, IsTrue)
 }
+
+func (s *testSafePointSuite) TestScanHistoricalVersion(c *C) {
+	// Use a separate store so that the safe point saved by other tests doesn't matter.
+	store := tikv.StoreProbe{KVStore: NewTestStore(c)}
+	defer store.Close()
+	prefix := s.prefix + "_history"
+	put := func(val string) uint64 {
+		txn, err := store.Begin()
+		c.Assert(err, IsNil)
+		for i := 0; i < 3; i++ {
+			err = txn.Set(encodeKey(prefix, s08d("key", i)), []byte(val))
+			c.Assert(err, IsNil)
+		}
+		err = txn.Commit(context.Background())
+		c.Assert(err, IsNil)
+		return txn.GetCommitTS()
+	}
+	scanValues := func(ts uint64) ([]string, error) {
+		iter, err := store.GetSnapshot(ts).Iter(encodeKey(prefix, ""), encodeKey(prefix, "~"))
+		if err != nil {
+			return nil, err
+		}
+		defer iter.Close()
+		var vals []string
+		for iter.Valid() {
+			vals = append(vals, string(iter.Value()))
+			if err = iter.Next(); err != nil {
+				return nil, err
+			}
+		}
+		return vals, nil
+	}
+
+	ts1 := put("v1")
+	ts2 := put("v2")
+
+	vals, err := scanValues(ts1)
+	c.Assert(err, IsNil)
+	c.Assert(vals, DeepEquals, []string{"v1", "v1", "v1"})
+	vals, err = scanValues(ts2)
+	c.Assert(err, IsNil)
+	c.Assert(vals, DeepEquals, []string{"v2", "v2", "v2"})
+	vals, err = scanValues(ts1 - 1)
+	c.Assert(err, IsNil)
+	c.Assert(vals, HasLen, 0)
+
+	// Versions before the GC safe point can't be read anymore.
+	store.UpdateSPCache(ts2, time.Now())
+	_, err = scanValues(ts1)
+	c.Assert(terror.ErrorEqual(errors.Cause(err), kv.ErrGCTooEarly), IsTrue)
+	vals, err = scanValues(ts2)
+	c.Assert(err, IsNil)
+	c.Assert(vals, DeepEquals, []string{"v2", "v2", "v2"})
+}
@@ -0,0 +1,101 @@
+// Copyright 2021 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tikv_test
+
+import (
+	"time"
+
+	. "github.com/pingcap/check"
+	"github.com/pingcap/tidb/store/tikv"
+	"github.com/pingcap/tidb/store/tikv/tikvrpc"
+)
+
+type testScanAdaptiveSuite struct {
+	OneByOneSuite
+}
+
+var _ = Suite(&testScanAdaptiveSuite{})
+
+func (s *testScanAdaptiveSuite) TestScanAdaptiveBatchSize(c *C) {
+	store, client := newHookedTestStore(c)
+	defer store.Close()
+	putAlphabet(c, store)
+	client.setOnSend(func(req *tikvrpc.Request) (*tikvrpc.Response, error) {
+		if req.Type == tikvrpc.CmdScan {
+			time.Sleep(5 * time.Millisecond)
+		}
+		return nil, nil
+	})
+
+	// scan returns the keys and the batch sizes seen by a consumer spending delay on
+	// every key.
+	scan := func(delay time.Duration) (string, []int) {
+		txn, err := store.Begin()
+		c.Assert(err, IsNil)
+		scanner, err := txn.NewScanner([]byte("a"), nil, 4, false, tikv.WithAdaptiveBatchSize(2, 8))
+		c.Assert(err, IsNil)
+		var (
+			keys  []byte
+			sizes []int
+		)
+		for scanner.Valid() {
+			keys = append(keys, scanner.Key()...)
+			sizes = append(sizes, scanner.Stats().BatchSize)
+			time.Sleep(delay)
+			c.Assert(scanner.Next(), IsNil)
+		}
+		return string(keys), sizes
+	}
+
+	keys, sizes := scan(0)
+	c.Assert(keys, Equals, "abcdefghijklmnopqrstuvwxyz")
+	c.Assert(sizes[0], Equals, 4)
+	c.Assert(sizes[len(sizes)-1], Equals, 8)
+	keys, sizes = scan(10 * time.Millisecond)
+	c.Assert(keys, Equals, "abcdefghijklmnopqrstuvwxyz")
+	c.Assert(sizes[0], Equals, 4)
+	c.Assert(sizes[len(sizes)-1], Equals, 2)
+}
+
+func (s *testScanAdaptiveSuite) TestScanDeadlineBatchShrink(c *C) {
+	store, client := newHookedTestStore(c)
+	defer store.Close()
+	putAlphabet(c, store)
+	var limits []uint32
+	client.setOnSend(func(req *tikvrpc.Request) (*tikvrpc.Response, error) {
+		if req.Type == tikvrpc.CmdScan {
+			limits = append(limits, req.Scan().Limit)
+			time.Sleep(20 * time.Millisecond)
+		}
+		return nil, nil
+	})
+
+	txn, err := store.Begin()
+	c.Assert(err, IsNil)
+	scanner, err := txn.NewScanner([]byte("a"), nil, 8, false, tikv.WithMaxDuration(time.Second), tikv.WithDeadlineBatchShrink(2))
+	c.Assert(err, IsNil)
+	var keys []byte
+	for scanner.Valid() {
+		keys = append(keys, scanner.Key()...)
+		// The consumer uses up most of the budget by the end of the scan.
+		time.Sleep(25 * time.Millisecond)
+		c.Assert(scanner.Next(), IsNil)
+	}
+	c.Assert(string(keys), Equals, "abcdefghijklmnopqrstuvwxyz")
+	c.Assert(limits[0], Equals, uint32(8))
+	for i := 1; i < len(limits); i++ {
+		c.Assert(limits[i], LessEqual, limits[i-1])
+	}
+	c.Assert(limits[len(limits)-1], Less, uint32(8))
+}
@@ -0,0 +1,67 @@
+// Copyright 2021 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tikv_test
+
+import (
+	. "github.com/pingcap/check"
+	"github.com/pingcap/tidb/store/tikv"
+	"github.com/pingcap/tidb/store/tikv/kv"
+)
+
+type testScanCoalesceSuite struct {
+	OneByOneSuite
+}
+
+var _ = Suite(&testScanCoalesceSuite{})
+
+func (s *testScanCoalesceSuite) TestCoalescedRangeScanner(c *C) {
+	store := tikv.StoreProbe{KVStore: NewTestStore(c)}
+	defer store.Close()
+	putAlphabet(c, store)
+
+	txn, err := store.Begin()
+	c.Assert(err, IsNil)
+	snapshot := txn.GetSnapshot()
+	var ranges []kv.KeyRange
+	for _, key := range []string{"b", "d", "f", "p", "r"} {
+		ranges = append(ranges, kv.KeyRange{StartKey: []byte(key), EndKey: kv.PrefixNextKey([]byte(key))})
+	}
+	scan := func(maxGap uint64) (string, []int, int) {
+		scanner, err := snapshot.NewCoalescedRangeScanner(ranges, maxGap, 4)
+		c.Assert(err, IsNil)
+		defer scanner.Close()
+		var (
+			keys    []byte
+			indexes []int
+		)
+		for scanner.Valid() {
+			c.Assert(scanner.Value(), BytesEquals, scanner.Key())
+			keys = append(keys, scanner.Key()...)
+			indexes = append(indexes, scanner.Range())
+			c.Assert(scanner.Next(), IsNil)
+		}
+		c.Assert(scanner.Range(), Equals, -1)
+		return string(keys), indexes, scanner.Scans()
+	}
+	for _, maxGap := range []uint64{0, 1, 9} {
+		keys, indexes, scans := scan(maxGap)
+		c.Assert(keys, Equals, "bdfpr")
+		c.Assert(indexes, DeepEquals, []int{0, 1, 2, 3, 4})
+		c.Assert(scans, Equals, map[uint64]int{0: 5, 1: 2, 9: 1}[maxGap])
+	}
+
+	ranges[2], ranges[3] = ranges[3], ranges[2]
+	_, err = snapshot.NewCoalescedRangeScanner(ranges, 1, 4)
+	c.Assert(err, NotNil)
+}
@@ -0,0 +1,305 @@
+// Copyright 2021 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tikv_test
+
+import (
+	"bytes"
+	"context"
+	"time"
+
+	. "github.com/pingcap/check"
+	"github.com/pingcap/errors"
+	"github.com/pingcap/tidb/store/tikv"
+	"github.com/pingcap/tidb/store/tikv/kv"
+	"github.com/pingcap/tidb/store/tikv/tikvrpc"
+)
+
+type testScanCursorSuite struct {
+	OneByOneSuite
+}
+
+var _ = Suite(&testScanCursorSuite{})
+
+func (s *testScanCursorSuite) TestScanNextChunkBestEffort(c *C) {
+	store, client := newHookedTestStore(c)
+	defer store.Close()
+	putAlphabet(c, store)
+
+	txn, err := store.Begin()
+	c.Assert(err, IsNil)
+	scanner, err := txn.NewScanner([]byte("a"), []byte("{"), 3, false)
+	c.Assert(err, IsNil)
+	chunk, cursor, err := scanner.NextChunkBestEffort(30)
+	c.Assert(err, IsNil)
+	c.Assert(chunk, HasLen, 26)
+	c.Assert(cursor.EOF, IsTrue)
+
+	// The second batch takes longer than the max duration of the scan.
+	client.setOnSend(func(req *tikvrpc.Request) (*tikvrpc.Response, error) {
+		if req.Type == tikvrpc.CmdScan && bytes.Equal(req.Scan().StartKey, []byte("c\x00")) {
+			time.Sleep(100 * time.Millisecond)
+		}
+		return nil, nil
+	})
+	defer client.setOnSend(nil)
+	scanner, err = txn.NewScanner([]byte("a"), []byte("{"), 3, false, tikv.WithMaxDuration(50*time.Millisecond))
+	c.Assert(err, IsNil)
+	chunk, cursor, err = scanner.NextChunkBestEffort(30)
+	c.Assert(errors.Cause(err), Equals, kv.ErrDeadlinePartial)
+	var keys []byte
+	for _, pair := range chunk {
+		keys = append(keys, pair.Key...)
+	}
+	c.Assert(string(keys), Equals, "abc")
+	c.Assert(cursor.Version, Equals, txn.StartTS())
+
+	scanner, err = store.ResumeScanner(cursor, []byte("{"), 3)
+	c.Assert(err, IsNil)
+	chunk, _, err = scanner.NextChunkBestEffort(30)
+	c.Assert(err, IsNil)
+	c.Assert(chunk, HasLen, 23)
+	c.Assert(chunk[0].Key, BytesEquals, []byte("d"))
+}
+
+func (s *testScanCursorSuite) TestScanCursor(c *C) {
+	store := tikv.StoreProbe{KVStore: NewTestStore(c)}
+	defer store.Close()
+	putAlphabet(c, store)
+
+	txn, err := store.Begin()
+	c.Assert(err, IsNil)
+	scanner, err := txn.NewScanner([]byte("a"), []byte("{"), 5, false)
+	c.Assert(err, IsNil)
+	for ch := byte('a'); ch < byte('k'); ch++ {
+		c.Assert(scanner.Next(), IsNil)
+	}
+	cursor, err := scanner.Cursor()
+	c.Assert(err, IsNil)
+	data := cursor.Encode()
+
+	// Changes committed after the snapshot are invisible to the resumed scan.
+	txn1, err := store.Begin()
+	c.Assert(err, IsNil)
+	c.Assert(txn1.Set([]byte("m"), []byte("M")), IsNil)
+	c.Assert(txn1.Commit(context.Background()), IsNil)
+
+	cursor, err = tikv.DecodeScanCursor(data)
+	c.Assert(err, IsNil)
+	c.Assert(cursor.Version, Equals, txn.StartTS())
+	c.Assert(cursor.NextStartKey, BytesEquals, []byte("k"))
+	c.Assert(cursor.EOF, IsFalse)
+	scanner, err = store.ResumeScanner(cursor, []byte("{"), 5)
+	c.Assert(err, IsNil)
+	var values []byte
+	for scanner.Valid() {
+		values = append(values, scanner.Value()...)
+		c.Assert(scanner.Next(), IsNil)
+	}
+	c.Assert(string(values), Equals, "klmnopqrstuvwxyz")
+
+	// A finished scan resumes as finished.
+	cursor, err = scanner.Cursor()
+	c.Assert(err, IsNil)
+	cursor, err = tikv.DecodeScanCursor(cursor.Encode())
+	c.Assert(err, IsNil)
+	c.Assert(cursor.EOF, IsTrue)
+	scanner, err = store.ResumeScanner(cursor, []byte("{"), 5)
+	c.Assert(err, IsNil)
+	c.Assert(scanner.Valid(), IsFalse)
+
+	// Cursors of unknown formats and corrupted cursors are rejected.
+	future := append([]byte(nil), data...)
+	future[0] = 3
+	_, err = tikv.DecodeScanCursor(future)
+	e, ok := errors.Cause(err).(*kv.ErrUnsupportedScanCursor)
+	c.Assert(ok, IsTrue)
+	c.Assert(e.Format, Equals, byte(3))
+	for _, corrupted := range [][]byte{nil, data[:5], data[:len(data)-1], append(data, 'x')} {
+		_, err = tikv.DecodeScanCursor(corrupted)
+		c.Assert(err, NotNil)
+	}
+
+	// Reverse scans can't be resumed.
+	scanner, err = txn.NewScanner(nil, []byte("k"), 5, true)
+	c.Assert(err, IsNil)
+	_, err = scanner.Cursor()
+	c.Assert(err, NotNil)
+}
+
+func (s *testScanCursorSuite) TestScanResumeOrderCheck(c *C) {
+	store := tikv.StoreProbe{KVStore: NewTestStore(c)}
+	defer store.Close()
+	putAlphabet(c, store)
+
+	txn, err := store.Begin()
+	c.Assert(err, IsNil)
+	scanner, err := txn.NewScanner([]byte("a"), []byte("{"), 3, false)
+	c.Assert(err, IsNil)
+	cursor, err := scanner.Cursor()
+	c.Assert(err, IsNil)
+	c.Assert(cursor.LastKey, IsNil)
+	// Cursors without the last key are still encoded in the first format.
+	c.Assert(cursor.Encode()[0], Equals, byte(1))
+	for ch := byte('a'); ch < byte('k'); ch++ {
+		c.Assert(scanner.Next(), IsNil)
+	}
+	cursor, err = scanner.Cursor()
+	c.Assert(err, IsNil)
+	cursor, err = tikv.DecodeScanCursor(cursor.Encode())
+	c.Assert(err, IsNil)
+	c.Assert(cursor.NextStartKey, BytesEquals, []byte("k"))
+	c.Assert(cursor.LastKey, BytesEquals, []byte("j"))
+
+	scanner, err = store.ResumeScanner(cursor, []byte("{"), 3, tikv.WithResumeOrderCheck())
+	c.Assert(err, IsNil)
+	c.Assert(scanner.Key(), BytesEquals, []byte("k"))
+
+	// A cursor whose next key isn't after its last key is rejected.
+	cursor.NextStartKey = []byte("e")
+	_, err = store.ResumeScanner(cursor, []byte("{"), 3, tikv.WithResumeOrderCheck())
+	e, ok := errors.Cause(err).(*kv.ErrResumeOrderViolation)
+	c.Assert(ok, IsTrue)
+	c.Assert(e.Key, BytesEquals, []byte("e"))
+	c.Assert(e.LastKey, BytesEquals, []byte("j"))
+	// The check is optional.
+	scanner, err = store.ResumeScanner(cursor, []byte("{"), 3)
+	c.Assert(err, IsNil)
+	c.Assert(scanner.Key(), BytesEquals, []byte("e"))
+
+	// The cursor of a finished scan keeps the last key.
+	for scanner.Valid() {
+		c.Assert(scanner.Next(), IsNil)
+	}
+	cursor, err = scanner.Cursor()
+	c.Assert(err, IsNil)
+	c.Assert(cursor.EOF, IsTrue)
+	c.Assert(cursor.LastKey, BytesEquals, []byte("z"))
+}
+
+func (s *testScanCursorSuite) TestResumeScannerBatchSize(c *C) {
+	store, client := newHookedTestStore(c)
+	defer store.Close()
+	putAlphabet(c, store)
+
+	var limits []uint32
+	client.setOnSend(func(req *tikvrpc.Request) (*tikvrpc.Response, error) {
+		if req.Type == tikvrpc.CmdScan {
+			limits = append(limits, req.Scan().Limit)
+		}
+		return nil, nil
+	})
+	txn, err := store.Begin()
+	c.Assert(err, IsNil)
+	scanner, err := txn.NewScanner([]byte("a"), []byte("{"), 8, false, tikv.WithAdaptiveBatchSize(2, 16))
+	c.Assert(err, IsNil)
+	for ch := byte('a'); ch < byte('k'); ch++ {
+		c.Assert(scanner.Next(), IsNil)
+	}
+	cursor, err := scanner.Cursor()
+	c.Assert(err, IsNil)
+	cursor, err = tikv.DecodeScanCursor(cursor.Encode())
+	c.Assert(err, IsNil)
+
+	// The scan is resumed with a smaller batch size, and the adaptive batch size
+	// starts again from it instead of the size tuned by the original scan.
+	limits = limits[:0]
+	scanner, err = store.ResumeScanner(cursor, []byte("{"), 3, tikv.WithAdaptiveBatchSize(2, 16))
+	c.Assert(err, IsNil)
+	c.Assert(limits[0], Equals, uint32(3))
+	var keys []byte
+	for scanner.Valid() {
+		keys = append(keys, scanner.Key()...)
+		c.Assert(scanner.Next(), IsNil)
+	}
+	c.Assert(string(keys), Equals, "klmnopqrstuvwxyz")
+
+	limits = limits[:0]
+	scanner, err = store.ResumeScanner(cursor, []byte("{"), 3)
+	c.Assert(err, IsNil)
+	c.Assert(scanner.Stats().BatchSize, Equals, 3)
+	keys = keys[:0]
+	for scanner.Valid() {
+		keys = append(keys, scanner.Key()...)
+		c.Assert(scanner.Next(), IsNil)
+	}
+	c.Assert(string(keys), Equals, "klmnopqrstuvwxyz")
+	for _, limit := range limits {
+		c.Assert(limit, Equals, uint32(3))
+	}
+}
+
+func (s *testScanCursorSuite) TestScanPage(c *C) {
+	store := tikv.StoreProbe{KVStore: NewTestStore(c)}
+	defer store.Close()
+	putAlphabet(c, store)
+
+	txn, err := store.Begin()
+	c.Assert(err, IsNil)
+	_, _, err = txn.GetSnapshot().ScanPage([]byte("a"), []byte("{"), 0)
+	c.Assert(err, NotNil)
+	pairs, cursor, err := txn.GetSnapshot().ScanPage([]byte("a"), []byte("{"), 5)
+	c.Assert(err, IsNil)
+	c.Assert(cursor, NotNil)
+	var values []byte
+	for _, pair := range pairs {
+		values = append(values, pair.Value...)
+	}
+
+	// Changes committed after the first page are invisible to the next pages.
+	txn1, err := store.Begin()
+	c.Assert(err, IsNil)
+	c.Assert(txn1.Set([]byte("m"), []byte("M")), IsNil)
+	c.Assert(txn1.Commit(context.Background()), IsNil)
+
+	pages := 1
+	for cursor != nil {
+		pairs, cursor, err = store.ScanNextPage(cursor, []byte("{"), 5)
+		c.Assert(err, IsNil)
+		c.Assert(len(pairs), LessEqual, 5)
+		for _, pair := range pairs {
+			values = append(values, pair.Value...)
+		}
+		pages++
+	}
+	c.Assert(string(values), Equals, "abcdefghijklmnopqrstuvwxyz")
+	c.Assert(pages, Equals, 6)
+}
+
+func (s *testScanCursorSuite) TestScanRegionPosition(c *C) {
+	store := newSplitTestStore(c, []byte("h"), []byte("p"))
+	defer store.Close()
+	putAlphabet(c, store)
+
+	txn, err := store.Begin()
+	c.Assert(err, IsNil)
+	scanner, err := txn.NewScanner([]byte("a"), []byte("{"), 3, false)
+	c.Assert(err, IsNil)
+	pos := scanner.RegionPosition()
+	c.Assert(pos.Region.StartKey, HasLen, 0)
+	c.Assert(pos.Rows, Equals, 3)
+	c.Assert(pos.Bytes, Equals, 6)
+	// The position restarts in the next region.
+	for ch := byte('a'); ch < byte('k'); ch++ {
+		c.Assert(scanner.Next(), IsNil)
+	}
+	pos = scanner.RegionPosition()
+	c.Assert(pos.Region.StartKey, BytesEquals, []byte("h"))
+	c.Assert(pos.Rows, Equals, 6)
+	// The values of the secondary keys are read after their locks are resolved.
+	c.Assert(pos.Bytes, GreaterEqual, 6)
+	// The cursor resumes the scan from the middle of the region.
+	cursor, err := scanner.Cursor()
+	c.Assert(err, IsNil)
+	c.Assert(cursor.NextStartKey, BytesEquals, []byte("k"))
+}
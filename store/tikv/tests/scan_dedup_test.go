@@ -0,0 +1,68 @@
+// Copyright 2021 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tikv_test
+
+import (
+	. "github.com/pingcap/check"
+	"github.com/pingcap/errors"
+	"github.com/pingcap/kvproto/pkg/kvrpcpb"
+	"github.com/pingcap/tidb/store/tikv"
+	"github.com/pingcap/tidb/store/tikv/kv"
+	"github.com/pingcap/tidb/store/tikv/tikvrpc"
+)
+
+type testScanDedupSuite struct {
+	OneByOneSuite
+}
+
+var _ = Suite(&testScanDedupSuite{})
+
+func (s *testScanDedupSuite) TestScanDuplicateCheck(c *C) {
+	store, client := newHookedTestStore(c)
+	defer store.Close()
+	putAlphabet(c, store)
+
+	txn, err := store.Begin()
+	c.Assert(err, IsNil)
+	scanner, err := txn.NewScanner([]byte("a"), []byte("{"), 4, false, tikv.WithDuplicateCheck(1024))
+	c.Assert(err, IsNil)
+	for scanner.Valid() {
+		c.Assert(scanner.Next(), IsNil)
+	}
+
+	// The second batch returns "b" again.
+	batches := [][]string{{"a", "b", "c"}, {"d", "b"}}
+	client.setOnSend(func(req *tikvrpc.Request) (*tikvrpc.Response, error) {
+		if req.Type != tikvrpc.CmdScan || len(batches) == 0 {
+			return nil, nil
+		}
+		resp := &kvrpcpb.ScanResponse{}
+		for _, key := range batches[0] {
+			resp.Pairs = append(resp.Pairs, &kvrpcpb.KvPair{Key: []byte(key), Value: []byte(key)})
+		}
+		batches = batches[1:]
+		return &tikvrpc.Response{Resp: resp}, nil
+	})
+	defer client.setOnSend(nil)
+	scanner, err = txn.NewScanner([]byte("a"), []byte("{"), 3, false, tikv.WithDuplicateCheck(1024))
+	c.Assert(err, IsNil)
+	for scanner.Valid() {
+		if err = scanner.Next(); err != nil {
+			break
+		}
+	}
+	e, ok := errors.Cause(err).(*kv.ErrDuplicateKey)
+	c.Assert(ok, IsTrue)
+	c.Assert(e.Key, BytesEquals, []byte("b"))
+}
@@ -0,0 +1,67 @@
+// Copyright 2021 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tikv_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"testing/iotest"
+
+	. "github.com/pingcap/check"
+	"github.com/pingcap/tidb/store/tikv"
+)
+
+type testScanExportSuite struct {
+	OneByOneSuite
+}
+
+var _ = Suite(&testScanExportSuite{})
+
+func (s *testScanExportSuite) TestScanExportReader(c *C) {
+	store := tikv.StoreProbe{KVStore: NewTestStore(c)}
+	defer store.Close()
+	putAlphabet(c, store)
+
+	txn, err := store.Begin()
+	c.Assert(err, IsNil)
+	scanner, err := txn.NewScanner([]byte("c"), []byte("t"), 4, false)
+	c.Assert(err, IsNil)
+	_, err = scanner.ExportReader(tikv.ExportFormat(100))
+	c.Assert(err, NotNil)
+	reader, err := scanner.ExportReader(tikv.ExportFormatLengthPrefixed)
+	c.Assert(err, IsNil)
+	// Read one byte at a time to cross the boundaries of all pairs.
+	data, err := ioutil.ReadAll(iotest.OneByteReader(reader))
+	c.Assert(err, IsNil)
+	c.Assert(scanner.Valid(), IsFalse)
+
+	r := bytes.NewReader(data)
+	readField := func() []byte {
+		l, err := binary.ReadUvarint(r)
+		c.Assert(err, IsNil)
+		field := make([]byte, l)
+		_, err = io.ReadFull(r, field)
+		c.Assert(err, IsNil)
+		return field
+	}
+	var keys []byte
+	for r.Len() > 0 {
+		key, value := readField(), readField()
+		c.Assert(value, BytesEquals, key)
+		keys = append(keys, key...)
+	}
+	c.Assert(string(keys), Equals, "cdefghijklmnopqrs")
+}
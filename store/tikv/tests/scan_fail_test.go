@@ -0,0 +1,98 @@
+// Copyright 2021 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tikv_test
+
+import (
+	"sync/atomic"
+	"time"
+
+	. "github.com/pingcap/check"
+	"github.com/pingcap/errors"
+	"github.com/pingcap/failpoint"
+	"github.com/pingcap/tidb/store/tikv"
+	"github.com/pingcap/tidb/store/tikv/kv"
+)
+
+const mockScanResponseFault = "github.com/pingcap/tidb/store/tikv/mockScanResponseFault"
+
+type testScanFailSuite struct {
+	OneByOneSuite
+}
+
+var _ = SerialSuites(&testScanFailSuite{})
+
+func (s *testScanFailSuite) scanAlphabet(c *C, store tikv.StoreProbe) error {
+	txn, err := store.Begin()
+	c.Assert(err, IsNil)
+	scanner, err := txn.NewScanner([]byte("a"), nil, 5, false)
+	if err != nil {
+		return err
+	}
+	for ch := byte('a'); ch <= byte('z'); ch++ {
+		c.Assert(scanner.Key(), BytesEquals, []byte{ch})
+		if err = scanner.Next(); err != nil {
+			return err
+		}
+	}
+	c.Assert(scanner.Valid(), IsFalse)
+	return nil
+}
+
+func (s *testScanFailSuite) TestScanInjectedFaults(c *C) {
+	store, _ := newHookedTestStore(c, []byte("h"), []byte("p"))
+	defer store.Close()
+	putAlphabet(c, store)
+
+	// Region errors and locks are retried transparently.
+	c.Assert(failpoint.Enable(mockScanResponseFault, `2*return("regionError")`), IsNil)
+	defer func() {
+		c.Assert(failpoint.Disable(mockScanResponseFault), IsNil)
+	}()
+	c.Assert(s.scanAlphabet(c, store), IsNil)
+	c.Assert(failpoint.Enable(mockScanResponseFault, `1*return("lockError")`), IsNil)
+	c.Assert(s.scanAlphabet(c, store), IsNil)
+
+	// Slow responses only delay the scan.
+	c.Assert(failpoint.Enable(mockScanResponseFault, `1*sleep(100)`), IsNil)
+	start := time.Now()
+	c.Assert(s.scanAlphabet(c, store), IsNil)
+	c.Assert(time.Since(start), GreaterEqual, 100*time.Millisecond)
+
+	// Errors of the RPC layer are returned.
+	c.Assert(failpoint.Enable(mockScanResponseFault, `1*return("timeout")`), IsNil)
+	err := s.scanAlphabet(c, store)
+	c.Assert(errors.Cause(err), Equals, kv.ErrTiKVServerTimeout)
+}
+
+func (s *testScanFailSuite) TestScanRegionNotInitialized(c *C) {
+	store, _ := newHookedTestStore(c)
+	defer store.Close()
+	putAlphabet(c, store)
+
+	// The scan waits for the region to be initialized.
+	c.Assert(failpoint.Enable(mockScanResponseFault, `3*return("regionNotInitialized")`), IsNil)
+	defer func() {
+		c.Assert(failpoint.Disable(mockScanResponseFault), IsNil)
+	}()
+	c.Assert(s.scanAlphabet(c, store), IsNil)
+
+	// A region that is never initialized fails the scan with a clear error.
+	defer atomic.StoreUint64(&tikv.ScanRegionNotInitializedMaxBackoff, atomic.LoadUint64(&tikv.ScanRegionNotInitializedMaxBackoff))
+	atomic.StoreUint64(&tikv.ScanRegionNotInitializedMaxBackoff, 100)
+	c.Assert(failpoint.Enable(mockScanResponseFault, `return("regionNotInitialized")`), IsNil)
+	err := s.scanAlphabet(c, store)
+	e, ok := errors.Cause(err).(*kv.ErrRegionNotInitialized)
+	c.Assert(ok, IsTrue, Commentf("%v", err))
+	c.Assert(e.Waited, GreaterEqual, 100*time.Millisecond)
+}
@@ -0,0 +1,67 @@
+// Copyright 2021 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tikv_test
+
+import (
+	. "github.com/pingcap/check"
+	"github.com/pingcap/tidb/store/tikv"
+	"github.com/pingcap/tidb/store/tikv/oracle"
+)
+
+type testScanFingerprintSuite struct {
+	OneByOneSuite
+}
+
+var _ = Suite(&testScanFingerprintSuite{})
+
+func (s *testScanFingerprintSuite) TestScanFingerprint(c *C) {
+	store := tikv.StoreProbe{KVStore: NewTestStore(c)}
+	defer store.Close()
+
+	ver, err := store.CurrentTimestamp(oracle.GlobalTxnScope)
+	c.Assert(err, IsNil)
+	fingerprint := func(ver uint64, startKey, endKey string, reverse bool, batchSize int, opts ...tikv.ScannerOption) string {
+		txn, err := store.BeginWithStartTS(oracle.GlobalTxnScope, ver)
+		c.Assert(err, IsNil)
+		scanner, err := tikv.TxnProbe{KVTxn: txn}.NewScanner([]byte(startKey), []byte(endKey), batchSize, reverse, opts...)
+		c.Assert(err, IsNil)
+		defer scanner.Close()
+		return scanner.Fingerprint()
+	}
+	base := fingerprint(ver, "b", "x", false, 3)
+	c.Assert(base, HasLen, 64)
+
+	// The options which don't change the returned pairs don't change the fingerprint.
+	c.Assert(fingerprint(ver, "b", "x", false, 10), Equals, base)
+	c.Assert(fingerprint(ver, "b", "x", false, 3, tikv.WithTags(map[string]string{"job": "a"}),
+		tikv.WithMaxRegions(5), tikv.WithLazyStart()), Equals, base)
+
+	// The inputs deciding the returned pairs change it.
+	others := []string{
+		fingerprint(ver+1, "b", "x", false, 3),
+		fingerprint(ver, "b", "y", false, 3),
+		fingerprint(ver, "bx", "", false, 3),
+		fingerprint(ver, "b", "x", true, 3),
+		fingerprint(ver, "b", "x", false, 3, tikv.WithKeyOnly()),
+	}
+	seen := map[string]bool{base: true}
+	for _, other := range others {
+		c.Assert(seen[other], IsFalse)
+		seen[other] = true
+	}
+
+	// A key filter can't be hashed, so the scanner has no fingerprint.
+	filter := func(key []byte) bool { return key[0] != 'c' }
+	c.Assert(fingerprint(ver, "b", "x", false, 3, tikv.WithKeyFilter(filter)), Equals, "")
+}
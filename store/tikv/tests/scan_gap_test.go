@@ -0,0 +1,66 @@
+// Copyright 2021 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tikv_test
+
+import (
+	"context"
+
+	. "github.com/pingcap/check"
+	tidbkv "github.com/pingcap/tidb/kv"
+	"github.com/pingcap/tidb/store/tikv/kv"
+	"github.com/pingcap/tidb/tablecodec"
+)
+
+type testScanGapSuite struct {
+	OneByOneSuite
+}
+
+var _ = Suite(&testScanGapSuite{})
+
+func (s *testScanGapSuite) TestGapScanner(c *C) {
+	rowKey := func(handle int64) []byte { return tablecodec.EncodeRowKeyWithHandle(1, tidbkv.IntHandle(handle)) }
+	store := newSplitTestStore(c, rowKey(5))
+	defer store.Close()
+	txn, err := store.Begin()
+	c.Assert(err, IsNil)
+	for _, handle := range []int64{1, 2, 3, 6, 7, 9} {
+		c.Assert(txn.Set(rowKey(handle), []byte{'v'}), IsNil)
+	}
+	c.Assert(txn.Commit(context.Background()), IsNil)
+
+	next := func(key []byte) []byte {
+		handle, err := tablecodec.DecodeRowKey(key)
+		c.Assert(err, IsNil)
+		return rowKey(handle.IntValue() + 1)
+	}
+	var gaps []kv.KeyRange
+	onGap := func(gap kv.KeyRange) { gaps = append(gaps, gap) }
+	txn, err = store.Begin()
+	c.Assert(err, IsNil)
+	prefix := tablecodec.GenTableRecordPrefix(1)
+	scanner, err := txn.GetSnapshot().NewGapScanner(prefix, kv.PrefixNextKey(prefix), 2, next, onGap)
+	c.Assert(err, IsNil)
+	defer scanner.Close()
+	var rows int
+	for scanner.Valid() {
+		rows++
+		c.Assert(scanner.Next(), IsNil)
+	}
+	c.Assert(rows, Equals, 6)
+	c.Assert(scanner.Gaps(), Equals, 2)
+	c.Assert(gaps, DeepEquals, []kv.KeyRange{
+		{StartKey: rowKey(4), EndKey: rowKey(6)},
+		{StartKey: rowKey(8), EndKey: rowKey(9)},
+	})
+}
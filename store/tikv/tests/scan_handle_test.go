@@ -0,0 +1,64 @@
+// Copyright 2021 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tikv_test
+
+import (
+	"context"
+
+	. "github.com/pingcap/check"
+	tidbkv "github.com/pingcap/tidb/kv"
+	"github.com/pingcap/tidb/store/tikv"
+	"github.com/pingcap/tidb/store/tikv/kv"
+	"github.com/pingcap/tidb/tablecodec"
+)
+
+type testScanHandleSuite struct {
+	OneByOneSuite
+}
+
+var _ = Suite(&testScanHandleSuite{})
+
+func (s *testScanHandleSuite) TestHandleIterator(c *C) {
+	store := tikv.StoreProbe{KVStore: NewTestStore(c)}
+	defer store.Close()
+	prefix := tablecodec.GenTableRecordPrefix(1)
+	malformed := append(append([]byte(nil), prefix...), 'x')
+	txn, err := store.Begin()
+	c.Assert(err, IsNil)
+	c.Assert(txn.Set(malformed, []byte("x")), IsNil)
+	for i := int64(1); i <= 3; i++ {
+		c.Assert(txn.Set(tablecodec.EncodeRowKeyWithHandle(1, tidbkv.IntHandle(i)), []byte{byte('0' + i)}), IsNil)
+	}
+	c.Assert(txn.Commit(context.Background()), IsNil)
+
+	txn, err = store.Begin()
+	c.Assert(err, IsNil)
+	decode := func(key []byte) (tidbkv.Handle, error) { return tablecodec.DecodeRowKey(key) }
+	it, err := txn.GetSnapshot().NewHandleIterator(prefix, kv.PrefixNextKey(prefix), 2, decode)
+	c.Assert(err, IsNil)
+	defer it.Close()
+	// The malformed key is surfaced as is.
+	c.Assert(it.Key(), BytesEquals, malformed)
+	c.Assert(it.Handle(), IsNil)
+	c.Assert(it.HandleErr(), NotNil)
+	c.Assert(it.Next(), IsNil)
+	for i := int64(1); i <= 3; i++ {
+		c.Assert(it.Valid(), IsTrue)
+		c.Assert(it.HandleErr(), IsNil)
+		c.Assert(it.Handle().IntValue(), Equals, i)
+		c.Assert(it.Next(), IsNil)
+	}
+	c.Assert(it.Valid(), IsFalse)
+	c.Assert(it.Handle(), IsNil)
+}
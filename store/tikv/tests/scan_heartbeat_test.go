@@ -0,0 +1,83 @@
+// Copyright 2021 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tikv_test
+
+import (
+	"bytes"
+	"sync"
+	"time"
+
+	. "github.com/pingcap/check"
+	"github.com/pingcap/tidb/store/tikv"
+	"github.com/pingcap/tidb/store/tikv/tikvrpc"
+)
+
+type testScanHeartbeatSuite struct {
+	OneByOneSuite
+}
+
+var _ = Suite(&testScanHeartbeatSuite{})
+
+func (s *testScanHeartbeatSuite) TestScanHeartbeat(c *C) {
+	store, client := newHookedTestStore(c)
+	defer store.Close()
+	putAlphabet(c, store)
+	client.setOnSend(func(req *tikvrpc.Request) (*tikvrpc.Response, error) {
+		if req.Type == tikvrpc.CmdScan {
+			time.Sleep(10 * time.Millisecond)
+		}
+		return nil, nil
+	})
+
+	var (
+		mu         sync.Mutex
+		heartbeats []tikv.ScanProgress
+	)
+	heartbeat := tikv.WithHeartbeat(2*time.Millisecond, func(progress tikv.ScanProgress) {
+		mu.Lock()
+		heartbeats = append(heartbeats, progress)
+		mu.Unlock()
+	})
+	// Only "x" matches the filter, so no pair is returned before it for a long time.
+	filter := tikv.WithKeyFilter(func(key []byte) bool { return bytes.Equal(key, []byte("x")) })
+	txn, err := store.Begin()
+	c.Assert(err, IsNil)
+	scanner, err := txn.NewScanner([]byte("a"), nil, 4, false, filter, heartbeat)
+	c.Assert(err, IsNil)
+	c.Assert(scanner.Key(), BytesEquals, []byte("x"))
+	c.Assert(scanner.Next(), IsNil)
+	c.Assert(scanner.Valid(), IsFalse)
+
+	mu.Lock()
+	count := len(heartbeats)
+	c.Assert(count, Greater, 0)
+	var readBeforeRows bool
+	for i, progress := range heartbeats {
+		if progress.Rows == 0 && progress.Bytes > 0 {
+			readBeforeRows = true
+		}
+		if i > 0 {
+			c.Assert(progress.Rows, GreaterEqual, heartbeats[i-1].Rows)
+			c.Assert(progress.Bytes, GreaterEqual, heartbeats[i-1].Bytes)
+		}
+		c.Assert(progress.Rows, LessEqual, 1)
+	}
+	c.Assert(readBeforeRows, IsTrue)
+	mu.Unlock()
+	// The heartbeats stop once the scanner is closed.
+	time.Sleep(10 * time.Millisecond)
+	mu.Lock()
+	c.Assert(heartbeats, HasLen, count)
+	mu.Unlock()
+}
@@ -0,0 +1,71 @@
+// Copyright 2021 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tikv_test
+
+import (
+	"bytes"
+	"time"
+
+	. "github.com/pingcap/check"
+	"github.com/pingcap/tidb/store/tikv"
+	"github.com/pingcap/tidb/store/tikv/tikvrpc"
+)
+
+type testScanHotspotSuite struct {
+	OneByOneSuite
+}
+
+var _ = Suite(&testScanHotspotSuite{})
+
+func (s *testScanHotspotSuite) TestScanHotspotThrottle(c *C) {
+	store, client := newHookedTestStore(c, []byte("h"), []byte("p"))
+	defer store.Close()
+	putAlphabet(c, store)
+
+	// The requests to region [h, p) are slow.
+	var (
+		hotRegion uint64
+		sent      []time.Time
+	)
+	client.setOnSend(func(req *tikvrpc.Request) (*tikvrpc.Response, error) {
+		if req.Type != tikvrpc.CmdScan {
+			return nil, nil
+		}
+		if key := req.Scan().StartKey; bytes.Compare(key, []byte("h")) >= 0 && bytes.Compare(key, []byte("p")) < 0 {
+			hotRegion = req.Context.RegionId
+			sent = append(sent, time.Now())
+			time.Sleep(30 * time.Millisecond)
+		}
+		return nil, nil
+	})
+	txn, err := store.Begin()
+	c.Assert(err, IsNil)
+	scanner, err := txn.NewScanner([]byte("a"), nil, 2, false, tikv.WithHotspotThrottle(20*time.Millisecond, 50*time.Millisecond))
+	c.Assert(err, IsNil)
+	var keys []byte
+	for scanner.Valid() {
+		keys = append(keys, scanner.Key()...)
+		c.Assert(scanner.Next(), IsNil)
+	}
+	c.Assert(string(keys), Equals, "abcdefghijklmnopqrstuvwxyz")
+	// Region [h, p) takes 5 requests, and the requests after the third one are
+	// throttled.
+	c.Assert(sent, HasLen, 5)
+	c.Assert(sent[3].Sub(sent[2]) >= 50*time.Millisecond, IsTrue)
+	hotspots := scanner.Stats().Hotspots
+	c.Assert(hotspots, HasLen, 1)
+	c.Assert(hotspots[0].RegionID, Equals, hotRegion)
+	c.Assert(hotspots[0].SlowRequests, Equals, 5)
+	c.Assert(hotspots[0].Delay, Equals, 50*time.Millisecond)
+}
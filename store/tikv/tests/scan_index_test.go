@@ -0,0 +1,68 @@
+// Copyright 2021 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tikv_test
+
+import (
+	"context"
+
+	. "github.com/pingcap/check"
+	"github.com/pingcap/errors"
+	"github.com/pingcap/tidb/store/tikv"
+)
+
+type testScanIndexSuite struct {
+	OneByOneSuite
+}
+
+var _ = Suite(&testScanIndexSuite{})
+
+func (s *testScanIndexSuite) TestCollectIndexKeys(c *C) {
+	store := tikv.StoreProbe{KVStore: NewTestStore(c)}
+	defer store.Close()
+	putAlphabet(c, store)
+
+	txn, err := store.Begin()
+	c.Assert(err, IsNil)
+	snapshot := txn.GetSnapshot()
+	// Every row has its own index key and shares "i/all" with the others.
+	build := func(key, value []byte) ([][]byte, error) {
+		return [][]byte{[]byte("i/" + string(value)), []byte("i/all")}, nil
+	}
+	var (
+		rows    int
+		lastKey []byte
+	)
+	progress := func(n int, key []byte) {
+		c.Assert(n, Greater, rows)
+		rows, lastKey = n, key
+	}
+	keys, err := snapshot.CollectIndexKeys(context.Background(), []byte("b"), []byte("y"), build, progress)
+	c.Assert(err, IsNil)
+	c.Assert(keys, HasLen, 24)
+	c.Assert(keys[0], BytesEquals, []byte("i/all"))
+	c.Assert(keys[1], BytesEquals, []byte("i/b"))
+	c.Assert(keys[23], BytesEquals, []byte("i/x"))
+	c.Assert(rows, Equals, 23)
+	c.Assert(lastKey, BytesEquals, []byte("x"))
+
+	_, err = snapshot.CollectIndexKeys(context.Background(), []byte("a"), nil, func(key, value []byte) ([][]byte, error) {
+		return nil, errors.New("bad row")
+	}, nil)
+	c.Assert(err, ErrorMatches, "bad row")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = snapshot.CollectIndexKeys(ctx, []byte("a"), nil, build, nil)
+	c.Assert(errors.Cause(err), Equals, context.Canceled)
+}
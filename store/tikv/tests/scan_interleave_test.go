@@ -0,0 +1,61 @@
+// Copyright 2021 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tikv_test
+
+import (
+	. "github.com/pingcap/check"
+	"github.com/pingcap/tidb/store/tikv/tikvrpc"
+)
+
+type testScanInterleaveSuite struct {
+	OneByOneSuite
+}
+
+var _ = Suite(&testScanInterleaveSuite{})
+
+func (s *testScanInterleaveSuite) TestRegionInterleavedScanner(c *C) {
+	store, client := newHookedTestStore(c, []byte("h"), []byte("p"))
+	defer store.Close()
+	putAlphabet(c, store)
+
+	var limits []uint32
+	client.setOnSend(func(req *tikvrpc.Request) (*tikvrpc.Response, error) {
+		if req.Type == tikvrpc.CmdScan {
+			limits = append(limits, req.Scan().Limit)
+		}
+		return nil, nil
+	})
+	txn, err := store.Begin()
+	c.Assert(err, IsNil)
+	_, err = txn.GetSnapshot().NewRegionInterleavedScanner([]byte("a"), nil, 0)
+	c.Assert(err, NotNil)
+	scanner, err := txn.GetSnapshot().NewRegionInterleavedScanner([]byte("a"), nil, 2)
+	c.Assert(err, IsNil)
+	defer scanner.Close()
+	var (
+		keys    []byte
+		regions []int
+	)
+	for scanner.Valid() {
+		keys = append(keys, scanner.Key()...)
+		regions = append(regions, scanner.Region())
+		c.Assert(scanner.Next(), IsNil)
+	}
+	// Two rows are read from each region in turn.
+	c.Assert(string(keys), Equals, "abhipqcdjkrseflmtugnovwxyz")
+	c.Assert(regions[:9], DeepEquals, []int{0, 0, 1, 1, 2, 2, 0, 0, 1})
+	for _, limit := range limits {
+		c.Assert(limit, Equals, uint32(2))
+	}
+}
@@ -0,0 +1,83 @@
+// Copyright 2021 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tikv_test
+
+import (
+	"context"
+	"sync/atomic"
+
+	. "github.com/pingcap/check"
+	"github.com/pingcap/tidb/store/tikv/kv"
+	"github.com/pingcap/tidb/store/tikv/tikvrpc"
+)
+
+type testScanKeysSuite struct {
+	OneByOneSuite
+}
+
+var _ = Suite(&testScanKeysSuite{})
+
+func (s *testScanKeysSuite) TestKeysScanner(c *C) {
+	store, client := newHookedTestStore(c, []byte("h"), []byte("p"))
+	defer store.Close()
+	putAlphabet(c, store)
+
+	// region returns the region of key, which is split by "h" and "p".
+	region := func(key []byte) int {
+		switch {
+		case kv.CmpKey(key, []byte("h")) < 0:
+			return 0
+		case kv.CmpKey(key, []byte("p")) < 0:
+			return 1
+		}
+		return 2
+	}
+	// The BatchGet requests of different regions are sent concurrently.
+	var scans, batchGets int64
+	client.setOnSend(func(req *tikvrpc.Request) (*tikvrpc.Response, error) {
+		switch req.Type {
+		case tikvrpc.CmdScan:
+			atomic.AddInt64(&scans, 1)
+		case tikvrpc.CmdBatchGet:
+			atomic.AddInt64(&batchGets, 1)
+			keys := req.BatchGet().Keys
+			for _, key := range keys {
+				c.Assert(region(key), Equals, region(keys[0]))
+			}
+		}
+		return nil, nil
+	})
+	defer client.setOnSend(nil)
+
+	txn, err := store.Begin()
+	c.Assert(err, IsNil)
+	var keys [][]byte
+	for _, key := range []string{"z", "b", "q", "1", "i", "b", "a", "zz", "c"} {
+		keys = append(keys, []byte(key))
+	}
+	scanner, err := txn.GetSnapshot().NewKeysScanner(context.Background(), keys, 4)
+	c.Assert(err, IsNil)
+	var got []string
+	for scanner.Valid() {
+		c.Assert(scanner.Value(), BytesEquals, scanner.Key())
+		got = append(got, string(scanner.Key()))
+		c.Assert(scanner.Next(), IsNil)
+	}
+	// The duplicates are read once, and the absent keys "1" and "zz" are skipped.
+	c.Assert(got, DeepEquals, []string{"a", "b", "c", "i", "q", "z"})
+	// The keys are read by BatchGet requests within a region: batch [1, a, b, c] is
+	// in one region, and batch [i, q, z, zz] is split into two.
+	c.Assert(atomic.LoadInt64(&scans), Equals, int64(0))
+	c.Assert(atomic.LoadInt64(&batchGets) >= 3, IsTrue)
+}
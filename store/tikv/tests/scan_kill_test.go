@@ -0,0 +1,73 @@
+// Copyright 2021 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tikv_test
+
+import (
+	"time"
+
+	. "github.com/pingcap/check"
+	"github.com/pingcap/errors"
+	"github.com/pingcap/kvproto/pkg/errorpb"
+	"github.com/pingcap/kvproto/pkg/kvrpcpb"
+	"github.com/pingcap/tidb/store/tikv"
+	"github.com/pingcap/tidb/store/tikv/kv"
+	"github.com/pingcap/tidb/store/tikv/tikvrpc"
+)
+
+type testScanKillSuite struct {
+	OneByOneSuite
+}
+
+var _ = Suite(&testScanKillSuite{})
+
+func (s *testScanKillSuite) TestScanKillConn(c *C) {
+	store, client := newHookedTestStore(c)
+	defer store.Close()
+	putAlphabet(c, store)
+
+	txn, err := store.Begin()
+	c.Assert(err, IsNil)
+	scanner, err := txn.NewScanner([]byte("a"), []byte("{"), 3, false, tikv.WithConnID(1))
+	c.Assert(err, IsNil)
+	other, err := txn.NewScanner([]byte("a"), []byte("{"), 3, false, tikv.WithConnID(2))
+	c.Assert(err, IsNil)
+	defer other.Close()
+	c.Assert(scanner.Next(), IsNil)
+	c.Assert(scanner.Next(), IsNil)
+
+	// The next batch request keeps failing with region errors, and the scanner backs off.
+	client.setOnSend(func(req *tikvrpc.Request) (*tikvrpc.Response, error) {
+		if req.Type == tikvrpc.CmdScan {
+			return &tikvrpc.Response{Resp: &kvrpcpb.ScanResponse{
+				RegionError: &errorpb.Error{ServerIsBusy: &errorpb.ServerIsBusy{}},
+			}}, nil
+		}
+		return nil, nil
+	})
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		c.Assert(store.KillScans(1), Equals, 1)
+	}()
+	start := time.Now()
+	err = scanner.Next()
+	c.Assert(errors.Cause(err), Equals, kv.ErrQueryInterrupted)
+	c.Assert(time.Since(start), Less, 5*time.Second)
+	c.Assert(scanner.Valid(), IsFalse)
+
+	// Closed scanners are unregistered, and the others are not affected.
+	c.Assert(store.KillScans(1), Equals, 0)
+	client.setOnSend(nil)
+	c.Assert(other.Next(), IsNil)
+	c.Assert(other.Key(), BytesEquals, []byte("b"))
+}
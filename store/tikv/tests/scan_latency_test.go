@@ -0,0 +1,54 @@
+// Copyright 2021 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tikv_test
+
+import (
+	"time"
+
+	. "github.com/pingcap/check"
+	"github.com/pingcap/tidb/store/tikv"
+	"github.com/pingcap/tidb/store/tikv/tikvrpc"
+)
+
+type testScanLatencySuite struct {
+	OneByOneSuite
+}
+
+var _ = Suite(&testScanLatencySuite{})
+
+func (s *testScanLatencySuite) TestScanLatencyHistogram(c *C) {
+	store, client := newHookedTestStore(c)
+	defer store.Close()
+	putAlphabet(c, store)
+
+	var requests int64
+	client.setOnSend(func(req *tikvrpc.Request) (*tikvrpc.Response, error) {
+		if req.Type == tikvrpc.CmdScan {
+			requests++
+			time.Sleep(time.Millisecond)
+		}
+		return nil, nil
+	})
+	txn, err := store.Begin()
+	c.Assert(err, IsNil)
+	h := tikv.NewLatencyHistogram(tikv.ExponentialLatencyBounds(100*time.Microsecond, 2, 16))
+	scanner, err := txn.NewScanner([]byte("a"), nil, 4, false, tikv.WithLatencyHistogram(h))
+	c.Assert(err, IsNil)
+	for scanner.Valid() {
+		c.Assert(scanner.Next(), IsNil)
+	}
+	c.Assert(scanner.Stats().RPCLatency, Equals, h)
+	c.Assert(h.Count(), Equals, requests)
+	c.Assert(h.Quantile(0.5) >= time.Millisecond, IsTrue)
+}
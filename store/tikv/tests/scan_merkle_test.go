@@ -0,0 +1,77 @@
+// Copyright 2021 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tikv_test
+
+import (
+	"context"
+
+	. "github.com/pingcap/check"
+	"github.com/pingcap/tidb/store/tikv"
+	"github.com/pingcap/tidb/store/tikv/kv"
+)
+
+type testScanMerkleSuite struct {
+	OneByOneSuite
+}
+
+var _ = Suite(&testScanMerkleSuite{})
+
+func (s *testScanMerkleSuite) TestMerkleTree(c *C) {
+	store := newSplitTestStore(c, []byte("h"), []byte("p"))
+	defer store.Close()
+	putAlphabet(c, store)
+
+	txn, err := store.Begin()
+	c.Assert(err, IsNil)
+	before := txn.GetSnapshot()
+	// The leaves are the regions by default.
+	tree, err := before.BuildMerkleTree([]byte("a"), nil, nil)
+	c.Assert(err, IsNil)
+	c.Assert(tree.Keys, Equals, int64(26))
+	c.Assert(tree.Children, HasLen, 2)
+	c.Assert(tree.Children[0].Children, HasLen, 2)
+	c.Assert(tree.Children[0].Children[1].Range, DeepEquals, kv.KeyRange{StartKey: []byte("h"), EndKey: []byte("p")})
+	c.Assert(tree.Children[0].Children[1].Keys, Equals, int64(8))
+	same, err := before.BuildMerkleTree([]byte("a"), nil, nil)
+	c.Assert(err, IsNil)
+	c.Assert(same.Hash, BytesEquals, tree.Hash)
+	c.Assert(tikv.DiffMerkleTrees(tree, same), HasLen, 0)
+
+	txn, err = store.Begin()
+	c.Assert(err, IsNil)
+	c.Assert(txn.Set([]byte("m"), []byte("M")), IsNil)
+	c.Assert(txn.Commit(context.Background()), IsNil)
+	txn, err = store.Begin()
+	c.Assert(err, IsNil)
+	after := txn.GetSnapshot()
+
+	// The differences are localized to the leaves containing them.
+	changed, err := after.BuildMerkleTree([]byte("a"), nil, nil)
+	c.Assert(err, IsNil)
+	c.Assert(changed.Hash, Not(BytesEquals), tree.Hash)
+	c.Assert(tikv.DiffMerkleTrees(tree, changed), DeepEquals, []kv.KeyRange{{StartKey: []byte("h"), EndKey: []byte("p")}})
+	boundaries := [][]byte{[]byte("e"), []byte("j"), []byte("n"), []byte("t")}
+	tree, err = before.BuildMerkleTree([]byte("a"), []byte("x"), boundaries)
+	c.Assert(err, IsNil)
+	changed, err = after.BuildMerkleTree([]byte("a"), []byte("x"), boundaries)
+	c.Assert(err, IsNil)
+	c.Assert(tikv.DiffMerkleTrees(tree, changed), DeepEquals, []kv.KeyRange{{StartKey: []byte("j"), EndKey: []byte("n")}})
+
+	// Trees split differently are compared as a whole.
+	changed, err = after.BuildMerkleTree([]byte("a"), []byte("x"), boundaries[:2])
+	c.Assert(err, IsNil)
+	c.Assert(tikv.DiffMerkleTrees(tree, changed), DeepEquals, []kv.KeyRange{{StartKey: []byte("a"), EndKey: []byte("x")}})
+	_, err = after.BuildMerkleTree([]byte("a"), []byte("x"), [][]byte{[]byte("j"), []byte("e")})
+	c.Assert(err, NotNil)
+}
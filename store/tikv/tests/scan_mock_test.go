@@ -14,10 +14,19 @@
 package tikv_test
 
 import (
+	"bytes"
 	"context"
+	"fmt"
+	"sync"
+	"time"
 
 	. "github.com/pingcap/check"
+	"github.com/pingcap/errors"
+	"github.com/pingcap/kvproto/pkg/kvrpcpb"
+	"github.com/pingcap/tidb/store/mockstore/unistore"
 	"github.com/pingcap/tidb/store/tikv"
+	"github.com/pingcap/tidb/store/tikv/kv"
+	"github.com/pingcap/tidb/store/tikv/tikvrpc"
 )
 
 type testScanMockSuite struct {
@@ -89,3 +98,962 @@ func (s *testScanMockSuite) TestReverseScan(c *C) {
 	}
 	c.Assert(scanner.Valid(), IsFalse)
 }
+
+// hookedClient wraps a tikv.Client and lets tests intercept the requests.
+type hookedClient struct {
+	tikv.Client
+	// mu protects the hooks, which are replaced by setOnSend and setOnSendCtx while
+	// the requests are sent concurrently.
+	mu sync.RWMutex
+	// onSend is called before sending the request. If it returns a non-nil response or error,
+	// the request won't be sent to the underlying client.
+	onSend func(req *tikvrpc.Request) (*tikvrpc.Response, error)
+	// onSendCtx is onSend with the context of the request, which is done once the
+	// request is canceled, like the context of a gRPC call on the server.
+	onSendCtx func(ctx context.Context, req *tikvrpc.Request) (*tikvrpc.Response, error)
+}
+
+func (c *hookedClient) setOnSend(onSend func(req *tikvrpc.Request) (*tikvrpc.Response, error)) {
+	c.mu.Lock()
+	c.onSend = onSend
+	c.mu.Unlock()
+}
+
+func (c *hookedClient) setOnSendCtx(onSendCtx func(ctx context.Context, req *tikvrpc.Request) (*tikvrpc.Response, error)) {
+	c.mu.Lock()
+	c.onSendCtx = onSendCtx
+	c.mu.Unlock()
+}
+
+func (c *hookedClient) SendRequest(ctx context.Context, addr string, req *tikvrpc.Request, timeout time.Duration) (*tikvrpc.Response, error) {
+	c.mu.RLock()
+	onSend, onSendCtx := c.onSend, c.onSendCtx
+	c.mu.RUnlock()
+	if onSendCtx != nil {
+		if resp, err := onSendCtx(ctx, req); resp != nil || err != nil {
+			return resp, err
+		}
+	}
+	if onSend != nil {
+		if resp, err := onSend(req); resp != nil || err != nil {
+			return resp, err
+		}
+	}
+	return c.Client.SendRequest(ctx, addr, req, timeout)
+}
+
+func newHookedTestStore(c *C, splitKeys ...[]byte) (tikv.StoreProbe, *hookedClient) {
+	client, pdClient, cluster, err := unistore.New("")
+	c.Assert(err, IsNil)
+	unistore.BootstrapWithMultiRegions(cluster, splitKeys...)
+	hooked := &hookedClient{}
+	store, err := tikv.NewTestTiKVStore(client, pdClient, func(c tikv.Client) tikv.Client {
+		hooked.Client = c
+		return hooked
+	}, nil, 0)
+	c.Assert(err, IsNil)
+	return tikv.StoreProbe{KVStore: store}, hooked
+}
+
+// newSplitTestStore creates a mock store whose regions are split at splitKeys.
+func newSplitTestStore(c *C, splitKeys ...[]byte) tikv.StoreProbe {
+	client, pdClient, cluster, err := unistore.New("")
+	c.Assert(err, IsNil)
+	unistore.BootstrapWithMultiRegions(cluster, splitKeys...)
+	store, err := tikv.NewTestTiKVStore(client, pdClient, nil, nil, 0)
+	c.Assert(err, IsNil)
+	return tikv.StoreProbe{KVStore: store}
+}
+
+// putAlphabet writes 'a' to 'z' as both keys and values.
+func putAlphabet(c *C, store tikv.StoreProbe) {
+	txn, err := store.Begin()
+	c.Assert(err, IsNil)
+	for ch := byte('a'); ch <= byte('z'); ch++ {
+		c.Assert(txn.Set([]byte{ch}, []byte{ch}), IsNil)
+	}
+	c.Assert(txn.Commit(context.Background()), IsNil)
+}
+
+func (s *testScanMockSuite) TestScanPreloadedRegions(c *C) {
+	store, client := newHookedTestStore(c)
+	defer store.Close()
+	err := store.PreloadRegions([]tikv.SyntheticRegion{
+		{ID: 100, EndKey: []byte("h"), LeaderStoreID: 100, LeaderAddr: "store100"},
+		{ID: 101, StartKey: []byte("h"), EndKey: []byte("p"), LeaderStoreID: 100, LeaderAddr: "store100"},
+		{ID: 102, StartKey: []byte("p"), LeaderStoreID: 101, LeaderAddr: "store101"},
+	})
+	c.Assert(err, IsNil)
+	// The scan requests are served by the hook from the alphabet, so the scanner
+	// only sees the synthetic regions.
+	var requests []string
+	client.setOnSend(func(req *tikvrpc.Request) (*tikvrpc.Response, error) {
+		if req.Type != tikvrpc.CmdScan {
+			return nil, nil
+		}
+		scan := req.Scan()
+		requests = append(requests, fmt.Sprintf("%d:%q-%q", req.Context.RegionId, scan.StartKey, scan.EndKey))
+		var pairs []*kvrpcpb.KvPair
+		for ch := byte('a'); ch <= byte('z') && len(pairs) < int(scan.Limit); ch++ {
+			key := []byte{ch}
+			if bytes.Compare(key, scan.StartKey) >= 0 && (len(scan.EndKey) == 0 || bytes.Compare(key, scan.EndKey) < 0) {
+				pairs = append(pairs, &kvrpcpb.KvPair{Key: key, Value: key})
+			}
+		}
+		return &tikvrpc.Response{Resp: &kvrpcpb.ScanResponse{Pairs: pairs}}, nil
+	})
+
+	txn, err := store.Begin()
+	c.Assert(err, IsNil)
+	scanner, err := txn.NewScanner([]byte("a"), []byte("{"), 3, false)
+	c.Assert(err, IsNil)
+	var keys []byte
+	for scanner.Valid() {
+		keys = append(keys, scanner.Key()...)
+		c.Assert(scanner.Next(), IsNil)
+	}
+	c.Assert(string(keys), Equals, "abcdefghijklmnopqrstuvwxyz")
+	// Each region is read until a short batch, then the scan moves to the next one.
+	c.Assert(requests, DeepEquals, []string{
+		`100:"a"-"h"`, `100:"c\x00"-"h"`, `100:"f\x00"-"h"`,
+		`101:"h"-"p"`, `101:"j\x00"-"p"`, `101:"m\x00"-"p"`,
+		`102:"p"-"{"`, `102:"r\x00"-"{"`, `102:"u\x00"-"{"`, `102:"x\x00"-"{"`,
+	})
+}
+
+func (s *testScanMockSuite) TestScanCurrentRegion(c *C) {
+	store := newSplitTestStore(c, []byte("h"), []byte("p"))
+	defer store.Close()
+	putAlphabet(c, store)
+
+	txn, err := store.Begin()
+	c.Assert(err, IsNil)
+	scanner, err := txn.NewScanner([]byte("a"), nil, 10, false)
+	c.Assert(err, IsNil)
+	bo := tikv.NewBackofferWithVars(context.Background(), 5000, nil)
+	regions := make(map[uint64]struct{})
+	for scanner.Valid() {
+		loc, err := store.GetRegionCache().LocateKey(bo, scanner.Key())
+		c.Assert(err, IsNil)
+		region := scanner.CurrentRegion()
+		c.Assert(region.Region, Equals, loc.Region)
+		c.Assert(region.StartKey, BytesEquals, loc.StartKey)
+		c.Assert(region.EndKey, BytesEquals, loc.EndKey)
+		pdRegion, err := store.GetPDClient().GetRegion(context.Background(), scanner.Key())
+		c.Assert(err, IsNil)
+		c.Assert(region.Epoch().GetVersion(), Equals, pdRegion.Meta.GetRegionEpoch().GetVersion())
+		c.Assert(region.Epoch().GetConfVer(), Equals, pdRegion.Meta.GetRegionEpoch().GetConfVer())
+		regions[region.Region.GetID()] = struct{}{}
+		c.Assert(scanner.Next(), IsNil)
+	}
+	c.Assert(regions, HasLen, 3)
+}
+
+func (s *testScanMockSuite) TestScanMaxRegions(c *C) {
+	store := newSplitTestStore(c, []byte("h"), []byte("p"))
+	defer store.Close()
+	putAlphabet(c, store)
+
+	txn, err := store.Begin()
+	c.Assert(err, IsNil)
+	scanner, err := txn.NewScanner([]byte("a"), nil, 10, false, tikv.WithMaxRegions(3))
+	c.Assert(err, IsNil)
+	for ch := byte('a'); ch <= byte('z'); ch++ {
+		c.Assert(scanner.Key(), BytesEquals, []byte{ch})
+		c.Assert(scanner.Next(), IsNil)
+	}
+	c.Assert(scanner.Valid(), IsFalse)
+
+	scanner, err = txn.NewScanner([]byte("a"), nil, 10, false, tikv.WithMaxRegions(2))
+	c.Assert(err, IsNil)
+	for ch := byte('a'); ch < byte('p'); ch++ {
+		c.Assert(scanner.Key(), BytesEquals, []byte{ch})
+		err = scanner.Next()
+		if ch < byte('o') {
+			c.Assert(err, IsNil)
+		}
+	}
+	e, ok := errors.Cause(err).(*kv.ErrTooManyRegions)
+	c.Assert(ok, IsTrue)
+	c.Assert(e.Count, Equals, 3)
+	c.Assert(scanner.Valid(), IsFalse)
+}
+
+func (s *testScanMockSuite) TestScanFenceToken(c *C) {
+	store := tikv.StoreProbe{KVStore: NewTestStore(c)}
+	defer store.Close()
+	putAlphabet(c, store)
+
+	var token uint64 = 1
+	txn, err := store.Begin()
+	c.Assert(err, IsNil)
+	scanner, err := txn.NewScanner([]byte("a"), []byte("{"), 5, false, tikv.WithFenceToken(func() uint64 { return token }))
+	c.Assert(err, IsNil)
+	// The current batch is still returned after the token advances.
+	token = 2
+	for ch := byte('a'); ch <= byte('e'); ch++ {
+		c.Assert(scanner.Key(), BytesEquals, []byte{ch})
+		err = scanner.Next()
+		if ch < byte('e') {
+			c.Assert(err, IsNil)
+		}
+	}
+	e, ok := errors.Cause(err).(*kv.ErrSchemaChanged)
+	c.Assert(ok, IsTrue)
+	c.Assert(e.StartToken, Equals, uint64(1))
+	c.Assert(e.CurrentToken, Equals, uint64(2))
+	c.Assert(scanner.Valid(), IsFalse)
+}
+
+func (s *testScanMockSuite) TestScanPauseResume(c *C) {
+	store := tikv.StoreProbe{KVStore: NewTestStore(c)}
+	defer store.Close()
+	putAlphabet(c, store)
+
+	txn, err := store.Begin()
+	c.Assert(err, IsNil)
+	scanner, err := txn.NewScanner([]byte("a"), []byte("{"), 3, false)
+	c.Assert(err, IsNil)
+	scanner.Pause()
+	// The buffered pairs can still be read.
+	c.Assert(scanner.Next(), IsNil)
+	c.Assert(scanner.Next(), IsNil)
+	c.Assert(scanner.Key(), BytesEquals, []byte("c"))
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		scanner.Resume()
+	}()
+	start := time.Now()
+	c.Assert(scanner.Next(), IsNil)
+	c.Assert(time.Since(start), GreaterEqual, 50*time.Millisecond)
+	c.Assert(scanner.Key(), BytesEquals, []byte("d"))
+
+	// The context can be canceled while the scanner is paused.
+	ctx, cancel := context.WithCancel(context.Background())
+	scanner, err = txn.NewScanner([]byte("a"), []byte("{"), 3, false, tikv.WithContext(ctx))
+	c.Assert(err, IsNil)
+	scanner.Pause()
+	c.Assert(scanner.Next(), IsNil)
+	c.Assert(scanner.Next(), IsNil)
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		cancel()
+	}()
+	err = scanner.Next()
+	c.Assert(errors.Cause(err), Equals, context.Canceled)
+	c.Assert(scanner.Valid(), IsFalse)
+}
+
+func (s *testScanMockSuite) TestScanRemainingRegions(c *C) {
+	store := newSplitTestStore(c, []byte("h"), []byte("p"))
+	defer store.Close()
+	putAlphabet(c, store)
+
+	bo := tikv.NewBackofferWithVars(context.Background(), 5000, nil)
+	for _, key := range []string{"h", "p"} {
+		loc, err := store.GetRegionCache().LocateKey(bo, []byte(key))
+		c.Assert(err, IsNil)
+		store.GetRegionCache().InvalidateCachedRegion(loc.Region)
+	}
+	txn, err := store.Begin()
+	c.Assert(err, IsNil)
+	scanner, err := txn.NewScanner([]byte("a"), []byte("{"), 3, false)
+	c.Assert(err, IsNil)
+	// Only the first region is cached, the rest of the range is counted as one region.
+	n, err := scanner.RemainingRegions()
+	c.Assert(errors.Cause(err), Equals, kv.ErrRegionCountEstimated)
+	c.Assert(n, Equals, 2)
+
+	_, err = store.GetRegionCache().LoadRegionsInKeyRange(bo, []byte("a"), []byte("{"))
+	c.Assert(err, IsNil)
+	n, err = scanner.RemainingRegions()
+	c.Assert(err, IsNil)
+	c.Assert(n, Equals, 3)
+	for scanner.Key()[0] < 'i' {
+		c.Assert(scanner.Next(), IsNil)
+	}
+	n, err = scanner.RemainingRegions()
+	c.Assert(err, IsNil)
+	c.Assert(n, Equals, 2)
+	for scanner.Valid() {
+		c.Assert(scanner.Next(), IsNil)
+	}
+	n, err = scanner.RemainingRegions()
+	c.Assert(err, IsNil)
+	c.Assert(n, Equals, 0)
+}
+
+func (s *testScanMockSuite) TestTxnIterWithOptions(c *C) {
+	store := newSplitTestStore(c, []byte("c"))
+	defer store.Close()
+	putAlphabet(c, store)
+
+	txn, err := store.Begin()
+	c.Assert(err, IsNil)
+	c.Assert(txn.Set([]byte("b"), []byte("bb")), IsNil)
+	c.Assert(txn.Delete([]byte("c")), IsNil)
+	c.Assert(txn.Set([]byte("c1"), []byte("c1")), IsNil)
+	// Deleting a key which isn't committed doesn't hide anything.
+	c.Assert(txn.Delete([]byte("d1")), IsNil)
+	// The filter only applies to the snapshot.
+	notE := func(key []byte) bool { return key[0] != 'e' }
+	it, err := txn.IterWithOptions([]byte("a"), []byte("g"), 2, tikv.WithKeyFilter(notE))
+	c.Assert(err, IsNil)
+	defer it.Close()
+	var pairs []string
+	for it.Valid() {
+		pairs = append(pairs, string(it.Key())+"="+string(it.Value()))
+		c.Assert(it.Next(), IsNil)
+	}
+	c.Assert(pairs, DeepEquals, []string{"a=a", "b=bb", "c1=c1", "d=d", "f=f"})
+}
+
+func (s *testScanMockSuite) TestScanKeyFilter(c *C) {
+	store := tikv.StoreProbe{KVStore: NewTestStore(c)}
+	defer store.Close()
+	putAlphabet(c, store)
+
+	vowels := func(key []byte) bool { return bytes.IndexByte([]byte("aeiou"), key[0]) >= 0 }
+	txn, err := store.Begin()
+	c.Assert(err, IsNil)
+	for _, reverse := range []bool{false, true} {
+		scanner, err := txn.NewScanner([]byte("a"), []byte("{"), 4, reverse, tikv.WithKeyFilter(vowels))
+		c.Assert(err, IsNil)
+		var keys []byte
+		for scanner.Valid() {
+			keys = append(keys, scanner.Key()...)
+			c.Assert(scanner.Next(), IsNil)
+		}
+		if reverse {
+			c.Assert(string(keys), Equals, "uoiea")
+		} else {
+			c.Assert(string(keys), Equals, "aeiou")
+		}
+		c.Assert(scanner.Stats().SkippedByFilter, Equals, 21)
+	}
+}
+
+// countingLimiter is a BandwidthLimiter which records the bytes drawn from it.
+type countingLimiter struct {
+	mu    sync.Mutex
+	burst int
+	bytes int
+	calls int
+}
+
+func (l *countingLimiter) WaitN(ctx context.Context, n int) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if n > l.burst {
+		return errors.Errorf("n %d exceeds burst %d", n, l.burst)
+	}
+	l.bytes += n
+	l.calls++
+	return ctx.Err()
+}
+
+func (l *countingLimiter) Burst() int {
+	return l.burst
+}
+
+func (s *testScanMockSuite) TestScanBandwidthLimiter(c *C) {
+	store := tikv.StoreProbe{KVStore: NewTestStore(c)}
+	defer store.Close()
+	putAlphabet(c, store)
+
+	// The scanners share the limiter, which is drawn in pieces of at most its burst.
+	limiter := &countingLimiter{burst: 3}
+	txn, err := store.Begin()
+	c.Assert(err, IsNil)
+	totalBytes := 0
+	for i := 0; i < 2; i++ {
+		scanner, err := txn.NewScanner([]byte("a"), []byte("{"), 5, false, tikv.WithBandwidthLimiter(limiter))
+		c.Assert(err, IsNil)
+		for scanner.Valid() {
+			c.Assert(scanner.Next(), IsNil)
+		}
+		totalBytes += scanner.Stats().Bytes
+	}
+	c.Assert(totalBytes, Greater, 0)
+	c.Assert(limiter.bytes, Equals, totalBytes)
+	c.Assert(limiter.calls >= totalBytes/3, IsTrue)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = txn.NewScanner([]byte("a"), []byte("{"), 5, false, tikv.WithBandwidthLimiter(limiter), tikv.WithContext(ctx))
+	c.Assert(errors.Cause(err), Equals, context.Canceled)
+}
+
+func (s *testScanMockSuite) TestRangeScanner(c *C) {
+	store := tikv.StoreProbe{KVStore: NewTestStore(c)}
+	defer store.Close()
+	putAlphabet(c, store)
+
+	txn, err := store.Begin()
+	c.Assert(err, IsNil)
+	snapshot := txn.GetSnapshot()
+	for _, t := range []struct {
+		r    kv.KeyRange
+		keys string
+	}{
+		{kv.KeyRange{StartKey: []byte("f"), EndKey: []byte("j")}, "fghi"},
+		// The range without EndKey is unbounded.
+		{kv.KeyRange{StartKey: []byte("w")}, "wxyz"},
+	} {
+		scanner, err := snapshot.NewRangeScanner(t.r, 2, tikv.WithKeyOnly())
+		c.Assert(err, IsNil)
+		var keys []byte
+		for scanner.Valid() {
+			keys = append(keys, scanner.Key()...)
+			c.Assert(scanner.Next(), IsNil)
+		}
+		c.Assert(string(keys), Equals, t.keys)
+	}
+}
+
+func (s *testScanMockSuite) TestScanSingleRegion(c *C) {
+	store := newSplitTestStore(c, []byte("h"), []byte("p"))
+	defer store.Close()
+	putAlphabet(c, store)
+
+	txn, err := store.Begin()
+	c.Assert(err, IsNil)
+	scanner, err := txn.NewScanner([]byte("h"), []byte("p"), 3, false, tikv.WithSingleRegion())
+	c.Assert(err, IsNil)
+	for ch := byte('h'); ch < byte('p'); ch++ {
+		c.Assert(scanner.Key(), BytesEquals, []byte{ch})
+		c.Assert(scanner.Next(), IsNil)
+	}
+	c.Assert(scanner.Valid(), IsFalse)
+
+	scanner, err = txn.NewScanner([]byte("a"), nil, 3, false, tikv.WithSingleRegion())
+	c.Assert(err, IsNil)
+	for ch := byte('a'); ch < byte('h'); ch++ {
+		c.Assert(scanner.Key(), BytesEquals, []byte{ch})
+		err = scanner.Next()
+		if ch < byte('g') {
+			c.Assert(err, IsNil)
+		}
+	}
+	e, ok := errors.Cause(err).(*kv.ErrRangeSpansRegions)
+	c.Assert(ok, IsTrue)
+	c.Assert(e.Key, BytesEquals, []byte("h"))
+	c.Assert(scanner.Valid(), IsFalse)
+}
+
+func (s *testScanMockSuite) TestScanMaxTotalBytes(c *C) {
+	store := newSplitTestStore(c, []byte("h"))
+	defer store.Close()
+	putAlphabet(c, store)
+
+	txn, err := store.Begin()
+	c.Assert(err, IsNil)
+	// Each pair takes 2 bytes, so the whole alphabet is 52 bytes.
+	scanner, err := txn.NewScanner([]byte("a"), nil, 10, false, tikv.WithMaxTotalBytes(52))
+	c.Assert(err, IsNil)
+	for ch := byte('a'); ch <= byte('z'); ch++ {
+		c.Assert(scanner.Key(), BytesEquals, []byte{ch})
+		c.Assert(scanner.Next(), IsNil)
+	}
+	c.Assert(scanner.Valid(), IsFalse)
+
+	// The first region [a, h) is 14 bytes, reading the second one exceeds the limit.
+	scanner, err = txn.NewScanner([]byte("a"), nil, 10, false, tikv.WithMaxTotalBytes(20))
+	c.Assert(err, IsNil)
+	for ch := byte('a'); ch < byte('h'); ch++ {
+		c.Assert(scanner.Key(), BytesEquals, []byte{ch})
+		err = scanner.Next()
+	}
+	c.Assert(errors.Cause(err), Equals, kv.ErrScanTooBig)
+	c.Assert(scanner.Valid(), IsFalse)
+}
+
+func (s *testScanMockSuite) TestScannerReset(c *C) {
+	store := newSplitTestStore(c, []byte("h"))
+	defer store.Close()
+	putAlphabet(c, store)
+
+	txn, err := store.Begin()
+	c.Assert(err, IsNil)
+	scanner, err := txn.NewScanner([]byte("a"), []byte("c"), 10, false, tikv.WithMaxRegions(1))
+	c.Assert(err, IsNil)
+	c.Assert(scanner.Key(), BytesEquals, []byte("a"))
+	c.Assert(scanner.Next(), IsNil)
+	c.Assert(scanner.Next(), IsNil)
+	c.Assert(scanner.Valid(), IsFalse)
+
+	// The scanner can be reused on another snapshot and the previous end key and options are cleared.
+	txn2, err := store.Begin()
+	c.Assert(err, IsNil)
+	err = scanner.Reset(txn2.GetSnapshot(), []byte("g"), 10)
+	c.Assert(err, IsNil)
+	for ch := byte('g'); ch <= byte('z'); ch++ {
+		c.Assert(scanner.Key(), BytesEquals, []byte{ch})
+		c.Assert(scanner.Next(), IsNil)
+	}
+	c.Assert(scanner.Valid(), IsFalse)
+}
+
+func (s *testScanMockSuite) TestScanNextChunk(c *C) {
+	store := tikv.StoreProbe{KVStore: NewTestStore(c)}
+	defer store.Close()
+	putAlphabet(c, store)
+
+	txn, err := store.Begin()
+	c.Assert(err, IsNil)
+	scanner, err := txn.NewScanner([]byte("a"), nil, 4, false)
+	c.Assert(err, IsNil)
+	var keys []byte
+	for {
+		chunk, err := scanner.NextChunk(10)
+		c.Assert(err, IsNil)
+		if len(chunk) == 0 {
+			break
+		}
+		if len(keys) < 20 {
+			c.Assert(chunk, HasLen, 10)
+		} else {
+			c.Assert(chunk, HasLen, 6)
+		}
+		for _, pair := range chunk {
+			c.Assert(pair.Value, BytesEquals, pair.Key)
+			keys = append(keys, pair.Key...)
+		}
+	}
+	c.Assert(string(keys), Equals, "abcdefghijklmnopqrstuvwxyz")
+	c.Assert(scanner.Valid(), IsFalse)
+
+	_, err = scanner.NextChunk(0)
+	c.Assert(err, NotNil)
+}
+
+func (s *testScanMockSuite) TestScanNextEncodedChunk(c *C) {
+	store := tikv.StoreProbe{KVStore: NewTestStore(c)}
+	defer store.Close()
+	putAlphabet(c, store)
+
+	txn, err := store.Begin()
+	c.Assert(err, IsNil)
+	scanner, err := txn.NewScanner([]byte("c"), []byte("x"), 4, false)
+	c.Assert(err, IsNil)
+	var keys []byte
+	for scanner.Valid() {
+		data, err := scanner.NextEncodedChunk(10)
+		c.Assert(err, IsNil)
+		resp := &kvrpcpb.ScanResponse{}
+		c.Assert(resp.Unmarshal(data), IsNil)
+		c.Assert(len(resp.Pairs), LessEqual, 10)
+		for _, pair := range resp.Pairs {
+			c.Assert(pair.Value, BytesEquals, pair.Key)
+			keys = append(keys, pair.Key...)
+		}
+	}
+	c.Assert(string(keys), Equals, "cdefghijklmnopqrstuvw")
+}
+
+func (s *testScanMockSuite) TestScanKeyspace(c *C) {
+	store := newSplitTestStore(c, []byte("ks1m"), []byte("ks2"), []byte("ks\xff"))
+	defer store.Close()
+	txn, err := store.Begin()
+	c.Assert(err, IsNil)
+	keys := []string{"ks0z", "ks1", "ks1a", "ks1z", "ks1\xff\xff", "ks2", "ks2a", "ks\xff", "ks\xffa", "kt"}
+	for _, k := range keys {
+		c.Assert(txn.Set([]byte(k), []byte(k)), IsNil)
+	}
+	c.Assert(txn.Commit(context.Background()), IsNil)
+
+	scanKeys := func(start, end []byte, reverse bool, keyspace string) []string {
+		txn, err := store.Begin()
+		c.Assert(err, IsNil)
+		if reverse {
+			start, end = end, start
+		}
+		scanner, err := txn.NewScanner(start, end, 2, reverse, tikv.WithKeyspace([]byte(keyspace)))
+		c.Assert(err, IsNil)
+		var keys []string
+		for scanner.Valid() {
+			keys = append(keys, string(scanner.Key()))
+			c.Assert(scanner.Next(), IsNil)
+		}
+		return keys
+	}
+	ks1 := []string{"ks1", "ks1a", "ks1z", "ks1\xff\xff"}
+	ks1Reverse := []string{"ks1\xff\xff", "ks1z", "ks1a", "ks1"}
+	// Unbounded scans stop at the boundaries of the keyspace.
+	c.Assert(scanKeys(nil, nil, false, "ks1"), DeepEquals, ks1)
+	c.Assert(scanKeys(nil, nil, true, "ks1"), DeepEquals, ks1Reverse)
+	// Bounds inside the keyspace are kept.
+	c.Assert(scanKeys([]byte("ks1a"), []byte("ks1z"), false, "ks1"), DeepEquals, []string{"ks1a"})
+	// Ranges outside the keyspace are empty.
+	c.Assert(scanKeys([]byte("ks2"), nil, false, "ks1"), HasLen, 0)
+	c.Assert(scanKeys(nil, []byte("ks1"), false, "ks1"), HasLen, 0)
+	// The upper bound of a keyspace ending with 0xff doesn't overflow into the next prefix.
+	c.Assert(scanKeys(nil, nil, false, "ks\xff"), DeepEquals, []string{"ks\xff", "ks\xffa"})
+	c.Assert(scanKeys(nil, nil, true, "ks\xff"), DeepEquals, []string{"ks\xffa", "ks\xff"})
+}
+
+func (s *testScanMockSuite) TestScanStrippedKeyspace(c *C) {
+	store := newSplitTestStore(c, []byte("ks/c"))
+	defer store.Close()
+	txn, err := store.Begin()
+	c.Assert(err, IsNil)
+	for _, k := range []string{"ks/a", "ks/b", "ks/d", "kt"} {
+		c.Assert(txn.Set([]byte(k), []byte(k)), IsNil)
+	}
+	c.Assert(txn.Commit(context.Background()), IsNil)
+
+	// Leave the secondary locks of ks/c and ks/e behind a committed primary, so that
+	// the scan meets the locks and resolves them.
+	txn, err = store.Begin()
+	c.Assert(err, IsNil)
+	for _, k := range []string{"ks/b", "ks/c", "ks/e"} {
+		c.Assert(txn.Set([]byte(k), []byte(k+"2")), IsNil)
+	}
+	committer, err := txn.NewCommitter(0)
+	c.Assert(err, IsNil)
+	committer.SetPrimaryKey([]byte("ks/b"))
+	c.Assert(committer.PrewriteAllMutations(context.Background()), IsNil)
+	commitTxn, err := store.Begin()
+	c.Assert(err, IsNil)
+	committer.SetCommitTS(commitTxn.StartTS())
+	c.Assert(committer.CommitMutations(context.Background()), IsNil)
+	txn, err = store.Begin()
+	c.Assert(err, IsNil)
+	it, err := txn.GetSnapshot().NewScanResponseIterator([]byte("ks/c"), nil, 1, false)
+	c.Assert(err, IsNil)
+	resp, err := it.Next()
+	c.Assert(err, IsNil)
+	c.Assert(resp.Pairs, HasLen, 1)
+	c.Assert(resp.Pairs[0].Error, NotNil)
+
+	for _, reverse := range []bool{false, true} {
+		txn, err = store.Begin()
+		c.Assert(err, IsNil)
+		scanner, err := txn.NewScanner(nil, nil, 2, reverse, tikv.WithKeyspace([]byte("ks/")), tikv.WithStrippedKeyspace())
+		c.Assert(err, IsNil)
+		var keys, values []string
+		for scanner.Valid() {
+			keys = append(keys, string(scanner.Key()))
+			values = append(values, string(scanner.Value()))
+			c.Assert(scanner.Next(), IsNil)
+		}
+		if reverse {
+			c.Assert(keys, DeepEquals, []string{"e", "d", "c", "b", "a"})
+			c.Assert(values, DeepEquals, []string{"ks/e2", "ks/d", "ks/c2", "ks/b2", "ks/a"})
+		} else {
+			c.Assert(keys, DeepEquals, []string{"a", "b", "c", "d", "e"})
+			c.Assert(values, DeepEquals, []string{"ks/a", "ks/b2", "ks/c2", "ks/d", "ks/e2"})
+		}
+	}
+
+	// Chunks are stripped too, while the cursor keeps the full key to resume from.
+	txn, err = store.Begin()
+	c.Assert(err, IsNil)
+	scanner, err := txn.NewScanner(nil, nil, 2, false, tikv.WithKeyspace([]byte("ks/")), tikv.WithStrippedKeyspace())
+	c.Assert(err, IsNil)
+	chunk, err := scanner.NextChunk(3)
+	c.Assert(err, IsNil)
+	c.Assert(chunk, HasLen, 3)
+	for i, key := range []string{"a", "b", "c"} {
+		c.Assert(string(chunk[i].Key), Equals, key)
+	}
+	cursor, err := scanner.Cursor()
+	c.Assert(err, IsNil)
+	c.Assert(string(cursor.NextStartKey), Equals, "ks/d")
+	c.Assert(string(scanner.Key()), Equals, "d")
+}
+
+type testMemTracker struct {
+	quota    int64
+	consumed int64
+	peak     int64
+}
+
+func (t *testMemTracker) Consume(bytes int64) bool {
+	t.consumed += bytes
+	if t.consumed > t.peak {
+		t.peak = t.consumed
+	}
+	return t.quota <= 0 || t.consumed <= t.quota
+}
+
+func (t *testMemTracker) Release(bytes int64) {
+	t.consumed -= bytes
+}
+
+func (s *testScanMockSuite) TestScanMemoryTracker(c *C) {
+	store := newSplitTestStore(c, []byte("h"), []byte("p"))
+	defer store.Close()
+	putAlphabet(c, store)
+
+	txn, err := store.Begin()
+	c.Assert(err, IsNil)
+	// Only one batch is held at a time, the largest one has 10 pairs of [p, +inf).
+	tracker := &testMemTracker{}
+	scanner, err := txn.NewScanner([]byte("a"), nil, 10, false, tikv.WithMemoryTracker(tracker))
+	c.Assert(err, IsNil)
+	for scanner.Valid() {
+		c.Assert(tracker.consumed, Greater, int64(0))
+		c.Assert(scanner.Next(), IsNil)
+	}
+	c.Assert(tracker.peak, Equals, int64(20))
+	c.Assert(tracker.consumed, Equals, int64(0))
+
+	// The scan stops once the quota is exceeded, and the memory is released.
+	tracker = &testMemTracker{quota: 15}
+	scanner, err = txn.NewScanner([]byte("a"), nil, 10, false, tikv.WithMemoryTracker(tracker))
+	c.Assert(err, IsNil)
+	for ch := byte('a'); ch < byte('h'); ch++ {
+		c.Assert(scanner.Key(), BytesEquals, []byte{ch})
+		err = scanner.Next()
+	}
+	c.Assert(errors.Cause(err), Equals, kv.ErrScanMemoryQuotaExceeded)
+	c.Assert(scanner.Valid(), IsFalse)
+	c.Assert(tracker.consumed, Equals, int64(0))
+}
+
+func (s *testScanMockSuite) TestLatestScanner(c *C) {
+	store := tikv.StoreProbe{KVStore: NewTestStore(c)}
+	defer store.Close()
+	putAlphabet(c, store)
+
+	scanValues := func(scanner *tikv.Scanner) string {
+		var values []byte
+		for scanner.Valid() {
+			values = append(values, scanner.Value()...)
+			c.Assert(scanner.Next(), IsNil)
+		}
+		return string(values)
+	}
+	scanner, err := store.NewLatestScanner([]byte("a"), []byte("{"), 10)
+	c.Assert(err, IsNil)
+	c.Assert(scanValues(scanner), Equals, "abcdefghijklmnopqrstuvwxyz")
+
+	txn, err := store.Begin()
+	c.Assert(err, IsNil)
+	txn1, err := store.Begin()
+	c.Assert(err, IsNil)
+	c.Assert(txn1.Set([]byte("c"), []byte("C")), IsNil)
+	c.Assert(txn1.Delete([]byte("x")), IsNil)
+	c.Assert(txn1.Commit(context.Background()), IsNil)
+
+	// The latest scanner sees the new commit while the snapshot doesn't.
+	scanner, err = store.NewLatestScanner([]byte("a"), []byte("{"), 10)
+	c.Assert(err, IsNil)
+	c.Assert(scanValues(scanner), Equals, "abCdefghijklmnopqrstuvwyz")
+	scanner, err = txn.NewScanner([]byte("a"), []byte("{"), 10, false)
+	c.Assert(err, IsNil)
+	c.Assert(scanValues(scanner), Equals, "abcdefghijklmnopqrstuvwxyz")
+}
+
+func (s *testScanMockSuite) TestScanVisibilityCheckRetries(c *C) {
+	store := tikv.StoreProbe{KVStore: NewTestStore(c)}
+	defer store.Close()
+	defer kv.ScanVisibilityCheckRetries.Store(0)
+
+	newScanner := func() error {
+		txn, err := store.Begin()
+		c.Assert(err, IsNil)
+		_, err = txn.NewScanner([]byte("a"), nil, 10, false)
+		return err
+	}
+
+	// A stale cached safe point fails the scan without retries.
+	store.UpdateSPCache(0, time.Now().Add(-time.Hour))
+	err := newScanner()
+	c.Assert(kv.ErrPDServerTimeout.Equal(errors.Cause(err)), IsTrue)
+
+	// The safe point is reloaded before retrying.
+	kv.ScanVisibilityCheckRetries.Store(1)
+	store.UpdateSPCache(0, time.Now().Add(-time.Hour))
+	c.Assert(newScanner(), IsNil)
+
+	// GC has really passed the snapshot.
+	txn, err := store.Begin()
+	c.Assert(err, IsNil)
+	c.Assert(store.SaveSafePoint(txn.StartTS()+1), IsNil)
+	store.UpdateSPCache(0, time.Now().Add(-time.Hour))
+	_, err = txn.NewScanner([]byte("a"), nil, 10, false)
+	c.Assert(kv.ErrGCTooEarly.Equal(errors.Cause(err)), IsTrue)
+}
+
+func (s *testScanMockSuite) TestScanFutureTimestampCheck(c *C) {
+	store := tikv.StoreProbe{KVStore: NewTestStore(c)}
+	defer store.Close()
+	putAlphabet(c, store)
+
+	r := kv.KeyRange{StartKey: []byte("a"), EndKey: []byte("c")}
+	txn, err := store.Begin()
+	c.Assert(err, IsNil)
+	scanner, err := txn.GetSnapshot().NewRangeScanner(r, 10, tikv.WithFutureTimestampCheck())
+	c.Assert(err, IsNil)
+	c.Assert(string(scanner.Key()), Equals, "a")
+	scanner.Close()
+
+	future := txn.StartTS() + uint64(time.Hour.Milliseconds())<<18
+	snapshot := store.GetSnapshot(future)
+	_, err = snapshot.NewRangeScanner(r, 10, tikv.WithFutureTimestampCheck())
+	futureErr, ok := errors.Cause(err).(*kv.ErrFutureTimestamp)
+	c.Assert(ok, IsTrue)
+	c.Assert(futureErr.Version, Equals, future)
+	c.Assert(futureErr.Current, Less, future)
+
+	// The check is opt-in.
+	scanner, err = snapshot.NewRangeScanner(r, 10)
+	c.Assert(err, IsNil)
+	scanner.Close()
+}
+
+func (s *testScanMockSuite) TestScanEmptyRangeError(c *C) {
+	store := tikv.StoreProbe{KVStore: NewTestStore(c)}
+	defer store.Close()
+	putAlphabet(c, store)
+
+	txn, err := store.Begin()
+	c.Assert(err, IsNil)
+	snapshot := txn.GetSnapshot()
+	empty := kv.KeyRange{StartKey: []byte("0"), EndKey: []byte("9")}
+	scanner, err := snapshot.NewRangeScanner(empty, 10)
+	c.Assert(err, IsNil)
+	c.Assert(scanner.Valid(), IsFalse)
+
+	_, err = snapshot.NewRangeScanner(empty, 10, tikv.WithEmptyRangeError())
+	c.Assert(errors.Cause(err), Equals, kv.ErrEmptyRange)
+
+	scanner, err = snapshot.NewRangeScanner(kv.KeyRange{StartKey: []byte("g"), EndKey: []byte("i")}, 10, tikv.WithEmptyRangeError())
+	c.Assert(err, IsNil)
+	c.Assert(string(scanner.Key()), Equals, "g")
+	scanner.Close()
+}
+
+func (s *testScanMockSuite) TestScanLazyStart(c *C) {
+	store, client := newHookedTestStore(c)
+	defer store.Close()
+	putAlphabet(c, store)
+
+	var scans int
+	client.setOnSend(func(req *tikvrpc.Request) (*tikvrpc.Response, error) {
+		if req.Type == tikvrpc.CmdScan {
+			scans++
+		}
+		return nil, nil
+	})
+	txn, err := store.Begin()
+	c.Assert(err, IsNil)
+	scanner, err := txn.NewScanner([]byte("f"), []byte("k"), 2, false, tikv.WithLazyStart())
+	c.Assert(err, IsNil)
+	c.Assert(scans, Equals, 0)
+	c.Assert(scanner.Valid(), IsFalse)
+	c.Assert(scanner.Key(), IsNil)
+	cursor, err := scanner.Cursor()
+	c.Assert(err, IsNil)
+	c.Assert(cursor.EOF, IsFalse)
+	c.Assert(cursor.NextStartKey, BytesEquals, []byte("f"))
+
+	// The first Next reads the first pair.
+	var keys []byte
+	for {
+		c.Assert(scanner.Next(), IsNil)
+		if !scanner.Valid() {
+			break
+		}
+		keys = append(keys, scanner.Key()...)
+	}
+	c.Assert(string(keys), Equals, "fghij")
+	c.Assert(scans > 0, IsTrue)
+	c.Assert(scanner.Next(), NotNil)
+
+	scanner, err = txn.NewScanner([]byte("f"), []byte("k"), 2, false, tikv.WithLazyStart())
+	c.Assert(err, IsNil)
+	chunk, err := scanner.NextChunk(3)
+	c.Assert(err, IsNil)
+	c.Assert(chunk, HasLen, 3)
+	c.Assert(chunk[0].Key, BytesEquals, []byte("f"))
+
+	// Errors on creation are returned by the first read.
+	scans = 0
+	scanner, err = txn.NewScanner([]byte("0"), []byte("9"), 2, false, tikv.WithLazyStart(), tikv.WithEmptyRangeError())
+	c.Assert(err, IsNil)
+	c.Assert(scans, Equals, 0)
+	c.Assert(errors.Cause(scanner.Next()), Equals, kv.ErrEmptyRange)
+	c.Assert(scanner.Valid(), IsFalse)
+}
+
+func (s *testScanMockSuite) TestScanStopCondition(c *C) {
+	store, client := newHookedTestStore(c)
+	defer store.Close()
+	putAlphabet(c, store)
+
+	var scans int
+	client.setOnSend(func(req *tikvrpc.Request) (*tikvrpc.Response, error) {
+		if req.Type == tikvrpc.CmdScan {
+			scans++
+		}
+		return nil, nil
+	})
+	txn, err := store.Begin()
+	c.Assert(err, IsNil)
+	// Stop once the total size of the values reaches 5.
+	var total int
+	shouldStop := func(key, value []byte) bool {
+		total += len(value)
+		return total >= 5
+	}
+	scanner, err := txn.NewScanner([]byte("a"), nil, 3, false, tikv.WithStopCondition(shouldStop))
+	c.Assert(err, IsNil)
+	var keys []byte
+	for scanner.Valid() {
+		keys = append(keys, scanner.Key()...)
+		c.Assert(scanner.Next(), IsNil)
+	}
+	c.Assert(string(keys), Equals, "abcde")
+	c.Assert(total, Equals, 5)
+	// Batch [a, c] and [d, f] are read, and no more batches are fetched.
+	c.Assert(scans, Equals, 2)
+	c.Assert(scanner.Next(), NotNil)
+}
+
+func (s *testScanMockSuite) TestIterWithReadSet(c *C) {
+	store := tikv.StoreProbe{KVStore: NewTestStore(c)}
+	defer store.Close()
+	putAlphabet(c, store)
+
+	scanRange := func(txn tikv.TxnProbe) {
+		iter, err := txn.IterWithReadSet(context.Background(), []byte("c"), []byte("s"))
+		c.Assert(err, IsNil)
+		for ch := byte('c'); ch < byte('s'); ch++ {
+			c.Assert(iter.Key(), BytesEquals, []byte{ch})
+			c.Assert(iter.Next(), IsNil)
+		}
+		c.Assert(iter.Valid(), IsFalse)
+		c.Assert(txn.Set([]byte("z"), []byte("x")), IsNil)
+	}
+	write := func(key string) {
+		txn, err := store.Begin()
+		c.Assert(err, IsNil)
+		c.Assert(txn.Set([]byte(key), []byte("y")), IsNil)
+		c.Assert(txn.Commit(context.Background()), IsNil)
+	}
+
+	// A write to a scanned key conflicts with the transaction.
+	txn, err := store.Begin()
+	c.Assert(err, IsNil)
+	scanRange(txn)
+	write("k")
+	err = txn.Commit(context.Background())
+	c.Assert(kv.IsErrWriteConflict(err), IsTrue)
+
+	// Writes out of the range don't.
+	txn, err = store.Begin()
+	c.Assert(err, IsNil)
+	scanRange(txn)
+	write("t")
+	c.Assert(txn.Commit(context.Background()), IsNil)
+
+	txn, err = store.Begin()
+	c.Assert(err, IsNil)
+	txn.SetOption(kv.Pessimistic, true)
+	_, err = txn.IterWithReadSet(context.Background(), []byte("c"), []byte("s"))
+	c.Assert(err, NotNil)
+	c.Assert(txn.Rollback(), IsNil)
+}
@@ -0,0 +1,134 @@
+// Copyright 2021 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tikv_test
+
+import (
+	"context"
+	"sync"
+
+	. "github.com/pingcap/check"
+	"github.com/pingcap/errors"
+	"github.com/pingcap/tidb/store/tikv"
+	"github.com/pingcap/tidb/store/tikv/kv"
+	"github.com/pingcap/tidb/store/tikv/tikvrpc"
+)
+
+type testScanMultiSuite struct {
+	OneByOneSuite
+}
+
+var _ = Suite(&testScanMultiSuite{})
+
+func (s *testScanMultiSuite) TestScanKeyPresence(c *C) {
+	store := tikv.StoreProbe{KVStore: NewTestStore(c)}
+	defer store.Close()
+	putAlphabet(c, store)
+
+	txn, err := store.Begin()
+	c.Assert(err, IsNil)
+	expected := [][]byte{[]byte("x"), []byte("a"), []byte("bb"), []byte("m"), []byte("x"), []byte("zz"), []byte("j")}
+	presence, err := txn.GetSnapshot().ScanKeyPresence([]byte("b"), []byte("y"), expected)
+	c.Assert(err, IsNil)
+	// "a" and "zz" are out of the range.
+	c.Assert(presence.Present, DeepEquals, [][]byte{[]byte("j"), []byte("m"), []byte("x")})
+	c.Assert(presence.Absent, DeepEquals, [][]byte{[]byte("a"), []byte("bb"), []byte("zz")})
+
+	presence, err = txn.GetSnapshot().ScanKeyPresence([]byte("a"), nil, nil)
+	c.Assert(err, IsNil)
+	c.Assert(presence.Present, HasLen, 0)
+	c.Assert(presence.Absent, HasLen, 0)
+}
+
+func (s *testScanMultiSuite) TestMultiRangeScanner(c *C) {
+	store := tikv.StoreProbe{KVStore: NewTestStore(c)}
+	defer store.Close()
+	putAlphabet(c, store)
+
+	txn, err := store.Begin()
+	c.Assert(err, IsNil)
+	// Changes committed after the snapshot is taken are invisible to all ranges.
+	txn1, err := store.Begin()
+	c.Assert(err, IsNil)
+	c.Assert(txn1.Set([]byte("c"), []byte("x")), IsNil)
+	c.Assert(txn1.Delete([]byte("r")), IsNil)
+	c.Assert(txn1.Commit(context.Background()), IsNil)
+
+	ranges := []kv.KeyRange{
+		{StartKey: []byte("b"), EndKey: []byte("e")},
+		{StartKey: []byte("q"), EndKey: []byte("t")},
+	}
+	scanner, err := txn.GetSnapshot().NewMultiRangeScanner(context.Background(), ranges, 2)
+	c.Assert(err, IsNil)
+	c.Assert(scanner.Len(), Equals, 2)
+	expected := []string{"bcd", "qrs"}
+	for i := range ranges {
+		var keys []byte
+		for scanner.Valid(i) {
+			c.Assert(scanner.Value(i), BytesEquals, scanner.Key(i))
+			keys = append(keys, scanner.Key(i)...)
+			c.Assert(scanner.Next(i), IsNil)
+		}
+		c.Assert(string(keys), Equals, expected[i])
+	}
+
+	// Canceling the context closes all ranges.
+	ctx, cancel := context.WithCancel(context.Background())
+	scanner, err = txn.GetSnapshot().NewMultiRangeScanner(ctx, ranges, 2)
+	c.Assert(err, IsNil)
+	c.Assert(scanner.Next(0), IsNil)
+	cancel()
+	err = scanner.Next(1)
+	c.Assert(errors.Cause(err), Equals, context.Canceled)
+	c.Assert(scanner.Valid(0), IsFalse)
+	c.Assert(scanner.Valid(1), IsFalse)
+}
+
+func (s *testScanMultiSuite) TestAnyKeyInRanges(c *C) {
+	store, client := newHookedTestStore(c, []byte("h"), []byte("p"))
+	defer store.Close()
+	putAlphabet(c, store)
+
+	txn, err := store.Begin()
+	c.Assert(err, IsNil)
+	c.Assert(txn.Delete([]byte("k")), IsNil)
+	c.Assert(txn.Delete([]byte("l")), IsNil)
+	c.Assert(txn.Commit(context.Background()), IsNil)
+
+	var limits sync.Map
+	client.setOnSend(func(req *tikvrpc.Request) (*tikvrpc.Response, error) {
+		if req.Type == tikvrpc.CmdScan {
+			limits.Store(req.Scan().Limit, true)
+		}
+		return nil, nil
+	})
+	defer client.setOnSend(nil)
+
+	txn, err = store.Begin()
+	c.Assert(err, IsNil)
+	found, err := txn.GetSnapshot().AnyKeyInRanges([]kv.KeyRange{
+		{StartKey: []byte("a"), EndKey: []byte("b")},
+		{StartKey: []byte("b\x00"), EndKey: []byte("c")},
+		{StartKey: []byte("k"), EndKey: []byte("m")},
+		{StartKey: []byte("k"), EndKey: []byte("n")},
+		// The range crosses the boundary of regions.
+		{StartKey: []byte("g\x00"), EndKey: []byte("i")},
+		{StartKey: []byte("z\x00"), EndKey: []byte("{")},
+	})
+	c.Assert(err, IsNil)
+	c.Assert(found, DeepEquals, []bool{true, false, false, true, true, false})
+	limits.Range(func(limit, _ interface{}) bool {
+		c.Assert(limit, Equals, uint32(1))
+		return true
+	})
+}
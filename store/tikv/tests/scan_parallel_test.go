@@ -0,0 +1,86 @@
+// Copyright 2021 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tikv_test
+
+import (
+	"context"
+
+	. "github.com/pingcap/check"
+	"github.com/pingcap/errors"
+)
+
+type testScanParallelSuite struct {
+	OneByOneSuite
+}
+
+var _ = Suite(&testScanParallelSuite{})
+
+func (s *testScanParallelSuite) TestOrderedParallelScanner(c *C) {
+	store := newSplitTestStore(c, []byte("e"), []byte("h"), []byte("p"), []byte("t"))
+	defer store.Close()
+	putAlphabet(c, store)
+
+	txn, err := store.Begin()
+	c.Assert(err, IsNil)
+	snapshot := txn.GetSnapshot()
+	for _, concurrency := range []int{1, 2, 3, 10} {
+		scanner, err := snapshot.NewOrderedParallelScanner(context.Background(), []byte("b"), []byte("{"), concurrency, 3)
+		c.Assert(err, IsNil)
+		var keys []byte
+		for scanner.Valid() {
+			c.Assert(scanner.Value(), BytesEquals, scanner.Key())
+			keys = append(keys, scanner.Key()...)
+			c.Assert(scanner.Next(), IsNil)
+		}
+		c.Assert(string(keys), Equals, "bcdefghijklmnopqrstuvwxyz", Commentf("concurrency %d", concurrency))
+		// There are only 5 regions to scan in parallel.
+		if concurrency <= 5 {
+			c.Assert(scanner.Concurrency(), Equals, concurrency)
+		} else {
+			c.Assert(scanner.Concurrency(), Equals, 5)
+		}
+		scanner.Close()
+	}
+
+	// The adaptive scanner returns the same results with at most max concurrency.
+	scanner, err := snapshot.NewAdaptiveOrderedParallelScanner(context.Background(), []byte("b"), []byte("{"), 3, 3)
+	c.Assert(err, IsNil)
+	var keys []byte
+	for scanner.Valid() {
+		keys = append(keys, scanner.Key()...)
+		c.Assert(scanner.Next(), IsNil)
+		c.Assert(scanner.Concurrency(), Greater, 0)
+		c.Assert(scanner.Concurrency() <= 3, IsTrue)
+	}
+	c.Assert(string(keys), Equals, "bcdefghijklmnopqrstuvwxyz")
+	scanner.Close()
+
+	// Closing the scanner early stops all workers.
+	scanner, err = snapshot.NewOrderedParallelScanner(context.Background(), []byte("a"), []byte("{"), 5, 1)
+	c.Assert(err, IsNil)
+	c.Assert(scanner.Key(), BytesEquals, []byte("a"))
+	c.Assert(scanner.Next(), IsNil)
+	c.Assert(scanner.Key(), BytesEquals, []byte("b"))
+	scanner.Close()
+	c.Assert(scanner.Valid(), IsFalse)
+
+	// Canceling the context fails the scan.
+	ctx, cancel := context.WithCancel(context.Background())
+	scanner, err = snapshot.NewOrderedParallelScanner(ctx, []byte("a"), []byte("{"), 5, 1)
+	c.Assert(err, IsNil)
+	cancel()
+	err = scanner.Next()
+	c.Assert(errors.Cause(err), Equals, context.Canceled)
+	c.Assert(scanner.Valid(), IsFalse)
+}
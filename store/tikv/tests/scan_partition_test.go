@@ -0,0 +1,83 @@
+// Copyright 2021 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tikv_test
+
+import (
+	"context"
+
+	. "github.com/pingcap/check"
+	"github.com/pingcap/errors"
+	"github.com/pingcap/tidb/store/tikv"
+	"github.com/pingcap/tidb/store/tikv/kv"
+)
+
+type testScanPartitionSuite struct {
+	OneByOneSuite
+}
+
+var _ = Suite(&testScanPartitionSuite{})
+
+func (s *testScanPartitionSuite) TestPartitionScanner(c *C) {
+	store := tikv.StoreProbe{KVStore: NewTestStore(c)}
+	defer store.Close()
+	txn, err := store.Begin()
+	c.Assert(err, IsNil)
+	for _, key := range []string{"p0_1", "p0_4", "p1_2", "p1_3", "p1_4", "p1_5", "p2_0", "p3_6"} {
+		c.Assert(txn.Set([]byte(key), []byte(key)), IsNil)
+	}
+	c.Assert(txn.Commit(context.Background()), IsNil)
+
+	ranges := make([]tikv.PartitionRange, 0, 3)
+	for _, prefix := range []string{"p0_", "p1_", "p3_"} {
+		ranges = append(ranges, tikv.PartitionRange{
+			KeyRange: kv.KeyRange{StartKey: []byte(prefix), EndKey: kv.PrefixNextKey([]byte(prefix))},
+			Prefix:   []byte(prefix),
+		})
+	}
+	txn, err = store.Begin()
+	c.Assert(err, IsNil)
+	scan := func(ordered bool) ([]string, []int) {
+		scanner, err := txn.GetSnapshot().NewPartitionScanner(context.Background(), ranges, 2, ordered)
+		c.Assert(err, IsNil)
+		defer scanner.Close()
+		var (
+			keys  []string
+			parts []int
+		)
+		for scanner.Valid() {
+			c.Assert(scanner.Value(), BytesEquals, scanner.Key())
+			keys = append(keys, string(scanner.Key()))
+			parts = append(parts, scanner.Partition())
+			c.Assert(scanner.Next(), IsNil)
+		}
+		c.Assert(scanner.Partition(), Equals, -1)
+		return keys, parts
+	}
+	keys, parts := scan(true)
+	c.Assert(keys, DeepEquals, []string{"p0_1", "p1_2", "p1_3", "p0_4", "p1_4", "p1_5", "p3_6"})
+	c.Assert(parts, DeepEquals, []int{0, 1, 1, 0, 1, 1, 2})
+	keys, parts = scan(false)
+	c.Assert(keys, DeepEquals, []string{"p0_1", "p0_4", "p1_2", "p1_3", "p1_4", "p1_5", "p3_6"})
+	c.Assert(parts, DeepEquals, []int{0, 0, 1, 1, 1, 1, 2})
+
+	// Canceling the context closes the whole scan.
+	ctx, cancel := context.WithCancel(context.Background())
+	scanner, err := txn.GetSnapshot().NewPartitionScanner(ctx, ranges, 2, true)
+	c.Assert(err, IsNil)
+	c.Assert(scanner.Next(), IsNil)
+	cancel()
+	err = scanner.Next()
+	c.Assert(errors.Cause(err), Equals, context.Canceled)
+	c.Assert(scanner.Valid(), IsFalse)
+}
@@ -0,0 +1,91 @@
+// Copyright 2021 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tikv_test
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	. "github.com/pingcap/check"
+	"github.com/pingcap/errors"
+	"github.com/pingcap/tidb/store/tikv"
+	"github.com/pingcap/tidb/store/tikv/kv"
+	"github.com/pingcap/tidb/store/tikv/tikvrpc"
+)
+
+type testScanPrefetchSuite struct {
+	OneByOneSuite
+}
+
+var _ = Suite(&testScanPrefetchSuite{})
+
+func (s *testScanPrefetchSuite) TestScanPrefetch(c *C) {
+	store, client := newHookedTestStore(c, []byte("h"), []byte("p"))
+	defer store.Close()
+	putAlphabet(c, store)
+
+	txn, err := store.Begin()
+	c.Assert(err, IsNil)
+	scan := func(opts ...tikv.ScannerOption) (string, string) {
+		scanner, err := txn.NewScanner([]byte("a"), []byte("{"), 3, false, opts...)
+		c.Assert(err, IsNil)
+		var keys, values []byte
+		for scanner.Valid() {
+			keys = append(keys, scanner.Key()...)
+			values = append(values, scanner.Value()...)
+			c.Assert(scanner.Next(), IsNil)
+		}
+		return string(keys), string(values)
+	}
+	keys, values := scan()
+	c.Assert(keys, Equals, "abcdefghijklmnopqrstuvwxyz")
+	for _, batches := range []int{1, 2, 5} {
+		prefetchedKeys, prefetchedValues := scan(tikv.WithPrefetch(batches))
+		c.Assert(prefetchedKeys, Equals, keys)
+		c.Assert(prefetchedValues, Equals, values)
+	}
+	// The number of batches can be set by the variables of the session.
+	vars := kv.NewVariables(nil)
+	vars.ScanPrefetchBatches = 2
+	txn.SetVars(vars)
+	prefetchedKeys, prefetchedValues := scan()
+	c.Assert(prefetchedKeys, Equals, keys)
+	c.Assert(prefetchedValues, Equals, values)
+
+	// The second batch is fetched in the background once the first one is received,
+	// and Close cancels it and waits for it.
+	var exited int32
+	entered := make(chan struct{})
+	client.setOnSendCtx(func(reqCtx context.Context, req *tikvrpc.Request) (*tikvrpc.Response, error) {
+		if req.Type != tikvrpc.CmdScan || kv.CmpKey(req.Scan().StartKey, []byte("c")) <= 0 {
+			return nil, nil
+		}
+		defer atomic.StoreInt32(&exited, 1)
+		close(entered)
+		<-reqCtx.Done()
+		return nil, errors.Trace(reqCtx.Err())
+	})
+	defer client.setOnSendCtx(nil)
+	scanner, err := txn.NewScanner([]byte("a"), []byte("{"), 3, false, tikv.WithPrefetch(1))
+	c.Assert(err, IsNil)
+	c.Assert(scanner.Key(), BytesEquals, []byte("a"))
+	select {
+	case <-entered:
+	case <-time.After(5 * time.Second):
+		c.Fatal("the next batch isn't prefetched")
+	}
+	scanner.Close()
+	c.Assert(atomic.LoadInt32(&exited), Equals, int32(1))
+}
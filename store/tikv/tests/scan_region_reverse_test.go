@@ -0,0 +1,59 @@
+// Copyright 2021 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tikv_test
+
+import (
+	. "github.com/pingcap/check"
+)
+
+type testScanRegionReverseSuite struct {
+	OneByOneSuite
+}
+
+var _ = Suite(&testScanRegionReverseSuite{})
+
+func (s *testScanRegionReverseSuite) TestRegionReverseScanner(c *C) {
+	store := newSplitTestStore(c, []byte("h"), []byte("p"))
+	defer store.Close()
+	putAlphabet(c, store)
+
+	txn, err := store.Begin()
+	c.Assert(err, IsNil)
+	scan := func(startKey, endKey []byte) (string, []int) {
+		scanner, err := txn.GetSnapshot().NewRegionReverseScanner(startKey, endKey, 3)
+		c.Assert(err, IsNil)
+		defer scanner.Close()
+		var (
+			keys    []byte
+			regions []int
+		)
+		for scanner.Valid() {
+			keys = append(keys, scanner.Key()...)
+			regions = append(regions, scanner.Region())
+			c.Assert(scanner.Next(), IsNil)
+		}
+		c.Assert(scanner.Region(), Equals, -1)
+		return string(keys), regions
+	}
+	keys, regions := scan([]byte("a"), []byte("{"))
+	c.Assert(keys, Equals, "gfedcbaonmlkjihzyxwvutsrqp")
+	c.Assert(regions[0], Equals, 0)
+	c.Assert(regions[7], Equals, 1)
+	c.Assert(regions[25], Equals, 2)
+	keys, _ = scan([]byte("e"), []byte("r"))
+	c.Assert(keys, Equals, "gfeonmlkjihqp")
+	// Empty regions are skipped.
+	keys, _ = scan([]byte("h0"), []byte("i"))
+	c.Assert(keys, Equals, "")
+}
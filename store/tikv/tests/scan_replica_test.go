@@ -0,0 +1,95 @@
+// Copyright 2021 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tikv_test
+
+import (
+	. "github.com/pingcap/check"
+	"github.com/pingcap/errors"
+	"github.com/pingcap/kvproto/pkg/kvrpcpb"
+	"github.com/pingcap/tidb/store/mockstore/unistore"
+	"github.com/pingcap/tidb/store/tikv"
+	"github.com/pingcap/tidb/store/tikv/kv"
+	"github.com/pingcap/tidb/store/tikv/tikvrpc"
+)
+
+type testScanReplicaSuite struct {
+	OneByOneSuite
+}
+
+var _ = Suite(&testScanReplicaSuite{})
+
+func (s *testScanReplicaSuite) TestScanAnomalyReread(c *C) {
+	client, pdClient, cluster, err := unistore.New("")
+	c.Assert(err, IsNil)
+	storeIDs, _, _, _ := unistore.BootstrapWithMultiStores(cluster, 3)
+	// The replicas in the bad stores return a key out of range.
+	bad := make(map[uint64]bool)
+	hooked := &hookedClient{onSend: func(req *tikvrpc.Request) (*tikvrpc.Response, error) {
+		if req.Type == tikvrpc.CmdScan && bad[req.Context.GetPeer().GetStoreId()] {
+			pairs := []*kvrpcpb.KvPair{{Key: []byte("zz"), Value: []byte("zz")}}
+			return &tikvrpc.Response{Resp: &kvrpcpb.ScanResponse{Pairs: pairs}}, nil
+		}
+		return nil, nil
+	}}
+	kvStore, err := tikv.NewTestTiKVStore(client, pdClient, func(c tikv.Client) tikv.Client {
+		hooked.Client = c
+		return hooked
+	}, nil, 0)
+	c.Assert(err, IsNil)
+	store := tikv.StoreProbe{KVStore: kvStore}
+	defer store.Close()
+	putAlphabet(c, store)
+
+	scan := func() ([]byte, []*tikv.ReplicaDivergence, error) {
+		var divergences []*tikv.ReplicaDivergence
+		report := func(d *tikv.ReplicaDivergence) {
+			divergences = append(divergences, d)
+		}
+		txn, err := store.Begin()
+		c.Assert(err, IsNil)
+		scanner, err := txn.NewScanner([]byte("b"), []byte("m"), 100, false, tikv.WithAnomalyReread(report))
+		if err != nil {
+			return nil, divergences, err
+		}
+		var keys []byte
+		for scanner.Valid() {
+			keys = append(keys, scanner.Key()...)
+			if err = scanner.Next(); err != nil {
+				return nil, divergences, err
+			}
+		}
+		return keys, divergences, nil
+	}
+	keys, divergences, err := scan()
+	c.Assert(err, IsNil)
+	c.Assert(string(keys), Equals, "bcdefghijkl")
+	c.Assert(divergences, HasLen, 0)
+
+	bad[storeIDs[0]], bad[storeIDs[2]] = true, true
+	keys, divergences, err = scan()
+	c.Assert(err, IsNil)
+	c.Assert(string(keys), Equals, "bcdefghijkl")
+	c.Assert(divergences, HasLen, 1)
+	c.Assert(divergences[0].StartKey, BytesEquals, []byte("b"))
+	c.Assert(divergences[0].Diverged, DeepEquals, []uint64{storeIDs[0], storeIDs[2]})
+	c.Assert(divergences[0].Healthy, Equals, storeIDs[1])
+
+	bad[storeIDs[1]] = true
+	_, divergences, err = scan()
+	_, ok := errors.Cause(err).(*kv.ErrKeyOutOfRange)
+	c.Assert(ok, IsTrue)
+	c.Assert(divergences, HasLen, 1)
+	c.Assert(divergences[0].Diverged, DeepEquals, storeIDs)
+	c.Assert(divergences[0].Healthy, Equals, uint64(0))
+}
@@ -0,0 +1,781 @@
+// Copyright 2021 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tikv_test
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"time"
+
+	. "github.com/pingcap/check"
+	"github.com/pingcap/errors"
+	"github.com/pingcap/kvproto/pkg/errorpb"
+	"github.com/pingcap/kvproto/pkg/kvrpcpb"
+	"github.com/pingcap/kvproto/pkg/metapb"
+	"github.com/pingcap/tidb/store/mockstore/unistore"
+	"github.com/pingcap/tidb/store/tikv"
+	"github.com/pingcap/tidb/store/tikv/kv"
+	"github.com/pingcap/tidb/store/tikv/tikvrpc"
+	"google.golang.org/grpc"
+)
+
+type testScanResponseSuite struct {
+	OneByOneSuite
+}
+
+var _ = Suite(&testScanResponseSuite{})
+
+func (s *testScanResponseSuite) TestScanMaxExecutionTime(c *C) {
+	store, client := newHookedTestStore(c)
+	defer store.Close()
+	putAlphabet(c, store)
+
+	var scanCnt int
+	client.setOnSend(func(req *tikvrpc.Request) (*tikvrpc.Response, error) {
+		if req.Type != tikvrpc.CmdScan {
+			return nil, nil
+		}
+		scanCnt++
+		c.Assert(req.Scan().Context.MaxExecutionDurationMs, Equals, uint64(100))
+		return nil, nil
+	})
+	txn, err := store.Begin()
+	c.Assert(err, IsNil)
+	txn.GetSnapshot().SetOption(kv.MaxExecutionTime, uint64(100))
+	scanner, err := txn.NewScanner([]byte("a"), nil, 10, false)
+	c.Assert(err, IsNil)
+	for ch := byte('a'); ch <= byte('z'); ch++ {
+		c.Assert([]byte{ch}, BytesEquals, scanner.Key())
+		c.Assert(scanner.Next(), IsNil)
+	}
+	c.Assert(scanner.Valid(), IsFalse)
+	c.Assert(scanCnt, Greater, 0)
+
+	// The deadline exceeded error reported by TiKV should not be retried.
+	scanCnt = 0
+	client.setOnSend(func(req *tikvrpc.Request) (*tikvrpc.Response, error) {
+		if req.Type != tikvrpc.CmdScan {
+			return nil, nil
+		}
+		scanCnt++
+		return &tikvrpc.Response{Resp: &kvrpcpb.ScanResponse{RegionError: &errorpb.Error{
+			ServerIsBusy: &errorpb.ServerIsBusy{Reason: "deadline is exceeded"},
+		}}}, nil
+	})
+	_, err = txn.NewScanner([]byte("a"), nil, 10, false)
+	c.Assert(errors.Cause(err), Equals, kv.ErrTiKVDeadlineExceeded)
+	c.Assert(scanCnt, Equals, 1)
+}
+
+func (s *testScanResponseSuite) TestScanRangeVerification(c *C) {
+	store, client := newHookedTestStore(c)
+	defer store.Close()
+	putAlphabet(c, store)
+
+	scanValues := func(scanner *tikv.Scanner) string {
+		var values []byte
+		for scanner.Valid() {
+			values = append(values, scanner.Value()...)
+			c.Assert(scanner.Next(), IsNil)
+		}
+		return string(values)
+	}
+	txn, err := store.Begin()
+	c.Assert(err, IsNil)
+	scanner, err := txn.NewScanner([]byte("c"), []byte("f"), 10, false, tikv.WithRangeVerification())
+	c.Assert(err, IsNil)
+	c.Assert(scanValues(scanner), Equals, "cde")
+	scanner, err = txn.NewScanner([]byte("c"), []byte("f"), 10, true, tikv.WithRangeVerification())
+	c.Assert(err, IsNil)
+	c.Assert(scanValues(scanner), Equals, "edc")
+
+	// A misrouted response returns keys of other ranges.
+	client.setOnSend(func(req *tikvrpc.Request) (*tikvrpc.Response, error) {
+		if req.Type != tikvrpc.CmdScan {
+			return nil, nil
+		}
+		return &tikvrpc.Response{Resp: &kvrpcpb.ScanResponse{Pairs: []*kvrpcpb.KvPair{
+			{Key: []byte("d"), Value: []byte("d")},
+			{Key: []byte("x"), Value: []byte("x")},
+		}}}, nil
+	})
+	defer client.setOnSend(nil)
+	_, err = txn.NewScanner([]byte("c"), []byte("f"), 10, false, tikv.WithRangeVerification())
+	e, ok := errors.Cause(err).(*kv.ErrKeyOutOfRange)
+	c.Assert(ok, IsTrue)
+	c.Assert(e.Key, BytesEquals, []byte("x"))
+}
+
+func (s *testScanResponseSuite) TestScanCancelAcrossRegions(c *C) {
+	store, client := newHookedTestStore(c, []byte("h"))
+	defer store.Close()
+	putAlphabet(c, store)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	// The query is killed once the scan moves to the second region, which is busy,
+	// so the scanner backs off.
+	client.setOnSend(func(req *tikvrpc.Request) (*tikvrpc.Response, error) {
+		if req.Type != tikvrpc.CmdScan || kv.CmpKey(req.Scan().StartKey, []byte("h")) < 0 {
+			return nil, nil
+		}
+		cancel()
+		return &tikvrpc.Response{Resp: &kvrpcpb.ScanResponse{
+			RegionError: &errorpb.Error{ServerIsBusy: &errorpb.ServerIsBusy{}},
+		}}, nil
+	})
+	defer client.setOnSend(nil)
+
+	txn, err := store.Begin()
+	c.Assert(err, IsNil)
+	scanner, err := txn.NewScanner([]byte("a"), []byte("{"), 3, false, tikv.WithContext(ctx))
+	c.Assert(err, IsNil)
+	var keys []byte
+	start := time.Now()
+	for scanner.Valid() {
+		keys = append(keys, scanner.Key()...)
+		if err = scanner.Next(); err != nil {
+			break
+		}
+	}
+	c.Assert(string(keys), Equals, "abcdefg")
+	// The backoff returns on the cancellation instead of sleeping for the busy server.
+	c.Assert(errors.Cause(err), Equals, context.Canceled)
+	c.Assert(time.Since(start), Less, time.Second)
+	c.Assert(scanner.Valid(), IsFalse)
+}
+
+func (s *testScanResponseSuite) TestScanCacheHint(c *C) {
+	store, client := newHookedTestStore(c)
+	defer store.Close()
+
+	var notFillCache []bool
+	client.setOnSend(func(req *tikvrpc.Request) (*tikvrpc.Response, error) {
+		if req.Type == tikvrpc.CmdScan {
+			notFillCache = append(notFillCache, req.Context.NotFillCache)
+		}
+		return nil, nil
+	})
+	scan := func(txn tikv.TxnProbe, opts ...tikv.ScannerOption) {
+		scanner, err := txn.NewScanner([]byte("a"), []byte("c"), 10, false, opts...)
+		c.Assert(err, IsNil)
+		scanner.Close()
+	}
+	txn, err := store.Begin()
+	c.Assert(err, IsNil)
+	scan(txn)
+	scan(txn, tikv.WithCacheHint(tikv.ScanCacheBypass))
+	txn.SetOption(kv.NotFillCache, true)
+	scan(txn)
+	scan(txn, tikv.WithCacheHint(tikv.ScanCacheFill))
+	c.Assert(notFillCache, DeepEquals, []bool{false, true, true, false})
+}
+
+func (s *testScanResponseSuite) TestScanCallOptions(c *C) {
+	store, client := newHookedTestStore(c)
+	defer store.Close()
+	putAlphabet(c, store)
+
+	var withOptions int
+	client.setOnSend(func(req *tikvrpc.Request) (*tikvrpc.Response, error) {
+		if req.Type == tikvrpc.CmdScan && len(req.CallOptions) == 1 {
+			withOptions++
+		}
+		return nil, nil
+	})
+	txn, err := store.Begin()
+	c.Assert(err, IsNil)
+	scanner, err := txn.NewScanner([]byte("a"), []byte("{"), 10, false, tikv.WithCallOptions(grpc.WaitForReady(true)))
+	c.Assert(err, IsNil)
+	for scanner.Valid() {
+		c.Assert(scanner.Next(), IsNil)
+	}
+	c.Assert(withOptions, Equals, 3)
+}
+
+func (s *testScanResponseSuite) TestScanSnapshotVerification(c *C) {
+	store := newSplitTestStore(c, []byte("h"))
+	defer store.Close()
+	putAlphabet(c, store)
+
+	txn, err := store.Begin()
+	c.Assert(err, IsNil)
+	// The interceptor moves the read version of the second region.
+	adjust := tikv.WithRequestInterceptor(func(req *tikvrpc.Request) {
+		if bytes.Compare(req.Scan().StartKey, []byte("h")) >= 0 {
+			req.Scan().Version++
+		}
+	})
+	scan := func(opts ...tikv.ScannerOption) error {
+		scanner, err := txn.NewScanner([]byte("a"), []byte("{"), 10, false, opts...)
+		if err != nil {
+			return err
+		}
+		for scanner.Valid() {
+			if err = scanner.Next(); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	c.Assert(scan(adjust), IsNil)
+	c.Assert(scan(tikv.WithSnapshotVerification()), IsNil)
+	err = scan(adjust, tikv.WithSnapshotVerification())
+	e, ok := errors.Cause(err).(*kv.ErrInconsistentSnapshot)
+	c.Assert(ok, IsTrue)
+	c.Assert(e.StartTS, Equals, txn.StartTS())
+	c.Assert(e.ReadTS, Equals, txn.StartTS()+1)
+}
+
+func (s *testScanResponseSuite) TestScanWithRegionCacheInvalidated(c *C) {
+	store, client := newHookedTestStore(c, []byte("h"), []byte("p"))
+	defer store.Close()
+	putAlphabet(c, store)
+
+	var scanned [][]byte
+	client.setOnSend(func(req *tikvrpc.Request) (*tikvrpc.Response, error) {
+		if req.Type == tikvrpc.CmdScan {
+			scanned = append(scanned, req.Scan().StartKey)
+		}
+		return nil, nil
+	})
+	for _, reverse := range []bool{false, true} {
+		scanned = scanned[:0]
+		txn, err := store.Begin()
+		c.Assert(err, IsNil)
+		scanner, err := txn.NewScanner([]byte("a"), []byte("{"), 3, reverse)
+		c.Assert(err, IsNil)
+		var keys []byte
+		for scanner.Valid() {
+			keys = append(keys, scanner.Key()...)
+			store.GetRegionCache().InvalidateCachedRegion(scanner.CurrentRegion().Region)
+			c.Assert(scanner.Next(), IsNil)
+		}
+		if !reverse {
+			c.Assert(string(keys), Equals, "abcdefghijklmnopqrstuvwxyz")
+		} else {
+			c.Assert(string(keys), Equals, "zyxwvutsrqponmlkjihgfedcba")
+		}
+		// Every request starts from a different key, nothing is read twice.
+		seen := make(map[string]struct{})
+		for _, k := range scanned {
+			_, ok := seen[string(k)]
+			c.Assert(ok, IsFalse, Commentf("scan from %q twice", k))
+			seen[string(k)] = struct{}{}
+		}
+	}
+}
+
+func (s *testScanResponseSuite) TestScanResponseIterator(c *C) {
+	store := newSplitTestStore(c, []byte("h"), []byte("p"))
+	defer store.Close()
+	putAlphabet(c, store)
+
+	txn, err := store.Begin()
+	c.Assert(err, IsNil)
+	it, err := txn.GetSnapshot().NewScanResponseIterator([]byte("a"), nil, 5, false)
+	c.Assert(err, IsNil)
+	var keys []byte
+	var responses int
+	for {
+		resp, err := it.Next()
+		c.Assert(err, IsNil)
+		if resp == nil {
+			break
+		}
+		responses++
+		c.Assert(len(resp.Pairs) <= 5, IsTrue)
+		for _, pair := range resp.Pairs {
+			// Keys whose secondary locks are not committed yet are returned
+			// with their lock errors.
+			if pair.Error == nil {
+				c.Assert(pair.Value, BytesEquals, pair.Key)
+			}
+			c.Assert(bytes.Compare(pair.Key, it.CurrentRegion().StartKey) >= 0, IsTrue)
+			keys = append(keys, pair.Key...)
+		}
+	}
+	c.Assert(string(keys), Equals, "abcdefghijklmnopqrstuvwxyz")
+	// Regions [a, h) and [h, p) take 2 requests each, [p, +inf) takes 3.
+	c.Assert(responses, Equals, 7)
+}
+
+func (s *testScanResponseSuite) TestScanTags(c *C) {
+	store, client := newHookedTestStore(c)
+	defer store.Close()
+	putAlphabet(c, store)
+
+	// Fail the first request, so that the retry summary is logged with the tags.
+	var scans int
+	client.setOnSend(func(req *tikvrpc.Request) (*tikvrpc.Response, error) {
+		if req.Type != tikvrpc.CmdScan {
+			return nil, nil
+		}
+		if scans++; scans == 1 {
+			return &tikvrpc.Response{Resp: &kvrpcpb.ScanResponse{RegionError: &errorpb.Error{StaleCommand: &errorpb.StaleCommand{}}}}, nil
+		}
+		return nil, nil
+	})
+	txn, err := store.Begin()
+	c.Assert(err, IsNil)
+	tags := map[string]string{"query": "42", "operator": "TableReader_5"}
+	scanner, err := txn.NewScanner([]byte("a"), []byte("d"), 10, false, tikv.WithTags(tags))
+	c.Assert(err, IsNil)
+	c.Assert(scanner.Stats().Tags, DeepEquals, tags)
+	for scanner.Valid() {
+		c.Assert(scanner.Next(), IsNil)
+	}
+	c.Assert(scans, Equals, 2)
+	c.Assert(scanner.Stats().Tags, DeepEquals, tags)
+
+	scanner, err = txn.NewScanner([]byte("a"), []byte("d"), 10, false)
+	c.Assert(err, IsNil)
+	c.Assert(scanner.Stats().Tags, IsNil)
+}
+
+func (s *testScanResponseSuite) TestScanRegionErrorClassification(c *C) {
+	store, client := newHookedTestStore(c)
+	defer store.Close()
+	putAlphabet(c, store)
+
+	var scanCnt int
+	injectRegionErr := func(n int, regionErr *errorpb.Error) {
+		scanCnt = 0
+		client.setOnSend(func(req *tikvrpc.Request) (*tikvrpc.Response, error) {
+			if req.Type != tikvrpc.CmdScan {
+				return nil, nil
+			}
+			scanCnt++
+			if n >= 0 && scanCnt > n {
+				return nil, nil
+			}
+			return &tikvrpc.Response{Resp: &kvrpcpb.ScanResponse{RegionError: regionErr}}, nil
+		})
+	}
+	defer client.setOnSend(nil)
+	txn, err := store.Begin()
+	c.Assert(err, IsNil)
+
+	// Retriable errors are retried until they go away.
+	retriable := []*errorpb.Error{
+		{EpochNotMatch: &errorpb.EpochNotMatch{}},
+		{RegionNotFound: &errorpb.RegionNotFound{}},
+		{KeyNotInRegion: &errorpb.KeyNotInRegion{}},
+		{StaleCommand: &errorpb.StaleCommand{}},
+	}
+	for _, regionErr := range retriable {
+		n := 3
+		if regionErr.GetRegionNotFound() != nil || regionErr.GetKeyNotInRegion() != nil {
+			n = 1
+		}
+		injectRegionErr(n, regionErr)
+		scanner, err := txn.NewScanner([]byte("a"), nil, 30, false)
+		c.Assert(err, IsNil, Commentf("%s", regionErr))
+		c.Assert(scanner.Key(), BytesEquals, []byte("a"))
+	}
+
+	// Terminal errors fail fast once the region cache is reloaded.
+	terminal := []*errorpb.Error{
+		{RegionNotFound: &errorpb.RegionNotFound{}},
+		{KeyNotInRegion: &errorpb.KeyNotInRegion{}},
+	}
+	for _, regionErr := range terminal {
+		injectRegionErr(-1, regionErr)
+		_, err = txn.NewScanner([]byte("a"), nil, 30, false)
+		_, ok := errors.Cause(err).(*kv.ErrRegionErrorNotRetriable)
+		c.Assert(ok, IsTrue, Commentf("%s: %v", regionErr, err))
+		c.Assert(scanCnt, Equals, 2)
+	}
+}
+
+func (s *testScanResponseSuite) TestScanRegionMerge(c *C) {
+	// scan scans all keys with region ["h", "p") merged into its neighbor target in
+	// the middle of the scan, once the scan reaches mergeAt.
+	scan := func(reverse bool, target int, mergeAt string, opts ...tikv.ScannerOption) (string, []uint64, error) {
+		client, pdClient, cluster, err := unistore.New("")
+		c.Assert(err, IsNil)
+		_, regionIDs, _ := unistore.BootstrapWithMultiRegions(cluster, []byte("h"), []byte("p"))
+		var armed, merged bool
+		hooked := &hookedClient{onSend: func(req *tikvrpc.Request) (*tikvrpc.Response, error) {
+			if req.Type != tikvrpc.CmdScan || !armed || merged || req.Context.RegionId != regionIDs[1] {
+				return nil, nil
+			}
+			// The start key of reverse scan requests is the upper bound.
+			if bytes.Equal(req.Scan().StartKey, []byte(mergeAt)) {
+				cluster.Merge(regionIDs[1], regionIDs[target])
+				merged = true
+			}
+			return nil, nil
+		}}
+		kvStore, err := tikv.NewTestTiKVStore(client, pdClient, func(c tikv.Client) tikv.Client {
+			hooked.Client = c
+			return hooked
+		}, nil, 0)
+		c.Assert(err, IsNil)
+		store := tikv.StoreProbe{KVStore: kvStore}
+		defer store.Close()
+		putAlphabet(c, store)
+		// Resolve the locks of the secondaries first, which may be committed in the
+		// background, so that the scan below doesn't back off for them.
+		txn, err := store.Begin()
+		c.Assert(err, IsNil)
+		scanner, err := txn.NewScanner([]byte("a"), nil, 26, false)
+		c.Assert(err, IsNil)
+		for scanner.Valid() {
+			c.Assert(scanner.Next(), IsNil)
+		}
+		armed = true
+
+		txn, err = store.Begin()
+		c.Assert(err, IsNil)
+		opts = append(opts, tikv.WithRegionProfile())
+		if reverse {
+			scanner, err = txn.NewScanner(nil, []byte("{"), 3, true, opts...)
+		} else {
+			scanner, err = txn.NewScanner([]byte("a"), nil, 3, false, opts...)
+		}
+		if err != nil {
+			return "", regionIDs, err
+		}
+		var keys []byte
+		for scanner.Valid() {
+			keys = append(keys, scanner.Key()...)
+			if err = scanner.Next(); err != nil {
+				return string(keys), regionIDs, err
+			}
+		}
+		c.Assert(merged, IsTrue)
+		// The scan moves to the merged region without backoff.
+		for _, profile := range scanner.Stats().RegionProfiles {
+			c.Assert(profile.BackoffTime, Equals, time.Duration(0))
+		}
+		return string(keys), regionIDs, nil
+	}
+
+	keys, _, err := scan(false, 2, "j\x00")
+	c.Assert(err, IsNil)
+	c.Assert(keys, Equals, "abcdefghijklmnopqrstuvwxyz")
+	// The reverse scan reads "o", "n" and "m" before the merge.
+	keys, _, err = scan(true, 0, "m")
+	c.Assert(err, IsNil)
+	c.Assert(keys, Equals, "zyxwvutsrqponmlkjihgfedcba")
+
+	keys, regionIDs, err := scan(false, 2, "j\x00", tikv.WithRegionMergePolicy(tikv.RegionMergeFail))
+	e, ok := errors.Cause(err).(*kv.ErrRegionMerged)
+	c.Assert(ok, IsTrue)
+	c.Assert(e.RegionID, Equals, regionIDs[1])
+	c.Assert(e.MergedInto, Equals, regionIDs[2])
+	c.Assert(keys, Equals, "abcdefghij")
+}
+
+func (s *testScanResponseSuite) TestScanMaxDuration(c *C) {
+	store, client := newHookedTestStore(c, []byte("h"))
+	defer store.Close()
+	putAlphabet(c, store)
+
+	txn, err := store.Begin()
+	c.Assert(err, IsNil)
+	scanner, err := txn.NewScanner([]byte("a"), nil, 10, false, tikv.WithMaxDuration(time.Minute))
+	c.Assert(err, IsNil)
+	for ch := byte('a'); ch <= byte('z'); ch++ {
+		c.Assert(scanner.Key(), BytesEquals, []byte{ch})
+		c.Assert(scanner.Next(), IsNil)
+	}
+
+	// The scan is aborted in the middle of backoff once its budget is used up.
+	client.setOnSend(func(req *tikvrpc.Request) (*tikvrpc.Response, error) {
+		if req.Type != tikvrpc.CmdScan || bytes.Compare(req.Scan().StartKey, []byte("h")) < 0 {
+			return nil, nil
+		}
+		return &tikvrpc.Response{Resp: &kvrpcpb.ScanResponse{RegionError: &errorpb.Error{
+			EpochNotMatch: &errorpb.EpochNotMatch{},
+		}}}, nil
+	})
+	defer client.setOnSend(nil)
+	start := time.Now()
+	scanner, err = txn.NewScanner([]byte("a"), nil, 10, false, tikv.WithMaxDuration(200*time.Millisecond))
+	c.Assert(err, IsNil)
+	for ch := byte('a'); ch < byte('h'); ch++ {
+		c.Assert(scanner.Key(), BytesEquals, []byte{ch})
+		err = scanner.Next()
+	}
+	c.Assert(errors.Cause(err), Equals, kv.ErrScanDeadlineExceeded)
+	c.Assert(scanner.Valid(), IsFalse)
+	c.Assert(time.Since(start), Less, 2*time.Second)
+}
+
+func (s *testScanResponseSuite) TestScanRegionErrorStats(c *C) {
+	store, client := newHookedTestStore(c)
+	defer store.Close()
+	putAlphabet(c, store)
+
+	injected := []*errorpb.Error{
+		{NotLeader: &errorpb.NotLeader{}},
+		{EpochNotMatch: &errorpb.EpochNotMatch{}},
+		{EpochNotMatch: &errorpb.EpochNotMatch{}},
+		{StaleCommand: &errorpb.StaleCommand{}},
+		{ServerIsBusy: &errorpb.ServerIsBusy{BackoffMs: 10}},
+	}
+	client.setOnSend(func(req *tikvrpc.Request) (*tikvrpc.Response, error) {
+		if req.Type != tikvrpc.CmdScan || len(injected) == 0 {
+			return nil, nil
+		}
+		regionErr := injected[0]
+		injected = injected[1:]
+		return &tikvrpc.Response{Resp: &kvrpcpb.ScanResponse{RegionError: regionErr}}, nil
+	})
+	defer client.setOnSend(nil)
+
+	txn, err := store.Begin()
+	c.Assert(err, IsNil)
+	scanner, err := txn.NewScanner([]byte("a"), nil, 10, false)
+	c.Assert(err, IsNil)
+	for scanner.Valid() {
+		c.Assert(scanner.Next(), IsNil)
+	}
+	stats := scanner.Stats()
+	c.Assert(stats.NotLeaderErrors, Equals, 1)
+	c.Assert(stats.EpochNotMatchErrors, Equals, 2)
+	c.Assert(stats.ServerBusyErrors, Equals, 1)
+	c.Assert(stats.SuggestedBackoff, Equals, 10*time.Millisecond)
+	c.Assert(stats.OtherRegionErrors, Equals, 1)
+}
+
+func (s *testScanResponseSuite) TestScanRegionProfile(c *C) {
+	store, client := newHookedTestStore(c, []byte("h"), []byte("p"))
+	defer store.Close()
+	putAlphabet(c, store)
+
+	// The region [h, p) is slow.
+	client.setOnSend(func(req *tikvrpc.Request) (*tikvrpc.Response, error) {
+		if req.Type == tikvrpc.CmdScan && string(req.Scan().StartKey) == "h" {
+			time.Sleep(20 * time.Millisecond)
+		}
+		return nil, nil
+	})
+	defer client.setOnSend(nil)
+
+	txn, err := store.Begin()
+	c.Assert(err, IsNil)
+	scanner, err := txn.NewScanner([]byte("a"), []byte("{"), 10, false)
+	c.Assert(err, IsNil)
+	for scanner.Valid() {
+		c.Assert(scanner.Next(), IsNil)
+	}
+	c.Assert(scanner.Stats().RegionProfiles, IsNil)
+
+	scanner, err = txn.NewScanner([]byte("a"), []byte("{"), 10, false, tikv.WithRegionProfile())
+	c.Assert(err, IsNil)
+	for scanner.Valid() {
+		c.Assert(scanner.Next(), IsNil)
+	}
+	profiles := scanner.Stats().RegionProfiles
+	c.Assert(profiles, HasLen, 3)
+	rows := 0
+	for _, profile := range profiles {
+		rows += profile.Rows
+	}
+	c.Assert(rows, Equals, 26)
+	c.Assert(profiles[1].Rows, Equals, 8)
+	c.Assert(profiles[1].RPCTime, GreaterEqual, 20*time.Millisecond)
+}
+
+func (s *testScanResponseSuite) TestScanMatchStoreLabels(c *C) {
+	client, pdClient, cluster, err := unistore.New("")
+	c.Assert(err, IsNil)
+	storeIDs, _, _, _ := unistore.BootstrapWithMultiStores(cluster, 3)
+	for i, storeID := range storeIDs {
+		cluster.AddStore(storeID, fmt.Sprintf("store%d", storeID), &metapb.StoreLabel{Key: "zone", Value: fmt.Sprintf("z%d", i)})
+	}
+	hooked := &hookedClient{}
+	kvStore, err := tikv.NewTestTiKVStore(client, pdClient, func(c tikv.Client) tikv.Client {
+		hooked.Client = c
+		return hooked
+	}, nil, 0)
+	c.Assert(err, IsNil)
+	store := tikv.StoreProbe{KVStore: kvStore}
+	defer store.Close()
+
+	var scannedStores []uint64
+	hooked.setOnSend(func(req *tikvrpc.Request) (*tikvrpc.Response, error) {
+		if req.Type != tikvrpc.CmdScan {
+			return nil, nil
+		}
+		scannedStores = append(scannedStores, req.Context.GetPeer().GetStoreId())
+		return &tikvrpc.Response{Resp: &kvrpcpb.ScanResponse{}}, nil
+	})
+	for _, replicaRead := range []kv.ReplicaReadType{kv.ReplicaReadFollower, kv.ReplicaReadMixed} {
+		for i := 0; i < 5; i++ {
+			scannedStores = scannedStores[:0]
+			txn, err := store.Begin()
+			c.Assert(err, IsNil)
+			txn.GetSnapshot().SetOption(kv.ReplicaRead, replicaRead)
+			txn.GetSnapshot().SetOption(kv.MatchStoreLabels, []*metapb.StoreLabel{{Key: "zone", Value: "z2"}})
+			_, err = txn.NewScanner([]byte("a"), nil, 10, false)
+			c.Assert(err, IsNil)
+			c.Assert(scannedStores, DeepEquals, []uint64{storeIDs[2]})
+		}
+	}
+}
+
+func (s *testScanResponseSuite) TestScanRequestInterceptor(c *C) {
+	store, client := newHookedTestStore(c)
+	defer store.Close()
+	putAlphabet(c, store)
+
+	regionErrs := 1
+	var priorities []kvrpcpb.CommandPri
+	client.setOnSend(func(req *tikvrpc.Request) (*tikvrpc.Response, error) {
+		if req.Type != tikvrpc.CmdScan {
+			return nil, nil
+		}
+		priorities = append(priorities, req.Context.Priority)
+		if regionErrs > 0 {
+			regionErrs--
+			return &tikvrpc.Response{Resp: &kvrpcpb.ScanResponse{RegionError: &errorpb.Error{
+				EpochNotMatch: &errorpb.EpochNotMatch{},
+			}}}, nil
+		}
+		return nil, nil
+	})
+	defer client.setOnSend(nil)
+
+	var intercepted int
+	interceptor := tikv.WithRequestInterceptor(func(req *tikvrpc.Request) {
+		intercepted++
+		req.Context.Priority = kvrpcpb.CommandPri_High
+	})
+	txn, err := store.Begin()
+	c.Assert(err, IsNil)
+	scanner, err := txn.NewScanner([]byte("a"), nil, 10, false, interceptor)
+	c.Assert(err, IsNil)
+	for scanner.Valid() {
+		c.Assert(scanner.Next(), IsNil)
+	}
+	// The retry of the first request, and 3 requests for the 26 keys.
+	c.Assert(intercepted, Equals, 4)
+	c.Assert(priorities, HasLen, 4)
+	for _, priority := range priorities {
+		c.Assert(priority, Equals, kvrpcpb.CommandPri_High)
+	}
+}
+
+func (s *testScanResponseSuite) TestScanServerCancellation(c *C) {
+	store, client := newHookedTestStore(c)
+	defer store.Close()
+	putAlphabet(c, store)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var (
+		scans    int
+		noBatch  []bool
+		canceled bool
+	)
+	client.setOnSendCtx(func(reqCtx context.Context, req *tikvrpc.Request) (*tikvrpc.Response, error) {
+		if req.Type != tikvrpc.CmdScan {
+			return nil, nil
+		}
+		noBatch = append(noBatch, req.NoBatch)
+		if scans++; scans < 2 {
+			return nil, nil
+		}
+		// The client gives up while the second request is being processed, which is
+		// observed by the server through the context of the call.
+		cancel()
+		select {
+		case <-reqCtx.Done():
+			canceled = true
+			return nil, errors.Trace(reqCtx.Err())
+		case <-time.After(5 * time.Second):
+			return nil, nil
+		}
+	})
+	txn, err := store.Begin()
+	c.Assert(err, IsNil)
+	scanner, err := txn.NewScanner([]byte("a"), nil, 3, false, tikv.WithContext(ctx), tikv.WithServerCancellation())
+	c.Assert(err, IsNil)
+	for scanner.Valid() && err == nil {
+		err = scanner.Next()
+	}
+	c.Assert(err, NotNil)
+	c.Assert(canceled, IsTrue)
+	c.Assert(noBatch, DeepEquals, []bool{true, true})
+
+	// The requests are sent by batch commands by default.
+	client.setOnSendCtx(func(reqCtx context.Context, req *tikvrpc.Request) (*tikvrpc.Response, error) {
+		if req.Type == tikvrpc.CmdScan {
+			c.Assert(req.NoBatch, IsFalse)
+		}
+		return nil, nil
+	})
+	scanner, err = txn.NewScanner([]byte("a"), nil, 3, false)
+	c.Assert(err, IsNil)
+	c.Assert(scanner.Valid(), IsTrue)
+}
+
+func (s *testScanResponseSuite) TestScanTSORefresh(c *C) {
+	store, client := newHookedTestStore(c, []byte("h"), []byte("p"))
+	defer store.Close()
+	putAlphabet(c, store)
+
+	var (
+		regions  []uint64
+		versions []uint64
+	)
+	client.setOnSend(func(req *tikvrpc.Request) (*tikvrpc.Response, error) {
+		if req.Type == tikvrpc.CmdScan {
+			regions = append(regions, req.Context.RegionId)
+			versions = append(versions, req.Scan().Version)
+		}
+		return nil, nil
+	})
+	txn, err := store.Begin()
+	c.Assert(err, IsNil)
+	scan := func(opts ...tikv.ScannerOption) *tikv.Scanner {
+		regions, versions = regions[:0], versions[:0]
+		scanner, err := txn.NewScanner([]byte("a"), nil, 4, false, opts...)
+		c.Assert(err, IsNil)
+		var keys []byte
+		for scanner.Valid() {
+			keys = append(keys, scanner.Key()...)
+			c.Assert(scanner.Next(), IsNil)
+		}
+		c.Assert(string(keys), Equals, "abcdefghijklmnopqrstuvwxyz")
+		return scanner
+	}
+
+	// The batches of a region are read at the same version, while a new version is
+	// taken as the scan enters the next region.
+	scanner := scan(tikv.WithTSORefresh(time.Nanosecond))
+	c.Assert(versions[0], Equals, txn.StartTS())
+	for i := 1; i < len(versions); i++ {
+		if regions[i] == regions[i-1] {
+			c.Assert(versions[i], Equals, versions[i-1])
+		} else {
+			c.Assert(versions[i], Greater, versions[i-1])
+		}
+	}
+	c.Assert(versions[len(versions)-1], Greater, txn.StartTS())
+	cursor, err := scanner.Cursor()
+	c.Assert(err, IsNil)
+	c.Assert(cursor.Version, Equals, versions[len(versions)-1])
+
+	// The snapshot of the caller isn't changed.
+	scan(tikv.WithTSORefresh(time.Hour))
+	for _, version := range versions {
+		c.Assert(version, Equals, txn.StartTS())
+	}
+}
@@ -0,0 +1,94 @@
+// Copyright 2021 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tikv_test
+
+import (
+	. "github.com/pingcap/check"
+	"github.com/pingcap/errors"
+	"github.com/pingcap/kvproto/pkg/kvrpcpb"
+	"github.com/pingcap/tidb/store/tikv"
+	"github.com/pingcap/tidb/store/tikv/kv"
+	"github.com/pingcap/tidb/store/tikv/tikvrpc"
+)
+
+type testScanRestartSuite struct {
+	OneByOneSuite
+}
+
+var _ = Suite(&testScanRestartSuite{})
+
+func (s *testScanRestartSuite) TestScanAtomicRestart(c *C) {
+	store, client := newHookedTestStore(c)
+	defer store.Close()
+	putAlphabet(c, store)
+
+	// The second batch returns "b" again, once or twice.
+	injectDuplicates := func(n int) {
+		var batches [][]string
+		for i := 0; i < n; i++ {
+			batches = append(batches, []string{"a", "b", "c"}, []string{"d", "b"})
+		}
+		client.setOnSend(func(req *tikvrpc.Request) (*tikvrpc.Response, error) {
+			if req.Type != tikvrpc.CmdScan || len(batches) == 0 {
+				return nil, nil
+			}
+			resp := &kvrpcpb.ScanResponse{}
+			for _, key := range batches[0] {
+				resp.Pairs = append(resp.Pairs, &kvrpcpb.KvPair{Key: []byte(key), Value: []byte(key)})
+			}
+			batches = batches[1:]
+			return &tikvrpc.Response{Resp: resp}, nil
+		})
+	}
+	defer client.setOnSend(nil)
+
+	txn, err := store.Begin()
+	c.Assert(err, IsNil)
+	injectDuplicates(1)
+	var (
+		keys     []byte
+		restarts []int
+	)
+	onRestart := func(restart int, cause error) {
+		_, ok := errors.Cause(cause).(*kv.ErrDuplicateKey)
+		c.Assert(ok, IsTrue)
+		restarts = append(restarts, restart)
+		keys = keys[:0]
+	}
+	scanner, err := txn.NewScanner([]byte("a"), []byte("{"), 3, false,
+		tikv.WithDuplicateCheck(1024), tikv.WithAtomicRestart(2, onRestart))
+	c.Assert(err, IsNil)
+	for scanner.Valid() {
+		keys = append(keys, scanner.Key()...)
+		c.Assert(scanner.Next(), IsNil)
+	}
+	c.Assert(string(keys), Equals, "abcdefghijklmnopqrstuvwxyz")
+	c.Assert(restarts, DeepEquals, []int{1})
+	c.Assert(scanner.Stats().Restarts, Equals, 1)
+
+	// The scan fails once it runs out of restarts.
+	injectDuplicates(2)
+	restarts = nil
+	scanner, err = txn.NewScanner([]byte("a"), []byte("{"), 3, false,
+		tikv.WithDuplicateCheck(1024), tikv.WithAtomicRestart(1, onRestart))
+	c.Assert(err, IsNil)
+	for scanner.Valid() {
+		if err = scanner.Next(); err != nil {
+			break
+		}
+	}
+	_, ok := errors.Cause(err).(*kv.ErrDuplicateKey)
+	c.Assert(ok, IsTrue)
+	c.Assert(restarts, DeepEquals, []int{1})
+}
@@ -0,0 +1,218 @@
+// Copyright 2021 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tikv_test
+
+import (
+	"context"
+
+	. "github.com/pingcap/check"
+	"github.com/pingcap/tidb/store/mockstore/unistore"
+	"github.com/pingcap/tidb/store/tikv"
+)
+
+type testScanSampleSuite struct {
+	OneByOneSuite
+}
+
+var _ = Suite(&testScanSampleSuite{})
+
+func (s *testScanSampleSuite) TestReservoirSample(c *C) {
+	store := tikv.StoreProbe{KVStore: NewTestStore(c)}
+	defer store.Close()
+	putAlphabet(c, store)
+
+	txn, err := store.Begin()
+	c.Assert(err, IsNil)
+	sample, err := txn.GetSnapshot().ReservoirSample([]byte("b"), []byte("y"), 5)
+	c.Assert(err, IsNil)
+	c.Assert(sample.Seen, Equals, int64(23))
+	c.Assert(sample.Pairs, HasLen, 5)
+	seen := make(map[string]struct{})
+	for _, pair := range sample.Pairs {
+		c.Assert(pair.Key, HasLen, 1)
+		c.Assert(pair.Key[0] >= 'b' && pair.Key[0] < 'y', IsTrue)
+		c.Assert(pair.Value, BytesEquals, pair.Key)
+		seen[string(pair.Key)] = struct{}{}
+	}
+	c.Assert(seen, HasLen, 5)
+
+	sample, err = txn.GetSnapshot().ReservoirSample(nil, nil, 100)
+	c.Assert(err, IsNil)
+	c.Assert(sample.Seen, Equals, int64(26))
+	c.Assert(sample.Pairs, HasLen, 26)
+}
+
+func (s *testScanSampleSuite) TestSuggestSplitKeys(c *C) {
+	store := newSplitTestStore(c, []byte("h"), []byte("p"))
+	defer store.Close()
+	putAlphabet(c, store)
+
+	txn, err := store.Begin()
+	c.Assert(err, IsNil)
+	snapshot := txn.GetSnapshot()
+	keys, err := snapshot.SuggestSplitKeys([]byte("a"), nil, 4, 100)
+	c.Assert(err, IsNil)
+	c.Assert(keys, DeepEquals, [][]byte{[]byte("g"), []byte("n"), []byte("t")})
+
+	// Existing region boundaries are not proposed.
+	keys, err = snapshot.SuggestSplitKeys([]byte("a"), nil, 26, 100)
+	c.Assert(err, IsNil)
+	c.Assert(keys, HasLen, 23)
+	for _, key := range keys {
+		c.Assert(string(key), Not(Equals), "h")
+		c.Assert(string(key), Not(Equals), "p")
+	}
+
+	keys, err = snapshot.SuggestSplitKeys([]byte("0"), []byte("1"), 4, 100)
+	c.Assert(err, IsNil)
+	c.Assert(keys, HasLen, 0)
+}
+
+func (s *testScanSampleSuite) TestEstimateRegionSizes(c *C) {
+	store := newSplitTestStore(c, []byte("h"), []byte("p"))
+	defer store.Close()
+	putAlphabet(c, store)
+
+	txn, err := store.Begin()
+	c.Assert(err, IsNil)
+	snapshot := txn.GetSnapshot()
+	sizes, err := snapshot.EstimateRegionSizes([]byte("c"), nil, 1, 20)
+	c.Assert(err, IsNil)
+	c.Assert(sizes, HasLen, 3)
+	c.Assert(string(sizes[1].Region.StartKey), Equals, "h")
+	c.Assert(string(sizes[2].Region.StartKey), Equals, "p")
+	expected := []struct {
+		keys, bytes int64
+		oversized   bool
+	}{{5, 10, false}, {8, 16, false}, {11, 22, true}}
+	for i, size := range sizes {
+		c.Assert(size.Keys, Equals, expected[i].keys)
+		c.Assert(size.Bytes, Equals, expected[i].bytes)
+		c.Assert(size.Oversized, Equals, expected[i].oversized)
+	}
+
+	// Every other pair is read and counted twice. The locks of the secondaries are
+	// resolved by the exact scan above.
+	sizes, err = snapshot.EstimateRegionSizes([]byte("a"), []byte("q"), 2, 0)
+	c.Assert(err, IsNil)
+	c.Assert(sizes, HasLen, 3)
+	for i, keys := range []int64{8, 8, 2} {
+		c.Assert(sizes[i].Keys, Equals, keys)
+		c.Assert(sizes[i].Bytes, Equals, 2*keys)
+		c.Assert(sizes[i].Oversized, IsFalse)
+	}
+
+	_, err = snapshot.EstimateRegionSizes([]byte("a"), nil, 0, 0)
+	c.Assert(err, NotNil)
+}
+
+func (s *testScanSampleSuite) TestScanStoreDistribution(c *C) {
+	client, pdClient, cluster, err := unistore.New("")
+	c.Assert(err, IsNil)
+	storeIDs, _, regionID, _ := unistore.BootstrapWithMultiStores(cluster, 2)
+	newPeerIDs := cluster.AllocIDs(2)
+	cluster.Split(regionID, cluster.AllocID(), []byte("n"), newPeerIDs, newPeerIDs[1])
+	kvStore, err := tikv.NewTestTiKVStore(client, pdClient, nil, nil, 0)
+	c.Assert(err, IsNil)
+	store := tikv.StoreProbe{KVStore: kvStore}
+	defer store.Close()
+	putAlphabet(c, store)
+	// The leader of region ["n", +inf) moves to the second store.
+	bo := tikv.NewBackofferWithVars(context.Background(), 5000, nil)
+	loc, err := store.GetRegionCache().LocateKey(bo, []byte("n"))
+	c.Assert(err, IsNil)
+	store.GetRegionCache().UpdateLeader(loc.Region, storeIDs[1], 0)
+
+	txn, err := store.Begin()
+	c.Assert(err, IsNil)
+	dists, err := txn.GetSnapshot().ScanStoreDistribution([]byte("c"), nil)
+	c.Assert(err, IsNil)
+	c.Assert(dists, DeepEquals, []tikv.StoreDistribution{
+		{StoreID: storeIDs[0], Keys: 11, Bytes: 22},
+		{StoreID: storeIDs[1], Keys: 13, Bytes: 26},
+	})
+}
+
+func (s *testScanSampleSuite) TestScanVersionCounts(c *C) {
+	store := tikv.StoreProbe{KVStore: NewTestStore(c)}
+	defer store.Close()
+	putAlphabet(c, store)
+	for i := 0; i < 3; i++ {
+		txn, err := store.Begin()
+		c.Assert(err, IsNil)
+		c.Assert(txn.Set([]byte("c"), []byte{byte(i)}), IsNil)
+		c.Assert(txn.Delete([]byte("d")), IsNil)
+		c.Assert(txn.Commit(context.Background()), IsNil)
+	}
+
+	txn, err := store.Begin()
+	c.Assert(err, IsNil)
+	snapshot := txn.GetSnapshot()
+	_, err = snapshot.ScanVersionCounts([]byte("a"), []byte("f"), 0)
+	c.Assert(err, NotNil)
+	counts, err := snapshot.ScanVersionCounts([]byte("a"), []byte("f"), 10)
+	c.Assert(err, IsNil)
+	// The deleted key "d" doesn't exist at the snapshot.
+	c.Assert(counts, DeepEquals, []tikv.KeyVersionCount{
+		{Key: []byte("a"), Versions: 1},
+		{Key: []byte("b"), Versions: 1},
+		{Key: []byte("c"), Versions: 4},
+		{Key: []byte("e"), Versions: 1},
+	})
+	counts, err = snapshot.ScanVersionCounts([]byte("a"), []byte("f"), 2)
+	c.Assert(err, IsNil)
+	c.Assert(counts, HasLen, 2)
+}
+
+func (s *testScanSampleSuite) TestScanDeletes(c *C) {
+	store := tikv.StoreProbe{KVStore: NewTestStore(c)}
+	defer store.Close()
+	putAlphabet(c, store)
+	txn, err := store.Begin()
+	c.Assert(err, IsNil)
+	fromVersion := txn.StartTS()
+
+	var commitTS []uint64
+	for _, key := range []string{"b", "d"} {
+		txn, err = store.Begin()
+		c.Assert(err, IsNil)
+		c.Assert(txn.Delete([]byte(key)), IsNil)
+		c.Assert(txn.Commit(context.Background()), IsNil)
+		commitTS = append(commitTS, txn.GetCommitTS())
+	}
+	// The key written after fromVersion is not reported.
+	txn, err = store.Begin()
+	c.Assert(err, IsNil)
+	c.Assert(txn.Set([]byte("bb"), []byte("bb")), IsNil)
+	c.Assert(txn.Commit(context.Background()), IsNil)
+	txn, err = store.Begin()
+	c.Assert(err, IsNil)
+	c.Assert(txn.Delete([]byte("bb")), IsNil)
+	c.Assert(txn.Commit(context.Background()), IsNil)
+
+	txn, err = store.Begin()
+	c.Assert(err, IsNil)
+	snapshot := txn.GetSnapshot()
+	_, err = snapshot.ScanDeletes([]byte("a"), []byte("f"), txn.StartTS(), 10)
+	c.Assert(err, NotNil)
+	deletes, err := snapshot.ScanDeletes([]byte("a"), []byte("f"), fromVersion, 10)
+	c.Assert(err, IsNil)
+	c.Assert(deletes, DeepEquals, []tikv.KeyDelete{
+		{Key: []byte("b"), CommitTS: commitTS[0]},
+		{Key: []byte("d"), CommitTS: commitTS[1]},
+	})
+	deletes, err = snapshot.ScanDeletes([]byte("a"), []byte("f"), fromVersion, 1)
+	c.Assert(err, IsNil)
+	c.Assert(deletes, HasLen, 1)
+}
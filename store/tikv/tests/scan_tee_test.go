@@ -0,0 +1,83 @@
+// Copyright 2021 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tikv_test
+
+import (
+	"context"
+	"sync"
+
+	. "github.com/pingcap/check"
+	"github.com/pingcap/errors"
+	"github.com/pingcap/tidb/store/tikv"
+)
+
+type testScanTeeSuite struct {
+	OneByOneSuite
+}
+
+var _ = Suite(&testScanTeeSuite{})
+
+func (s *testScanTeeSuite) TestScanTee(c *C) {
+	store := tikv.StoreProbe{KVStore: NewTestStore(c)}
+	defer store.Close()
+	putAlphabet(c, store)
+
+	txn, err := store.Begin()
+	c.Assert(err, IsNil)
+	scanner, err := txn.NewScanner([]byte("c"), []byte("t"), 4, false)
+	c.Assert(err, IsNil)
+	_, err = scanner.Tee(context.Background(), 0, 2)
+	c.Assert(err, NotNil)
+	tee, err := scanner.Tee(context.Background(), 2, 2)
+	c.Assert(err, IsNil)
+	var (
+		wg   sync.WaitGroup
+		keys [2][]byte
+	)
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for pair := range tee.Pairs(0) {
+			keys[0] = append(keys[0], pair.Key...)
+		}
+	}()
+	// The second consumer stops early, which doesn't block the first one.
+	go func() {
+		defer wg.Done()
+		for pair := range tee.Pairs(1) {
+			keys[1] = append(keys[1], pair.Key...)
+			if len(keys[1]) == 3 {
+				tee.Stop(1)
+				return
+			}
+		}
+	}()
+	c.Assert(tee.Wait(), IsNil)
+	wg.Wait()
+	c.Assert(string(keys[0]), Equals, "cdefghijklmnopqrs")
+	c.Assert(string(keys[1]), Equals, "cde")
+	c.Assert(scanner.Valid(), IsFalse)
+
+	// A consumer which doesn't read blocks the scan until the tee is closed.
+	scanner, err = txn.NewScanner([]byte("c"), []byte("t"), 4, false)
+	c.Assert(err, IsNil)
+	tee, err = scanner.Tee(context.Background(), 1, 0)
+	c.Assert(err, IsNil)
+	pair := <-tee.Pairs(0)
+	c.Assert(string(pair.Key), Equals, "c")
+	tee.Close()
+	c.Assert(errors.Cause(tee.Wait()), Equals, context.Canceled)
+	_, ok := <-tee.Pairs(0)
+	c.Assert(ok, IsFalse)
+}
@@ -17,8 +17,10 @@ import (
 	"bytes"
 	"context"
 	"fmt"
+	"testing"
 
 	. "github.com/pingcap/check"
+	"github.com/pingcap/tidb/store/mockstore/unistore"
 	"github.com/pingcap/tidb/store/tikv"
 	"github.com/pingcap/tidb/store/tikv/kv"
 	"github.com/pingcap/tidb/store/tikv/logutil"
@@ -167,3 +169,65 @@ func (s *testScanSuite) TestScan(c *C) {
 		check(c, scan, upperBound, true)
 	}
 }
+
+func BenchmarkScannerReset(b *testing.B) {
+	client, pdClient, cluster, err := unistore.New("")
+	if err != nil {
+		b.Fatal(err)
+	}
+	unistore.BootstrapWithSingleStore(cluster)
+	kvStore, err := tikv.NewTestTiKVStore(client, pdClient, nil, nil, 0)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer kvStore.Close()
+	store := tikv.StoreProbe{KVStore: kvStore}
+	txn, err := store.Begin()
+	if err != nil {
+		b.Fatal(err)
+	}
+	for i := 0; i < 100; i++ {
+		if err = txn.Set([]byte(fmt.Sprintf("key%03d", i)), []byte("value")); err != nil {
+			b.Fatal(err)
+		}
+	}
+	if err = txn.Commit(context.Background()); err != nil {
+		b.Fatal(err)
+	}
+	txn, err = store.Begin()
+	if err != nil {
+		b.Fatal(err)
+	}
+	scan := func(scanner *tikv.Scanner) {
+		for scanner.Valid() {
+			if err := scanner.Next(); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+
+	b.Run("New", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			scanner, err := txn.NewScanner(nil, nil, 16, false)
+			if err != nil {
+				b.Fatal(err)
+			}
+			scan(scanner)
+		}
+	})
+	b.Run("Reset", func(b *testing.B) {
+		scanner, err := txn.NewScanner(nil, nil, 16, false)
+		if err != nil {
+			b.Fatal(err)
+		}
+		b.ReportAllocs()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			if err = scanner.Reset(txn.GetSnapshot(), nil, 16); err != nil {
+				b.Fatal(err)
+			}
+			scan(scanner)
+		}
+	})
+}
@@ -29,6 +29,7 @@ import (
 	"github.com/pingcap/tidb/store/tikv/kv"
 	"github.com/pingcap/tidb/store/tikv/logutil"
 	"github.com/pingcap/tidb/store/tikv/tikvrpc"
+	"github.com/pingcap/tidb/store/tikv/unionstore"
 	"go.uber.org/zap"
 )
 
@@ -105,6 +106,20 @@ func (s *testSnapshotSuite) deleteKeys(keys [][]byte, c *C) {
 	c.Assert(err, IsNil)
 }
 
+// putAlphabet writes 'a' to 'z' under the prefix as both keys and values, and
+// returns the keys.
+func (s *testSnapshotSuite) putAlphabet(c *C) [][]byte {
+	txn := s.beginTxn(c)
+	var keys [][]byte
+	for ch := byte('a'); ch <= byte('z'); ch++ {
+		key := encodeKey(s.prefix, string(ch))
+		c.Assert(txn.Set(key, key), IsNil)
+		keys = append(keys, key)
+	}
+	c.Assert(txn.Commit(context.Background()), IsNil)
+	return keys
+}
+
 func (s *testSnapshotSuite) TestBatchGet(c *C) {
 	for _, rowNum := range s.rowNums {
 		logutil.BgLogger().Debug("test BatchGet",
@@ -314,3 +329,96 @@ func (s *testSnapshotSuite) TestSnapshotRuntimeStats(c *C) {
 		"block: {cache_hit_count: 20, read_count: 40, read_byte: 30 Bytes}}}"
 	c.Assert(snapshot.FormatStats(), Equals, expect)
 }
+
+func (s *testSnapshotSuite) TestSnapshotIterWithContext(c *C) {
+	keys := s.putAlphabet(c)
+	defer s.deleteKeys(keys, c)
+	_, err := s.store.SplitRegions(context.Background(), [][]byte{encodeKey(s.prefix, "h")}, false, nil)
+	c.Assert(err, IsNil)
+
+	snapshot := s.beginTxn(c).GetSnapshot()
+	for _, reverse := range []bool{false, true} {
+		ctx, cancel := context.WithCancel(context.Background())
+		var it unionstore.Iterator
+		if reverse {
+			it, err = snapshot.IterReverseWithContext(ctx, encodeKey(s.prefix, "{"))
+		} else {
+			it, err = snapshot.IterWithContext(ctx, encodeKey(s.prefix, "a"), encodeKey(s.prefix, "{"))
+		}
+		c.Assert(err, IsNil)
+		// The first region is read already, the scan stops once it moves to the
+		// second one.
+		cancel()
+		var scanned [][]byte
+		for it.Valid() {
+			scanned = append(scanned, append([]byte(nil), it.Key()...))
+			if err = it.Next(); err != nil {
+				break
+			}
+		}
+		if reverse {
+			c.Assert(scanned, HasLen, 19)
+			c.Assert(scanned[0], BytesEquals, keys[25])
+			c.Assert(scanned[18], BytesEquals, keys[7])
+		} else {
+			c.Assert(scanned, DeepEquals, keys[:7])
+		}
+		c.Assert(errors.Cause(err), Equals, context.Canceled)
+		c.Assert(it.Valid(), IsFalse)
+	}
+}
+
+func (s *testSnapshotSuite) TestFirstLastKey(c *C) {
+	keys := s.putAlphabet(c)
+	defer s.deleteKeys(keys, c)
+
+	snapshot := s.beginTxn(c).GetSnapshot()
+	cases := []struct {
+		start, end  string
+		first, last string
+	}{
+		{"", "{", "a", "z"},
+		{"b", "y", "b", "x"},
+		{"g", "i", "g", "h"},
+		{"h0", "p", "i", "o"},
+		{"z", "{", "z", "z"},
+	}
+	for _, t := range cases {
+		key, value, err := snapshot.FirstKey(encodeKey(s.prefix, t.start), encodeKey(s.prefix, t.end))
+		c.Assert(err, IsNil)
+		c.Assert(key, BytesEquals, encodeKey(s.prefix, t.first))
+		c.Assert(value, BytesEquals, key)
+		key, value, err = snapshot.LastKey(encodeKey(s.prefix, t.start), encodeKey(s.prefix, t.end))
+		c.Assert(err, IsNil)
+		c.Assert(key, BytesEquals, encodeKey(s.prefix, t.last))
+		c.Assert(value, BytesEquals, key)
+	}
+
+	_, _, err := snapshot.FirstKey(encodeKey(s.prefix, "z0"), encodeKey(s.prefix, "{"))
+	c.Assert(tidbkv.IsErrNotFound(err), IsTrue)
+	_, _, err = snapshot.LastKey(encodeKey(s.prefix, "h0"), encodeKey(s.prefix, "h1"))
+	c.Assert(tidbkv.IsErrNotFound(err), IsTrue)
+}
+
+func (s *testSnapshotSuite) TestKeyBounds(c *C) {
+	keys := s.putAlphabet(c)
+	defer s.deleteKeys(keys, c)
+
+	snapshot := s.beginTxn(c).GetSnapshot()
+	ctx := context.Background()
+	minKey, maxKey, err := snapshot.KeyBounds(ctx, encodeKey(s.prefix, "b"), encodeKey(s.prefix, "y"))
+	c.Assert(err, IsNil)
+	c.Assert(minKey, BytesEquals, encodeKey(s.prefix, "b"))
+	c.Assert(maxKey, BytesEquals, encodeKey(s.prefix, "x"))
+	minKey, maxKey, err = snapshot.KeyBounds(ctx, encodeKey(s.prefix, "h0"), encodeKey(s.prefix, "p"))
+	c.Assert(err, IsNil)
+	c.Assert(minKey, BytesEquals, encodeKey(s.prefix, "i"))
+	c.Assert(maxKey, BytesEquals, encodeKey(s.prefix, "o"))
+
+	_, _, err = snapshot.KeyBounds(ctx, encodeKey(s.prefix, "h0"), encodeKey(s.prefix, "h1"))
+	c.Assert(tidbkv.IsErrNotFound(err), IsTrue)
+	ctx, cancel := context.WithCancel(ctx)
+	cancel()
+	_, _, err = snapshot.KeyBounds(ctx, encodeKey(s.prefix, "b"), encodeKey(s.prefix, "y"))
+	c.Assert(errors.Cause(err), Equals, context.Canceled)
+}
@@ -21,6 +21,7 @@ import (
 	. "github.com/pingcap/check"
 	"github.com/pingcap/failpoint"
 	pb "github.com/pingcap/kvproto/pkg/kvrpcpb"
+	"github.com/pingcap/tidb/store/mockstore/unistore"
 	"github.com/pingcap/tidb/store/tikv"
 	"github.com/pingcap/tidb/store/tikv/kv"
 	"github.com/pingcap/tidb/store/tikv/oracle"
@@ -172,3 +173,33 @@ func (s *testStoreSerialSuite) TestOracleChangeByFailpoint(c *C) {
 	c.Assert(err, IsNil)
 	c.Assert(t1, Greater, t2)
 }
+
+// warmupClient records the addresses it is asked to warm up.
+type warmupClient struct {
+	tikv.Client
+	addrs []string
+}
+
+func (c *warmupClient) WarmupConns(ctx context.Context, addrs []string) error {
+	c.addrs = append(c.addrs, addrs...)
+	return nil
+}
+
+func (s *testStoreSuite) TestWarmupStores(c *C) {
+	client, pdClient, cluster, err := unistore.New("")
+	c.Assert(err, IsNil)
+	storeID, _, _ := unistore.BootstrapWithSingleStore(cluster)
+	warmup := &warmupClient{}
+	store, err := tikv.NewTestTiKVStore(client, pdClient, func(c tikv.Client) tikv.Client {
+		warmup.Client = c
+		return warmup
+	}, nil, 0)
+	c.Assert(err, IsNil)
+	defer store.Close()
+
+	c.Assert(store.WarmupStores(context.Background(), []uint64{storeID}), IsNil)
+	c.Assert(warmup.addrs, HasLen, 1)
+	c.Assert(warmup.addrs[0], Not(Equals), "")
+	err = store.WarmupStores(context.Background(), []uint64{storeID + 100})
+	c.Assert(err, ErrorMatches, ".*not found.*")
+}
@@ -28,6 +28,7 @@ import (
 	"github.com/pingcap/kvproto/pkg/mpp"
 	"github.com/pingcap/kvproto/pkg/tikvpb"
 	"github.com/pingcap/tidb/store/tikv/kv"
+	"google.golang.org/grpc"
 )
 
 // CmdType represents the concrete request type in Request or response type in Response.
@@ -181,6 +182,15 @@ type Request struct {
 	// If it's not empty, the store which receive the request will forward it to
 	// the forwarded host. It's useful when network partition occurs.
 	ForwardedHost string
+	// CallOptions are the extra gRPC call options of the request, e.g. for experiments
+	// on transport-level behaviors. Requests with call options are never sent by batch
+	// commands, because the options can't apply to a single request of a batch.
+	CallOptions []grpc.CallOption
+	// NoBatch makes the request sent by a unary call instead of batch commands, so
+	// that canceling its context cancels the call on the server too. A request of
+	// batch commands shares the stream with the others, and its cancellation only
+	// stops the client from waiting for it.
+	NoBatch bool
 }
 
 // NewRequest returns new kv rpc request.
@@ -823,96 +833,96 @@ func CallRPC(ctx context.Context, client tikvpb.TikvClient, req *Request) (*Resp
 	var err error
 	switch req.Type {
 	case CmdGet:
-		resp.Resp, err = client.KvGet(ctx, req.Get())
+		resp.Resp, err = client.KvGet(ctx, req.Get(), req.CallOptions...)
 	case CmdScan:
-		resp.Resp, err = client.KvScan(ctx, req.Scan())
+		resp.Resp, err = client.KvScan(ctx, req.Scan(), req.CallOptions...)
 	case CmdPrewrite:
-		resp.Resp, err = client.KvPrewrite(ctx, req.Prewrite())
+		resp.Resp, err = client.KvPrewrite(ctx, req.Prewrite(), req.CallOptions...)
 	case CmdPessimisticLock:
-		resp.Resp, err = client.KvPessimisticLock(ctx, req.PessimisticLock())
+		resp.Resp, err = client.KvPessimisticLock(ctx, req.PessimisticLock(), req.CallOptions...)
 	case CmdPessimisticRollback:
-		resp.Resp, err = client.KVPessimisticRollback(ctx, req.PessimisticRollback())
+		resp.Resp, err = client.KVPessimisticRollback(ctx, req.PessimisticRollback(), req.CallOptions...)
 	case CmdCommit:
-		resp.Resp, err = client.KvCommit(ctx, req.Commit())
+		resp.Resp, err = client.KvCommit(ctx, req.Commit(), req.CallOptions...)
 	case CmdCleanup:
-		resp.Resp, err = client.KvCleanup(ctx, req.Cleanup())
+		resp.Resp, err = client.KvCleanup(ctx, req.Cleanup(), req.CallOptions...)
 	case CmdBatchGet:
-		resp.Resp, err = client.KvBatchGet(ctx, req.BatchGet())
+		resp.Resp, err = client.KvBatchGet(ctx, req.BatchGet(), req.CallOptions...)
 	case CmdBatchRollback:
-		resp.Resp, err = client.KvBatchRollback(ctx, req.BatchRollback())
+		resp.Resp, err = client.KvBatchRollback(ctx, req.BatchRollback(), req.CallOptions...)
 	case CmdScanLock:
-		resp.Resp, err = client.KvScanLock(ctx, req.ScanLock())
+		resp.Resp, err = client.KvScanLock(ctx, req.ScanLock(), req.CallOptions...)
 	case CmdResolveLock:
-		resp.Resp, err = client.KvResolveLock(ctx, req.ResolveLock())
+		resp.Resp, err = client.KvResolveLock(ctx, req.ResolveLock(), req.CallOptions...)
 	case CmdGC:
-		resp.Resp, err = client.KvGC(ctx, req.GC())
+		resp.Resp, err = client.KvGC(ctx, req.GC(), req.CallOptions...)
 	case CmdDeleteRange:
-		resp.Resp, err = client.KvDeleteRange(ctx, req.DeleteRange())
+		resp.Resp, err = client.KvDeleteRange(ctx, req.DeleteRange(), req.CallOptions...)
 	case CmdRawGet:
-		resp.Resp, err = client.RawGet(ctx, req.RawGet())
+		resp.Resp, err = client.RawGet(ctx, req.RawGet(), req.CallOptions...)
 	case CmdRawBatchGet:
-		resp.Resp, err = client.RawBatchGet(ctx, req.RawBatchGet())
+		resp.Resp, err = client.RawBatchGet(ctx, req.RawBatchGet(), req.CallOptions...)
 	case CmdRawPut:
-		resp.Resp, err = client.RawPut(ctx, req.RawPut())
+		resp.Resp, err = client.RawPut(ctx, req.RawPut(), req.CallOptions...)
 	case CmdRawBatchPut:
-		resp.Resp, err = client.RawBatchPut(ctx, req.RawBatchPut())
+		resp.Resp, err = client.RawBatchPut(ctx, req.RawBatchPut(), req.CallOptions...)
 	case CmdRawDelete:
-		resp.Resp, err = client.RawDelete(ctx, req.RawDelete())
+		resp.Resp, err = client.RawDelete(ctx, req.RawDelete(), req.CallOptions...)
 	case CmdRawBatchDelete:
-		resp.Resp, err = client.RawBatchDelete(ctx, req.RawBatchDelete())
+		resp.Resp, err = client.RawBatchDelete(ctx, req.RawBatchDelete(), req.CallOptions...)
 	case CmdRawDeleteRange:
-		resp.Resp, err = client.RawDeleteRange(ctx, req.RawDeleteRange())
+		resp.Resp, err = client.RawDeleteRange(ctx, req.RawDeleteRange(), req.CallOptions...)
 	case CmdRawScan:
-		resp.Resp, err = client.RawScan(ctx, req.RawScan())
+		resp.Resp, err = client.RawScan(ctx, req.RawScan(), req.CallOptions...)
 	case CmdUnsafeDestroyRange:
-		resp.Resp, err = client.UnsafeDestroyRange(ctx, req.UnsafeDestroyRange())
+		resp.Resp, err = client.UnsafeDestroyRange(ctx, req.UnsafeDestroyRange(), req.CallOptions...)
 	case CmdRegisterLockObserver:
-		resp.Resp, err = client.RegisterLockObserver(ctx, req.RegisterLockObserver())
+		resp.Resp, err = client.RegisterLockObserver(ctx, req.RegisterLockObserver(), req.CallOptions...)
 	case CmdCheckLockObserver:
-		resp.Resp, err = client.CheckLockObserver(ctx, req.CheckLockObserver())
+		resp.Resp, err = client.CheckLockObserver(ctx, req.CheckLockObserver(), req.CallOptions...)
 	case CmdRemoveLockObserver:
-		resp.Resp, err = client.RemoveLockObserver(ctx, req.RemoveLockObserver())
+		resp.Resp, err = client.RemoveLockObserver(ctx, req.RemoveLockObserver(), req.CallOptions...)
 	case CmdPhysicalScanLock:
-		resp.Resp, err = client.PhysicalScanLock(ctx, req.PhysicalScanLock())
+		resp.Resp, err = client.PhysicalScanLock(ctx, req.PhysicalScanLock(), req.CallOptions...)
 	case CmdCop:
-		resp.Resp, err = client.Coprocessor(ctx, req.Cop())
+		resp.Resp, err = client.Coprocessor(ctx, req.Cop(), req.CallOptions...)
 	case CmdMPPTask:
-		resp.Resp, err = client.DispatchMPPTask(ctx, req.DispatchMPPTask())
+		resp.Resp, err = client.DispatchMPPTask(ctx, req.DispatchMPPTask(), req.CallOptions...)
 	case CmdMPPConn:
 		var streamClient tikvpb.Tikv_EstablishMPPConnectionClient
-		streamClient, err = client.EstablishMPPConnection(ctx, req.EstablishMPPConn())
+		streamClient, err = client.EstablishMPPConnection(ctx, req.EstablishMPPConn(), req.CallOptions...)
 		resp.Resp = &MPPStreamResponse{
 			Tikv_EstablishMPPConnectionClient: streamClient,
 		}
 	case CmdMPPCancel:
 		// it cannot use the ctx with cancel(), otherwise this cmd will fail.
-		resp.Resp, err = client.CancelMPPTask(ctx, req.CancelMPPTask())
+		resp.Resp, err = client.CancelMPPTask(ctx, req.CancelMPPTask(), req.CallOptions...)
 	case CmdCopStream:
 		var streamClient tikvpb.Tikv_CoprocessorStreamClient
-		streamClient, err = client.CoprocessorStream(ctx, req.Cop())
+		streamClient, err = client.CoprocessorStream(ctx, req.Cop(), req.CallOptions...)
 		resp.Resp = &CopStreamResponse{
 			Tikv_CoprocessorStreamClient: streamClient,
 		}
 	case CmdBatchCop:
 		var streamClient tikvpb.Tikv_BatchCoprocessorClient
-		streamClient, err = client.BatchCoprocessor(ctx, req.BatchCop())
+		streamClient, err = client.BatchCoprocessor(ctx, req.BatchCop(), req.CallOptions...)
 		resp.Resp = &BatchCopStreamResponse{
 			Tikv_BatchCoprocessorClient: streamClient,
 		}
 	case CmdMvccGetByKey:
-		resp.Resp, err = client.MvccGetByKey(ctx, req.MvccGetByKey())
+		resp.Resp, err = client.MvccGetByKey(ctx, req.MvccGetByKey(), req.CallOptions...)
 	case CmdMvccGetByStartTs:
-		resp.Resp, err = client.MvccGetByStartTs(ctx, req.MvccGetByStartTs())
+		resp.Resp, err = client.MvccGetByStartTs(ctx, req.MvccGetByStartTs(), req.CallOptions...)
 	case CmdSplitRegion:
-		resp.Resp, err = client.SplitRegion(ctx, req.SplitRegion())
+		resp.Resp, err = client.SplitRegion(ctx, req.SplitRegion(), req.CallOptions...)
 	case CmdEmpty:
 		resp.Resp, err = &tikvpb.BatchCommandsEmptyResponse{}, nil
 	case CmdCheckTxnStatus:
-		resp.Resp, err = client.KvCheckTxnStatus(ctx, req.CheckTxnStatus())
+		resp.Resp, err = client.KvCheckTxnStatus(ctx, req.CheckTxnStatus(), req.CallOptions...)
 	case CmdCheckSecondaryLocks:
-		resp.Resp, err = client.KvCheckSecondaryLocks(ctx, req.CheckSecondaryLocks())
+		resp.Resp, err = client.KvCheckSecondaryLocks(ctx, req.CheckSecondaryLocks(), req.CallOptions...)
 	case CmdTxnHeartBeat:
-		resp.Resp, err = client.KvTxnHeartBeat(ctx, req.TxnHeartBeat())
+		resp.Resp, err = client.KvTxnHeartBeat(ctx, req.TxnHeartBeat(), req.CallOptions...)
 	default:
 		return nil, errors.Errorf("invalid request type: %v", req.Type)
 	}
@@ -178,6 +178,57 @@ func (txn *KVTxn) Iter(k []byte, upperBound []byte) (unionstore.Iterator, error)
 	return txn.us.Iter(k, upperBound)
 }
 
+// IterForUpdate creates an Iterator which scans the snapshot like Iter, and acquires
+// pessimistic locks with lockCtx on every key before it's returned, so the locks are
+// held under the transaction's managed lock TTL and the ForUpdateTS in lockCtx.
+// Lock conflicts are waited according to lockCtx and are returned as LockKeys does.
+// The values are read at the ForUpdateTS once the keys are locked, and the keys
+// deleted since the snapshot are locked but not returned.
+// Note that the buffered mutations of the transaction are not merged into the result.
+func (txn *KVTxn) IterForUpdate(ctx context.Context, lockCtx *kv.LockCtx, k []byte, upperBound []byte) (unionstore.Iterator, error) {
+	scanner, err := newLockingScanner(ctx, txn, lockCtx, k, upperBound, scanBatchSize, false)
+	return scanner, errors.Trace(err)
+}
+
+// IterWithReadSet creates an Iterator which scans the snapshot like Iter, and adds
+// every returned key to the read set of the optimistic transaction. The keys are
+// prewritten as Op_Lock mutations on commit, so the commit fails with a write
+// conflict if any of them has been written by others since the transaction started.
+// Keys inserted into the range by others are not detected.
+// Note that the buffered mutations of the transaction are not merged into the result.
+func (txn *KVTxn) IterWithReadSet(ctx context.Context, k []byte, upperBound []byte) (unionstore.Iterator, error) {
+	if txn.IsPessimistic() {
+		return nil, errors.New("read set is only supported by optimistic transactions, use IterForUpdate instead")
+	}
+	scanner, err := newLockingScanner(ctx, txn, &kv.LockCtx{}, k, upperBound, scanBatchSize, false)
+	return scanner, errors.Trace(err)
+}
+
+// IterWithOptions is Iter with the snapshot scanned by a Scanner configured by opts,
+// e.g. to bound the scan by WithMaxDuration. The buffered mutations of the
+// transaction are merged into the scan results in key order, so the range read
+// reflects the writes of the transaction itself: a buffered value replaces the
+// committed one of the same key, and a buffered delete hides it. The options only
+// apply to the snapshot, the buffered mutations are always returned.
+func (txn *KVTxn) IterWithOptions(k []byte, upperBound []byte, batchSize int, opts ...ScannerOption) (unionstore.Iterator, error) {
+	bufferIt, err := txn.GetMemBuffer().Iter(k, upperBound)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	scanner, err := newScanner(txn.snapshot, k, upperBound, batchSize, false, opts...)
+	if err != nil {
+		bufferIt.Close()
+		return nil, errors.Trace(err)
+	}
+	it, err := unionstore.NewUnionIter(bufferIt, scanner, false)
+	if err != nil {
+		bufferIt.Close()
+		scanner.Close()
+		return nil, errors.Trace(err)
+	}
+	return it, nil
+}
+
 // IterReverse creates a reversed Iterator positioned on the first entry which key is less than k.
 func (txn *KVTxn) IterReverse(k []byte) (unionstore.Iterator, error) {
 	return txn.us.IterReverse(k)